@@ -144,6 +144,12 @@ func init() {
 		command.NewDedupCommand(),
 		command.NewHealthCmd(),
 		command.NewSchemaCommand(),
+		command.NewGraphCmd(),
+		command.NewCompactCmd(),
+		command.NewIndexCmd(),
+		command.NewFsckCmd(),
+		command.NewBackupCmd(),
+		command.NewRestoreCmd(),
 	)
 	rootCmd.PersistentFlags().StringP("config", "c", "", "path to an explicit configuration file")
 
@@ -151,6 +157,7 @@ func init() {
 	rootCmd.PersistentFlags().StringP("db", "d", "memstore", "database backend to use: "+strings.Join(qnames, ", "))
 	rootCmd.PersistentFlags().StringP("dbpath", "a", "", "path or address string for database")
 	rootCmd.PersistentFlags().Bool("read_only", false, "open database in read-only mode")
+	rootCmd.PersistentFlags().String("replication", "single", "replication method to use: "+strings.Join(graph.WriterMethods(), ", "))
 
 	rootCmd.PersistentFlags().Bool("dup", true, "don't stop loading on duplicated on add")
 	rootCmd.PersistentFlags().Bool("missing", false, "don't stop loading on missing key on delete")
@@ -166,6 +173,7 @@ func init() {
 	viper.BindPFlag(command.KeyBackend, rootCmd.PersistentFlags().Lookup("db"))
 	viper.BindPFlag(command.KeyAddress, rootCmd.PersistentFlags().Lookup("dbpath"))
 	viper.BindPFlag(command.KeyReadOnly, rootCmd.PersistentFlags().Lookup("read_only"))
+	viper.BindPFlag(command.KeyReplication, rootCmd.PersistentFlags().Lookup("replication"))
 	viper.BindPFlag("load.ignore_duplicates", rootCmd.PersistentFlags().Lookup("dup"))
 	viper.BindPFlag("load.ignore_missing", rootCmd.PersistentFlags().Lookup("missing"))
 	viper.BindPFlag(command.KeyLoadBatch, rootCmd.PersistentFlags().Lookup("batch"))
@@ -177,6 +185,8 @@ func init() {
 	viper.RegisterAlias("db_path", command.KeyAddress)
 	viper.RegisterAlias("read_only", command.KeyReadOnly)
 	viper.RegisterAlias("db_options", command.KeyOptions)
+	viper.RegisterAlias("replication", command.KeyReplication)
+	viper.RegisterAlias("replication_options", command.KeyReplicationOptions)
 
 	{ // re-register standard Go flags to cobra
 		rf := rootCmd.PersistentFlags()
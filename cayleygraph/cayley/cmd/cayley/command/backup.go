@@ -0,0 +1,151 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/kv"
+)
+
+const (
+	flagOutput = "output"
+	flagInput  = "input"
+	flagFrom   = "from"
+)
+
+func NewBackupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Make a consistent copy of the database.",
+		Long: `Make a consistent copy of the raw database content.
+
+Only key-value backends are supported. The backup contains a manifest with the data version
+and a checksum, which are verified by the restore command.
+With --from, the backup is downloaded from a running HTTP server, which continues to serve requests.
+The server must be started with --http_backup, since backups are not served by default.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("too many arguments provided, expected 0")
+			}
+			out, _ := cmd.Flags().GetString(flagOutput)
+			from, _ := cmd.Flags().GetString(flagFrom)
+			if out == "" {
+				return errors.New("output file must be specified")
+			}
+			var w io.Writer = os.Stdout
+			if out != "-" {
+				f, err := os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			if from != "" {
+				if err := downloadBackup(w, from); err != nil {
+					return err
+				}
+			} else {
+				printBackendInfo()
+				h, err := openDatabase()
+				if err != nil {
+					return err
+				}
+				defer h.Close()
+				err = graph.Backup(context.Background(), h.QuadStore, w)
+				if err == graph.ErrOperationNotSupported {
+					return errors.New("backup is only supported by key-value backends")
+				} else if err != nil {
+					return err
+				}
+			}
+			if f, ok := w.(*os.File); ok && f != os.Stdout {
+				if err := f.Sync(); err != nil {
+					return err
+				}
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				m, err := kv.VerifyBackup(f)
+				if err != nil {
+					return fmt.Errorf("cannot verify backup: %v", err)
+				}
+				clog.Infof("backed up %d keys, data version %d", m.Keys, m.Version)
+				return f.Close()
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringP(flagOutput, "o", "", `file to write the backup to ("-" for stdout)`)
+	cmd.Flags().String(flagFrom, "", "address of the HTTP server to download the backup from")
+	return cmd
+}
+
+// downloadBackup copies the backup served by the HTTP API to w.
+func downloadBackup(w io.Writer, addr string) error {
+	addr = strings.TrimSuffix(addr, "/") + "/api/v2/backup"
+	clog.Infof("downloading backup from %s", addr)
+	resp, err := http.Get(addr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s responded with status code %d: %s", addr, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func NewRestoreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore the database from a backup.",
+		Long: `Restore the database from a backup made by the backup command.
+
+The database must not exist. It is initialized only if the checksum and the data version
+of the backup are valid.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("too many arguments provided, expected 0")
+			}
+			in, _ := cmd.Flags().GetString(flagInput)
+			if in == "" {
+				return errors.New("input file must be specified")
+			}
+			var r io.Reader = os.Stdin
+			if in != "-" {
+				f, err := os.Open(in)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			printBackendInfo()
+			name := viper.GetString(KeyBackend)
+			addr := viper.GetString(KeyAddress)
+			opts := graph.Options(viper.GetStringMap(KeyOptions))
+			m, err := kv.RestoreTo(context.Background(), name, addr, opts, r)
+			if err != nil {
+				return err
+			}
+			clog.Infof("restored %d keys, backup created at %v", m.Keys, m.Created)
+			return nil
+		},
+	}
+	cmd.Flags().StringP(flagInput, "i", "", `file to read the backup from ("-" for stdin)`)
+	return cmd
+}
@@ -0,0 +1,43 @@
+package command
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/kv"
+)
+
+func NewCompactCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "compact",
+		Short: "Remove deleted quads and nodes from the database.",
+		Long: `Remove deleted quads and nodes from the database.
+
+Only key-value backends are supported. The database can be used by other processes while the compaction is in progress,
+if the backend allows it. Deleted quads are no longer visible in time-travel queries after the compaction,
+and the change feed is truncated, thus replicas that are behind the removed changes must be seeded again.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printBackendInfo()
+			h, err := openDatabase()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+
+			qs, ok := graph.Base(h.QuadStore).(*kv.QuadStore)
+			if !ok {
+				return errors.New("compaction is only supported by key-value backends")
+			}
+			st, err := qs.Compact(context.Background())
+			if err != nil {
+				return err
+			}
+			clog.Infof("removed %d quads, %d nodes and %d changes", st.Quads, st.Nodes, st.Changes)
+			return nil
+		},
+	}
+}
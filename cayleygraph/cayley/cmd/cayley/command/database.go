@@ -1,6 +1,7 @@
 package command
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -15,6 +16,8 @@ import (
 
 	"github.com/cayleygraph/cayley/clog"
 	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/fulltext"
+	"github.com/cayleygraph/cayley/graph/kv"
 	"github.com/cayleygraph/cayley/internal"
 	"github.com/cayleygraph/quad"
 )
@@ -26,6 +29,18 @@ const (
 	KeyReadOnly = "store.read_only"
 	KeyOptions  = "store.options"
 
+	KeyReapInterval = "store.reap_interval"
+	KeyHTTPBackup   = "store.http_backup"
+
+	KeyDatabases            = "store.databases"
+	KeyDatabasesIdleTimeout = "store.databases_idle_timeout"
+
+	KeyReplication        = "store.replication"
+	KeyReplicationOptions = "store.replication_options"
+
+	KeyFullText        = "store.fulltext"
+	KeyFullTextOptions = "store.fulltext_options"
+
 	KeyLoadBatch = "load.batch"
 )
 
@@ -34,6 +49,9 @@ const (
 	flagLoadFormat = "load_format"
 	flagDump       = "dump"
 	flagDumpFormat = "dump_format"
+
+	flagBulk        = "bulk"
+	flagBulkTempDir = "bulk_tmp"
 )
 
 var ErrNotPersistent = errors.New("database type is not persistent")
@@ -105,22 +123,31 @@ func NewLoadDatabaseCmd() *cobra.Command {
 					return err
 				}
 			}
+			typ, _ := cmd.Flags().GetString(flagLoadFormat)
+			bulk, _ := cmd.Flags().GetBool(flagBulk)
+			if bulk {
+				tmp, _ := cmd.Flags().GetString(flagBulkTempDir)
+				if err := bulkLoad(load, typ, tmp); err != nil {
+					return err
+				}
+			}
 			h, err := openDatabase()
 			if err != nil {
 				return err
 			}
 			defer h.Close()
 
-			qw, err := h.NewQuadWriter()
-			if err != nil {
-				return err
-			}
-			defer qw.Close()
+			if !bulk {
+				qw, err := h.NewQuadWriter()
+				if err != nil {
+					return err
+				}
+				defer qw.Close()
 
-			// TODO: check read-only flag in config before that?
-			typ, _ := cmd.Flags().GetString(flagLoadFormat)
-			if err = internal.Load(qw, quad.DefaultBatch, load, typ); err != nil {
-				return err
+				// TODO: check read-only flag in config before that?
+				if err = internal.Load(qw, quad.DefaultBatch, load, typ); err != nil {
+					return err
+				}
 			}
 
 			if dump, _ := cmd.Flags().GetString(flagDump); dump != "" {
@@ -133,11 +160,31 @@ func NewLoadDatabaseCmd() *cobra.Command {
 		},
 	}
 	cmd.Flags().Bool("init", false, "initialize the database before using it")
+	cmd.Flags().Bool(flagBulk, false, "load quads into an empty key-value database directly, without duplicate checks")
+	cmd.Flags().String(flagBulkTempDir, "", "directory for temporary files of the bulk load (system default if empty)")
 	registerLoadFlags(cmd)
 	registerDumpFlags(cmd)
 	return cmd
 }
 
+// bulkLoad loads a quad file into an empty key-value database with kv.BulkLoadTo.
+func bulkLoad(path, typ, tmp string) error {
+	name := viper.GetString(KeyBackend)
+	addr := viper.GetString(KeyAddress)
+	opts := graph.Options(viper.GetStringMap(KeyOptions))
+	qr, err := internal.QuadReaderFor(path, typ)
+	if err != nil {
+		return err
+	}
+	defer qr.Close()
+	st, err := kv.BulkLoadTo(context.Background(), name, addr, opts, qr, kv.BulkOptions{TempDir: tmp})
+	if err != nil {
+		return fmt.Errorf("db: failed to load data: %v", err)
+	}
+	clog.Infof("loaded %d quads and %d nodes, skipped %d duplicates", st.Quads, st.Nodes, st.Duplicates)
+	return nil
+}
+
 func NewDumpDatabaseCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "dump",
@@ -201,6 +248,11 @@ func initDatabase() error {
 }
 
 func openDatabase() (*graph.Handle, error) {
+	return openDatabaseWith(false)
+}
+
+// openDatabaseWith opens the database, and optionally wraps it with a full-text index, if it is enabled.
+func openDatabaseWith(search bool) (*graph.Handle, error) {
 	name := viper.GetString(KeyBackend)
 	path := viper.GetString(KeyAddress)
 	opts := graph.Options(viper.GetStringMap(KeyOptions))
@@ -208,13 +260,46 @@ func openDatabase() (*graph.Handle, error) {
 	if err != nil {
 		return nil, err
 	}
-	qw, err := graph.NewQuadWriter("single", qs, opts)
+	if typ := viper.GetString(KeyFullText); search && typ != "" {
+		qs, err = openFullText(qs, typ)
+		if err != nil {
+			return nil, err
+		}
+	}
+	wtyp := viper.GetString(KeyReplication)
+	if wtyp == "" {
+		wtyp = "single"
+	}
+	wopts := make(graph.Options, len(opts))
+	for k, v := range opts {
+		wopts[k] = v
+	}
+	for k, v := range viper.GetStringMap(KeyReplicationOptions) {
+		wopts[k] = v
+	}
+	qw, err := graph.NewQuadWriter(wtyp, qs, wopts)
 	if err != nil {
+		qs.Close()
 		return nil, err
 	}
 	return &graph.Handle{QuadStore: qs, QuadWriter: qw}, nil
 }
 
+func openFullText(qs graph.QuadStore, typ string) (graph.QuadStore, error) {
+	idx, err := fulltext.New(typ, graph.Options(viper.GetStringMap(KeyFullTextOptions)))
+	if err != nil {
+		qs.Close()
+		return nil, err
+	}
+	wqs, err := fulltext.Wrap(context.TODO(), qs, idx)
+	if err != nil {
+		idx.Close()
+		qs.Close()
+		return nil, err
+	}
+	return wqs, nil
+}
+
 func openForQueries(cmd *cobra.Command) (*graph.Handle, error) {
 	if init, err := cmd.Flags().GetBool("init"); err != nil {
 		return nil, err
@@ -226,11 +311,11 @@ func openForQueries(cmd *cobra.Command) (*graph.Handle, error) {
 		}
 	}
 	var load string
-	h, err := openDatabase()
+	h, err := openDatabaseWith(true)
 	if err == graph.ErrQuadStoreNotPersistent {
 		load = viper.GetString(KeyAddress)
 		viper.Set(KeyAddress, "")
-		h, err = openDatabase()
+		h, err = openDatabaseWith(true)
 	}
 	if err == graph.ErrQuadStoreNotPersistent {
 		return nil, fmt.Errorf("%v; did you mean -i flag?", err)
@@ -2,6 +2,7 @@ package command
 
 import (
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -68,7 +69,15 @@ func writerQuadsTo(path string, typ string, qr quad.Reader) error {
 
 func dumpDatabase(h *graph.Handle, path string, typ string) error {
 	//TODO: add possible support for exporting specific queries only
-	qr := graph.NewQuadStoreReader(h.QuadStore)
+	qs := h.QuadStore
+	// export a consistent view of the database, if the backend supports it
+	if sn, err := graph.Snapshot(context.TODO(), qs); err == nil {
+		defer sn.Close()
+		qs = sn
+	} else if err != graph.ErrOperationNotSupported {
+		return err
+	}
+	qr := graph.NewQuadStoreReader(qs)
 	defer qr.Close()
 	return writerQuadsTo(path, typ, qr)
 }
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/kv"
+)
+
+const flagFix = "fix"
+
+func NewFsckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Check consistency of the database.",
+		Long: `Check consistency of the database.
+
+Only key-value backends are supported. The primitive log is checked against value and reference count buckets,
+quad indexes and the size metadata. With --fix, the problems found are repaired using the log as the source of truth.
+Writes are blocked while the check is in progress.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fix, _ := cmd.Flags().GetBool(flagFix)
+			printBackendInfo()
+			h, err := openDatabase()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+
+			qs, ok := graph.Base(h.QuadStore).(*kv.QuadStore)
+			if !ok {
+				return errors.New("consistency check is only supported by key-value backends")
+			}
+			rep, err := qs.Check(context.Background(), fix)
+			if err != nil {
+				return err
+			}
+			for _, p := range rep.Problems {
+				if !p.Fixable {
+					fmt.Println(p, "(cannot be fixed)")
+				} else {
+					fmt.Println(p)
+				}
+			}
+			clog.Infof("checked %d nodes and %d quads: %d problems found, %d fixed", rep.Nodes, rep.Quads, len(rep.Problems), rep.Fixed)
+			if len(rep.Problems) > rep.Fixed {
+				return fmt.Errorf("database has %d unfixed problems", len(rep.Problems)-rep.Fixed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Bool(flagFix, false, "repair problems found by the check")
+	return cmd
+}
@@ -0,0 +1,120 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/quad"
+)
+
+func NewGraphCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "graph",
+		Short: "Manage named graphs in the database",
+		Long: `Manage named graphs in the database.
+
+Graphs are identified by quad labels in the same format as in N-Quads files, for example "<http://example.com/graph>".
+An empty label ("") refers to the default graph.`,
+	}
+	root.AddCommand(
+		newGraphListCmd(),
+		newGraphDropCmd(),
+		newGraphRelabelCmd("copy", "Copy all quads of a graph to another graph", graph.CopyLabel),
+		newGraphRelabelCmd("move", "Move all quads of a graph to another graph", graph.MoveLabel),
+	)
+	return root
+}
+
+// labelArg parses a graph label from a command line argument.
+func labelArg(s string) quad.Value {
+	if s == "" {
+		return nil
+	}
+	return quad.StringToValue(s)
+}
+
+func labelName(v quad.Value) string {
+	if v == nil {
+		return "default graph"
+	}
+	return quad.StringOf(v)
+}
+
+func newGraphListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List all named graphs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("too many arguments provided, expected 0")
+			}
+			printBackendInfo()
+			h, err := openDatabase()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			labels, err := graph.ListLabels(context.Background(), h)
+			if err != nil {
+				return err
+			}
+			for _, l := range labels {
+				fmt.Println(quad.StringOf(l))
+			}
+			return nil
+		},
+	}
+}
+
+func newGraphDropCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "drop <label>",
+		Short: "Remove all quads of a graph",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("expected exactly one graph label")
+			}
+			label := labelArg(args[0])
+			printBackendInfo()
+			h, err := openDatabase()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			if err = graph.DropLabel(context.Background(), h, label); err != nil {
+				return err
+			}
+			clog.Infof("dropped %s", labelName(label))
+			return nil
+		},
+	}
+}
+
+func newGraphRelabelCmd(name, short string, fnc func(ctx context.Context, qs graph.QuadStore, from, to quad.Value) error) *cobra.Command {
+	return &cobra.Command{
+		Use:   name + " <from> <to>",
+		Short: short,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("expected source and destination graph labels")
+			}
+			from, to := labelArg(args[0]), labelArg(args[1])
+			printBackendInfo()
+			h, err := openDatabase()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			if err = fnc(context.Background(), h, from, to); err != nil {
+				return err
+			}
+			clog.Infof("%s: %s -> %s", name, labelName(from), labelName(to))
+			return nil
+		},
+	}
+}
@@ -1,6 +1,7 @@
 package command
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"time"
@@ -9,7 +10,10 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	httpgraph "github.com/cayleygraph/cayley/graph/http"
 	chttp "github.com/cayleygraph/cayley/internal/http"
+	"github.com/cayleygraph/cayley/writer"
 )
 
 func NewHTTPCmd() *cobra.Command {
@@ -27,9 +31,36 @@ func NewHTTPCmd() *cobra.Command {
 			}
 			defer h.Close()
 
+			ro := viper.GetBool(KeyReadOnly)
+			if rep, ok := h.QuadWriter.(*writer.Replica); ok {
+				clog.Infof("replicating from %s, database is read-only", rep.Primary())
+				ro = true
+			}
+			// replicas receive deletions of expired quads from the primary
+			if iv := viper.GetDuration(KeyReapInterval); iv > 0 && !ro {
+				go func() {
+					err := graph.RunReaper(context.Background(), h.QuadStore, iv)
+					if err != nil && err != graph.ErrOperationNotSupported {
+						clog.Errorf("reaper stopped: %v", err)
+					}
+				}()
+			}
+			dbs, err := httpgraph.ParseDatabases(viper.Get(KeyDatabases))
+			if err != nil {
+				return err
+			} else if len(dbs) != 0 {
+				multi := httpgraph.NewDatabases(h.QuadStore, dbs, viper.GetDuration(KeyDatabasesIdleTimeout))
+				defer multi.Close()
+				if !viper.GetBool(KeyReadOnly) {
+					multi.SetReapInterval(viper.GetDuration(KeyReapInterval))
+				}
+				h = &graph.Handle{QuadStore: multi, QuadWriter: h.QuadWriter}
+				clog.Infof("serving databases: %v", multi.Names())
+			}
 			err = chttp.SetupRoutes(h, &chttp.Config{
 				Timeout:  viper.GetDuration(keyQueryTimeout),
-				ReadOnly: viper.GetBool(KeyReadOnly),
+				ReadOnly: ro,
+				Backup:   viper.GetBool(KeyHTTPBackup),
 			})
 			if err != nil {
 				return err
@@ -46,7 +77,13 @@ func NewHTTPCmd() *cobra.Command {
 	cmd.Flags().String("host", "127.0.0.1:64210", "host:port to listen on")
 	cmd.Flags().Bool("init", false, "initialize the database before using it")
 	cmd.Flags().DurationP("timeout", "t", 30*time.Second, "elapsed time until an individual query times out")
+	cmd.Flags().Duration("reap_interval", time.Minute, "interval between deletions of expired quads (0 to disable)")
+	cmd.Flags().Bool("http_backup", false, "serve backups of the database at /api/v2/backup")
+	cmd.Flags().Duration("databases_idle_timeout", 10*time.Minute, "time after which idle named databases are closed (0 to keep them open)")
 	registerLoadFlags(cmd)
 	viper.BindPFlag(keyQueryTimeout, cmd.Flags().Lookup("timeout"))
+	viper.BindPFlag(KeyReapInterval, cmd.Flags().Lookup("reap_interval"))
+	viper.BindPFlag(KeyHTTPBackup, cmd.Flags().Lookup("http_backup"))
+	viper.BindPFlag(KeyDatabasesIdleTimeout, cmd.Flags().Lookup("databases_idle_timeout"))
 	return cmd
 }
@@ -0,0 +1,132 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/kv"
+)
+
+func NewIndexCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "index",
+		Short: "Manage quad indexes of the database",
+		Long: `Manage quad indexes of the database.
+
+Only key-value backends are supported. Indexes are written as a list of direction prefixes,
+for example "sp" (subject, predicate) or "ops" (object, predicate, subject). Both "c" and "l" refer to the label.`,
+	}
+	root.AddCommand(
+		newIndexListCmd(),
+		newIndexAddCmd(),
+		newIndexDropCmd(),
+	)
+	return root
+}
+
+// openKV opens the database and checks that it uses a key-value backend.
+func openKV() (*kv.QuadStore, func() error, error) {
+	h, err := openDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	qs, ok := graph.Base(h.QuadStore).(*kv.QuadStore)
+	if !ok {
+		h.Close()
+		return nil, nil, errors.New("index management is only supported by key-value backends")
+	}
+	return qs, h.Close, nil
+}
+
+func newIndexListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List all quad indexes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("too many arguments provided, expected 0")
+			}
+			printBackendInfo()
+			qs, closer, err := openKV()
+			if err != nil {
+				return err
+			}
+			defer closer()
+			list, err := qs.ListIndexes(context.Background())
+			if err != nil {
+				return err
+			}
+			for _, ind := range list {
+				if ind.Building {
+					fmt.Println(ind.String(), "(building)")
+				} else {
+					fmt.Println(ind.String())
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func newIndexAddCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "add <index>",
+		Short: "Build a new quad index",
+		Long: `Build a new quad index from the log of the database.
+
+The database can be used by other processes while the index is being built, if the backend allows it.
+The index is used for lookups once it's complete. An interrupted build continues when the command is run again.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("expected exactly one index")
+			}
+			ind, err := kv.ParseQuadIndex(args[0])
+			if err != nil {
+				return err
+			}
+			printBackendInfo()
+			qs, closer, err := openKV()
+			if err != nil {
+				return err
+			}
+			defer closer()
+			if err = qs.AddIndex(context.Background(), ind); err != nil {
+				return err
+			}
+			clog.Infof("added index %v", ind)
+			return nil
+		},
+	}
+}
+
+func newIndexDropCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "drop <index>",
+		Short: "Remove a quad index",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("expected exactly one index")
+			}
+			ind, err := kv.ParseQuadIndex(args[0])
+			if err != nil {
+				return err
+			}
+			printBackendInfo()
+			qs, closer, err := openKV()
+			if err != nil {
+				return err
+			}
+			defer closer()
+			if err = qs.DropIndex(context.Background(), ind); err != nil {
+				return err
+			}
+			clog.Infof("dropped index %v", ind)
+			return nil
+		},
+	}
+}
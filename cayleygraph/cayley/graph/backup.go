@@ -0,0 +1,37 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"context"
+	"io"
+)
+
+// Backuper is an optional interface for QuadStores that can copy their raw data.
+type Backuper interface {
+	// Backup writes a consistent snapshot of the store to w, in a backend-specific format.
+	// The store can be read while the backup is in progress, but writes may wait for it to finish.
+	Backup(ctx context.Context, w io.Writer) error
+}
+
+// Backup writes a consistent snapshot of the QuadStore to w, in a backend-specific format.
+//
+// It returns ErrOperationNotSupported if the backend cannot copy its raw data.
+func Backup(ctx context.Context, qs QuadStore, w io.Writer) error {
+	if b, ok := Unwrap(qs).(Backuper); ok {
+		return b.Backup(ctx, w)
+	}
+	return ErrOperationNotSupported
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"context"
+	"time"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/quad"
+)
+
+// Expirer is an optional interface for QuadStores that support quads with an expiration time,
+// see Delta.Expires.
+//
+// Expired quads are hidden from iterators and Stats at once, but they are kept by the QuadStore
+// until ExpireQuads is called.
+type Expirer interface {
+	// ExpireQuads deletes all quads that expire at or before a given time and returns the deleted
+	// quads. Quads are deleted as a regular transaction, thus the deletion is reflected in indexes
+	// and change feeds the same way as if the quads were removed by ApplyDeltas.
+	ExpireQuads(ctx context.Context, now time.Time) ([]quad.Quad, error)
+}
+
+// ExpireQuads deletes all quads that expired before a given time and returns the deleted quads.
+//
+// It returns ErrOperationNotSupported if the backend does not support quad expiration.
+func ExpireQuads(ctx context.Context, qs QuadStore, now time.Time) ([]quad.Quad, error) {
+	if e, ok := Unwrap(qs).(Expirer); ok {
+		return e.ExpireQuads(ctx, now)
+	}
+	return nil, ErrOperationNotSupported
+}
+
+// CheckNoExpiry returns a DeltaError for the first delta that sets an expiration time.
+// It is used by backends that do not support quad expiration.
+func CheckNoExpiry(deltas []Delta) error {
+	for _, d := range deltas {
+		if !d.Expires.IsZero() {
+			return &DeltaError{Delta: d, Err: ErrOperationNotSupported}
+		}
+	}
+	return nil
+}
+
+// RunReaper deletes expired quads with a given interval until the context is cancelled.
+// Errors are logged and do not stop the reaper.
+//
+// It returns ErrOperationNotSupported right away if the backend does not support quad expiration.
+func RunReaper(ctx context.Context, qs QuadStore, interval time.Duration) error {
+	e, ok := Unwrap(qs).(Expirer)
+	if !ok {
+		return ErrOperationNotSupported
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-t.C:
+			deleted, err := e.ExpireQuads(ctx, now)
+			if err != nil {
+				clog.Errorf("cannot delete expired quads: %v", err)
+			} else if len(deleted) != 0 && clog.V(1) {
+				clog.Infof("deleted %d expired quads", len(deleted))
+			}
+		}
+	}
+}
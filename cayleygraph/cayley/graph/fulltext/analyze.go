@@ -0,0 +1,156 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fulltext
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Tokenize splits the text into lowercase words. Any character that is not a letter or a digit is a separator.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Stemmer reduces a lowercase word to its stem.
+type Stemmer func(word string) string
+
+var (
+	stemMu   sync.RWMutex
+	stemmers = map[string]Stemmer{
+		"en": StemEnglish,
+	}
+)
+
+// RegisterStemmer sets a stemmer for a given language tag.
+func RegisterStemmer(lang string, s Stemmer) {
+	if s == nil {
+		panic("stemmer must not be nil")
+	}
+	stemMu.Lock()
+	defer stemMu.Unlock()
+	stemmers[strings.ToLower(lang)] = s
+}
+
+// StemmerFor returns a stemmer for a given language tag. If there is no stemmer for the full tag,
+// the stemmer for its primary subtag is used. Words are not stemmed in all other cases.
+func StemmerFor(lang string) Stemmer {
+	lang = strings.ToLower(lang)
+	stemMu.RLock()
+	defer stemMu.RUnlock()
+	if s, ok := stemmers[lang]; ok {
+		return s
+	}
+	if i := strings.IndexByte(lang, '-'); i > 0 {
+		if s, ok := stemmers[lang[:i]]; ok {
+			return s
+		}
+	}
+	return noStem
+}
+
+func noStem(word string) string { return word }
+
+// Analyze splits the text into terms and stems them according to the language tag.
+func Analyze(text, lang string) []string {
+	stem := StemmerFor(lang)
+	words := Tokenize(text)
+	for i, w := range words {
+		words[i] = stem(w)
+	}
+	return words
+}
+
+// StemEnglish is a light suffix-stripping stemmer for English.
+// It handles plurals, past and progressive verb forms, and a few common derivational suffixes.
+func StemEnglish(w string) string {
+	if len(w) <= 3 {
+		return w
+	}
+	// plurals
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ies"):
+		w = w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "ss"), strings.HasSuffix(w, "us"), strings.HasSuffix(w, "is"):
+	case strings.HasSuffix(w, "s"):
+		w = w[:len(w)-1]
+	}
+	// verb forms
+	for _, suf := range []string{"ing", "ed"} {
+		stem := strings.TrimSuffix(w, suf)
+		if stem == w {
+			continue
+		}
+		if len(stem) >= 3 && hasVowel(stem) {
+			w = restoreStem(stem)
+		}
+		break
+	}
+	// derivational suffixes
+	for _, suf := range []string{"ness", "ly"} {
+		if strings.HasSuffix(w, suf) && len(w)-len(suf) >= 3 {
+			w = w[:len(w)-len(suf)]
+			break
+		}
+	}
+	return w
+}
+
+func isVowel(c byte) bool {
+	switch c {
+	case 'a', 'e', 'i', 'o', 'u', 'y':
+		return true
+	}
+	return false
+}
+
+func hasVowel(w string) bool {
+	for i := 0; i < len(w); i++ {
+		if isVowel(w[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreStem fixes a stem after a verb suffix was removed: "creat" becomes "create" and "runn" becomes "run".
+func restoreStem(w string) string {
+	switch {
+	case strings.HasSuffix(w, "at"), strings.HasSuffix(w, "bl"), strings.HasSuffix(w, "iz"):
+		return w + "e"
+	case isDoubleConsonant(w):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// isDoubleConsonant checks if the word ends with a double consonant that is usually
+// added before verb suffixes, like in "running" or "stopped".
+func isDoubleConsonant(w string) bool {
+	n := len(w)
+	if n < 2 || w[n-1] != w[n-2] || isVowel(w[n-1]) {
+		return false
+	}
+	switch w[n-1] {
+	case 'l', 's', 'z':
+		return false
+	}
+	return true
+}
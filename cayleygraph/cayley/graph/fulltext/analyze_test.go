@@ -0,0 +1,67 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fulltext
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	require.Equal(t,
+		[]string{"cool", "people", "and", "big", "ideas", "42"},
+		Tokenize("Cool people, and BIG-ideas: 42!"),
+	)
+	require.Equal(t, []string{"über", "straße"}, Tokenize("Über\tStraße"))
+	require.Empty(t, Tokenize(" .,; "))
+}
+
+func TestStemEnglish(t *testing.T) {
+	for _, c := range []struct {
+		word, stem string
+	}{
+		{"cats", "cat"},
+		{"ponies", "pony"},
+		{"classes", "class"},
+		{"status", "status"},
+		{"running", "run"},
+		{"stopped", "stop"},
+		{"falling", "fall"},
+		{"created", "create"},
+		{"creates", "create"},
+		{"feelings", "feel"},
+		{"coolness", "cool"},
+		{"quickly", "quick"},
+		{"only", "only"},
+		{"sing", "sing"},
+		{"bed", "bed"},
+		{"red", "red"},
+	} {
+		require.Equal(t, c.stem, StemEnglish(c.word), "stem of %q", c.word)
+	}
+}
+
+func TestStemmerFor(t *testing.T) {
+	require.Equal(t, "cat", StemmerFor("en")("cats"))
+	require.Equal(t, "cat", StemmerFor("en-GB")("cats"))
+	require.Equal(t, "cats", StemmerFor("")("cats"))
+	require.Equal(t, "chats", StemmerFor("fr")("chats"))
+
+	RegisterStemmer("x-upper", strings.ToUpper)
+	require.Equal(t, []string{"CATS", "DOGS"}, Analyze("cats, dogs", "X-Upper"))
+	require.Equal(t, []string{"cat", "dog"}, Analyze("cats, dogs", "en"))
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fulltext implements a full-text index over string literals of a QuadStore.
+package fulltext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/quad"
+)
+
+var ErrIndexNotRegistered = errors.New("fulltext: index type is not registered")
+
+// Index is a full-text index of string literals.
+//
+// Only quad.String and quad.LangString values are indexed; other values are ignored.
+// Literals with a language tag are analyzed with a stemmer for that language (see StemmerFor).
+type Index interface {
+	// Add indexes a literal. Adding a value that is already indexed does nothing.
+	Add(ctx context.Context, v quad.Value) error
+	// Remove removes a literal from the index. Removing a value that is not indexed does nothing.
+	Remove(ctx context.Context, v quad.Value) error
+	// Reset removes all values from the index.
+	Reset(ctx context.Context) error
+	// Search returns literals matching any term of the query, ordered by descending score.
+	// If lang is set, only literals with a matching language tag are returned.
+	Search(ctx context.Context, query, lang string) ([]graph.TextHit, error)
+	// Close releases resources held by the index.
+	Close() error
+}
+
+// NewFunc creates a new empty full-text index.
+type NewFunc func(opts graph.Options) (Index, error)
+
+var registry = make(map[string]NewFunc)
+
+// Register adds a full-text index implementation with a given name.
+func Register(name string, fnc NewFunc) {
+	if fnc == nil {
+		panic("NewFunc must not be nil")
+	}
+	if _, found := registry[name]; found {
+		panic(fmt.Sprintf("Already registered full-text index %q.", name))
+	}
+	registry[name] = fnc
+}
+
+// New creates a new full-text index of a given type.
+func New(name string, opts graph.Options) (Index, error) {
+	fnc, ok := registry[name]
+	if !ok {
+		return nil, ErrIndexNotRegistered
+	}
+	return fnc(opts)
+}
+
+// Types returns names of all registered full-text index implementations.
+func Types() []string {
+	t := make([]string, 0, len(registry))
+	for n := range registry {
+		t = append(t, n)
+	}
+	sort.Strings(t)
+	return t
+}
+
+// Literal returns the text and the language tag of a literal. It returns false if the value cannot be indexed.
+func Literal(v quad.Value) (text, lang string, ok bool) {
+	switch v := v.(type) {
+	case quad.String:
+		return string(v), "", true
+	case quad.LangString:
+		return string(v.Value), v.Lang, true
+	}
+	return "", "", false
+}
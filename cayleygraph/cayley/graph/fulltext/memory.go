@@ -0,0 +1,191 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fulltext
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/quad"
+)
+
+const MemType = "memory"
+
+func init() {
+	Register(MemType, func(graph.Options) (Index, error) {
+		return NewMemIndex(), nil
+	})
+}
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var _ Index = (*MemIndex)(nil)
+
+// MemIndex is an in-memory inverted index. Results are ranked with Okapi BM25.
+type MemIndex struct {
+	mu    sync.RWMutex
+	docs  map[quad.Value]memDoc
+	terms map[memTerm]map[quad.Value]int // term frequencies in each document
+	langs map[string]int                 // number of documents in each language
+	total int                            // total length of all documents
+}
+
+type memDoc struct {
+	lang  string
+	terms []string
+}
+
+type memTerm struct {
+	lang, term string
+}
+
+// NewMemIndex creates a new empty in-memory index.
+func NewMemIndex() *MemIndex {
+	idx := &MemIndex{}
+	idx.reset()
+	return idx
+}
+
+func (idx *MemIndex) reset() {
+	idx.docs = make(map[quad.Value]memDoc)
+	idx.terms = make(map[memTerm]map[quad.Value]int)
+	idx.langs = make(map[string]int)
+	idx.total = 0
+}
+
+func (idx *MemIndex) Add(ctx context.Context, v quad.Value) error {
+	text, lang, ok := Literal(v)
+	if !ok {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.docs[v]; ok {
+		return nil
+	}
+	lang = strings.ToLower(lang)
+	d := memDoc{lang: lang, terms: Analyze(text, lang)}
+	idx.docs[v] = d
+	idx.langs[lang]++
+	idx.total += len(d.terms)
+	for _, t := range d.terms {
+		k := memTerm{lang: lang, term: t}
+		m := idx.terms[k]
+		if m == nil {
+			m = make(map[quad.Value]int)
+			idx.terms[k] = m
+		}
+		m[v]++
+	}
+	return nil
+}
+
+func (idx *MemIndex) Remove(ctx context.Context, v quad.Value) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	d, ok := idx.docs[v]
+	if !ok {
+		return nil
+	}
+	delete(idx.docs, v)
+	if idx.langs[d.lang]--; idx.langs[d.lang] <= 0 {
+		delete(idx.langs, d.lang)
+	}
+	idx.total -= len(d.terms)
+	for _, t := range d.terms {
+		k := memTerm{lang: d.lang, term: t}
+		m := idx.terms[k]
+		delete(m, v)
+		if len(m) == 0 {
+			delete(idx.terms, k)
+		}
+	}
+	return nil
+}
+
+func (idx *MemIndex) Reset(ctx context.Context) error {
+	idx.mu.Lock()
+	idx.reset()
+	idx.mu.Unlock()
+	return nil
+}
+
+func (idx *MemIndex) Search(ctx context.Context, query, lang string) ([]graph.TextHit, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if len(idx.docs) == 0 {
+		return nil, nil
+	}
+	n := float64(len(idx.docs))
+	avgLen := float64(idx.total) / n
+	scores := make(map[quad.Value]float64)
+	for l := range idx.langs {
+		if lang != "" && !langMatches(l, lang) {
+			continue
+		}
+		// the query is analyzed separately for each language, since stemming rules are different
+		seen := make(map[string]struct{})
+		for _, t := range Analyze(query, l) {
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			seen[t] = struct{}{}
+			m := idx.terms[memTerm{lang: l, term: t}]
+			if len(m) == 0 {
+				continue
+			}
+			df := float64(len(m))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for v, cnt := range m {
+				tf := float64(cnt)
+				dl := float64(len(idx.docs[v].terms))
+				scores[v] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*dl/avgLen))
+			}
+		}
+	}
+	out := make([]graph.TextHit, 0, len(scores))
+	for v, s := range scores {
+		out = append(out, graph.TextHit{Value: v, Score: s})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Value.String() < out[j].Value.String()
+	})
+	return out, nil
+}
+
+func (idx *MemIndex) Close() error {
+	return nil
+}
+
+// langMatches checks if the language tag of a literal matches the requested one.
+// A primary language subtag matches all regional variants, e.g. "en" matches "en-US".
+func langMatches(tag, want string) bool {
+	want = strings.ToLower(want)
+	if tag == want {
+		return true
+	}
+	return len(tag) > len(want) && tag[len(want)] == '-' && tag[:len(want)] == want
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fulltext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/quad"
+)
+
+func hitValues(hits []graph.TextHit) []quad.Value {
+	out := make([]quad.Value, 0, len(hits))
+	for _, h := range hits {
+		out = append(out, h.Value)
+	}
+	return out
+}
+
+func TestMemIndex(t *testing.T) {
+	ctx := context.TODO()
+	idx := NewMemIndex()
+	defer idx.Close()
+
+	for _, v := range []quad.Value{
+		quad.String("cool cats and cool dogs"),
+		quad.String("a cool story about the ocean and the stars and the moon"),
+		quad.String("dogs only"),
+		quad.LangString{Value: "running cats", Lang: "en"},
+		quad.LangString{Value: "running cats", Lang: "en-US"},
+		quad.IRI("cool"),
+		quad.Int(42),
+	} {
+		require.NoError(t, idx.Add(ctx, v))
+	}
+	// adding a value twice should not change the scores
+	require.NoError(t, idx.Add(ctx, quad.String("dogs only")))
+
+	hits, err := idx.Search(ctx, "Cool", "")
+	require.NoError(t, err)
+	require.Equal(t, []quad.Value{
+		quad.String("cool cats and cool dogs"),
+		quad.String("a cool story about the ocean and the stars and the moon"),
+	}, hitValues(hits))
+	require.True(t, hits[0].Score > hits[1].Score)
+
+	// stemming is only applied to English literals
+	hits, err = idx.Search(ctx, "cats running", "")
+	require.NoError(t, err)
+	require.Len(t, hits, 3)
+	require.Equal(t, quad.String("cool cats and cool dogs"), hits[2].Value)
+
+	hits, err = idx.Search(ctx, "run", "en")
+	require.NoError(t, err)
+	require.Equal(t, []quad.Value{
+		quad.LangString{Value: "running cats", Lang: "en"},
+		quad.LangString{Value: "running cats", Lang: "en-US"},
+	}, hitValues(hits))
+
+	hits, err = idx.Search(ctx, "run", "en-us")
+	require.NoError(t, err)
+	require.Equal(t, []quad.Value{
+		quad.LangString{Value: "running cats", Lang: "en-US"},
+	}, hitValues(hits))
+
+	require.NoError(t, idx.Remove(ctx, quad.String("cool cats and cool dogs")))
+	require.NoError(t, idx.Remove(ctx, quad.String("missing")))
+	hits, err = idx.Search(ctx, "dogs", "")
+	require.NoError(t, err)
+	require.Equal(t, []quad.Value{quad.String("dogs only")}, hitValues(hits))
+
+	hits, err = idx.Search(ctx, "42", "")
+	require.NoError(t, err)
+	require.Empty(t, hits)
+
+	require.NoError(t, idx.Reset(ctx))
+	hits, err = idx.Search(ctx, "dogs", "")
+	require.NoError(t, err)
+	require.Empty(t, hits)
+}
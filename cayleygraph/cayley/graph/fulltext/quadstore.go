@@ -0,0 +1,393 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fulltext
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/query/shape"
+	"github.com/cayleygraph/quad"
+)
+
+var (
+	_ graph.TextSearcher      = (*QuadStore)(nil)
+	_ graph.ConditionalWriter = (*QuadStore)(nil)
+	_ graph.Wrapper           = (*QuadStore)(nil)
+	_ shape.Optimizer         = (*QuadStore)(nil)
+	_ graph.Watcher           = watchingQuadStore{}
+)
+
+// QuadStore wraps a QuadStore and keeps a full-text index of its string literals
+// up to date with all changes applied through it.
+//
+// Optional interfaces of the underlying QuadStore are forwarded to it, including shape
+// optimizations. Methods specific to the backend are available via graph.Base. Snapshots
+// and historical views returned by the wrapper are not indexed.
+type QuadStore struct {
+	graph.QuadStore
+	idx Index
+
+	// mu serializes writes, so checks for removed literals see a consistent state
+	mu sync.Mutex
+}
+
+// Wrap indexes all string literals of the QuadStore and returns a wrapper that keeps the index up to date.
+//
+// If the QuadStore supports change feeds, the wrapper supports them as well.
+func Wrap(ctx context.Context, qs graph.QuadStore, idx Index) (graph.QuadStore, error) {
+	s := &QuadStore{QuadStore: qs, idx: idx}
+	if err := s.reindex(ctx); err != nil {
+		return nil, err
+	}
+	if _, ok := graph.Unwrap(qs).(graph.Watcher); ok {
+		return watchingQuadStore{s}, nil
+	}
+	return s, nil
+}
+
+// Index returns the full-text index of the QuadStore.
+func (qs *QuadStore) Index() Index {
+	return qs.idx
+}
+
+// reindex rebuilds the index from all nodes of the underlying QuadStore.
+func (qs *QuadStore) reindex(ctx context.Context) error {
+	if err := qs.idx.Reset(ctx); err != nil {
+		return err
+	}
+	start := time.Now()
+	it := qs.QuadStore.NodesAllIterator().Iterate()
+	defer it.Close()
+	n := 0
+	for it.Next(ctx) {
+		v, err := qs.QuadStore.NameOf(it.Result())
+		if err != nil {
+			return err
+		}
+		if _, _, ok := Literal(v); !ok {
+			continue
+		}
+		if err = qs.idx.Add(ctx, v); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	clog.Infof("indexed %d literals for full-text search in %v", n, time.Since(start))
+	return nil
+}
+
+// Underlying implements graph.Wrapper.
+func (qs *QuadStore) Underlying() graph.QuadStore {
+	return qs.QuadStore
+}
+
+// OptimizeShape implements shape.Optimizer. Shapes are optimized by the underlying QuadStore;
+// shapes it returns find it with graph.Base when they are built with the wrapper.
+func (qs *QuadStore) OptimizeShape(ctx context.Context, s shape.Shape) (shape.Shape, bool) {
+	if o, ok := qs.QuadStore.(shape.Optimizer); ok {
+		return o.OptimizeShape(ctx, s)
+	}
+	return s, false
+}
+
+func (qs *QuadStore) SearchText(ctx context.Context, query, lang string) ([]graph.TextHit, error) {
+	return qs.idx.Search(ctx, query, lang)
+}
+
+// used checks if the value is still referenced by any quad.
+func (qs *QuadStore) used(ctx context.Context, v quad.Value) (bool, error) {
+	ref, err := qs.QuadStore.ValueOf(v)
+	if err != nil || ref == nil {
+		return false, err
+	}
+	for _, d := range quad.Directions {
+		it := qs.QuadStore.QuadIterator(d, ref).Iterate()
+		ok := it.Next(ctx)
+		err = it.Err()
+		it.Close()
+		if err != nil {
+			return false, err
+		} else if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// update applies changes that were committed to the underlying QuadStore to the index.
+func (qs *QuadStore) update(ctx context.Context, in []graph.Delta) error {
+	var removed []quad.Value
+	for _, d := range in {
+		for _, dir := range quad.Directions {
+			v := d.Quad.Get(dir)
+			if _, _, ok := Literal(v); !ok {
+				continue
+			}
+			switch d.Action {
+			case graph.Add:
+				if err := qs.idx.Add(ctx, v); err != nil {
+					return err
+				}
+			case graph.Delete:
+				removed = append(removed, v)
+			}
+		}
+	}
+	return qs.removeUnused(ctx, removed)
+}
+
+// removeUnused removes literals that are no longer referenced by any quad from the index.
+// Values that are not literals are skipped.
+func (qs *QuadStore) removeUnused(ctx context.Context, removed []quad.Value) error {
+	for _, v := range removed {
+		if _, _, ok := Literal(v); !ok {
+			continue
+		}
+		if ok, err := qs.used(ctx, v); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+		if err := qs.idx.Remove(ctx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if err := qs.QuadStore.ApplyDeltas(in, opts); err != nil {
+		return err
+	}
+	return qs.update(context.TODO(), in)
+}
+
+func (qs *QuadStore) ApplyDeltasIf(conds []graph.Precondition, in []graph.Delta, opts graph.IgnoreOpts) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if err := graph.ApplyDeltasIf(qs.QuadStore, conds, in, opts); err != nil {
+		return err
+	}
+	return qs.update(context.TODO(), in)
+}
+
+func (qs *QuadStore) NewQuadWriter() (quad.WriteCloser, error) {
+	w, err := qs.QuadStore.NewQuadWriter()
+	if err != nil {
+		return nil, err
+	}
+	return &quadWriter{qs: qs, w: w, vals: make(map[quad.Value]struct{})}, nil
+}
+
+// labelLiterals returns all literals used by quads of a named graph, including its label.
+func (qs *QuadStore) labelLiterals(ctx context.Context, label quad.Value) ([]quad.Value, error) {
+	var it iterator.Shape
+	if label == nil {
+		it = qs.QuadStore.QuadsAllIterator()
+	} else {
+		ref, err := qs.QuadStore.ValueOf(label)
+		if err != nil || ref == nil {
+			return nil, err
+		}
+		it = qs.QuadStore.QuadIterator(quad.Label, ref)
+	}
+	seen := make(map[quad.Value]struct{})
+	var out []quad.Value
+	sc := it.Iterate()
+	defer sc.Close()
+	for sc.Next(ctx) {
+		q, err := qs.QuadStore.Quad(sc.Result())
+		if err != nil {
+			return nil, err
+		} else if label == nil && q.Label != nil {
+			continue
+		}
+		for _, dir := range quad.Directions {
+			v := q.Get(dir)
+			if _, _, ok := Literal(v); !ok {
+				continue
+			} else if _, ok = seen[v]; ok {
+				continue
+			}
+			seen[v] = struct{}{}
+			out = append(out, v)
+		}
+	}
+	return out, sc.Err()
+}
+
+func (qs *QuadStore) Close() error {
+	err := qs.idx.Close()
+	if err2 := qs.QuadStore.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (qs *QuadStore) Backup(ctx context.Context, w io.Writer) error {
+	return graph.Backup(ctx, qs.QuadStore, w)
+}
+
+func (qs *QuadStore) Version(ctx context.Context) (int64, error) {
+	return graph.Version(ctx, qs.QuadStore)
+}
+
+func (qs *QuadStore) Snapshot(ctx context.Context) (graph.QuadStore, error) {
+	return graph.Snapshot(ctx, qs.QuadStore)
+}
+
+func (qs *QuadStore) AsOf(ctx context.Context, t time.Time) (graph.QuadStore, error) {
+	return graph.AsOf(ctx, qs.QuadStore, t)
+}
+
+func (qs *QuadStore) PredicateStats(ctx context.Context, pred graph.Ref) (graph.PredicateStats, error) {
+	return graph.GetPredicateStats(ctx, qs.QuadStore, pred)
+}
+
+func (qs *QuadStore) AllPredicateStats(ctx context.Context) ([]graph.PredicateStats, error) {
+	return graph.GetAllPredicateStats(ctx, qs.QuadStore)
+}
+
+func (qs *QuadStore) ExpireQuads(ctx context.Context, now time.Time) ([]quad.Quad, error) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	deleted, err := graph.ExpireQuads(ctx, qs.QuadStore, now)
+	if err != nil {
+		return deleted, err
+	}
+	var removed []quad.Value
+	for _, q := range deleted {
+		for _, dir := range quad.Directions {
+			removed = append(removed, q.Get(dir))
+		}
+	}
+	return deleted, qs.removeUnused(ctx, removed)
+}
+
+func (qs *QuadStore) ListLabels(ctx context.Context) ([]quad.Value, error) {
+	return graph.ListLabels(ctx, qs.QuadStore)
+}
+
+func (qs *QuadStore) DropLabel(ctx context.Context, label quad.Value) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	removed, err := qs.labelLiterals(ctx, label)
+	if err != nil {
+		return err
+	}
+	if err = graph.DropLabel(ctx, qs.QuadStore, label); err != nil {
+		return err
+	}
+	return qs.removeUnused(ctx, removed)
+}
+
+// addLabel indexes the label of a graph that quads were copied or moved to.
+func (qs *QuadStore) addLabel(ctx context.Context, to quad.Value) error {
+	if _, _, ok := Literal(to); !ok {
+		return nil
+	} else if ok, err := qs.used(ctx, to); err != nil || !ok {
+		return err
+	}
+	return qs.idx.Add(ctx, to)
+}
+
+func (qs *QuadStore) CopyLabel(ctx context.Context, from, to quad.Value) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	// all other values of copied quads are already indexed
+	if err := graph.CopyLabel(ctx, qs.QuadStore, from, to); err != nil {
+		return err
+	}
+	return qs.addLabel(ctx, to)
+}
+
+func (qs *QuadStore) MoveLabel(ctx context.Context, from, to quad.Value) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	// only the label of the source graph might be removed from the store
+	if err := graph.MoveLabel(ctx, qs.QuadStore, from, to); err != nil {
+		return err
+	}
+	if err := qs.addLabel(ctx, to); err != nil {
+		return err
+	}
+	return qs.removeUnused(ctx, []quad.Value{from})
+}
+
+// watchingQuadStore is returned by Wrap for QuadStores that support change feeds.
+type watchingQuadStore struct {
+	*QuadStore
+}
+
+func (qs watchingQuadStore) Watch(ctx context.Context, from int64) (graph.ChangeFeed, error) {
+	return graph.Watch(ctx, qs.QuadStore.QuadStore, from)
+}
+
+// quadWriter indexes literals of all written quads when the batch is committed.
+type quadWriter struct {
+	qs   *QuadStore
+	w    quad.WriteCloser
+	vals map[quad.Value]struct{}
+}
+
+func (w *quadWriter) add(q quad.Quad) {
+	for _, dir := range quad.Directions {
+		v := q.Get(dir)
+		if _, _, ok := Literal(v); ok {
+			w.vals[v] = struct{}{}
+		}
+	}
+}
+
+func (w *quadWriter) WriteQuad(q quad.Quad) error {
+	if err := w.w.WriteQuad(q); err != nil {
+		return err
+	}
+	w.add(q)
+	return nil
+}
+
+func (w *quadWriter) WriteQuads(buf []quad.Quad) (int, error) {
+	n, err := w.w.WriteQuads(buf)
+	for _, q := range buf[:n] {
+		w.add(q)
+	}
+	return n, err
+}
+
+func (w *quadWriter) Close() error {
+	if err := w.w.Close(); err != nil {
+		return err
+	}
+	ctx := context.TODO()
+	for v := range w.vals {
+		if err := w.qs.idx.Add(ctx, v); err != nil {
+			return err
+		}
+	}
+	w.vals = nil
+	return nil
+}
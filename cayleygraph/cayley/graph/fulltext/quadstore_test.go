@@ -0,0 +1,203 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fulltext_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/fulltext"
+	"github.com/cayleygraph/cayley/graph/kv"
+	"github.com/cayleygraph/cayley/graph/kv/btree"
+	"github.com/cayleygraph/cayley/graph/memstore"
+	"github.com/cayleygraph/cayley/query/path"
+	"github.com/cayleygraph/cayley/query/shape"
+	"github.com/cayleygraph/quad"
+)
+
+var searchQuads = []quad.Quad{
+	{Subject: quad.IRI("alice"), Predicate: quad.IRI("status"), Object: quad.String("cool person")},
+	{Subject: quad.IRI("bob"), Predicate: quad.IRI("status"), Object: quad.String("cool cool cool")},
+	{Subject: quad.IRI("bob"), Predicate: quad.IRI("name"), Object: quad.String("Bob the cool")},
+	{Subject: quad.IRI("charlie"), Predicate: quad.IRI("status"), Object: quad.LangString{Value: "sleeping", Lang: "en"}},
+}
+
+func search(t testing.TB, qs graph.QuadStore, p *path.Path) []quad.Value {
+	vals, err := p.Iterate(context.TODO()).AllValues(qs)
+	require.NoError(t, err)
+	return vals
+}
+
+func TestQuadStore(t *testing.T) {
+	ctx := context.TODO()
+	qs, err := fulltext.Wrap(ctx, memstore.New(searchQuads...), fulltext.NewMemIndex())
+	require.NoError(t, err)
+	defer qs.Close()
+	_, ok := qs.(graph.Watcher)
+	require.True(t, ok, "change feeds should be supported")
+
+	// index is built from existing data
+	require.Equal(t, []quad.Value{
+		quad.String("cool cool cool"),
+		quad.String("cool person"),
+		quad.String("Bob the cool"),
+	}, search(t, qs, path.StartPath(qs).Search("cool")))
+
+	// search is limited to the values of the path
+	require.Equal(t, []quad.Value{
+		quad.String("cool cool cool"),
+		quad.String("cool person"),
+	}, search(t, qs, path.StartPath(qs).Out(quad.IRI("status")).Search("cool")))
+	require.ElementsMatch(t, []quad.Value{
+		quad.IRI("bob"),
+		quad.IRI("alice"),
+	}, search(t, qs, path.StartPath(qs).Out(quad.IRI("status")).Search("cool").In(quad.IRI("status"))))
+
+	// stemming
+	require.Equal(t, []quad.Value{
+		quad.LangString{Value: "sleeping", Lang: "en"},
+	}, search(t, qs, path.StartPath(qs).Search("sleeps")))
+
+	// index is updated by transactions
+	err = qs.ApplyDeltas([]graph.Delta{
+		{Action: graph.Delete, Quad: searchQuads[0]},
+		{Action: graph.Delete, Quad: searchQuads[1]},
+		{Action: graph.Add, Quad: quad.Make(quad.IRI("alice"), quad.IRI("name"), quad.String("cool person"), nil)},
+		{Action: graph.Add, Quad: quad.Make(quad.IRI("dani"), quad.IRI("status"), quad.String("cool and sleepy"), nil)},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+	require.Equal(t, []quad.Value{
+		quad.String("cool person"),
+		quad.String("Bob the cool"),
+		quad.String("cool and sleepy"),
+	}, search(t, qs, path.StartPath(qs).Search("cool")))
+
+	// quad writers update the index when they are closed
+	qw, err := qs.NewQuadWriter()
+	require.NoError(t, err)
+	_, err = qw.WriteQuads([]quad.Quad{
+		quad.Make(quad.IRI("eve"), quad.IRI("status"), quad.String("a ghost"), nil),
+	})
+	require.NoError(t, err)
+	require.NoError(t, qw.Close())
+	require.Equal(t, []quad.Value{
+		quad.String("a ghost"),
+	}, search(t, qs, path.StartPath(qs).Search("ghost")))
+}
+
+func TestLabels(t *testing.T) {
+	ctx := context.TODO()
+	idx := fulltext.NewMemIndex()
+	qs, err := fulltext.Wrap(ctx, memstore.New(
+		quad.Make(quad.IRI("alice"), quad.IRI("status"), quad.String("cool person"), quad.String("cool graph")),
+		quad.Make(quad.IRI("bob"), quad.IRI("status"), quad.String("cool person"), nil),
+		quad.Make(quad.IRI("bob"), quad.IRI("name"), quad.String("Bob the cool"), quad.String("cool graph")),
+	), idx)
+	require.NoError(t, err)
+	defer qs.Close()
+
+	expect := func(exp ...quad.Value) {
+		t.Helper()
+		hits, err := idx.Search(ctx, "cool", "")
+		require.NoError(t, err)
+		var got []quad.Value
+		for _, h := range hits {
+			got = append(got, h.Value)
+		}
+		require.ElementsMatch(t, exp, got)
+	}
+	expect(quad.String("cool person"), quad.String("cool graph"), quad.String("Bob the cool"))
+
+	require.NoError(t, graph.CopyLabel(ctx, qs, quad.String("cool graph"), quad.String("cool copy")))
+	expect(quad.String("cool person"), quad.String("cool graph"), quad.String("cool copy"), quad.String("Bob the cool"))
+
+	require.NoError(t, graph.MoveLabel(ctx, qs, quad.String("cool graph"), quad.IRI("g")))
+	expect(quad.String("cool person"), quad.String("cool copy"), quad.String("Bob the cool"))
+
+	// literals used by other graphs are kept
+	require.NoError(t, graph.DropLabel(ctx, qs, quad.String("cool copy")))
+	expect(quad.String("cool person"), quad.String("Bob the cool"))
+	require.NoError(t, graph.DropLabel(ctx, qs, quad.IRI("g")))
+	expect(quad.String("cool person"))
+}
+
+func TestExpire(t *testing.T) {
+	ctx := context.TODO()
+	idx := fulltext.NewMemIndex()
+	qs, err := fulltext.Wrap(ctx, memstore.New(searchQuads...), idx)
+	require.NoError(t, err)
+	defer qs.Close()
+
+	now := time.Now()
+	err = qs.ApplyDeltas([]graph.Delta{
+		{Action: graph.Add, Quad: quad.Make(quad.IRI("dani"), quad.IRI("status"), quad.String("ghost"), nil), Expires: now},
+		{Action: graph.Add, Quad: quad.Make(quad.IRI("eve"), quad.IRI("status"), quad.String("cool person"), nil), Expires: now},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+	hits, err := idx.Search(ctx, "ghost", "")
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+
+	deleted, err := graph.ExpireQuads(ctx, qs, now.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+	hits, err = idx.Search(ctx, "ghost", "")
+	require.NoError(t, err)
+	require.Empty(t, hits)
+	// the literal is still used by another quad
+	hits, err = idx.Search(ctx, "person", "")
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+}
+
+func TestBackendShapes(t *testing.T) {
+	ctx := context.TODO()
+	db := btree.New()
+	require.NoError(t, kv.Init(db, nil))
+	kqs, err := kv.New(db, nil)
+	require.NoError(t, err)
+	var deltas []graph.Delta
+	for _, q := range searchQuads {
+		deltas = append(deltas, graph.Delta{Action: graph.Add, Quad: q})
+	}
+	require.NoError(t, kqs.ApplyDeltas(deltas, graph.IgnoreOpts{}))
+
+	qs, err := fulltext.Wrap(ctx, kqs, fulltext.NewMemIndex())
+	require.NoError(t, err)
+	defer qs.Close()
+	require.Equal(t, kqs, graph.Base(qs))
+
+	// the backend optimizes shapes of the wrapper in the same way
+	s := path.StartPath(qs).Out(quad.IRI("status")).Shape()
+	exp, ok := shape.Optimize(ctx, s, kqs)
+	require.True(t, ok)
+	got, _ := shape.Optimize(ctx, s, qs)
+	require.Equal(t, exp, got)
+
+	require.ElementsMatch(t, []quad.Value{
+		quad.IRI("bob"),
+		quad.IRI("alice"),
+	}, search(t, qs, path.StartPath(qs).Out(quad.IRI("status")).Search("cool").In(quad.IRI("status"))))
+}
+
+func TestNoIndex(t *testing.T) {
+	qs := memstore.New(searchQuads...)
+	_, err := path.StartPath(qs).Search("cool").Iterate(context.TODO()).All()
+	require.Equal(t, graph.ErrOperationNotSupported, err)
+}
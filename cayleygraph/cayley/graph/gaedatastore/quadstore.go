@@ -209,6 +209,9 @@ func (qs *QuadStore) ApplyDeltas(in []graph.Delta, ignoreOpts graph.IgnoreOpts)
 	if qs.context == nil {
 		return errors.New("No context, graph not correctly initialised")
 	}
+	if err := graph.CheckNoExpiry(in); err != nil {
+		return err
+	}
 	toKeep := make([]graph.Delta, 0)
 	for _, d := range in {
 		if d.Action != graph.Add && d.Action != graph.Delete {
@@ -35,6 +35,8 @@ type Config struct {
 
 	SkipDeletedFromIterator  bool
 	SkipSizeCheckAfterDelete bool
+
+	UniqueTriples bool // the same triple cannot be stored in multiple graphs
 }
 
 var graphTests = []struct {
@@ -59,6 +61,11 @@ var graphTests = []struct {
 	{"schema", TestSchema},
 	{"delete reinserted", TestDeleteReinserted},
 	{"delete reinserted dup", TestDeleteReinsertedDup},
+	{"snapshot", TestSnapshot},
+	{"as of", TestAsOf},
+	{"watch", TestWatch},
+	{"labels", TestLabels},
+	{"preconditions", TestPreconditions},
 }
 
 func TestAll(t *testing.T, gen testutil.DatabaseFunc, conf *Config) {
@@ -1308,6 +1315,358 @@ func TestDeleteReinsertedDup(t testing.TB, gen testutil.DatabaseFunc, _ *Config)
 	}
 }
 
+func TestSnapshot(t testing.TB, gen testutil.DatabaseFunc, _ *Config) {
+	qs, opts, closer := gen(t)
+	defer closer()
+
+	w := testutil.MakeWriter(t, qs, opts, MakeQuadSet()...)
+
+	ctx := context.TODO()
+	sn, err := graph.Snapshot(ctx, qs)
+	if err == graph.ErrOperationNotSupported {
+		t.SkipNow()
+	}
+	require.NoError(t, err)
+	defer sn.Close()
+
+	err = w.RemoveQuad(quad.Make("E", "follows", "F", nil))
+	require.NoError(t, err)
+	err = w.AddQuad(quad.Make("E", "follows", "H", nil))
+	require.NoError(t, err)
+
+	// snapshot must not observe any changes
+	ExpectIteratedQuads(t, sn, sn.QuadsAllIterator(), MakeQuadSet(), true)
+
+	v, err := sn.ValueOf(quad.String("E"))
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	ExpectIteratedQuads(t, sn, sn.QuadIterator(quad.Subject, v), []quad.Quad{
+		quad.Make("E", "follows", "F", nil),
+	}, false)
+
+	st, err := sn.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(MakeQuadSet())), st.Quads.Value)
+
+	err = sn.ApplyDeltas([]graph.Delta{
+		{Quad: quad.Make("E", "follows", "G", nil), Action: graph.Add},
+	}, graph.IgnoreOpts{})
+	require.Equal(t, graph.ErrReadOnly, err)
+
+	// while the store itself must
+	v, err = qs.ValueOf(quad.String("E"))
+	require.NoError(t, err)
+	ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, v), []quad.Quad{
+		quad.Make("E", "follows", "H", nil),
+	}, false)
+}
+
+func TestAsOf(t testing.TB, gen testutil.DatabaseFunc, _ *Config) {
+	qs, opts, closer := gen(t)
+	defer closer()
+
+	ctx := context.TODO()
+	if _, err := graph.AsOf(ctx, qs, time.Now()); err == graph.ErrOperationNotSupported {
+		t.SkipNow()
+	}
+
+	// make sure timestamps of primitives are distinct from the time of the view
+	tick := func() time.Time {
+		time.Sleep(10 * time.Millisecond)
+		now := time.Now()
+		time.Sleep(10 * time.Millisecond)
+		return now
+	}
+
+	w := testutil.MakeWriter(t, qs, opts,
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+	)
+	t1 := tick()
+
+	// node C is removed as well
+	err := w.RemoveQuad(quad.Make("A", "follows", "C", nil))
+	require.NoError(t, err)
+	err = w.AddQuad(quad.Make("A", "follows", "D", nil))
+	require.NoError(t, err)
+	t2 := tick()
+
+	// node C is created again
+	err = w.AddQuad(quad.Make("A", "follows", "C", nil))
+	require.NoError(t, err)
+
+	expect := func(t testing.TB, qs graph.QuadStore, exp []quad.Quad, nodes int) {
+		ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), exp, true)
+
+		v, err := qs.ValueOf(quad.String("A"))
+		require.NoError(t, err)
+		require.NotNil(t, v)
+		ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, v), exp, true)
+
+		for _, q := range exp {
+			v, err = qs.ValueOf(q.Object)
+			require.NoError(t, err)
+			require.NotNil(t, v, "%v", q.Object)
+			ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Object, v), []quad.Quad{q}, false)
+		}
+
+		st, err := qs.Stats(ctx, true)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(exp)), st.Quads.Value)
+		require.Equal(t, int64(nodes), st.Nodes.Value)
+	}
+
+	v1, err := graph.AsOf(ctx, qs, t1)
+	require.NoError(t, err)
+	defer v1.Close()
+	expect(t, v1, []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+	}, 4)
+
+	v2, err := graph.AsOf(ctx, qs, t2)
+	require.NoError(t, err)
+	defer v2.Close()
+	expect(t, v2, []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "D", nil),
+	}, 4)
+
+	v, err := v2.ValueOf(quad.String("C"))
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	expect(t, qs, []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+		quad.Make("A", "follows", "D", nil),
+	}, 5)
+
+	err = v1.ApplyDeltas([]graph.Delta{
+		{Quad: quad.Make("A", "follows", "E", nil), Action: graph.Add},
+	}, graph.IgnoreOpts{})
+	require.Equal(t, graph.ErrReadOnly, err)
+}
+
+func TestWatch(t testing.TB, gen testutil.DatabaseFunc, _ *Config) {
+	qs, opts, closer := gen(t)
+	defer closer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	w := testutil.MakeWriter(t, qs, opts,
+		quad.Make("A", "follows", "B", nil),
+	)
+
+	feed, err := graph.Watch(ctx, qs, -1)
+	if err == graph.ErrOperationNotSupported {
+		t.SkipNow()
+	}
+	require.NoError(t, err)
+	defer feed.Close()
+
+	err = w.AddQuadSet([]quad.Quad{
+		quad.Make("A", "follows", "C", nil),
+		quad.Make("A", "follows", "D", nil),
+	})
+	require.NoError(t, err)
+	err = w.RemoveQuad(quad.Make("A", "follows", "B", nil))
+	require.NoError(t, err)
+
+	require.True(t, feed.Next(ctx), "%v", feed.Err())
+	c1 := feed.Result()
+	require.Equal(t, []graph.Delta{
+		{Quad: quad.Make("A", "follows", "C", nil), Action: graph.Add},
+		{Quad: quad.Make("A", "follows", "D", nil), Action: graph.Add},
+	}, c1.Deltas)
+
+	require.True(t, feed.Next(ctx), "%v", feed.Err())
+	c2 := feed.Result()
+	require.True(t, c2.Position > c1.Position)
+	require.Equal(t, []graph.Delta{
+		{Quad: quad.Make("A", "follows", "B", nil), Action: graph.Delete},
+	}, c2.Deltas)
+
+	// resume after the first batch
+	feed2, err := graph.Watch(ctx, qs, c1.Position)
+	require.NoError(t, err)
+	defer feed2.Close()
+	require.True(t, feed2.Next(ctx), "%v", feed2.Err())
+	require.Equal(t, c2, feed2.Result())
+
+	// start from the beginning
+	feed3, err := graph.Watch(ctx, qs, 0)
+	require.NoError(t, err)
+	defer feed3.Close()
+	require.True(t, feed3.Next(ctx), "%v", feed3.Err())
+	require.Equal(t, []graph.Delta{
+		{Quad: quad.Make("A", "follows", "B", nil), Action: graph.Add},
+	}, feed3.Result().Deltas)
+	require.True(t, feed3.Next(ctx), "%v", feed3.Err())
+	require.Equal(t, c1, feed3.Result())
+
+	// feed must block until the next change is committed
+	sctx, scancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	require.False(t, feed2.Next(sctx))
+	scancel()
+	require.Equal(t, context.DeadlineExceeded, feed2.Err())
+
+	err = w.AddQuad(quad.Make("A", "follows", "E", nil))
+	require.NoError(t, err)
+	require.True(t, feed.Next(ctx), "%v", feed.Err())
+	require.Equal(t, []graph.Delta{
+		{Quad: quad.Make("A", "follows", "E", nil), Action: graph.Add},
+	}, feed.Result().Deltas)
+}
+
+func TestLabels(t testing.TB, gen testutil.DatabaseFunc, conf *Config) {
+	qs, opts, closer := gen(t)
+	defer closer()
+
+	g1, g2, g3 := quad.IRI("g1"), quad.IRI("g2"), quad.IRI("g3")
+	testutil.MakeWriter(t, qs, opts,
+		quad.Make("A", "follows", "B", g1),
+		quad.Make("B", "follows", "C", g1),
+		quad.Make("C", "follows", "D", nil),
+		quad.Make("D", "follows", "E", g2),
+	)
+
+	ctx := context.TODO()
+	expectLabels := func(exp ...quad.Value) {
+		labels, err := graph.ListLabels(ctx, qs)
+		require.NoError(t, err)
+		if len(exp) == 0 {
+			require.Empty(t, labels)
+		} else {
+			require.Equal(t, exp, labels)
+		}
+	}
+	expectQuads := func(exp ...quad.Quad) {
+		ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), exp, true)
+	}
+	expectLabels(g1, g2)
+
+	// same graph, or a graph that doesn't exist
+	require.NoError(t, graph.MoveLabel(ctx, qs, g1, g1))
+	require.NoError(t, graph.CopyLabel(ctx, qs, g3, g1))
+	require.NoError(t, graph.DropLabel(ctx, qs, g3))
+	expectLabels(g1, g2)
+
+	exp := []quad.Quad{
+		quad.Make("D", "follows", "E", g2),
+	}
+	if !conf.UniqueTriples {
+		require.NoError(t, graph.CopyLabel(ctx, qs, g1, g2))
+		// quads that already exist in the destination graph are skipped
+		require.NoError(t, graph.CopyLabel(ctx, qs, g1, g2))
+		exp = append(exp,
+			quad.Make("A", "follows", "B", g2),
+			quad.Make("B", "follows", "C", g2),
+		)
+		expectQuads(append([]quad.Quad{
+			quad.Make("A", "follows", "B", g1),
+			quad.Make("B", "follows", "C", g1),
+			quad.Make("C", "follows", "D", nil),
+		}, exp...)...)
+	}
+
+	require.NoError(t, graph.MoveLabel(ctx, qs, g1, g3))
+	require.NoError(t, graph.MoveLabel(ctx, qs, nil, g3))
+	exp = append(exp,
+		quad.Make("A", "follows", "B", g3),
+		quad.Make("B", "follows", "C", g3),
+		quad.Make("C", "follows", "D", g3),
+	)
+	expectQuads(exp...)
+	expectLabels(g2, g3)
+
+	// when triples are not unique, g3 already contains some of the quads from g2
+	require.NoError(t, graph.MoveLabel(ctx, qs, g2, g3))
+	expectQuads(
+		quad.Make("A", "follows", "B", g3),
+		quad.Make("B", "follows", "C", g3),
+		quad.Make("C", "follows", "D", g3),
+		quad.Make("D", "follows", "E", g3),
+	)
+	expectLabels(g3)
+
+	require.NoError(t, graph.MoveLabel(ctx, qs, g3, nil))
+	expectQuads(
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("B", "follows", "C", nil),
+		quad.Make("C", "follows", "D", nil),
+		quad.Make("D", "follows", "E", nil),
+	)
+	expectLabels()
+
+	require.NoError(t, graph.DropLabel(ctx, qs, nil))
+	expectQuads()
+	if !conf.SkipSizeCheckAfterDelete {
+		// nodes without references must be removed as well; some backends return refs
+		// for values that are not stored, thus check the list of all nodes instead of ValueOf
+		ExpectIteratedValues(t, qs, qs.NodesAllIterator(), nil, false)
+	}
+}
+
+func TestPreconditions(t testing.TB, gen testutil.DatabaseFunc, conf *Config) {
+	ctx := context.TODO()
+	qs, opts, closer := gen(t)
+	defer closer()
+
+	if _, ok := graph.Unwrap(qs).(graph.ConditionalWriter); !ok {
+		t.SkipNow()
+	}
+	testutil.MakeWriter(t, qs, opts, MakeQuadSet()...)
+
+	add := func(q quad.Quad) []graph.Delta {
+		return []graph.Delta{{Quad: q, Action: graph.Add}}
+	}
+
+	if ver, err := graph.Version(ctx, qs); err != graph.ErrOperationNotSupported {
+		require.NoError(t, err)
+
+		// two writers read the same version, only the first one wins
+		cond := graph.VersionEquals{Version: ver}
+		err = graph.ApplyDeltasIf(qs, []graph.Precondition{cond}, add(quad.Make("A", "status", "cool", nil)), graph.IgnoreOpts{})
+		require.NoError(t, err)
+		err = graph.ApplyDeltasIf(qs, []graph.Precondition{cond}, add(quad.Make("A", "status", "smart", nil)), graph.IgnoreOpts{})
+		require.True(t, graph.IsPreconditionFailed(err), "%v", err)
+		require.Equal(t, cond, err.(*graph.PreconditionError).Precondition)
+		if ref, err := qs.ValueOf(quad.String("smart")); err == nil && ref != nil {
+			ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Object, ref), nil, false)
+		}
+	}
+
+	for _, c := range []struct {
+		cond graph.Precondition
+		ok   bool
+	}{
+		{graph.QuadExists{Quad: quad.Make("A", "follows", "B", nil)}, true},
+		{graph.QuadExists{Quad: quad.Make("A", "follows", "Z", nil)}, false},
+		{graph.QuadExists{Quad: quad.Make("B", "status", "cool", nil)}, false},
+		{graph.QuadExists{Quad: quad.Make("B", "status", "cool", "status_graph")}, true},
+		{graph.QuadNotExists{Quad: quad.Make("A", "follows", "Z", nil)}, true},
+		{graph.QuadNotExists{Quad: quad.Make("A", "follows", "B", nil)}, false},
+		{graph.NoLinks{Node: quad.String("A"), Dir: quad.Subject, Via: quad.String("follows")}, false},
+		{graph.NoLinks{Node: quad.String("A"), Dir: quad.Object, Via: quad.String("follows")}, true},
+		{graph.NoLinks{Node: quad.String("A"), Dir: quad.Subject, Via: quad.String("unknown")}, true},
+		{graph.NoLinks{Node: quad.String("B"), Dir: quad.Subject}, false},
+		{graph.NoLinks{Node: quad.String("Z"), Dir: quad.Subject}, true},
+		{graph.NoLinks{Node: quad.String("status_graph"), Dir: quad.Label}, false},
+		{graph.NoLinks{Node: quad.String("follows"), Dir: quad.Label}, true},
+	} {
+		q := quad.Make("X", "checked", "Y", nil)
+		err := graph.ApplyDeltasIf(qs, []graph.Precondition{c.cond}, add(q), graph.IgnoreOpts{})
+		if c.ok {
+			require.NoError(t, err, "%v", c.cond)
+			require.NoError(t, qs.ApplyDeltas([]graph.Delta{{Quad: q, Action: graph.Delete}}, graph.IgnoreOpts{}))
+		} else {
+			require.True(t, graph.IsPreconditionFailed(err), "%v: %v", c.cond, err)
+		}
+	}
+}
+
 func irif(format string, args ...interface{}) quad.IRI {
 	return quad.IRI(fmt.Sprintf(format, args...))
 }
@@ -50,6 +50,10 @@ type HasA struct {
 	qs      QuadIndexer
 	primary iterator.Shape
 	dir     quad.Direction
+
+	// pred is a predicate of all quads of the subiterator, if known
+	pred  Ref
+	stats *PredicateStats
 }
 
 // NewHasA construct a new HasA iterator, given the quad subiterator, and the quad
@@ -62,6 +66,14 @@ func NewHasA(qs QuadIndexer, subIt iterator.Shape, d quad.Direction) *HasA {
 	}
 }
 
+// NewHasAWithPredicate is the same as NewHasA, but all quads of the subiterator must have a given predicate.
+// Per-predicate statistics of the QuadStore are then used to estimate the size and the fanout (see StatsProvider).
+func NewHasAWithPredicate(qs QuadIndexer, subIt iterator.Shape, d quad.Direction, pred Ref) *HasA {
+	it := NewHasA(qs, subIt, d)
+	it.pred = pred
+	return it
+}
+
 func (it *HasA) Iterate() iterator.Scanner {
 	return newHasANext(it.qs, it.primary.Iterate(), it.dir)
 }
@@ -109,6 +121,10 @@ func (it *HasA) Stats(ctx context.Context) (iterator.Costs, error) {
 	fanoutFactor := int64(30)
 	nextConstant := int64(2)
 	quadConstant := int64(1)
+	if quads, nodes := it.predicateFanout(ctx); nodes > 0 {
+		// each node has quads/nodes quads with the predicate on average
+		fanoutFactor = (quads + nodes - 1) / nodes
+	}
 	return iterator.Costs{
 		NextCost:     quadConstant + subitStats.NextCost,
 		ContainsCost: (fanoutFactor * nextConstant) * subitStats.ContainsCost,
@@ -119,6 +135,29 @@ func (it *HasA) Stats(ctx context.Context) (iterator.Costs, error) {
 	}, err
 }
 
+// predicateFanout returns the number of quads with the predicate of the iterator, and the number of distinct
+// nodes in its direction. The number of nodes is zero if either of them is not known.
+func (it *HasA) predicateFanout(ctx context.Context) (quads, nodes int64) {
+	if it.pred == nil || (it.dir != quad.Subject && it.dir != quad.Object) {
+		return 0, 0
+	}
+	if it.stats == nil {
+		st, err := GetPredicateStats(ctx, it.qs, it.pred)
+		if err != nil {
+			st = PredicateStats{}
+		}
+		it.stats = &st
+	}
+	quads, nodes = it.stats.Quads.Value, it.stats.Subjects.Value
+	if it.dir == quad.Object {
+		nodes = it.stats.Objects.Value
+	}
+	if quads <= 0 {
+		return 0, 0
+	}
+	return quads, nodes
+}
+
 // A HasA consists of a reference back to the graph.QuadStore that it references,
 // a primary subiterator, a direction in which the quads for that subiterator point,
 // and a temporary holder for the iterator generated on Contains().
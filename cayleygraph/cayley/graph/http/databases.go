@@ -0,0 +1,440 @@
+package httpgraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/writer"
+)
+
+const (
+	// DatabaseHeader is a request header that selects a database by name.
+	DatabaseHeader = "X-Cayley-Database"
+	// DatabasePrefix is a path prefix that selects a database by name, for example "/db/name/api/v2/query".
+	// The prefix and the name are removed from the path before the request is served.
+	DatabasePrefix = "/db/"
+)
+
+// ErrUnknownDatabase is returned when a request selects a database that is not configured.
+var ErrUnknownDatabase = errors.New("unknown database")
+
+var errClosed = errors.New("databases are closed")
+
+// Database is a configuration of a named database.
+type Database struct {
+	Name    string
+	Backend string
+	Address string
+	Options graph.Options
+	// Init enables initialization of the database when it is opened for the first time.
+	Init bool
+	// Replication is the name of the writer used for the database. Default is "single".
+	Replication        string
+	ReplicationOptions graph.Options
+}
+
+// ParseDatabases reads a list of databases from the configuration value.
+// Each database is an object with "name", "backend", "address", "options", "init", "replication"
+// and "replication_options" fields.
+func ParseDatabases(v interface{}) ([]Database, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("databases must be a list, got %T", v)
+	}
+	out := make([]Database, 0, len(list))
+	seen := make(map[string]struct{}, len(list))
+	for i, o := range list {
+		opt, ok := graph.AsOptions(o)
+		if !ok {
+			return nil, fmt.Errorf("invalid database %d: %T", i, o)
+		}
+		var (
+			db  Database
+			err error
+		)
+		if db.Name, err = opt.StringKey("name", ""); err != nil {
+			return nil, err
+		} else if db.Name == "" || strings.Contains(db.Name, "/") {
+			return nil, fmt.Errorf("invalid name of database %d: %q", i, db.Name)
+		} else if _, ok := seen[db.Name]; ok {
+			return nil, fmt.Errorf("duplicate database: %q", db.Name)
+		}
+		seen[db.Name] = struct{}{}
+		if db.Backend, err = opt.StringKey("backend", ""); err != nil {
+			return nil, err
+		} else if !graph.IsRegistered(db.Backend) {
+			return nil, fmt.Errorf("unknown backend for database %q: %q", db.Name, db.Backend)
+		}
+		if db.Address, err = opt.StringKey("address", ""); err != nil {
+			return nil, err
+		}
+		if db.Init, err = opt.BoolKey("init", false); err != nil {
+			return nil, err
+		}
+		if o, ok := opt["options"]; ok {
+			if db.Options, ok = graph.AsOptions(o); !ok {
+				return nil, fmt.Errorf("invalid options for database %q: %T", db.Name, o)
+			}
+		}
+		if db.Replication, err = opt.StringKey("replication", defaultReplication); err != nil {
+			return nil, err
+		} else if !isWriterRegistered(db.Replication) {
+			return nil, fmt.Errorf("unknown replication for database %q: %q", db.Name, db.Replication)
+		}
+		if o, ok := opt["replication_options"]; ok {
+			if db.ReplicationOptions, ok = graph.AsOptions(o); !ok {
+				return nil, fmt.Errorf("invalid replication options for database %q: %T", db.Name, o)
+			}
+		}
+		out = append(out, db)
+	}
+	return out, nil
+}
+
+const defaultReplication = "single"
+
+func isWriterRegistered(name string) bool {
+	for _, n := range graph.WriterMethods() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+var _ Handles = (*Databases)(nil)
+
+// Databases hosts a default database and a set of named databases. Named databases are opened on the first
+// request and are closed after they were not used for a given time.
+//
+// Requests select a named database by the DatabasePrefix of the path or by the DatabaseHeader, and must be
+// served through the Handler. Other requests, as well as the methods of graph.QuadStore, use the default database.
+// Each named database has its own writer; the writer of the default database is owned by the caller.
+type Databases struct {
+	graph.QuadStore // default database
+	idle            time.Duration
+	reap            time.Duration
+
+	mu     sync.Mutex
+	dbs    map[string]*database
+	stop   chan struct{}
+	closed bool
+}
+
+type database struct {
+	conf Database
+	open sync.Mutex // held while the database is opened
+
+	// protected by Databases.mu
+	h      *graph.Handle // nil if closed
+	reaper func()        // stops the reaper of an open database; nil if it's not running
+	active int           // number of requests in flight
+	used   time.Time     // end of the last request
+}
+
+// NewDatabases creates a set of databases with a given default database. Named databases that were idle for
+// a given time are closed; zero disables it.
+func NewDatabases(def graph.QuadStore, dbs []Database, idle time.Duration) *Databases {
+	d := &Databases{
+		QuadStore: def,
+		idle:      idle,
+		dbs:       make(map[string]*database, len(dbs)),
+		stop:      make(chan struct{}),
+	}
+	for _, db := range dbs {
+		d.dbs[db.Name] = &database{conf: db}
+	}
+	if idle > 0 {
+		go d.closeIdleLoop()
+	}
+	return d
+}
+
+// SetReapInterval enables deletion of expired quads in named databases with a given interval, see graph.RunReaper.
+// Replicas are not reaped, since they receive deletions from the primary. Zero disables it.
+// It affects databases opened after the call.
+func (d *Databases) SetReapInterval(interval time.Duration) {
+	d.mu.Lock()
+	d.reap = interval
+	d.mu.Unlock()
+}
+
+// Names returns names of all configured databases, except the default one.
+func (d *Databases) Names() []string {
+	names := make([]string, 0, len(d.dbs))
+	for name := range d.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open reports whether a named database is open now.
+func (d *Databases) Open(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	db := d.dbs[name]
+	return db != nil && db.h != nil
+}
+
+// acquire opens a named database if necessary and prevents it from being closed until release is called.
+func (d *Databases) acquire(name string) (*graph.Handle, func(), error) {
+	d.mu.Lock()
+	db := d.dbs[name]
+	if db == nil {
+		d.mu.Unlock()
+		return nil, nil, ErrUnknownDatabase
+	} else if d.closed {
+		d.mu.Unlock()
+		return nil, nil, errClosed
+	}
+	db.active++
+	d.mu.Unlock()
+	release := func() {
+		d.mu.Lock()
+		db.active--
+		db.used = time.Now()
+		d.mu.Unlock()
+	}
+
+	db.open.Lock()
+	defer db.open.Unlock()
+	d.mu.Lock()
+	h := db.h
+	d.mu.Unlock()
+	if h != nil {
+		return h, release, nil
+	}
+	h, err := d.openDatabase(db.conf)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		h.Close()
+		release()
+		return nil, nil, errClosed
+	}
+	db.h = h
+	if d.reap > 0 {
+		db.reaper = startReaper(name, h, d.reap)
+	}
+	d.mu.Unlock()
+	clog.Infof("opened database %q", name)
+	return h, release, nil
+}
+
+func (d *Databases) openDatabase(c Database) (*graph.Handle, error) {
+	qs, err := graph.NewQuadStore(c.Backend, c.Address, c.Options)
+	if err == graph.ErrNotInitialized && c.Init {
+		if err = graph.InitQuadStore(c.Backend, c.Address, c.Options); err != nil {
+			return nil, err
+		}
+		qs, err = graph.NewQuadStore(c.Backend, c.Address, c.Options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	wtyp := c.Replication
+	if wtyp == "" {
+		wtyp = defaultReplication
+	}
+	// replicas report metrics for each database
+	wopt := graph.Options{"database": c.Name}
+	for k, v := range c.ReplicationOptions {
+		wopt[k] = v
+	}
+	qw, err := graph.NewQuadWriter(wtyp, qs, wopt)
+	if err != nil {
+		qs.Close()
+		return nil, err
+	}
+	return &graph.Handle{QuadStore: qs, QuadWriter: qw}, nil
+}
+
+// startReaper deletes expired quads from an open database until the returned function is called.
+// The function waits for the reaper to stop.
+func startReaper(name string, h *graph.Handle, interval time.Duration) func() {
+	if _, ok := h.QuadWriter.(*writer.Replica); ok {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := graph.RunReaper(ctx, h.QuadStore, interval)
+		if err != nil && err != context.Canceled && err != graph.ErrOperationNotSupported {
+			clog.Errorf("reaper of database %q stopped: %v", name, err)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// closeHandle stops the reaper of a database and closes it.
+func closeHandle(h *graph.Handle, reaper func()) error {
+	if reaper != nil {
+		reaper()
+	}
+	return h.Close()
+}
+
+// CloseIdle closes all named databases that were not used since a given time.
+func (d *Databases) CloseIdle(before time.Time) {
+	isIdle := func(db *database) bool {
+		return db.h != nil && db.active == 0 && db.used.Before(before)
+	}
+	var idle []*database
+	d.mu.Lock()
+	for _, db := range d.dbs {
+		if isIdle(db) {
+			idle = append(idle, db)
+		}
+	}
+	d.mu.Unlock()
+	for _, db := range idle {
+		// the database is closed before it can be opened again
+		db.open.Lock()
+		d.mu.Lock()
+		var (
+			h      *graph.Handle
+			reaper func()
+		)
+		if isIdle(db) {
+			h, db.h = db.h, nil
+			reaper, db.reaper = db.reaper, nil
+		}
+		d.mu.Unlock()
+		if h != nil {
+			if err := closeHandle(h, reaper); err != nil {
+				clog.Errorf("cannot close database %q: %v", db.conf.Name, err)
+			} else {
+				clog.Infof("closed idle database %q", db.conf.Name)
+			}
+		}
+		db.open.Unlock()
+	}
+}
+
+func (d *Databases) closeIdleLoop() {
+	t := time.NewTicker(d.idle / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now := <-t.C:
+			d.CloseIdle(now.Add(-d.idle))
+		}
+	}
+}
+
+type databaseKey struct{}
+
+// selectDatabase returns a name of the database selected by the request and the path with the database prefix removed.
+func selectDatabase(r *http.Request) (name, path string) {
+	path = r.URL.Path
+	if strings.HasPrefix(path, DatabasePrefix) {
+		name = path[len(DatabasePrefix):]
+		path = "/"
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name, path = name[:i], name[i:]
+		}
+		return name, path
+	}
+	return r.Header.Get(DatabaseHeader), path
+}
+
+// Handler returns a handler that opens the database selected by the request and serves the request with next.
+func (d *Databases) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, path := selectDatabase(r)
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h, release, err := d.acquire(name)
+		if err == ErrUnknownDatabase {
+			http.Error(w, fmt.Sprintf("%v: %q", err, name), http.StatusNotFound)
+			return
+		} else if err != nil {
+			clog.Errorf("cannot open database %q: %v", name, err)
+			http.Error(w, fmt.Sprintf("cannot open database %q", name), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		r = r.WithContext(context.WithValue(r.Context(), databaseKey{}, h))
+		if path != r.URL.Path {
+			u := *r.URL
+			u.Path, u.RawPath = path, ""
+			r.URL = &u
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ForRequest returns the database selected by the request, or the default database.
+// The database remains open until the request is served by the Handler.
+func (d *Databases) ForRequest(r *http.Request) (graph.QuadStore, error) {
+	if h, ok := d.HandleForRequest(r); ok {
+		return h.QuadStore, nil
+	}
+	return d.QuadStore, nil
+}
+
+// HandleForRequest returns the named database selected by the request together with its writer.
+// It returns false if the request uses the default database.
+func (d *Databases) HandleForRequest(r *http.Request) (*graph.Handle, bool) {
+	h, ok := r.Context().Value(databaseKey{}).(*graph.Handle)
+	return h, ok
+}
+
+// Default returns the default database.
+func (d *Databases) Default() graph.QuadStore {
+	return d.QuadStore
+}
+
+// Close closes all named databases. The default database is owned by the caller and is not closed.
+func (d *Databases) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	close(d.stop)
+	var (
+		handles []*graph.Handle
+		reapers []func()
+	)
+	for _, db := range d.dbs {
+		if db.h != nil {
+			handles = append(handles, db.h)
+			reapers = append(reapers, db.reaper)
+			db.h, db.reaper = nil, nil
+		}
+	}
+	d.mu.Unlock()
+	var last error
+	for i, h := range handles {
+		if err := closeHandle(h, reapers[i]); err != nil {
+			last = err
+		}
+	}
+	return last
+}
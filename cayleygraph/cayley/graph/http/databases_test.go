@@ -0,0 +1,162 @@
+package httpgraph_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/cayley/graph"
+	httpgraph "github.com/cayleygraph/cayley/graph/http"
+	"github.com/cayleygraph/cayley/graph/memstore"
+	cayleyhttp "github.com/cayleygraph/cayley/server/http"
+	"github.com/cayleygraph/cayley/writer"
+	"github.com/cayleygraph/quad"
+	_ "github.com/cayleygraph/quad/nquads"
+)
+
+func TestParseDatabases(t *testing.T) {
+	dbs, err := httpgraph.ParseDatabases([]interface{}{
+		map[interface{}]interface{}{"name": "a", "backend": "memstore"},
+		map[string]interface{}{"name": "b", "backend": "memstore", "address": "dir", "init": true,
+			"options": map[string]interface{}{"nosync": true}, "replication": "single",
+			"replication_options": map[string]interface{}{"ignore_missing": true}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []httpgraph.Database{
+		{Name: "a", Backend: "memstore", Replication: "single"},
+		{Name: "b", Backend: "memstore", Address: "dir", Init: true, Options: graph.Options{"nosync": true},
+			Replication: "single", ReplicationOptions: graph.Options{"ignore_missing": true}},
+	}, dbs)
+
+	_, err = httpgraph.ParseDatabases([]interface{}{
+		map[string]interface{}{"name": "a", "backend": "memstore"},
+		map[string]interface{}{"name": "a", "backend": "memstore"},
+	})
+	require.Error(t, err)
+	_, err = httpgraph.ParseDatabases([]interface{}{
+		map[string]interface{}{"name": "a", "backend": "unknown"},
+	})
+	require.Error(t, err)
+	_, err = httpgraph.ParseDatabases([]interface{}{
+		map[string]interface{}{"name": "a", "backend": "memstore", "replication": "unknown"},
+	})
+	require.Error(t, err)
+}
+
+func TestDatabases(t *testing.T) {
+	ctx := context.TODO()
+	dir, err := ioutil.TempDir("", "cayley_test_databases")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	def := memstore.New(quad.MakeRaw("a", "b", "c", ""))
+	wr, err := writer.NewSingleReplication(def, graph.Options{"ignore_missing": true})
+	require.NoError(t, err)
+	dbs := httpgraph.NewDatabases(def, []httpgraph.Database{
+		{Name: "one", Backend: "memstore", Address: filepath.Join(dir, "one"), Init: true,
+			Options: graph.Options{memstore.OptPersist: true}},
+		{Name: "strict", Backend: "memstore"},
+		{Name: "two", Backend: "memstore"},
+	}, 0)
+	defer dbs.Close()
+	api := cayleyhttp.NewAPIv2(&graph.Handle{QuadStore: dbs, QuadWriter: wr})
+	srv := dbs.Handler(api)
+
+	do := func(method, path, db, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if db != "" {
+			req.Header.Set(httpgraph.DatabaseHeader, db)
+		}
+		req.Header.Set("Content-Type", "application/n-quads")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	quads := func(path, db string) int64 {
+		rr := do(http.MethodGet, path, db, "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var st struct {
+			Quads int64 `json:"quads"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &st))
+		return st.Quads
+	}
+
+	require.False(t, dbs.Open("one"))
+	rr := do(http.MethodPost, "/db/one/api/v2/write", "", "<x> <y> <z> .\n<x> <y> <w> .\n")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.True(t, dbs.Open("one"))
+	require.False(t, dbs.Open("two"))
+
+	require.Equal(t, int64(2), quads("/db/one/api/v2/stats", ""))
+	require.Equal(t, int64(2), quads("/api/v2/stats", "one"))
+	require.Equal(t, int64(0), quads("/api/v2/stats", "two"))
+	require.Equal(t, int64(1), quads("/api/v2/stats", ""))
+
+	rr = do(http.MethodGet, "/db/three/api/v2/stats", "", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	// the default database uses the configured writer, named databases use their own
+	rr = do(http.MethodPost, "/api/v2/delete", "", "<x> <y> <z> .\n")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr = do(http.MethodPost, "/api/v2/delete", "strict", "<x> <y> <z> .\n")
+	require.NotEqual(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// idle databases are closed and opened again on the next request
+	dbs.CloseIdle(time.Now().Add(time.Second))
+	require.False(t, dbs.Open("one"))
+	require.Equal(t, int64(2), quads("/db/one/api/v2/stats", ""))
+
+	st, err := def.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), st.Quads.Value)
+}
+
+func TestDatabasesReaper(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dbs := httpgraph.NewDatabases(memstore.New(), []httpgraph.Database{
+		{Name: "one", Backend: "memstore"},
+	}, 0)
+	defer dbs.Close()
+	dbs.SetReapInterval(10 * time.Millisecond)
+
+	var qs graph.QuadStore
+	srv := dbs.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := dbs.HandleForRequest(r)
+		require.True(t, ok)
+		qs = h.QuadStore
+	}))
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/db/one/api/v2/stats", nil))
+	require.NotNil(t, qs)
+
+	feed, err := graph.Watch(ctx, qs, -1)
+	require.NoError(t, err)
+	defer feed.Close()
+	q := quad.MakeRaw("a", "b", "c", "")
+	err = qs.ApplyDeltas([]graph.Delta{
+		{Quad: q, Action: graph.Add, Expires: time.Now().Add(-time.Second)},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+
+	// expired quads of named databases are deleted by their own reaper
+	for feed.Next(ctx) {
+		for _, d := range feed.Result().Deltas {
+			if d.Action == graph.Delete {
+				require.Equal(t, q, d.Quad)
+				return
+			}
+		}
+	}
+	t.Fatal("expired quad was not deleted:", feed.Err())
+}
@@ -10,3 +10,13 @@ type QuadStore interface {
 	graph.QuadStore
 	ForRequest(r *http.Request) (graph.QuadStore, error)
 }
+
+// Handles is implemented by QuadStores that host several databases, each with its own writer.
+type Handles interface {
+	QuadStore
+	// HandleForRequest returns a database selected by the request together with its writer.
+	// It returns false if the request uses the default database.
+	HandleForRequest(r *http.Request) (*graph.Handle, bool)
+	// Default returns the default database.
+	Default() graph.QuadStore
+}
@@ -0,0 +1,250 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+
+	"github.com/cayleygraph/cayley/graph/refs"
+)
+
+var _ Shape = &Search{}
+
+// SearchFunc runs a search and returns matching values, ordered from the best match to the worst.
+type SearchFunc func(ctx context.Context) ([]refs.Ref, error)
+
+// Search iterator returns results of a search in the order they were ranked,
+// optionally limiting them to values from a subiterator.
+//
+// The search runs lazily, on the first call to Next or Contains.
+type Search struct {
+	search SearchFunc
+	subIt  Shape
+}
+
+// NewSearch creates a new Search iterator. If subIt is nil, all search results are returned.
+func NewSearch(search SearchFunc, subIt Shape) *Search {
+	return &Search{search: search, subIt: subIt}
+}
+
+func (it *Search) Iterate() Scanner {
+	var sub Index
+	if it.subIt != nil {
+		sub = it.subIt.Lookup()
+	}
+	return newSearchNext(it.search, sub)
+}
+
+func (it *Search) Lookup() Index {
+	var sub Index
+	if it.subIt != nil {
+		sub = it.subIt.Lookup()
+	}
+	return newSearchContains(it.search, sub)
+}
+
+func (it *Search) Optimize(ctx context.Context) (Shape, bool) {
+	if it.subIt == nil {
+		return it, false
+	}
+	newIt, optimized := it.subIt.Optimize(ctx)
+	if IsNull(newIt) {
+		return newIt, true
+	}
+	if optimized {
+		it.subIt = newIt
+	}
+	return it, false
+}
+
+func (it *Search) Stats(ctx context.Context) (Costs, error) {
+	// TODO(dennwc): ask the index for an estimate
+	st := Costs{
+		NextCost:     1,
+		ContainsCost: 1,
+		Size: refs.Size{
+			Value: 100,
+			Exact: false,
+		},
+	}
+	if it.subIt == nil {
+		return st, nil
+	}
+	subStats, err := it.subIt.Stats(ctx)
+	st.NextCost += subStats.ContainsCost
+	st.ContainsCost += subStats.ContainsCost
+	if subStats.Size.Value < st.Size.Value {
+		st.Size.Value = subStats.Size.Value
+	}
+	return st, err
+}
+
+func (it *Search) String() string {
+	return "Search"
+}
+
+// SubIterators returns a slice of the sub iterators.
+func (it *Search) SubIterators() []Shape {
+	if it.subIt == nil {
+		return nil
+	}
+	return []Shape{it.subIt}
+}
+
+type searchNext struct {
+	search  SearchFunc
+	subIt   Index
+	results []refs.Ref
+	done    bool
+	index   int
+	result  refs.Ref
+	err     error
+}
+
+func newSearchNext(search SearchFunc, subIt Index) *searchNext {
+	return &searchNext{search: search, subIt: subIt}
+}
+
+func (it *searchNext) TagResults(dst map[string]refs.Ref) {
+	if it.subIt != nil {
+		it.subIt.TagResults(dst)
+	}
+}
+
+func (it *searchNext) Err() error {
+	return it.err
+}
+
+func (it *searchNext) Result() refs.Ref {
+	return it.result
+}
+
+func (it *searchNext) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.done {
+		it.done = true
+		it.results, it.err = it.search(ctx)
+		if it.err != nil {
+			return false
+		}
+	}
+	for it.index < len(it.results) {
+		v := it.results[it.index]
+		it.index++
+		if it.subIt != nil && !it.subIt.Contains(ctx, v) {
+			if err := it.subIt.Err(); err != nil {
+				it.err = err
+				return false
+			}
+			continue
+		}
+		it.result = v
+		return true
+	}
+	it.result = nil
+	return false
+}
+
+func (it *searchNext) NextPath(ctx context.Context) bool {
+	if it.subIt == nil {
+		return false
+	}
+	return it.subIt.NextPath(ctx)
+}
+
+func (it *searchNext) Close() error {
+	it.results = nil
+	if it.subIt == nil {
+		return nil
+	}
+	return it.subIt.Close()
+}
+
+func (it *searchNext) String() string {
+	return "SearchNext"
+}
+
+type searchContains struct {
+	search SearchFunc
+	subIt  Index
+	keys   map[interface{}]struct{}
+	result refs.Ref
+	err    error
+}
+
+func newSearchContains(search SearchFunc, subIt Index) *searchContains {
+	return &searchContains{search: search, subIt: subIt}
+}
+
+func (it *searchContains) TagResults(dst map[string]refs.Ref) {
+	if it.subIt != nil {
+		it.subIt.TagResults(dst)
+	}
+}
+
+func (it *searchContains) Err() error {
+	return it.err
+}
+
+func (it *searchContains) Result() refs.Ref {
+	return it.result
+}
+
+func (it *searchContains) Contains(ctx context.Context, v refs.Ref) bool {
+	if it.err != nil {
+		return false
+	}
+	if it.keys == nil {
+		results, err := it.search(ctx)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.keys = make(map[interface{}]struct{}, len(results))
+		for _, r := range results {
+			it.keys[refs.ToKey(r)] = struct{}{}
+		}
+	}
+	if _, ok := it.keys[refs.ToKey(v)]; !ok {
+		return false
+	}
+	if it.subIt != nil && !it.subIt.Contains(ctx, v) {
+		it.err = it.subIt.Err()
+		return false
+	}
+	it.result = v
+	return true
+}
+
+func (it *searchContains) NextPath(ctx context.Context) bool {
+	if it.subIt == nil {
+		return false
+	}
+	return it.subIt.NextPath(ctx)
+}
+
+func (it *searchContains) Close() error {
+	it.keys = nil
+	if it.subIt == nil {
+		return nil
+	}
+	return it.subIt.Close()
+}
+
+func (it *searchContains) String() string {
+	return "SearchContains"
+}
@@ -8,4 +8,6 @@ import (
 	_ "github.com/cayleygraph/cayley/graph/kv"
 	// legacy: override bolt implementation; check the package for details
 	_ "github.com/cayleygraph/cayley/graph/kv/bolt"
+	// pebble is not supported by hidalgo yet
+	_ "github.com/cayleygraph/cayley/graph/kv/pebble"
 )
@@ -160,7 +160,7 @@ func (it *allIteratorNext) Next(ctx context.Context) bool {
 			p := it.buf[0]
 			it.prim = p
 			// 这个点已经被删除
-			if p == nil || p.Deleted {
+			if p == nil || !it.qs.isAlive(p) {
 				continue
 			}
 			it.id = it.prim.ID
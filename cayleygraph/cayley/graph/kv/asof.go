@@ -0,0 +1,213 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+	"github.com/hidal-go/hidalgo/kv"
+)
+
+var _ graph.TimeTraveler = (*QuadStore)(nil)
+
+// AsOf implements graph.TimeTraveler.
+//
+// Primitives are never changed after being written to the log, except for being marked as deleted,
+// thus the view uses creation and deletion timestamps of primitives to decide if they were present
+// at a given time. Primitives deleted before the last compaction are not visible. Compact keeps
+// primitives visible to the view while it has open iterators, thus closing the view is optional.
+func (qs *QuadStore) AsOf(ctx context.Context, t time.Time) (graph.QuadStore, error) {
+	if t.IsZero() {
+		return nil, errors.New("kv: time is not set")
+	}
+	v := qs.newView(t.UnixNano())
+	v.asOf = t.UnixNano()
+	return v, nil
+}
+
+// newView creates a read-only QuadStore that shares the database with this one. Primitives deleted
+// after a given time (in Unix nanoseconds) are visible to the view, see QuadStore.readers.
+// The caller must set the point in time the view observes.
+func (qs *QuadStore) newView(at int64) *QuadStore {
+	if db, ok := qs.db.(*viewKV); ok {
+		// views of views are tracked by the same store
+		qs = db.qs
+	}
+	v := newQuadStore(&viewKV{db: qs.db, qs: qs, at: at})
+	v.stats = qs.stats
+	qs.indexes.RLock()
+	v.indexes.all = qs.indexes.all
+	qs.indexes.RUnlock()
+	// bloom filters are only used for writes
+	v.exists.disabled = true
+	return v
+}
+
+// isView checks if the QuadStore is a read-only view of a past state of the store.
+func (qs *QuadStore) isView() bool {
+	return qs.asOf != 0 || qs.upto != 0
+}
+
+// isCreated checks if the primitive was already written at the point in time the view observes.
+func (qs *QuadStore) isCreated(p *proto.Primitive) bool {
+	if qs.upto != 0 {
+		return p.ID < qs.upto
+	}
+	return p.Timestamp <= qs.asOf
+}
+
+// now returns the time the QuadStore observes, in Unix nanoseconds. Only live stores use the wall clock.
+func (qs *QuadStore) now() int64 {
+	switch {
+	case qs.asOf != 0:
+		return qs.asOf
+	case qs.upto != 0:
+		return qs.taken
+	}
+	return time.Now().UnixNano()
+}
+
+// isAlive checks if the primitive is visible in this QuadStore.
+func (qs *QuadStore) isAlive(p *proto.Primitive) bool {
+	if isExpired(p, qs.now()) {
+		return false
+	}
+	if !qs.isView() {
+		return !p.Deleted
+	}
+	if !qs.isCreated(p) {
+		return false
+	} else if !p.Deleted {
+		return true
+	}
+	// DeletedAt and DeletedHorizon are not set for primitives deleted by older versions
+	if qs.upto != 0 {
+		return p.DeletedHorizon >= qs.upto
+	}
+	return p.DeletedAt > qs.asOf
+}
+
+// resolvePastValues replaces IDs of nodes that were created after the view time with IDs of
+// node primitives that held the same values at that time.
+func (qs *QuadStore) resolvePastValues(ctx context.Context, tx kv.Tx, vals []quad.Value, ids []uint64) error {
+	for i, v := range vals {
+		if v == nil {
+			continue
+		}
+		id := ids[i]
+		ids[i] = 0
+		if id != 0 {
+			p, err := qs.getPrimitiveFromLog(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if qs.isCreated(p) {
+				// the node is alive now, thus it was alive since it was created
+				ids[i] = id
+				continue
+			}
+		}
+		h := refs.HashOf(v)
+		b, err := tx.Get(ctx, deadBucket.AppendBytes(h[:]))
+		if err == kv.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		id, _ = binary.Uvarint(b)
+		for id != 0 {
+			p, err := qs.getPrimitiveFromLog(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if qs.isCreated(p) {
+				if qs.isAlive(p) {
+					ids[i] = id
+				}
+				break
+			}
+			id = p.Replaces
+		}
+	}
+	return nil
+}
+
+// viewKV is a read-only kv.KV that is shared with another QuadStore.
+//
+// The store keeps primitives visible to the view while any transaction of the view is open,
+// or until the view is closed, if it's held (see Snapshot).
+type viewKV struct {
+	db kv.KV
+	qs *QuadStore
+	at int64
+
+	mu   sync.Mutex
+	held bool
+}
+
+func (db *viewKV) Tx(rw bool) (kv.Tx, error) {
+	if rw {
+		return nil, graph.ErrReadOnly
+	}
+	tx, err := db.db.Tx(false)
+	if err != nil {
+		return nil, err
+	}
+	db.qs.pinView(db.at)
+	return &viewTx{Tx: tx, db: db}, nil
+}
+
+// hold keeps primitives visible to the view until it's closed.
+func (db *viewKV) hold() {
+	db.mu.Lock()
+	if !db.held {
+		db.held = true
+		db.qs.pinView(db.at)
+	}
+	db.mu.Unlock()
+}
+
+func (db *viewKV) Close() error {
+	db.mu.Lock()
+	if db.held {
+		db.held = false
+		db.qs.unpinView(db.at)
+	}
+	db.mu.Unlock()
+	return nil
+}
+
+// viewTx is a read-only transaction of a view.
+type viewTx struct {
+	kv.Tx
+	db     *viewKV
+	closed bool
+}
+
+func (tx *viewTx) Close() error {
+	if !tx.closed {
+		tx.closed = true
+		tx.db.qs.unpinView(tx.db.at)
+	}
+	return tx.Tx.Close()
+}
@@ -0,0 +1,472 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/cayley/graph"
+)
+
+var _ graph.Backuper = (*QuadStore)(nil)
+
+const (
+	// backupMagic starts every backup file.
+	backupMagic = "cayley-kv-backup\n"
+	// backupFormat is the version of the backup file format.
+	backupFormat = 1
+	// restoreBatch is the number of keys written by Restore in a single transaction.
+	restoreBatch = 4096
+)
+
+// backupBatch is the maximal number of keys read by Backup in a single transaction.
+var backupBatch = 4096
+
+var (
+	keyMetaVersion = metaBucket.AppendBytes([]byte("version"))
+
+	ErrBackupCorrupted = errors.New("kv: backup is corrupted")
+)
+
+// BackupManifest describes a backup of the raw key-value data. It is written at the end of the backup.
+type BackupManifest struct {
+	Format   int       `json:"format"`
+	Version  int64     `json:"version"` // data version of the database, see setVersion
+	Keys     int64     `json:"keys"`
+	Checksum string    `json:"checksum"` // SHA-256 of all records, hex-encoded
+	Created  time.Time `json:"created"`
+}
+
+// backupWriter encodes backup records. Each record is a number of key parts followed by
+// the parts and the value, all prefixed with their lengths as uvarints.
+type backupWriter struct {
+	w    *bufio.Writer
+	h    hash.Hash
+	keys int64
+	buf  [binary.MaxVarintLen64]byte
+}
+
+func (w *backupWriter) writeBytes(p []byte) error {
+	n := binary.PutUvarint(w.buf[:], uint64(len(p)))
+	if _, err := w.w.Write(w.buf[:n]); err != nil {
+		return err
+	}
+	w.h.Write(w.buf[:n])
+	_, err := w.w.Write(p)
+	w.h.Write(p)
+	return err
+}
+
+func (w *backupWriter) writeRecord(k kv.Key, v kv.Value) error {
+	n := binary.PutUvarint(w.buf[:], uint64(len(k)))
+	if _, err := w.w.Write(w.buf[:n]); err != nil {
+		return err
+	}
+	w.h.Write(w.buf[:n])
+	if len(k) == 0 {
+		return nil // end of records
+	}
+	for _, p := range k {
+		if err := w.writeBytes(p); err != nil {
+			return err
+		}
+	}
+	w.keys++
+	return w.writeBytes(v)
+}
+
+// Backup implements graph.Backuper.
+//
+// Keys are read in batches, each in a separate read transaction, thus no transaction is kept open
+// for the whole backup: bolt cannot grow the database file while a read transaction is in progress.
+// The copy is made to a temporary file while writes wait, which keeps it consistent, and is written
+// to w only after writes are resumed, thus a slow reader of the backup doesn't block the store.
+func (qs *QuadStore) Backup(ctx context.Context, w io.Writer) error {
+	if qs.isView() {
+		return graph.ErrOperationNotSupported
+	}
+	_, err := qs.backup(ctx, w)
+	return err
+}
+
+func (qs *QuadStore) backup(ctx context.Context, w io.Writer) (*BackupManifest, error) {
+	f, err := ioutil.TempFile("", "cayley-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	m, err := qs.copyBackup(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(w, f); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// copyBackup writes a backup of the database while no other writes are in progress.
+func (qs *QuadStore) copyBackup(ctx context.Context, w io.Writer) (*BackupManifest, error) {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+
+	m := &BackupManifest{Format: backupFormat, Created: time.Now().UTC()}
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		vers, err := tx.Get(ctx, keyMetaVersion)
+		if err != nil {
+			return fmt.Errorf("cannot read data version: %v", err)
+		}
+		m.Version, err = asInt64(vers, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	bw := &backupWriter{w: bufio.NewWriter(w), h: sha256.New()}
+	if _, err = bw.w.WriteString(backupMagic); err != nil {
+		return nil, err
+	}
+	if err = qs.backupPrefix(ctx, bw, kv.Key{{}}); err != nil {
+		return nil, err
+	}
+	if err = bw.writeRecord(nil, nil); err != nil {
+		return nil, err
+	}
+	m.Keys = bw.keys
+	m.Checksum = hex.EncodeToString(bw.h.Sum(nil))
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err = bw.writeBytes(data); err != nil {
+		return nil, err
+	}
+	return m, bw.w.Flush()
+}
+
+// backupPrefix writes all records with a given key prefix. They are read in a single transaction,
+// unless there are more than backupBatch of them, in which case the prefix is split into longer ones.
+func (qs *QuadStore) backupPrefix(ctx context.Context, bw *backupWriter, pref kv.Key) error {
+	var (
+		recs []kv.Pair
+		more bool
+	)
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		it := tx.Scan(pref)
+		defer it.Close()
+		for it.Next(ctx) {
+			if len(recs) == backupBatch {
+				more = true
+				return nil
+			}
+			recs = append(recs, kv.Pair{Key: it.Key().Clone(), Val: it.Val().Clone()})
+		}
+		return it.Err()
+	})
+	if err != nil {
+		return err
+	} else if more {
+		return qs.backupSplit(ctx, bw, pref)
+	}
+	for _, r := range recs {
+		if err = bw.writeRecord(r.Key, r.Val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupSplit writes records with a given key prefix in parts: the key equal to the prefix,
+// keys that have more parts after it, and keys with each possible next byte of the last part.
+func (qs *QuadStore) backupSplit(ctx context.Context, bw *backupWriter, pref kv.Key) error {
+	var (
+		val   kv.Value
+		found bool
+	)
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		v, err := tx.Get(ctx, pref)
+		if err == kv.ErrNotFound {
+			return nil
+		}
+		val, found = v.Clone(), err == nil
+		return err
+	})
+	if err != nil {
+		return err
+	} else if found {
+		if err = bw.writeRecord(pref, val); err != nil {
+			return err
+		}
+	}
+	if err = qs.backupPrefix(ctx, bw, pref.AppendBytes([]byte{})); err != nil {
+		return err
+	}
+	for b := 0; b < 256; b++ {
+		k := pref.Clone()
+		k[len(k)-1] = append(k[len(k)-1], byte(b))
+		if err = qs.backupPrefix(ctx, bw, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupReader decodes backup records and calculates the checksum.
+type backupReader struct {
+	r *bufio.Reader
+	h hash.Hash
+}
+
+func (r *backupReader) readUvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	r.h.Write(buf[:n])
+	return v, nil
+}
+
+func (r *backupReader) readBytes() ([]byte, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	} else if n > 1<<30 {
+		return nil, ErrBackupCorrupted
+	}
+	p := make([]byte, n)
+	if _, err = io.ReadFull(r.r, p); err != nil {
+		return nil, err
+	}
+	r.h.Write(p)
+	return p, nil
+}
+
+// readRecord reads the next key-value pair. It returns a nil key at the end of records.
+func (r *backupReader) readRecord() (kv.Key, kv.Value, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return nil, nil, err
+	} else if n == 0 {
+		return nil, nil, nil
+	} else if n > 64 {
+		return nil, nil, ErrBackupCorrupted
+	}
+	k := make(kv.Key, n)
+	for i := range k {
+		if k[i], err = r.readBytes(); err != nil {
+			return nil, nil, err
+		}
+	}
+	v, err := r.readBytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, v, nil
+}
+
+// readManifest reads the manifest at the end of the backup and checks it against the records.
+func (r *backupReader) readManifest(keys int64, vers []byte) (*BackupManifest, error) {
+	sum := hex.EncodeToString(r.h.Sum(nil))
+	data, err := r.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	var m BackupManifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("cannot decode backup manifest: %v", err)
+	}
+	if m.Format != backupFormat {
+		return &m, fmt.Errorf("kv: unsupported backup format: %d", m.Format)
+	} else if m.Checksum != sum || m.Keys != keys {
+		return &m, ErrBackupCorrupted
+	}
+	if v, err := asInt64(vers, 0); err != nil || v != m.Version {
+		return &m, ErrBackupCorrupted
+	} else if m.Version != latestDataVersion {
+		return &m, fmt.Errorf("kv: backup has data version %d, expected %d", m.Version, latestDataVersion)
+	}
+	return &m, nil
+}
+
+// newBackupReader checks the header of the backup and returns a reader for its records.
+func newBackupReader(r io.Reader) (*backupReader, error) {
+	br := &backupReader{r: bufio.NewReader(r), h: sha256.New()}
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br.r, magic); err != nil || string(magic) != backupMagic {
+		return nil, errors.New("kv: not a backup file")
+	}
+	return br, nil
+}
+
+// VerifyBackup reads a backup made by Backup and checks it against the manifest, without restoring it.
+func VerifyBackup(r io.Reader) (*BackupManifest, error) {
+	br, err := newBackupReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		keys int64
+		vers []byte
+	)
+	for {
+		k, v, err := br.readRecord()
+		if err != nil {
+			return nil, err
+		} else if k == nil {
+			break
+		}
+		keys++
+		if k.Compare(keyMetaVersion) == 0 {
+			vers = v
+		}
+	}
+	return br.readManifest(keys, vers)
+}
+
+// Restore writes key-value pairs from a backup made by Backup to an empty database.
+//
+// The backup is verified before anything is written to the database. It is read twice if r implements
+// io.Seeker, or is copied to a temporary file otherwise. The data version of the database is written last,
+// thus the database remains uninitialized if the restore fails.
+func Restore(ctx context.Context, db kv.KV, r io.Reader) (*BackupManifest, error) {
+	if _, err := newQuadStore(db).getMetadata(ctx); err == nil {
+		return nil, graph.ErrDatabaseExists
+	} else if err != ErrNoBucket {
+		return nil, err
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if m, err := VerifyBackup(rs); err != nil {
+			return m, err
+		}
+		if _, err = rs.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return restore(ctx, db, rs)
+	}
+	f, err := ioutil.TempFile("", "cayley-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	if m, err := VerifyBackup(io.TeeReader(r, f)); err != nil {
+		return m, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return restore(ctx, db, f)
+}
+
+// restore writes key-value pairs from a verified backup. The manifest is checked again at the end,
+// in case the backup has changed since it was verified.
+func restore(ctx context.Context, db kv.KV, r io.Reader) (*BackupManifest, error) {
+	br, err := newBackupReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		keys int64
+		vers []byte
+		done bool
+	)
+	for !done {
+		err := kv.Update(ctx, db, func(tx kv.Tx) error {
+			for i := 0; i < restoreBatch; i++ {
+				k, v, err := br.readRecord()
+				if err != nil {
+					return err
+				} else if k == nil {
+					done = true
+					return nil
+				}
+				keys++
+				switch {
+				case k.Compare(keyMetaVersion) == 0:
+					vers = v
+				case len(k) == 1 && len(v) == 0:
+					// bucket marker, see kv.CreateBucket
+					err = kv.CreateBucket(ctx, tx, k)
+				default:
+					err = tx.Put(k, v)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	m, err := br.readManifest(keys, vers)
+	if err != nil {
+		return m, err
+	}
+	err = kv.Update(ctx, db, func(tx kv.Tx) error {
+		return tx.Put(keyMetaVersion, vers)
+	})
+	return m, err
+}
+
+// RestoreTo creates a database for a registered key-value backend and restores a backup into it.
+func RestoreTo(ctx context.Context, name, addr string, opt graph.Options, r io.Reader) (*BackupManifest, error) {
+	reg, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("kv: unknown backend: %q", name)
+	} else if !reg.IsPersistent {
+		return nil, errors.New("kv: backend is not persistent")
+	}
+	db, err := reg.InitFunc(addr, opt)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Restore(ctx, db, r)
+	if err2 := db.Close(); err == nil {
+		err = err2
+	}
+	return m, err
+}
@@ -0,0 +1,121 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hidal-go/hidalgo/kv"
+	"github.com/hidal-go/hidalgo/kv/flat"
+	"github.com/hidal-go/hidalgo/kv/flat/btree"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/quad"
+)
+
+func TestBackupBatches(t *testing.T) {
+	ctx := context.TODO()
+	defer func(n int) {
+		backupBatch = n
+	}(backupBatch)
+	backupBatch = 3
+
+	db := flat.Upgrade(btree.New())
+	require.NoError(t, Init(db, nil))
+	qs, err := New(db, nil)
+	require.NoError(t, err)
+	defer qs.Close()
+	var deltas []graph.Delta
+	for _, o := range []string{"B", "C", "D", "E", "F"} {
+		deltas = append(deltas, graph.Delta{Quad: quad.MakeIRI("A", "follows", o, ""), Action: graph.Add})
+	}
+	require.NoError(t, qs.ApplyDeltas(deltas, graph.IgnoreOpts{}))
+
+	var buf bytes.Buffer
+	m, err := qs.(*QuadStore).backup(ctx, &buf)
+	require.NoError(t, err)
+
+	// all keys are copied exactly once, even though they are read in many transactions
+	var keys int64
+	err = kv.View(db, func(tx kv.Tx) error {
+		it := tx.Scan(nil)
+		defer it.Close()
+		for it.Next(ctx) {
+			keys++
+		}
+		return it.Err()
+	})
+	require.NoError(t, err)
+	require.True(t, keys > int64(backupBatch))
+	require.Equal(t, keys, m.Keys)
+
+	db2 := flat.Upgrade(btree.New())
+	_, err = Restore(ctx, db2, &buf)
+	require.NoError(t, err)
+	qs2, err := New(db2, nil)
+	require.NoError(t, err)
+	defer qs2.Close()
+	rep, err := qs2.(*QuadStore).Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+	require.Equal(t, int64(len(deltas)), qs2.(*QuadStore).Size())
+}
+
+// blockedWriter blocks the first write until it's released.
+type blockedWriter struct {
+	buf     bytes.Buffer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockedWriter) Write(p []byte) (int, error) {
+	if w.started != nil {
+		close(w.started)
+		w.started = nil
+		<-w.release
+	}
+	return w.buf.Write(p)
+}
+
+func TestBackupSlowWriter(t *testing.T) {
+	ctx := context.TODO()
+
+	db := flat.Upgrade(btree.New())
+	require.NoError(t, Init(db, nil))
+	qs, err := New(db, nil)
+	require.NoError(t, err)
+	defer qs.Close()
+	err = qs.ApplyDeltas([]graph.Delta{
+		{Quad: quad.MakeIRI("A", "follows", "B", ""), Action: graph.Add},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+
+	w := &blockedWriter{started: make(chan struct{}), release: make(chan struct{})}
+	started := w.started
+	errc := make(chan error, 1)
+	go func() {
+		errc <- qs.(*QuadStore).Backup(ctx, w)
+	}()
+	select {
+	case <-started:
+	case err = <-errc:
+		t.Fatal("backup finished before writing:", err)
+	}
+
+	// writes are not blocked by the reader of the backup
+	err = qs.ApplyDeltas([]graph.Delta{
+		{Quad: quad.MakeIRI("A", "follows", "C", ""), Action: graph.Add},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+	close(w.release)
+	require.NoError(t, <-errc)
+
+	db2 := flat.Upgrade(btree.New())
+	_, err = Restore(ctx, db2, &w.buf)
+	require.NoError(t, err)
+	qs2, err := New(db2, nil)
+	require.NoError(t, err)
+	defer qs2.Close()
+	require.Equal(t, int64(1), qs2.(*QuadStore).Size())
+}
@@ -0,0 +1,827 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+)
+
+const (
+	// bulkBatch is the number of keys written by BulkLoad in a single transaction.
+	bulkBatch = 16 * 1024
+	// defaultBulkRunSize is the default number of records sorted in memory by BulkLoad.
+	defaultBulkRunSize = 4 * 1024 * 1024
+)
+
+// BulkOptions controls BulkLoad.
+type BulkOptions struct {
+	// TempDir is a directory for values of nodes and sorted runs of records. The system temporary directory is used if empty.
+	TempDir string
+	// RunSize is the number of records sorted in memory before they are written to a temporary file.
+	RunSize int
+}
+
+// BulkStats reports how many primitives were written by BulkLoad.
+type BulkStats struct {
+	Nodes      int64
+	Quads      int64
+	Duplicates int64 // duplicate quads in the input
+}
+
+// BulkLoad writes quads to an initialized empty database, bypassing duplicate checks and
+// read-modify-write cycles of ApplyDeltas.
+//
+// Values of nodes are written to a temporary file, and node IDs are assigned in the order of value
+// hashes. Nodes, quads and index entries are sorted externally on disk, thus memory use depends on
+// RunSize and not on the size of the input. Each index bucket is written once, in the key order.
+// The load is not recorded in the change feed.
+//
+// The database must not be used by anyone else during the load. If the load fails, the database
+// is left in an inconsistent state and must be recreated.
+func BulkLoad(ctx context.Context, db kv.KV, r quad.Reader, opt BulkOptions) (*BulkStats, error) {
+	qs := newQuadStore(db)
+	if vers, err := qs.getMetadata(ctx); err == ErrNoBucket {
+		return nil, graph.ErrNotInitialized
+	} else if err != nil {
+		return nil, err
+	} else if vers != latestDataVersion {
+		return nil, errors.New("kv: data version is out of date. Run cayleyupgrade for your config to update the data")
+	}
+	if h, err := qs.getMetaInt(ctx, "horizon"); err != nil && err != ErrNoBucket {
+		return nil, err
+	} else if h != 0 {
+		return nil, errors.New("kv: bulk load requires an empty database")
+	}
+	inds, err := qs.readIndexesMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := qs.hasStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if opt.RunSize <= 0 {
+		opt.RunSize = defaultBulkRunSize
+	}
+	l := &bulkLoader{
+		w:     &bulkWriter{ctx: ctx, db: db},
+		opt:   opt,
+		inds:  inds,
+		stats: stats,
+		ts:    time.Now().UnixNano(),
+		seen:  make(map[refs.ValueHash]struct{}),
+	}
+	defer l.close()
+	if err = l.load(ctx, r); err != nil {
+		l.w.rollback()
+		return nil, err
+	}
+	return &l.st, nil
+}
+
+// BulkLoadTo opens a database for a registered key-value backend and loads quads into it with BulkLoad.
+// The database must be initialized and empty.
+func BulkLoadTo(ctx context.Context, name, addr string, opt graph.Options, r quad.Reader, bopt BulkOptions) (*BulkStats, error) {
+	reg, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("kv: unknown backend: %q", name)
+	} else if !reg.IsPersistent {
+		return nil, errors.New("kv: backend is not persistent")
+	}
+	db, err := reg.NewFunc(addr, opt)
+	if err != nil {
+		return nil, err
+	}
+	st, err := BulkLoad(ctx, db, r, bopt)
+	if err2 := db.Close(); err == nil {
+		err = err2
+	}
+	return st, err
+}
+
+type bulkLoader struct {
+	w     *bulkWriter
+	opt   BulkOptions
+	inds  []QuadIndex
+	stats bool
+	ts    int64 // timestamp of all primitives
+	st    BulkStats
+
+	seen   map[refs.ValueHash]struct{} // hashes of values written to the spool, reset after RunSize entries
+	values *spoolFile                  // values of nodes, only used until node IDs are assigned
+	hashes *spoolFile                  // value hashes of nodes, in the order of IDs
+
+	occs    *extSorter   // value hash, position in the input and spool offset of each node of each quad
+	refs    *extSorter   // position in the input and ID of each node of each quad
+	quads   *extSorter   // subject, predicate, object and label IDs of quads
+	uses    *extSorter   // node IDs of unique quads, for reference counts
+	indexes []*extSorter // index key and quad ID, for each index
+	pairs   *extSorter   // keys of subject-predicate and object-predicate counters, see statsKey
+}
+
+func (l *bulkLoader) close() {
+	for _, s := range append([]*extSorter{l.occs, l.refs, l.quads, l.uses, l.pairs}, l.indexes...) {
+		if s != nil {
+			s.Close()
+		}
+	}
+	for _, f := range []*spoolFile{l.values, l.hashes} {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+func (l *bulkLoader) load(ctx context.Context, r quad.Reader) (err error) {
+	if l.values, err = newSpoolFile(l.opt.TempDir); err != nil {
+		return err
+	}
+	l.occs = newExtSorter(l.opt.TempDir, quad.HashSize+2*8, l.opt.RunSize)
+	if err = l.readQuads(ctx, r); err != nil {
+		return err
+	}
+	l.seen = nil
+	if l.hashes, err = newSpoolFile(l.opt.TempDir); err != nil {
+		return err
+	}
+	l.refs = newExtSorter(l.opt.TempDir, 2*8, l.opt.RunSize)
+	if err = l.writeNodes(ctx); err != nil {
+		return err
+	}
+	if clog.V(1) {
+		clog.Infof("kv: bulk load: %d nodes written", l.st.Nodes)
+	}
+	l.quads = newExtSorter(l.opt.TempDir, 4*8, l.opt.RunSize)
+	if err = l.sortQuads(); err != nil {
+		return err
+	}
+	l.uses = newExtSorter(l.opt.TempDir, 8, l.opt.RunSize)
+	for _, ind := range l.inds {
+		l.indexes = append(l.indexes, newExtSorter(l.opt.TempDir, 8*len(ind.Dirs)+8, l.opt.RunSize))
+	}
+	if l.stats {
+		l.pairs = newExtSorter(l.opt.TempDir, 1+2*8, l.opt.RunSize)
+	}
+	preds, err := l.writeQuads(ctx)
+	if err != nil {
+		return err
+	}
+	if clog.V(1) {
+		clog.Infof("kv: bulk load: %d quads written, %d duplicates skipped", l.st.Quads, l.st.Duplicates)
+	}
+	for i, ind := range l.inds {
+		if err := l.writeIndex(ind, l.indexes[i]); err != nil {
+			return err
+		}
+	}
+	if l.stats {
+		if err := l.writeStats(preds); err != nil {
+			return err
+		}
+	}
+	if err := l.writeValues(); err != nil {
+		return err
+	}
+	if err := l.writeMeta(); err != nil {
+		return err
+	}
+	return l.w.commit()
+}
+
+// noValue is a spool offset of a node which value was already written to the spool.
+const noValue = ^uint64(0)
+
+// readQuads writes values of nodes to the spool and sorts positions of nodes in the input by value hashes.
+// A position is the number of the quad in the input and the index of the direction.
+//
+// Values are written to the spool once per node as long as the node is remembered in the seen set,
+// thus the first position of each node always has an offset of the value.
+func (l *bulkLoader) readQuads(ctx context.Context, r quad.Reader) error {
+	var rec [quad.HashSize + 2*8]byte
+	for n := uint64(1); ; n++ {
+		q, err := r.ReadQuad()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		} else if !q.IsValid() {
+			return fmt.Errorf("kv: invalid quad: %v", q)
+		}
+		for i, dir := range quad.Directions {
+			v := q.Get(dir)
+			if v == nil {
+				continue
+			}
+			h := refs.HashOf(v)
+			off := noValue
+			if _, ok := l.seen[h]; !ok {
+				if off, err = l.spoolValue(v); err != nil {
+					return err
+				}
+				if len(l.seen) >= l.opt.RunSize {
+					l.seen = make(map[refs.ValueHash]struct{})
+				}
+				l.seen[h] = struct{}{}
+			}
+			copy(rec[:], h[:])
+			quadKeyEnc.PutUint64(rec[quad.HashSize:], n<<2|uint64(i))
+			quadKeyEnc.PutUint64(rec[quad.HashSize+8:], off)
+			if err := l.occs.Add(rec[:]); err != nil {
+				return err
+			}
+		}
+		if n%uint64(quad.DefaultBatch) == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// spoolValue writes an encoded value to the spool and returns its offset.
+func (l *bulkLoader) spoolValue(v quad.Value) (uint64, error) {
+	p, err := createNodePrimitive(v)
+	if err != nil {
+		return 0, err
+	}
+	off, err := l.values.WriteValue(p.Value)
+	return uint64(off), err
+}
+
+// writeNodes assigns IDs to nodes in the order of value hashes and writes node primitives to the log
+// in the order of IDs, along with IDs by value hashes. Positions of nodes are sorted with their IDs.
+func (l *bulkLoader) writeNodes(ctx context.Context) error {
+	if err := l.values.Flush(); err != nil {
+		return err
+	}
+	var (
+		last refs.ValueHash
+		id   uint64
+		buf  []byte
+		rec  [2 * 8]byte
+	)
+	err := l.occs.Iterate(func(occ []byte) error {
+		if id == 0 || !bytes.Equal(last[:], occ[:quad.HashSize]) {
+			off := quadKeyEnc.Uint64(occ[quad.HashSize+8:])
+			if off == noValue {
+				return errors.New("kv: bulk load: value of a node is missing in the spool")
+			}
+			var err error
+			if buf, err = l.values.ReadValue(buf, int64(off)); err != nil {
+				return err
+			}
+			id++
+			copy(last[:], occ[:quad.HashSize])
+			if err = l.w.putPrimitive(&proto.Primitive{ID: id, Value: buf, Timestamp: l.ts}); err != nil {
+				return err
+			} else if err = l.w.put(bucketKeyForHash(last), uint64toBytes(id)); err != nil {
+				return err
+			} else if _, err = l.hashes.Write(last[:]); err != nil {
+				return err
+			}
+			if id%uint64(quad.DefaultBatch) == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+		}
+		copy(rec[:8], occ[quad.HashSize:quad.HashSize+8])
+		quadKeyEnc.PutUint64(rec[8:], id)
+		return l.refs.Add(rec[:])
+	})
+	if err != nil {
+		return err
+	}
+	l.st.Nodes = int64(id)
+	// the spool and the positions by hashes are no longer needed
+	l.occs.Close()
+	l.values.Close()
+	return l.hashes.Flush()
+}
+
+// sortQuads collects node IDs of each quad from the sorted positions, and sorts quads by the IDs of their nodes.
+func (l *bulkLoader) sortQuads() error {
+	var (
+		rec  [4 * 8]byte
+		cur  uint64 // number of the current quad
+		full bool
+	)
+	err := l.refs.Iterate(func(ref []byte) error {
+		pos := quadKeyEnc.Uint64(ref)
+		if full && pos>>2 != cur {
+			if err := l.quads.Add(rec[:]); err != nil {
+				return err
+			}
+			rec = [4 * 8]byte{}
+		}
+		cur, full = pos>>2, true
+		copy(rec[8*(pos&3):], ref[8:])
+		return nil
+	})
+	if err != nil || !full {
+		return err
+	}
+	l.refs.Close()
+	return l.quads.Add(rec[:])
+}
+
+// writeQuads assigns IDs to unique quads and writes quad primitives to the log. It returns the number
+// of quads for each predicate.
+func (l *bulkLoader) writeQuads(ctx context.Context) (map[uint64]*predStats, error) {
+	preds := make(map[uint64]*predStats)
+	var last []byte
+	err := l.quads.Iterate(func(rec []byte) error {
+		if last != nil && bytes.Equal(last, rec) {
+			l.st.Duplicates++
+			return nil
+		}
+		last = append(last[:0], rec...)
+		p := &proto.Primitive{
+			ID:        uint64(l.st.Nodes) + uint64(l.st.Quads) + 1,
+			Timestamp: l.ts,
+		}
+		for i, dir := range quad.Directions {
+			id := quadKeyEnc.Uint64(rec[8*i:])
+			if id != 0 {
+				if err := l.uses.Add(rec[8*i : 8*i+8]); err != nil {
+					return err
+				}
+			}
+			p.SetDirection(dir, id)
+		}
+		l.st.Quads++
+		if err := l.w.putPrimitive(p); err != nil {
+			return err
+		}
+		for i, ind := range l.inds {
+			key := ind.KeyFor(p)[1]
+			if err := l.indexes[i].Add(append(key, uint64KeyBytes(p.ID)[0]...)); err != nil {
+				return err
+			}
+		}
+		if !l.stats {
+			return nil
+		}
+		st := preds[p.Predicate]
+		if st == nil {
+			st = new(predStats)
+			preds[p.Predicate] = st
+		}
+		st.Quads++
+		if err := l.pairs.Add(statsKey('s', p.Predicate, p.Subject)[1]); err != nil {
+			return err
+		}
+		return l.pairs.Add(statsKey('o', p.Predicate, p.Object)[1])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return preds, ctx.Err()
+}
+
+// writeIndex writes entries of a quad index. Sorted records group quad IDs by the index key.
+func (l *bulkLoader) writeIndex(ind QuadIndex, s *extSorter) error {
+	var (
+		key []byte
+		ids []uint64
+	)
+	flush := func() error {
+		if len(ids) == 0 {
+			return nil
+		}
+		err := l.w.put(ind.bucket().AppendBytes(key), appendIndex(nil, ids))
+		ids = ids[:0]
+		return err
+	}
+	n := 8 * len(ind.Dirs)
+	err := s.Iterate(func(rec []byte) error {
+		if !bytes.Equal(key, rec[:n]) {
+			if err := flush(); err != nil {
+				return err
+			}
+			key = append([]byte{}, rec[:n]...)
+		}
+		ids = append(ids, quadKeyEnc.Uint64(rec[n:]))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// writeStats writes per-predicate statistics. Sorted records group quads by the predicate
+// and the subject or the object.
+func (l *bulkLoader) writeStats(preds map[uint64]*predStats) error {
+	var (
+		key []byte
+		cnt uint64
+	)
+	flush := func() error {
+		if cnt == 0 {
+			return nil
+		}
+		st := preds[binary.BigEndian.Uint64(key[1:])]
+		if key[0] == 's' {
+			st.Subjects++
+		} else {
+			st.Objects++
+		}
+		err := l.w.put(statsBucket.AppendBytes(key), uint64toBytes(cnt))
+		cnt = 0
+		return err
+	}
+	err := l.pairs.Iterate(func(rec []byte) error {
+		if !bytes.Equal(key, rec) {
+			if err := flush(); err != nil {
+				return err
+			}
+			key = append([]byte{}, rec...)
+		}
+		cnt++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+	ids := make([]uint64, 0, len(preds))
+	for id := range preds {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if err := l.w.put(statsKey('p', id), preds[id].encode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeValues writes reference counts of nodes by value hashes. Node IDs follow the order of value hashes,
+// thus the sorted IDs of nodes in unique quads are counted in the order of hashes.
+func (l *bulkLoader) writeValues() error {
+	r, err := l.hashes.Reader()
+	if err != nil {
+		return err
+	}
+	var (
+		h       refs.ValueHash
+		id, cnt uint64
+	)
+	flush := func() error {
+		if cnt == 0 {
+			return nil
+		}
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return err
+		}
+		err := l.w.put(bucketKeyForHashRefs(h), uint64toBytes(cnt))
+		cnt = 0
+		return err
+	}
+	err = l.uses.Iterate(func(rec []byte) error {
+		if next := quadKeyEnc.Uint64(rec); next != id {
+			if err := flush(); err != nil {
+				return err
+			}
+			// every node is used by at least one unique quad
+			if next != id+1 {
+				return fmt.Errorf("kv: bulk load: node %d is not used", id+1)
+			}
+			id = next
+		}
+		cnt++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+func (l *bulkLoader) writeMeta() error {
+	for _, m := range []struct {
+		key string
+		val int64
+	}{
+		{"horizon", l.st.Nodes + l.st.Quads},
+		{"size", l.st.Quads},
+	} {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(m.val))
+		if err := l.w.put(metaBucket.AppendBytes([]byte(m.key)), buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkWriter writes key-value pairs in a sequence of transactions of bulkBatch keys.
+type bulkWriter struct {
+	ctx context.Context
+	db  kv.KV
+	tx  kv.Tx
+	n   int
+}
+
+func (w *bulkWriter) put(k kv.Key, v []byte) error {
+	if w.tx == nil {
+		tx, err := w.db.Tx(true)
+		if err != nil {
+			return err
+		}
+		w.tx = wrapTx(tx)
+	}
+	if err := w.tx.Put(k, v); err != nil {
+		return err
+	}
+	w.n++
+	if w.n >= bulkBatch {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *bulkWriter) putPrimitive(p *proto.Primitive) error {
+	buf, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+	if err = w.put(logIndex.Append(uint64KeyBytes(p.ID)), buf); err != nil {
+		return err
+	}
+	mPrimitiveAppend.Inc()
+	return nil
+}
+
+func (w *bulkWriter) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	tx := w.tx
+	w.tx, w.n = nil, 0
+	return tx.Commit(w.ctx)
+}
+
+func (w *bulkWriter) rollback() {
+	if w.tx != nil {
+		_ = w.tx.Close()
+		w.tx = nil
+	}
+}
+
+// spoolFile is a temporary file that is written sequentially and read back after it is flushed.
+type spoolFile struct {
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+func newSpoolFile(dir string) (*spoolFile, error) {
+	f, err := ioutil.TempFile(dir, "cayley-bulk-")
+	if err != nil {
+		return nil, err
+	}
+	return &spoolFile{f: f, w: bufio.NewWriterSize(f, 64*1024)}, nil
+}
+
+func (s *spoolFile) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+// WriteValue writes a length-prefixed value and returns its offset in the file.
+func (s *spoolFile) WriteValue(p []byte) (int64, error) {
+	off := s.size
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(p)))
+	if _, err := s.Write(hdr[:]); err != nil {
+		return 0, err
+	}
+	_, err := s.Write(p)
+	return off, err
+}
+
+// Flush writes buffered data to the file. It must be called before the file is read.
+func (s *spoolFile) Flush() error {
+	return s.w.Flush()
+}
+
+// ReadValue reads a value written with WriteValue at a given offset. The buffer is reused if it is large enough.
+func (s *spoolFile) ReadValue(buf []byte, off int64) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := s.f.ReadAt(hdr[:], off); err != nil {
+		return buf, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[:]))
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	_, err := s.f.ReadAt(buf, off+int64(len(hdr)))
+	return buf, err
+}
+
+// Reader reads the file from the start.
+func (s *spoolFile) Reader() (io.Reader, error) {
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return bufio.NewReaderSize(s.f, 64*1024), nil
+}
+
+// Close removes the file. It is safe to call it multiple times.
+func (s *spoolFile) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	s.f.Close()
+	err := os.Remove(s.f.Name())
+	s.f = nil
+	return err
+}
+
+// extSorter sorts fixed-size records that do not fit in memory. Records are sorted in runs,
+// which are written to temporary files and merged when the records are read back.
+type extSorter struct {
+	dir  string
+	size int // size of a record
+	max  int // number of records sorted in memory
+	buf  []byte
+	runs []*os.File
+}
+
+func newExtSorter(dir string, size, max int) *extSorter {
+	return &extSorter{dir: dir, size: size, max: max}
+}
+
+// Add copies a record to the sorter.
+func (s *extSorter) Add(rec []byte) error {
+	if len(rec) != s.size {
+		return fmt.Errorf("kv: unexpected record size: %d vs %d", len(rec), s.size)
+	}
+	s.buf = append(s.buf, rec...)
+	if len(s.buf) >= s.max*s.size {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill sorts records in memory and writes them to a new run.
+func (s *extSorter) spill() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	sort.Sort(records{buf: s.buf, size: s.size, tmp: make([]byte, s.size)})
+	f, err := ioutil.TempFile(s.dir, "cayley-bulk-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+	if _, err = f.Write(s.buf); err != nil {
+		return err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Iterate calls fnc for all records in sorted order. The record is only valid until fnc returns.
+func (s *extSorter) Iterate(fnc func(rec []byte) error) error {
+	if len(s.runs) == 0 {
+		sort.Sort(records{buf: s.buf, size: s.size, tmp: make([]byte, s.size)})
+		for off := 0; off < len(s.buf); off += s.size {
+			if err := fnc(s.buf[off : off+s.size]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := s.spill(); err != nil {
+		return err
+	}
+	s.buf = nil
+	h := make(runHeap, 0, len(s.runs))
+	for _, f := range s.runs {
+		r := &run{r: bufio.NewReaderSize(f, 64*1024), rec: make([]byte, s.size)}
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			h = append(h, r)
+		}
+	}
+	heap.Init(&h)
+	for len(h) != 0 {
+		r := h[0]
+		if err := fnc(r.rec); err != nil {
+			return err
+		}
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// Close removes all temporary files.
+func (s *extSorter) Close() error {
+	var last error
+	for _, f := range s.runs {
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			last = err
+		}
+	}
+	s.runs, s.buf = nil, nil
+	return last
+}
+
+// records implements sort.Interface for fixed-size records stored in a single buffer.
+type records struct {
+	buf  []byte
+	size int
+	tmp  []byte
+}
+
+func (r records) Len() int { return len(r.buf) / r.size }
+func (r records) Less(i, j int) bool {
+	return bytes.Compare(r.buf[i*r.size:(i+1)*r.size], r.buf[j*r.size:(j+1)*r.size]) < 0
+}
+func (r records) Swap(i, j int) {
+	a, b := r.buf[i*r.size:(i+1)*r.size], r.buf[j*r.size:(j+1)*r.size]
+	copy(r.tmp, a)
+	copy(a, b)
+	copy(b, r.tmp)
+}
+
+// run is a sorted sequence of records read from a temporary file.
+type run struct {
+	r   *bufio.Reader
+	rec []byte
+}
+
+func (r *run) next() (bool, error) {
+	_, err := io.ReadFull(r.r, r.rec)
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// runHeap merges runs by their current records.
+type runHeap []*run
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return bytes.Compare(h[i].rec, h[j].rec) < 0 }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*run)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,512 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+)
+
+// Problem is an inconsistency in the database found by Check.
+type Problem struct {
+	Desc string
+	// Fixable is set if Check can repair the problem.
+	Fixable bool
+}
+
+func (p Problem) String() string {
+	return p.Desc
+}
+
+// CheckReport is the result of Check.
+type CheckReport struct {
+	Nodes    int64 // node primitives in the log
+	Quads    int64 // quad primitives in the log
+	Problems []Problem
+	Fixed    int // number of problems repaired
+}
+
+// fixFunc repairs a single problem in a write transaction.
+type fixFunc func(ctx context.Context, tx kv.Tx) error
+
+// checker holds the state of a single Check run.
+type checker struct {
+	qs     *QuadStore
+	tx     kv.Tx
+	report *CheckReport
+	fixes  []fixFunc
+
+	nodes  map[uint64]*checkNode
+	byHash map[refs.ValueHash]uint64 // alive nodes
+	links  []*proto.Primitive
+}
+
+type checkNode struct {
+	Hash    refs.ValueHash
+	Deleted bool
+	Refs    int64 // references from alive quads
+}
+
+func (c *checker) problem(fix fixFunc, format string, args ...interface{}) {
+	c.report.Problems = append(c.report.Problems, Problem{
+		Desc:    fmt.Sprintf(format, args...),
+		Fixable: fix != nil,
+	})
+	if fix != nil {
+		c.fixes = append(c.fixes, fix)
+	}
+}
+
+// indexProblem records a problem that is fixed by rewriting index entries.
+func (c *checker) indexProblem(format string, args ...interface{}) {
+	c.report.Problems = append(c.report.Problems, Problem{
+		Desc:    fmt.Sprintf(format, args...),
+		Fixable: true,
+	})
+}
+
+// Check verifies that the primitive log, the value hash buckets, node reference counts, quad indexes
+// and the size metadata are consistent with each other. If fix is set, fixable problems are repaired.
+//
+// Writes are blocked while the check is in progress. The log is treated as the source of truth:
+// other structures are rebuilt from it, while quads that refer to missing nodes are marked as deleted.
+func (qs *QuadStore) Check(ctx context.Context, fix bool) (*CheckReport, error) {
+	if qs.isView() {
+		return nil, graph.ErrReadOnly
+	}
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+
+	tx, err := qs.db.Tx(false)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{
+		qs:     qs,
+		tx:     wrapTx(tx),
+		report: &CheckReport{},
+		nodes:  make(map[uint64]*checkNode),
+		byHash: make(map[refs.ValueHash]uint64),
+	}
+	err = c.run(ctx)
+	tx.Close()
+	if err != nil || !fix || len(c.fixes) == 0 {
+		return c.report, err
+	}
+	// index entries might be written without the write buffer bloom filter
+	qs.mapBloom = nil
+	// apply fixes in batches
+	for len(c.fixes) != 0 {
+		n := compactBatch
+		if n > len(c.fixes) {
+			n = len(c.fixes)
+		}
+		err := kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+			tx = wrapTx(tx)
+			for _, f := range c.fixes[:n] {
+				if err := f(ctx, tx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return c.report, err
+		}
+		c.fixes = c.fixes[n:]
+	}
+	for _, p := range c.report.Problems {
+		if p.Fixable {
+			c.report.Fixed++
+		}
+	}
+	return c.report, nil
+}
+
+func (c *checker) run(ctx context.Context) error {
+	horizon, err := c.checkLog(ctx)
+	if err != nil {
+		return err
+	}
+	alive := c.checkLinks()
+	if err := c.checkValues(ctx); err != nil {
+		return err
+	}
+	if err := c.checkRefs(ctx); err != nil {
+		return err
+	}
+	if err := c.checkIndexes(ctx); err != nil {
+		return err
+	}
+	return c.checkMeta(ctx, horizon, alive)
+}
+
+// checkLog reads all primitives from the log and returns the largest ID.
+func (c *checker) checkLog(ctx context.Context) (uint64, error) {
+	var last uint64
+	it := c.tx.Scan(logIndex)
+	defer it.Close()
+	for it.Next(ctx) {
+		k := it.Key()
+		if len(k) < 2 || len(k[1]) == 0 {
+			continue // bucket marker
+		}
+		if len(k[1]) != 8 {
+			c.problem(nil, "log: invalid key %x", k[1])
+			continue
+		}
+		id := quadKeyEnc.Uint64(k[1])
+		p := new(proto.Primitive)
+		if err := p.Unmarshal(it.Val()); err != nil {
+			c.problem(nil, "log: cannot decode primitive %d: %v", id, err)
+			continue
+		} else if p.ID != id {
+			c.problem(nil, "log: primitive %d is stored as %d", p.ID, id)
+			continue
+		}
+		if id > last {
+			last = id
+		}
+		if !p.IsNode() {
+			c.report.Quads++
+			c.links = append(c.links, p)
+			continue
+		}
+		c.report.Nodes++
+		v, err := pquads.UnmarshalValue(p.Value)
+		if err != nil {
+			c.problem(nil, "log: cannot decode value of node %d: %v", id, err)
+			continue
+		}
+		n := &checkNode{Hash: refs.HashOf(v), Deleted: p.Deleted}
+		c.nodes[id] = n
+		if n.Deleted {
+			continue
+		}
+		if prev, ok := c.byHash[n.Hash]; ok {
+			c.problem(nil, "log: nodes %d and %d have the same value", prev, id)
+			continue
+		}
+		c.byHash[n.Hash] = id
+	}
+	return last, it.Err()
+}
+
+// checkLinks counts references to nodes from alive quads and returns the number of valid alive quads.
+func (c *checker) checkLinks() int64 {
+	var alive int64
+	for _, p := range c.links {
+		if p.Deleted {
+			continue
+		}
+		valid := true
+		for _, d := range quad.Directions {
+			id := p.GetDirection(d)
+			if id == 0 {
+				if d != quad.Label {
+					valid = false
+					c.problem(nil, "log: quad %d has no %v", p.ID, d)
+				}
+				continue
+			}
+			if n := c.nodes[id]; n == nil || n.Deleted {
+				valid = false
+				p := p
+				c.problem(func(ctx context.Context, tx kv.Tx) error {
+					pos, err := c.qs.genDeletion(ctx, tx)
+					if err != nil {
+						return err
+					}
+					// the deletion is not in the change feed, so the next compaction must scan the whole log
+					if err := tx.Del(metaBucket.AppendBytes([]byte(metaCompacted))); err != nil {
+						return err
+					}
+					return c.qs.markAsDead(tx, p, pos)
+				}, "log: quad %d refers to a missing node %d", p.ID, id)
+				break
+			}
+		}
+		if !valid {
+			continue
+		}
+		alive++
+		for _, d := range quad.Directions {
+			if id := p.GetDirection(d); id != 0 {
+				c.nodes[id].Refs++
+			}
+		}
+	}
+	return alive
+}
+
+// forgetValue removes the value of the node from the cache.
+func (c *checker) forgetValue(ctx context.Context, tx kv.Tx, id uint64) error {
+	p, err := c.qs.getPrimitiveFromLog(ctx, tx, id)
+	if err == kv.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	v, err := pquads.UnmarshalValue(p.Value)
+	if err != nil {
+		return err
+	}
+	if iri, ok := v.(quad.IRI); ok {
+		c.qs.valueLRU.Del(string(iri))
+	}
+	return nil
+}
+
+// scanHashes iterates over entries of value hash buckets with a given prefix.
+func (c *checker) scanHashes(ctx context.Context, pref byte, fnc func(k kv.Key, h refs.ValueHash, v []byte)) error {
+	it := c.tx.Scan(kv.Key{[]byte{pref}})
+	defer it.Close()
+	for it.Next(ctx) {
+		k := it.Key()
+		if len(k) < 2 || len(k[1]) == 0 {
+			continue // bucket marker
+		}
+		var h refs.ValueHash
+		if len(k[0]) != 3 || len(k[1]) != len(h) || !bytes.Equal(k[0][1:], k[1][:2]) {
+			c.problem(nil, "values: invalid key %q", k)
+			continue
+		}
+		copy(h[:], k[1])
+		fnc(k.Clone(), h, it.Val())
+	}
+	return it.Err()
+}
+
+// checkValues verifies that value hash buckets point to alive nodes with the same value.
+func (c *checker) checkValues(ctx context.Context) error {
+	seen := make(map[uint64]struct{})
+	err := c.scanHashes(ctx, 'v', func(k kv.Key, h refs.ValueHash, v []byte) {
+		id, n := binary.Uvarint(v)
+		if n <= 0 || c.byHash[h] != id {
+			c.problem(func(ctx context.Context, tx kv.Tx) error {
+				if err := c.forgetValue(ctx, tx, id); err != nil {
+					return err
+				}
+				return tx.Del(k)
+			}, "values: hash %x points to node %d instead of %d", h[:], id, c.byHash[h])
+			return
+		}
+		seen[id] = struct{}{}
+	})
+	if err != nil {
+		return err
+	}
+	for h, id := range c.byHash {
+		if _, ok := seen[id]; ok || c.nodes[id].Refs == 0 {
+			// nodes with no references are removed by checkRefs
+			continue
+		}
+		key, id := bucketKeyForHash(h), id
+		c.problem(func(ctx context.Context, tx kv.Tx) error {
+			return tx.Put(key, uint64toBytes(id))
+		}, "values: node %d is missing", id)
+	}
+	return nil
+}
+
+// checkRefs verifies reference counts of nodes against the number of alive quads that refer to them.
+func (c *checker) checkRefs(ctx context.Context) error {
+	seen := make(map[uint64]struct{})
+	err := c.scanHashes(ctx, 'n', func(k kv.Key, h refs.ValueHash, v []byte) {
+		cnt, n := binary.Uvarint(v)
+		id, ok := c.byHash[h]
+		if !ok {
+			c.problem(func(ctx context.Context, tx kv.Tx) error {
+				return tx.Del(k)
+			}, "refs: hash %x does not belong to any node", h[:])
+			return
+		}
+		seen[id] = struct{}{}
+		if exp := c.nodes[id].Refs; n <= 0 || int64(cnt) != exp {
+			if exp == 0 {
+				return // removed below
+			}
+			c.problem(func(ctx context.Context, tx kv.Tx) error {
+				return tx.Put(k, uint64toBytes(uint64(exp)))
+			}, "refs: node %d has %d references instead of %d", id, cnt, exp)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	ids := make([]uint64, 0, len(c.byHash))
+	for _, id := range c.byHash {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		n, id := c.nodes[id], id
+		if n.Refs == 0 {
+			c.problem(func(ctx context.Context, tx kv.Tx) error {
+				if err := c.forgetValue(ctx, tx, id); err != nil {
+					return err
+				}
+				if err := tx.Del(bucketKeyForHashRefs(n.Hash)); err != nil {
+					return err
+				}
+				if err := tx.Del(bucketKeyForHash(n.Hash)); err != nil {
+					return err
+				}
+				pos, err := c.qs.genDeletion(ctx, tx)
+				if err != nil {
+					return err
+				}
+				return c.qs.markNodeDead(ctx, tx, id, n.Hash, pos)
+			}, "refs: node %d is not used by any quad", id)
+		} else if _, ok := seen[id]; !ok {
+			key, cnt := bucketKeyForHashRefs(n.Hash), n.Refs
+			c.problem(func(ctx context.Context, tx kv.Tx) error {
+				return tx.Put(key, uint64toBytes(uint64(cnt)))
+			}, "refs: reference count of node %d is missing", id)
+		}
+	}
+	return nil
+}
+
+// checkIndexes verifies that each quad index contains all quads from the log under the right keys.
+func (c *checker) checkIndexes(ctx context.Context) error {
+	c.qs.indexes.RLock()
+	all := c.qs.indexes.all
+	c.qs.indexes.RUnlock()
+
+	byID := make(map[uint64]*proto.Primitive, len(c.links))
+	for _, p := range c.links {
+		byID[p.ID] = p
+	}
+	for _, ind := range all {
+		// valid IDs of index entries that must be rewritten
+		rewrite := make(map[string][]uint64)
+		seen := make(map[uint64]struct{}, len(c.links))
+		it := c.tx.Scan(ind.bucket().AppendBytes([]byte{}))
+		for it.Next(ctx) {
+			k := it.Key()
+			if len(k) < 2 || len(k[1]) == 0 {
+				continue // bucket marker
+			}
+			list, err := decodeIndex(it.Val())
+			if err != nil {
+				c.indexProblem("index %v: cannot decode entry %x: %v", ind, k[1], err)
+				rewrite[string(k[1])] = nil
+				continue
+			}
+			var (
+				valid = make([]uint64, 0, len(list))
+				prev  uint64
+				ok    = true
+			)
+			for _, id := range list {
+				p := byID[id]
+				if id <= prev || p == nil || !bytes.Equal(ind.KeyFor(p)[1], k[1]) {
+					ok = false
+				} else if _, dup := seen[id]; !dup {
+					seen[id] = struct{}{}
+					valid = append(valid, id)
+				}
+				if id > prev {
+					prev = id
+				}
+			}
+			if !ok {
+				c.indexProblem("index %v: entry %x has invalid quads", ind, k[1])
+				rewrite[string(k[1])] = valid
+			}
+		}
+		err := it.Err()
+		it.Close()
+		if err != nil {
+			return err
+		}
+		for _, p := range c.links {
+			if _, ok := seen[p.ID]; ok {
+				continue
+			}
+			c.indexProblem("index %v: quad %d is missing", ind, p.ID)
+			k := string(ind.KeyFor(p)[1])
+			list, ok := rewrite[k]
+			if !ok {
+				// keep other quads of the entry
+				v, err := c.tx.Get(ctx, ind.bucket().AppendBytes([]byte(k)))
+				if err != nil && err != kv.ErrNotFound {
+					return err
+				}
+				list, _ = decodeIndex(v)
+			}
+			rewrite[k] = append(list, p.ID)
+		}
+		if len(rewrite) == 0 {
+			continue
+		}
+		// all problems of the index are fixed by rewriting affected entries
+		keys := make([]string, 0, len(rewrite))
+		for k := range rewrite {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			list := rewrite[k]
+			sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+			key := ind.bucket().AppendBytes([]byte(k))
+			c.fixes = append(c.fixes, func(ctx context.Context, tx kv.Tx) error {
+				if len(list) == 0 {
+					return tx.Del(key)
+				}
+				return tx.Put(key, appendIndex(nil, list))
+			})
+		}
+	}
+	return nil
+}
+
+// checkMeta verifies the number of quads and the largest ID stored in metadata.
+func (c *checker) checkMeta(ctx context.Context, last uint64, alive int64) error {
+	putInt := func(key string, v int64) fixFunc {
+		return func(ctx context.Context, tx kv.Tx) error {
+			buf := make([]byte, 8)
+			binary.LittleEndian.PutUint64(buf, uint64(v))
+			return tx.Put(metaBucket.AppendBytes([]byte(key)), buf)
+		}
+	}
+	horizon, err := c.qs.getMetaIntTx(ctx, c.tx, "horizon")
+	if err != nil && err != kv.ErrNotFound {
+		return err
+	}
+	if uint64(horizon) < last {
+		c.problem(putInt("horizon", int64(last)), "meta: horizon is %d, while the log contains ID %d", horizon, last)
+	}
+	size, err := c.qs.getMetaIntTx(ctx, c.tx, "size")
+	if err != nil && err != kv.ErrNotFound {
+		return err
+	}
+	if size != alive {
+		c.problem(putInt("size", alive), "meta: size is %d instead of %d", size, alive)
+	}
+	return nil
+}
@@ -0,0 +1,555 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+)
+
+const (
+	// compactBatch is the number of log entries or change feed batches processed by Compact
+	// in a single write transaction.
+	compactBatch = 1024
+)
+
+// CompactStats reports how many entries were removed by Compact.
+type CompactStats struct {
+	Quads   int64 // deleted quad primitives
+	Nodes   int64 // node primitives with no references left
+	Changes int64 // batches of the change feed that referred to removed primitives
+}
+
+// Compact removes primitives that were deleted before the call from the log and from quad indexes.
+//
+// Work is split into small batches, each committed in a separate write transaction, thus the store
+// can be used while the compaction is in progress. Removed primitives are no longer visible in
+// views of the past state of the store (see AsOf), except for views that are in use: open snapshots
+// and views with open iterators. The change feed is truncated up to the last batch that refers to
+// removed primitives, but never past the position of a feed that is reading changes, and never
+// into the last batches kept for other feeds (see OptChangesKeep). Primitives deleted by the kept
+// batches are kept as well. Feeds that continue before the truncated position fail with ErrChangesCompacted.
+//
+// Primitives to remove are found in batches of the change feed committed after the last compaction.
+// The whole log is scanned only if the position of the last compaction is unknown, for example
+// on the first run, or after Check has deleted a quad.
+func (qs *QuadStore) Compact(ctx context.Context) (CompactStats, error) {
+	var st CompactStats
+	if qs.isView() {
+		return st, graph.ErrReadOnly
+	}
+	// wait for in-progress writes, so all primitives deleted before this point are committed
+	qs.writer.Lock()
+	before := time.Now().UnixNano()
+	qs.writer.Unlock()
+	before, keep := qs.readLimits(before, math.MaxInt64)
+
+	horizon, err := qs.getMetaInt(ctx, "horizon")
+	if err == ErrNoBucket {
+		return st, nil
+	} else if err != nil {
+		return st, err
+	}
+	first, last, before, err := qs.obsoleteChanges(ctx, before, keep)
+	if err != nil {
+		return st, err
+	}
+	// deleted IDs are read before the change feed is truncated
+	del, err := qs.deletedChanges(ctx, before)
+	if err != nil {
+		return st, err
+	}
+	before = del.before
+	if last > del.pos {
+		last = del.pos
+	}
+	// truncate the change feed first, so it never refers to missing primitives
+	st.Changes, err = qs.compactChanges(ctx, first, last)
+	if err != nil {
+		return st, err
+	}
+	compact := func(ids []uint64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		quads, nodes, err := qs.compactLog(ctx, ids, before)
+		st.Quads += quads
+		st.Nodes += nodes
+		return err
+	}
+	if del.all {
+		for start := uint64(1); start <= uint64(horizon); start += compactBatch {
+			n := uint64(compactBatch)
+			if start+n > uint64(horizon)+1 {
+				n = uint64(horizon) + 1 - start
+			}
+			ids := make([]uint64, n)
+			for i := range ids {
+				ids[i] = start + uint64(i)
+			}
+			if err := compact(ids); err != nil {
+				return st, err
+			}
+		}
+	}
+	for ids := del.ids; len(ids) != 0; {
+		n := len(ids)
+		if n > compactBatch {
+			n = compactBatch
+		}
+		if err := compact(ids[:n]); err != nil {
+			return st, err
+		}
+		ids = ids[n:]
+	}
+	if del.all && !del.done {
+		// the scan must be repeated until it catches up with the change feed
+		return st, nil
+	}
+	return st, qs.update(ctx, func(tx kv.Tx) error {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(del.pos))
+		return tx.Put(metaBucket.AppendBytes([]byte(metaCompacted)), buf)
+	})
+}
+
+// compactDeletions lists primitives deleted by batches of the change feed since the last compaction.
+type compactDeletions struct {
+	ids    []uint64 // sorted IDs of deleted quads and nodes they referred to; not set if all is set
+	pos    int64    // position of the last batch whose deletions are removed
+	before int64    // time before which deletions are removed, so batches after pos are not affected
+	all    bool     // the position of the last compaction is unknown, the whole log must be scanned
+	done   bool     // all batches of the change feed were processed
+}
+
+// deletedChanges reads batches of the change feed after the last compaction that only delete primitives
+// before a given time. The time is moved back if needed, so primitives deleted by the next batch are kept.
+func (qs *QuadStore) deletedChanges(ctx context.Context, before int64) (*compactDeletions, error) {
+	d := &compactDeletions{before: before}
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		tx = wrapTx(tx)
+		start, err := qs.getMetaIntTx(ctx, tx, metaChangesStart)
+		if err != nil && err != kv.ErrNotFound {
+			return err
+		}
+		d.pos, err = qs.getMetaIntTx(ctx, tx, metaCompacted)
+		if err == kv.ErrNotFound || (err == nil && d.pos < start) {
+			// deletions in removed batches are unknown
+			d.pos, d.all = start, true
+		} else if err != nil {
+			return err
+		}
+		end, err := qs.getMetaIntTx(ctx, tx, metaChanges)
+		if err != nil && err != kv.ErrNotFound {
+			return err
+		}
+		ids := make(map[uint64]struct{})
+		for ; d.pos < end; d.pos++ {
+			ts, prims, dels, err := qs.readChangePrimitives(ctx, tx, d.pos+1)
+			if err != nil {
+				return err
+			}
+			if ts > d.before {
+				// primitives are deleted before the batch is committed
+				for i, p := range prims {
+					if p != nil && dels[i] && isPurged(p, d.before) {
+						d.before = p.DeletedAt - 1
+					}
+				}
+				return nil
+			}
+			if d.all {
+				continue
+			}
+			for i, p := range prims {
+				if p == nil || !dels[i] {
+					continue
+				}
+				ids[p.ID] = struct{}{}
+				// nodes are deleted together with the last quad that refers to them
+				for _, dir := range quad.Directions {
+					if id := p.GetDirection(dir); id != 0 {
+						ids[id] = struct{}{}
+					}
+				}
+			}
+		}
+		d.done = true
+		if len(ids) != 0 {
+			d.ids = make([]uint64, 0, len(ids))
+			for id := range ids {
+				d.ids = append(d.ids, id)
+			}
+			sort.Sort(Int64Set(d.ids))
+		}
+		return nil
+	})
+	return d, err
+}
+
+// pinView marks a view that observes primitives deleted after a given time (in Unix nanoseconds) as being in use.
+func (qs *QuadStore) pinView(at int64) {
+	qs.readers.Lock()
+	if qs.readers.views == nil {
+		qs.readers.views = make(map[int64]int)
+	}
+	qs.readers.views[at]++
+	qs.readers.Unlock()
+}
+
+// unpinView releases a view pinned by pinView.
+func (qs *QuadStore) unpinView(at int64) {
+	qs.readers.Lock()
+	if qs.readers.views[at]--; qs.readers.views[at] <= 0 {
+		delete(qs.readers.views, at)
+	}
+	qs.readers.Unlock()
+}
+
+// pinFeed marks a change feed that reads changes after a given position as being in use.
+func (qs *QuadStore) pinFeed(after int64) {
+	qs.readers.Lock()
+	if qs.readers.feeds == nil {
+		qs.readers.feeds = make(map[int64]int)
+	}
+	qs.readers.feeds[after]++
+	qs.readers.Unlock()
+}
+
+// unpinFeed releases a change feed pinned by pinFeed.
+func (qs *QuadStore) unpinFeed(after int64) {
+	qs.readers.Lock()
+	if qs.readers.feeds[after]--; qs.readers.feeds[after] <= 0 {
+		delete(qs.readers.feeds, after)
+	}
+	qs.readers.Unlock()
+}
+
+// readLimits lowers a deletion time to the earliest one observed by views in use,
+// and a change feed position to the lowest one read by feeds in use.
+func (qs *QuadStore) readLimits(before, pos int64) (int64, int64) {
+	qs.readers.Lock()
+	defer qs.readers.Unlock()
+	for at := range qs.readers.views {
+		if at < before {
+			before = at
+		}
+	}
+	for p := range qs.readers.feeds {
+		if p < pos {
+			pos = p
+		}
+	}
+	return before, pos
+}
+
+// isPurged checks if the primitive will be removed by a compaction that started at a given time.
+func isPurged(p *proto.Primitive, before int64) bool {
+	// DeletedAt is not set for primitives deleted by older versions
+	return p.Deleted && p.DeletedAt <= before
+}
+
+// compactLog removes deleted primitives with given IDs from the log and from quad indexes.
+func (qs *QuadStore) compactLog(ctx context.Context, ids []uint64, before int64) (quads, nodes int64, _ error) {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	tx, err := qs.db.Tx(true)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Close()
+	tx = wrapTx(tx)
+
+	prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
+	if err != nil {
+		return 0, 0, err
+	}
+	qs.indexes.RLock()
+	all := append([]QuadIndex{}, qs.indexes.all...)
+	for _, b := range qs.indexes.building {
+		all = append(all, b.QuadIndex)
+	}
+	qs.indexes.RUnlock()
+
+	// IDs to remove from index entries, grouped by index bucket
+	del := make(map[string]map[string][]uint64)
+	for _, p := range prims {
+		if p == nil || !isPurged(p, before) {
+			continue
+		}
+		if p.IsNode() {
+			if err := qs.unlinkDeadNode(ctx, tx, p); err != nil {
+				return quads, nodes, err
+			}
+			nodes++
+		} else {
+			for _, ind := range all {
+				k := ind.KeyFor(p)
+				b := string(k[0])
+				m := del[b]
+				if m == nil {
+					m = make(map[string][]uint64)
+					del[b] = m
+				}
+				m[string(k[1])] = append(m[string(k[1])], p.ID)
+			}
+			quads++
+		}
+		if err := qs.delLog(tx, p.ID); err != nil {
+			return quads, nodes, err
+		}
+	}
+	if quads == 0 && nodes == 0 {
+		return 0, 0, nil
+	}
+	if err := qs.removeFromIndexes(ctx, tx, del); err != nil {
+		return quads, nodes, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, err
+	}
+	return quads, nodes, nil
+}
+
+// unlinkDeadNode removes a dead node primitive from the list of primitives that held the same value.
+func (qs *QuadStore) unlinkDeadNode(ctx context.Context, tx kv.Tx, p *proto.Primitive) error {
+	v, err := pquads.UnmarshalValue(p.Value)
+	if err != nil {
+		return err
+	}
+	h := refs.HashOf(v)
+	key := deadBucket.AppendBytes(h[:])
+	b, err := tx.Get(ctx, key)
+	if err == kv.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// the list starts from the latest primitive
+	id, _ := binary.Uvarint(b)
+	var prev *proto.Primitive
+	for id != 0 && id != p.ID {
+		prev, err = qs.getPrimitiveFromLog(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		id = prev.Replaces
+	}
+	if id == 0 {
+		// deleted by an older version
+		return nil
+	}
+	// the primitive might have been changed by this function in the same transaction
+	p, err = qs.getPrimitiveFromLog(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case prev != nil:
+		prev.Replaces = p.Replaces
+		return qs.addToLog(tx, prev)
+	case p.Replaces != 0:
+		return tx.Put(key, uint64toBytes(p.Replaces))
+	default:
+		return tx.Del(key)
+	}
+}
+
+// removeFromIndexes removes IDs from index entries, grouped by index bucket. Empty entries are deleted.
+func (qs *QuadStore) removeFromIndexes(ctx context.Context, tx kv.Tx, del map[string]map[string][]uint64) error {
+	bs := make([]string, 0, len(del))
+	for b := range del {
+		bs = append(bs, b)
+	}
+	sort.Strings(bs)
+	for _, bucket := range bs {
+		m := del[bucket]
+		b := kv.Key{[]byte(bucket)}
+		keys := make([]kv.Key, 0, len(m))
+		for k := range m {
+			keys = append(keys, b.AppendBytes([]byte(k)))
+		}
+		sort.Sort(kv.ByKey(keys))
+		lists, err := qs.getBucketIndexes(ctx, tx, keys)
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			rm := make(map[uint64]struct{})
+			for _, id := range m[string(k[1])] {
+				rm[id] = struct{}{}
+			}
+			list := lists[i][:0]
+			for _, id := range lists[i] {
+				if _, ok := rm[id]; !ok {
+					list = append(list, id)
+				}
+			}
+			if len(list) == 0 {
+				err = tx.Del(k)
+			} else {
+				err = tx.Put(k, appendIndex(nil, list))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compactChanges removes a range of batches from the beginning of the change feed.
+func (qs *QuadStore) compactChanges(ctx context.Context, first, last int64) (int64, error) {
+	if last < first {
+		return 0, nil
+	}
+	for pos := first; pos <= last; pos += compactBatch {
+		end := pos + compactBatch - 1
+		if end > last {
+			end = last
+		}
+		err := qs.update(ctx, func(tx kv.Tx) error {
+			if pos == first {
+				// feeds must fail instead of skipping removed batches
+				buf := make([]byte, 8)
+				binary.LittleEndian.PutUint64(buf, uint64(last))
+				if err := tx.Put(metaBucket.AppendBytes([]byte(metaChangesStart)), buf); err != nil {
+					return err
+				}
+			}
+			for p := pos; p <= end; p++ {
+				if err := tx.Del(changesBucket.Append(uint64KeyBytes(uint64(p)))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return last - first + 1, nil
+}
+
+// update runs a function in a write transaction while no other writes are in progress.
+func (qs *QuadStore) update(ctx context.Context, fnc func(tx kv.Tx) error) error {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	return kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		return fnc(wrapTx(tx))
+	})
+}
+
+// obsoleteChanges returns a range of change feed positions that should be removed by a compaction
+// that started at a given time. The range is empty if last < first.
+//
+// Batches after a given position are kept, as well as the last batches set by OptChangesKeep. Primitives
+// they refer to must be kept too, thus the time is moved back to the earliest deletion of such primitives
+// and returned.
+//
+// Batches before the last compaction are not scanned again: each primitive they refer to was either removed
+// together with them, or is still alive, or was deleted by a later batch, that ends the range anyway.
+func (qs *QuadStore) obsoleteChanges(ctx context.Context, before, keep int64) (first, last, _ int64, _ error) {
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		tx = wrapTx(tx)
+		start, err := qs.getMetaIntTx(ctx, tx, metaChangesStart)
+		if err != nil && err != kv.ErrNotFound {
+			return err
+		}
+		end, err := qs.getMetaIntTx(ctx, tx, metaChanges)
+		if err == kv.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		first, last = start+1, start
+		if keep > end-qs.changesKeep {
+			keep = end - qs.changesKeep
+		}
+		if keep > end {
+			keep = end
+		} else if keep < start {
+			keep = start
+		}
+		from := first
+		if pos, err := qs.getMetaIntTx(ctx, tx, metaCompacted); err == nil && pos >= start {
+			from = pos + 1
+		} else if err != nil && err != kv.ErrNotFound {
+			return err
+		}
+		started := before
+		for pos := keep + 1; pos <= end; pos++ {
+			ts, prims, _, err := qs.readChangePrimitives(ctx, tx, pos)
+			if err != nil {
+				return err
+			} else if ts > started {
+				// committed after the compaction has started
+				break
+			}
+			for _, p := range prims {
+				if p != nil && isPurged(p, before) {
+					before = p.DeletedAt - 1
+				}
+			}
+		}
+		for pos := from; pos <= keep; pos++ {
+			ts, prims, _, err := qs.readChangePrimitives(ctx, tx, pos)
+			if err != nil {
+				return err
+			} else if ts > started {
+				break
+			}
+			for _, p := range prims {
+				if p == nil || isPurged(p, before) {
+					last = pos
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return first, last, before, err
+}
+
+// readChangePrimitives reads the commit time of a batch in the change feed, primitives it refers to,
+// and flags for deleted ones.
+func (qs *QuadStore) readChangePrimitives(ctx context.Context, tx kv.Tx, pos int64) (int64, []*proto.Primitive, []bool, error) {
+	b, err := tx.Get(ctx, changesBucket.Append(uint64KeyBytes(uint64(pos))))
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("kv: cannot read change at position %d: %v", pos, err)
+	}
+	ts, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, nil, fmt.Errorf("kv: corrupted change at position %d", pos)
+	}
+	ids, dels, err := decodeChange(b[n:])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("kv: corrupted change at position %d", pos)
+	}
+	prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
+	return int64(ts), prims, dels, err
+}
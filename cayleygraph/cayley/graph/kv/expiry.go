@@ -0,0 +1,183 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/cayleygraph/cayley/graph"
+	graphlog "github.com/cayleygraph/cayley/graph/log"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+	"github.com/hidal-go/hidalgo/kv"
+)
+
+var _ graph.Expirer = (*QuadStore)(nil)
+
+// expiringBucket lists all quad primitives with an expiration time, including expired ones that were
+// not yet deleted. Keys are the expiration time followed by the primitive ID, thus the bucket is sorted
+// by the expiration time.
+var expiringBucket = kv.Key{[]byte("expiring")}
+
+func expiringKey(p *proto.Primitive) kv.Key {
+	k := make([]byte, 16)
+	quadKeyEnc.PutUint64(k, uint64(p.Expires))
+	quadKeyEnc.PutUint64(k[8:], p.ID)
+	return expiringBucket.AppendBytes(k)
+}
+
+// isExpired checks if the quad primitive is expired at a given time (in Unix nanoseconds).
+func isExpired(p *proto.Primitive, now int64) bool {
+	return p.Expires != 0 && p.Expires <= now
+}
+
+// replacesExpiring checks if a new quad with a given expiration time must replace an existing one.
+// Expired quads are always replaced, and other quads are replaced only if the expiration time differs,
+// thus adding a quad without the expiration time over an expiring one clears it.
+func replacesExpiring(old *proto.Primitive, expires, now int64) bool {
+	return isExpired(old, now) || old.Expires != expires
+}
+
+// scanExpired calls fnc for IDs of all quads that are expired at a given time, but were not yet deleted.
+func scanExpired(ctx context.Context, tx kv.Tx, now int64, fnc func(id uint64) error) error {
+	it := tx.Scan(expiringBucket)
+	defer it.Close()
+	for it.Next(ctx) {
+		k := it.Key()
+		if len(k) != 2 || len(k[1]) != 16 {
+			continue // bucket marker
+		}
+		b := k[1]
+		if int64(quadKeyEnc.Uint64(b)) > now {
+			break
+		}
+		if err := fnc(quadKeyEnc.Uint64(b[8:])); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// hasExpiring checks if any quads have an expiration time.
+func hasExpiring(ctx context.Context, tx kv.Tx) (bool, error) {
+	it := tx.Scan(expiringBucket)
+	defer it.Close()
+	for it.Next(ctx) {
+		if k := it.Key(); len(k) == 2 && len(k[1]) == 16 {
+			return true, nil
+		}
+	}
+	return false, it.Err()
+}
+
+// numExpired returns the number of expired quads that were not yet deleted.
+func (qs *QuadStore) numExpired(ctx context.Context) (int64, error) {
+	now := time.Now().UnixNano()
+	var n int64
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		return scanExpired(ctx, tx, now, func(id uint64) error {
+			n++
+			return nil
+		})
+	})
+	return n, err
+}
+
+// ExpireQuads implements graph.Expirer.
+//
+// Expired quads are deleted in a single transaction, which is recorded in the change feed.
+func (qs *QuadStore) ExpireQuads(ctx context.Context, now time.Time) ([]quad.Quad, error) {
+	if qs.isView() {
+		return nil, graph.ErrReadOnly
+	}
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	tx, err := qs.db.Tx(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close()
+	tx = wrapTx(tx)
+
+	var ids []uint64
+	if err := scanExpired(ctx, tx, now.UnixNano(), func(id uint64) error {
+		ids = append(ids, id)
+		return nil
+	}); err != nil {
+		return nil, err
+	} else if len(ids) == 0 {
+		return nil, nil
+	}
+	prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		links = make([]proto.Primitive, 0, len(prims))
+		quads = make([]quad.Quad, 0, len(prims))
+		// nodes lose a reference for each deleted quad
+		dec  = make(map[refs.ValueHash]*graphlog.NodeUpdate)
+		hids = make(map[refs.ValueHash]uint64)
+	)
+	for _, p := range prims {
+		if p == nil || p.Deleted {
+			continue
+		}
+		q, err := qs.primitiveToQuad(ctx, tx, p)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *p)
+		quads = append(quads, q)
+		for _, dir := range quad.Directions {
+			v := q.Get(dir)
+			if v == nil {
+				continue
+			}
+			h := refs.HashOf(v)
+			n := dec[h]
+			if n == nil {
+				n = &graphlog.NodeUpdate{Hash: h, Val: v}
+				dec[h] = n
+				hids[h] = p.GetDirection(dir)
+			}
+			n.RefInc--
+		}
+	}
+	decNodes := make([]graphlog.NodeUpdate, 0, len(dec))
+	for _, n := range dec {
+		decNodes = append(decNodes, *n)
+	}
+	qs.mapNodes = nil
+	if err := qs.markLinksDead(ctx, tx, links); err != nil {
+		return nil, err
+	}
+	if err := qs.decNodes(ctx, tx, decNodes, hids); err != nil {
+		return nil, err
+	}
+	if err := qs.logChange(ctx, tx, appendChange(nil, links, true)); err != nil {
+		return nil, err
+	}
+	if err := qs.flushMapBucket(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	qs.changes.Notify()
+	return quads, nil
+}
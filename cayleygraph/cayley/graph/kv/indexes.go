@@ -0,0 +1,379 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/quad"
+)
+
+var (
+	// keyMetaIndexesBuilding stores indexes that are being built, and the progress of the build.
+	keyMetaIndexesBuilding = metaBucket.AppendBytes([]byte("indexes_building"))
+
+	ErrIndexExists   = errors.New("kv: index already exists")
+	ErrIndexNotFound = errors.New("kv: index does not exist")
+)
+
+const (
+	// indexBuildBatch is the number of log entries indexed in a single write transaction.
+	indexBuildBatch = 1024
+)
+
+// ParseQuadIndex parses an index from a list of direction prefixes, for example "sp" or "ops".
+// Both 'c' and 'l' prefixes refer to the label.
+func ParseQuadIndex(s string) (QuadIndex, error) {
+	var ind QuadIndex
+	for _, c := range []byte(s) {
+		var d quad.Direction
+		switch c {
+		case 's':
+			d = quad.Subject
+		case 'p':
+			d = quad.Predicate
+		case 'o':
+			d = quad.Object
+		case 'c', 'l':
+			d = quad.Label
+		default:
+			return QuadIndex{}, fmt.Errorf("kv: invalid index direction: %q", c)
+		}
+		ind.Dirs = append(ind.Dirs, d)
+	}
+	return ind, ind.validate()
+}
+
+func (ind QuadIndex) validate() error {
+	if len(ind.Dirs) == 0 {
+		return errors.New("kv: index has no directions")
+	}
+	for i, d := range ind.Dirs {
+		if d.Prefix() == '\x00' || d == quad.Any {
+			return fmt.Errorf("kv: invalid index direction: %v", d)
+		} else if hasDir(ind.Dirs[:i], d) {
+			return fmt.Errorf("kv: duplicate index direction: %v", d)
+		}
+	}
+	return nil
+}
+
+// String returns direction prefixes of the index, for example "sp" or "ops".
+func (ind QuadIndex) String() string {
+	return string(ind.bucket()[0])
+}
+
+// IndexInfo describes a quad index of the store.
+type IndexInfo struct {
+	QuadIndex
+	// Building is set for indexes that are not used for lookups yet.
+	Building bool `json:"building,omitempty"`
+}
+
+// indexBuild is the state of an index that is being built.
+type indexBuild struct {
+	QuadIndex
+	// Next is the ID of the next primitive to index.
+	Next uint64 `json:"next"`
+}
+
+func findIndex(list []QuadIndex, ind QuadIndex) int {
+	for i, in := range list {
+		if in.String() == ind.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+func findBuild(list []indexBuild, ind QuadIndex) int {
+	for i, b := range list {
+		if b.String() == ind.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+// ListIndexes returns all quad indexes of the store, including ones that are being built.
+func (qs *QuadStore) ListIndexes(ctx context.Context) ([]IndexInfo, error) {
+	qs.indexes.RLock()
+	defer qs.indexes.RUnlock()
+	out := make([]IndexInfo, 0, len(qs.indexes.all)+len(qs.indexes.building))
+	for _, ind := range qs.indexes.all {
+		out = append(out, IndexInfo{QuadIndex: ind})
+	}
+	for _, b := range qs.indexes.building {
+		out = append(out, IndexInfo{QuadIndex: b.QuadIndex, Building: true})
+	}
+	return out, nil
+}
+
+// readBuildingMeta reads the list of indexes that are being built.
+func (qs *QuadStore) readBuildingMeta(ctx context.Context) ([]indexBuild, error) {
+	var out []indexBuild
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		val, err := tx.Get(ctx, keyMetaIndexesBuilding)
+		if err == kv.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(val, &out); err != nil {
+			return fmt.Errorf("cannot decode indexes: %v", err)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// writeIndexesMetaTx writes metadata about current indexes and indexes that are being built.
+func (qs *QuadStore) writeIndexesMetaTx(tx kv.Tx, all []QuadIndex, building []indexBuild) error {
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	if err = tx.Put(keyMetaIndexes, data); err != nil {
+		return err
+	}
+	if len(building) == 0 {
+		return tx.Del(keyMetaIndexesBuilding)
+	}
+	data, err = json.Marshal(building)
+	if err != nil {
+		return err
+	}
+	return tx.Put(keyMetaIndexesBuilding, data)
+}
+
+// setIndexes replaces the list of indexes and writes it to the database. Caller must hold the writer lock.
+func (qs *QuadStore) setIndexes(ctx context.Context, tx kv.Tx, all []QuadIndex, building []indexBuild) error {
+	if err := qs.writeIndexesMetaTx(tx, all, building); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	qs.indexes.Lock()
+	qs.indexes.all = all
+	qs.indexes.building = building
+	qs.indexes.exists = nil
+	qs.indexes.Unlock()
+	return nil
+}
+
+// AddIndex builds a new quad index from the log and starts using it for lookups once it's complete.
+//
+// The index is built in small batches, each committed in a separate write transaction, thus the
+// store can be used while the build is in progress. If the build was interrupted, calling AddIndex
+// again continues it.
+func (qs *QuadStore) AddIndex(ctx context.Context, ind QuadIndex) error {
+	if qs.isView() {
+		return graph.ErrReadOnly
+	}
+	if err := ind.validate(); err != nil {
+		return err
+	}
+	if err := qs.startIndexBuild(ctx, ind); err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		done, err := qs.buildIndexBatch(ctx, ind)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// startIndexBuild registers a new index as being built, unless it's already in progress.
+func (qs *QuadStore) startIndexBuild(ctx context.Context, ind QuadIndex) error {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	qs.indexes.RLock()
+	all, building := qs.indexes.all, qs.indexes.building
+	qs.indexes.RUnlock()
+	if findIndex(all, ind) >= 0 {
+		return ErrIndexExists
+	}
+	// index entries are written to the new bucket without the write buffer bloom filter,
+	// so it cannot be used to skip reading existing entries anymore
+	qs.mapBloom = nil
+	if findBuild(building, ind) >= 0 {
+		return nil // resume
+	}
+
+	tx, err := qs.db.Tx(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+	tx = wrapTx(tx)
+	if err = kv.CreateBucket(ctx, tx, ind.bucket()); err != nil {
+		return err
+	}
+	building = append(building[:len(building):len(building)], indexBuild{QuadIndex: ind, Next: 1})
+	return qs.setIndexes(ctx, tx, all, building)
+}
+
+// buildIndexBatch indexes the next batch of primitives from the log. Once all of them are indexed,
+// the index is moved to the list of complete indexes, while the writer lock is still held.
+func (qs *QuadStore) buildIndexBatch(ctx context.Context, ind QuadIndex) (bool, error) {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	qs.indexes.RLock()
+	all, building := qs.indexes.all, qs.indexes.building
+	qs.indexes.RUnlock()
+	i := findBuild(building, ind)
+	if i < 0 {
+		return false, errors.New("kv: index was dropped while building")
+	}
+	b := building[i]
+
+	tx, err := qs.db.Tx(true)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Close()
+	tx = wrapTx(tx)
+	horizon, err := qs.getMetaIntTx(ctx, tx, "horizon")
+	if err != nil && err != kv.ErrNotFound {
+		return false, err
+	}
+	end := b.Next + indexBuildBatch - 1
+	done := end >= uint64(horizon)
+	if done {
+		end = uint64(horizon)
+	}
+	if b.Next <= end {
+		ids := make([]uint64, end-b.Next+1)
+		for j := range ids {
+			ids[j] = b.Next + uint64(j)
+		}
+		prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
+		if err != nil {
+			return false, err
+		}
+		for _, p := range prims {
+			// deleted links are indexed as well, so they are visible in the past
+			if p == nil || p.IsNode() {
+				continue
+			}
+			if err := qs.addToMapBucket(tx, ind.KeyFor(p), p.ID); err != nil {
+				qs.mapBucket = nil
+				return false, err
+			}
+		}
+		if err := qs.flushMapBucket(ctx, tx); err != nil {
+			qs.mapBucket = nil
+			return false, err
+		}
+	}
+	building = append([]indexBuild{}, building...)
+	if done {
+		building = append(building[:i], building[i+1:]...)
+		all = append(all[:len(all):len(all)], ind)
+		clog.Infof("kv: index %v is built", ind)
+	} else {
+		building[i].Next = end + 1
+	}
+	if err := qs.setIndexes(ctx, tx, all, building); err != nil {
+		return false, err
+	}
+	return done, nil
+}
+
+// DropIndex stops using a quad index and removes all entries of it.
+// An index that is being built can be dropped as well. The last complete index cannot be dropped.
+func (qs *QuadStore) DropIndex(ctx context.Context, ind QuadIndex) error {
+	if qs.isView() {
+		return graph.ErrReadOnly
+	}
+	err := func() error {
+		qs.writer.Lock()
+		defer qs.writer.Unlock()
+		qs.indexes.RLock()
+		all, building := qs.indexes.all, qs.indexes.building
+		qs.indexes.RUnlock()
+		if i := findIndex(all, ind); i >= 0 {
+			if len(all) == 1 {
+				return errors.New("kv: cannot drop the last index")
+			}
+			all = append(append([]QuadIndex{}, all[:i]...), all[i+1:]...)
+		} else if i = findBuild(building, ind); i >= 0 {
+			building = append(append([]indexBuild{}, building[:i]...), building[i+1:]...)
+		} else {
+			return ErrIndexNotFound
+		}
+		if qs.mapBloom != nil {
+			delete(qs.mapBloom, ind.String())
+		}
+		tx, err := qs.db.Tx(true)
+		if err != nil {
+			return err
+		}
+		defer tx.Close()
+		return qs.setIndexes(ctx, wrapTx(tx), all, building)
+	}()
+	if err != nil {
+		return err
+	}
+	// the index is not used anymore, remove entries in batches
+	pref := ind.bucket().AppendBytes([]byte{})
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var keys []kv.Key
+		err := kv.View(qs.db, func(tx kv.Tx) error {
+			it := tx.Scan(pref)
+			defer it.Close()
+			for len(keys) < indexBuildBatch && it.Next(ctx) {
+				k := it.Key()
+				if len(k) < 2 || len(k[len(k)-1]) == 0 {
+					continue // keep the bucket marker, see kv.CreateBucket
+				}
+				keys = append(keys, k.Clone())
+			}
+			return it.Err()
+		})
+		if err != nil {
+			return err
+		} else if len(keys) == 0 {
+			break
+		}
+		err = qs.update(ctx, func(tx kv.Tx) error {
+			for _, k := range keys {
+				if err := tx.Del(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
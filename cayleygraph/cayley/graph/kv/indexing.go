@@ -40,6 +40,9 @@ import (
 var (
 	metaBucket = kv.Key{[]byte("meta")}
 	logIndex   = kv.Key{[]byte("log")}
+	// deadBucket maps hashes of deleted values to the last node primitive that held this value.
+	// Previous node primitives for the same value are linked via Replaces field.
+	deadBucket = kv.Key{[]byte("dead")}
 
 	keyMetaIndexes = metaBucket.AppendBytes([]byte("indexes"))
 
@@ -444,6 +447,10 @@ func (qs *QuadStore) decNodes(ctx context.Context, tx kv.Tx, deltas []graphlog.N
 		upds = append(upds, nodeUpdate{Ind: i, ID: id, NodeUpdate: d})
 	}
 	del, err := qs.incNodesCnt(ctx, tx, upds, nil)
+	if err != nil || len(del) == 0 {
+		return err
+	}
+	pos, err := qs.genDeletion(ctx, tx)
 	if err != nil {
 		return err
 	}
@@ -456,13 +463,37 @@ func (qs *QuadStore) decNodes(ctx context.Context, tx kv.Tx, deltas []graphlog.N
 		if iri, ok := d.Val.(quad.IRI); ok {
 			qs.valueLRU.Del(string(iri))
 		}
-		if err := qs.delLog(tx, d.ID); err != nil {
+		// keep the node in the log until compaction, so it's still visible in the past
+		if err := qs.markNodeDead(ctx, tx, d.ID, d.Hash, pos); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// markNodeDead marks the node primitive as deleted at a given log position and links it with
+// previously deleted primitives for the same value.
+func (qs *QuadStore) markNodeDead(ctx context.Context, tx kv.Tx, id uint64, h refs.ValueHash, pos uint64) error {
+	p, err := qs.getPrimitiveFromLog(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	key := deadBucket.AppendBytes(h[:])
+	prev, err := tx.Get(ctx, key)
+	if err != nil && err != kv.ErrNotFound {
+		return err
+	} else if len(prev) != 0 {
+		p.Replaces, _ = binary.Uvarint(prev)
+	}
+	p.Deleted = true
+	p.DeletedAt = time.Now().UnixNano()
+	p.DeletedHorizon = pos
+	if err := qs.addToLog(tx, p); err != nil {
+		return err
+	}
+	return tx.Put(key, uint64toBytes(id))
+}
+
 func (qs *QuadStore) NewQuadWriter() (quad.WriteCloser, error) {
 	return &quadWriter{qs: qs}, nil
 }
@@ -472,6 +503,8 @@ type quadWriter struct {
 	tx  kv.Tx
 	err error
 	n   int
+	// change is an encoded batch of changes for the change feed
+	change []byte
 }
 
 func (w *quadWriter) WriteQuad(q quad.Quad) error {
@@ -482,6 +515,11 @@ func (w *quadWriter) WriteQuad(q quad.Quad) error {
 func (w *quadWriter) flush() error {
 	w.n = 0
 	ctx := context.TODO()
+	if err := w.qs.logChange(ctx, w.tx, w.change); err != nil {
+		w.err = err
+		return err
+	}
+	w.change = w.change[:0]
 	if err := w.qs.flushMapBucket(ctx, w.tx); err != nil {
 		w.err = err
 		return err
@@ -492,6 +530,7 @@ func (w *quadWriter) flush() error {
 		w.err = err
 		return err
 	}
+	w.qs.changes.Notify()
 	tx, err := w.qs.db.Tx(true)
 	if err != nil {
 		w.qs.writer.Unlock()
@@ -518,10 +557,13 @@ func (w *quadWriter) WriteQuads(buf []quad.Quad) (int, error) {
 	}
 	// 拆分成对边/点的增加/删除操作
 	deltas := graphlog.InsertQuads(buf)
-	if _, err := w.qs.applyAddDeltas(w.tx, nil, deltas, graph.IgnoreOpts{IgnoreDup: true}); err != nil {
+	_, links, replaced, err := w.qs.applyAddDeltas(w.tx, nil, deltas, graph.IgnoreOpts{IgnoreDup: true})
+	if err != nil {
 		w.err = err
 		return 0, err
 	}
+	w.change = appendChange(w.change, replaced, true)
+	w.change = appendChange(w.change, links, false)
 	w.n += len(buf)
 	if w.n >= quad.DefaultBatch*20 {
 		if err := w.flush(); err != nil {
@@ -544,8 +586,15 @@ func (w *quadWriter) Close() error {
 	}
 
 	ctx := context.TODO()
+	err := w.qs.logChange(ctx, w.tx, w.change)
+	w.change = nil
+	if err != nil {
+		_ = w.tx.Close()
+		w.tx = nil
+		return err
+	}
 	// flush quad indexes and commit
-	err := w.qs.flushMapBucket(ctx, w.tx)
+	err = w.qs.flushMapBucket(ctx, w.tx)
 	if err != nil {
 		_ = w.tx.Close()
 		w.tx = nil
@@ -553,25 +602,44 @@ func (w *quadWriter) Close() error {
 	}
 	err = w.tx.Commit(ctx)
 	w.tx = nil
-	return err
+	if err != nil {
+		return err
+	}
+	w.qs.changes.Notify()
+	return nil
 }
 
-func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog.Deltas, ignoreOpts graph.IgnoreOpts) (map[refs.ValueHash]resolvedNode, error) {
+// applyAddDeltas adds new nodes and quads. It returns resolved nodes, added quads and existing quads
+// that were replaced by quads with a different expiration time.
+func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog.Deltas, ignoreOpts graph.IgnoreOpts) (map[refs.ValueHash]resolvedNode, []proto.Primitive, []proto.Primitive, error) {
 	ctx := context.TODO()
+	now := time.Now().UnixNano()
 
 	// first add all new nodes
 	// 首先添加所有的(Subject,Predicate,Object,Label)
 	// 添加所有的点操作
 	nodes, err := qs.incNodes(ctx, tx, deltas.IncNode)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	deltas.IncNode = nil
+	// existing quads must be fetched to compare expiration times
+	expiring, err := hasExpiring(ctx, tx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	// resolve and insert all new quads
-	links := make([]proto.Primitive, 0, len(deltas.QuadAdd))
+	var (
+		links    = make([]proto.Primitive, 0, len(deltas.QuadAdd))
+		replaced []proto.Primitive
+		fixNodes map[refs.ValueHash]int
+	)
 	qadd := make(map[[4]uint64]struct{}, len(deltas.QuadAdd))
 	for _, q := range deltas.QuadAdd {
 		var link proto.Primitive
+		if len(in) != 0 && !in[q.Ind].Expires.IsZero() {
+			link.Expires = in[q.Ind].Expires.UnixNano()
+		}
 		mustBeNew := false
 		var qkey [4]uint64
 		for i, dir := range quad.Directions {
@@ -589,9 +657,23 @@ func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog
 		qadd[qkey] = struct{}{}
 		if !mustBeNew {
 			// 不是新的边
-			p, err := qs.hasPrimitive(ctx, tx, &link, false)
+			get := expiring || link.Expires != 0
+			p, err := qs.hasPrimitive(ctx, tx, &link, get)
 			if err != nil {
-				return nil, err
+				return nil, nil, nil, err
+			}
+			if p != nil && get && replacesExpiring(p, link.Expires, now) {
+				// the new quad replaces the existing one, thus the node references it held must be released
+				replaced = append(replaced, *p)
+				if fixNodes == nil {
+					fixNodes = make(map[refs.ValueHash]int)
+				}
+				for _, dir := range quad.Directions {
+					if h := q.Quad.Get(dir); h.Valid() {
+						fixNodes[h]--
+					}
+				}
+				p = nil
 			}
 			if p != nil {
 				if ignoreOpts.IgnoreDup {
@@ -599,9 +681,9 @@ func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog
 				}
 				err = graph.ErrQuadExists
 				if len(in) != 0 {
-					return nil, &graph.DeltaError{Delta: in[q.Ind], Err: err}
+					return nil, nil, nil, &graph.DeltaError{Delta: in[q.Ind], Err: err}
 				}
-				return nil, err
+				return nil, nil, nil, err
 			}
 		}
 		links = append(links, link) // 这一步相当于去重?
@@ -609,9 +691,22 @@ func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog
 	qadd = nil
 	deltas.QuadAdd = nil
 
+	if len(replaced) != 0 {
+		if err := qs.markLinksDead(ctx, tx, replaced); err != nil {
+			return nil, nil, nil, err
+		}
+		upds := make([]nodeUpdate, 0, len(fixNodes))
+		for h, n := range fixNodes {
+			upds = append(upds, nodeUpdate{NodeUpdate: graphlog.NodeUpdate{Hash: h, RefInc: n}})
+		}
+		if _, err := qs.incNodesCnt(ctx, tx, upds, nil); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
 	qstart, err := qs.genIDs(ctx, tx, len(links))
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	for i := range links {
 		links[i].ID = qstart + uint64(i)
@@ -619,12 +714,16 @@ func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog
 	}
 	// 往kv中写入link结构
 	if err := qs.indexLinks(ctx, tx, links); err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	return nodes, nil
+	return nodes, links, replaced, nil
 }
 
 func (qs *QuadStore) ApplyDeltas(in []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
+	return qs.applyDeltas(nil, in, ignoreOpts)
+}
+
+func (qs *QuadStore) applyDeltas(conds []graph.Precondition, in []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
 	mApplyBatch.Observe(float64(len(in))) // prometheus
 	defer prometheus.NewTimer(mApplySeconds).ObserveDuration()
 
@@ -637,6 +736,9 @@ func (qs *QuadStore) ApplyDeltas(in []graph.Delta, ignoreOpts graph.IgnoreOpts)
 	}
 	defer tx.Close()
 	tx = wrapTx(tx)
+	if err := qs.checkPreconditions(ctx, tx, conds); err != nil {
+		return err
+	}
 
 	// 把操作...
 	deltas := graphlog.SplitDeltas(in)
@@ -644,10 +746,13 @@ func (qs *QuadStore) ApplyDeltas(in []graph.Delta, ignoreOpts graph.IgnoreOpts)
 		qs.mapNodes = nil
 	}
 
-	nodes, err := qs.applyAddDeltas(tx, in, deltas, ignoreOpts)
+	nodes, added, replaced, err := qs.applyAddDeltas(tx, in, deltas, ignoreOpts)
 	if err != nil {
 		return err
 	}
+	change := appendChange(nil, replaced, true)
+	change = appendChange(change, added, false)
+	added, replaced = nil, nil
 
 	if len(deltas.QuadDel) != 0 || len(deltas.DecNode) != 0 {
 		links := make([]proto.Primitive, 0, len(deltas.QuadDel))
@@ -687,7 +792,8 @@ func (qs *QuadStore) ApplyDeltas(in []graph.Delta, ignoreOpts graph.IgnoreOpts)
 				p, err := qs.hasPrimitive(ctx, tx, &link, true)
 				if err != nil {
 					return err
-				} else if p == nil || p.Deleted {
+				} else if p == nil || p.Deleted || isExpired(p, time.Now().UnixNano()) {
+					// expired quads are only deleted by ExpireQuads
 					exists = false
 				} else {
 					link = *p
@@ -712,6 +818,7 @@ func (qs *QuadStore) ApplyDeltas(in []graph.Delta, ignoreOpts graph.IgnoreOpts)
 		if err := qs.markLinksDead(ctx, tx, links); err != nil {
 			return err
 		}
+		change = appendChange(change, links, true)
 		links = nil
 		nodes = nil
 
@@ -732,12 +839,19 @@ func (qs *QuadStore) ApplyDeltas(in []graph.Delta, ignoreOpts graph.IgnoreOpts)
 		deltas = nil
 		dnodes = nil
 	}
+	if err := qs.logChange(ctx, tx, change); err != nil {
+		return err
+	}
 	// flush quad indexes and commit
 	err = qs.flushMapBucket(ctx, tx)
 	if err != nil {
 		return err
 	}
-	return tx.Commit(ctx) // 提交事务
+	if err := tx.Commit(ctx); err != nil { // 提交事务
+		return err
+	}
+	qs.changes.Notify()
+	return nil
 }
 
 func (qs *QuadStore) indexNode(tx kv.Tx, p *proto.Primitive, val quad.Value) error {
@@ -775,6 +889,9 @@ func (qs *QuadStore) indexLinks(ctx context.Context, tx kv.Tx, links []proto.Pri
 			return err
 		}
 	}
+	if err := qs.updateStats(ctx, tx, links, +1); err != nil {
+		return err
+	}
 	// 累加meta的size参数
 	return qs.incSize(ctx, tx, int64(len(links)))
 }
@@ -790,6 +907,11 @@ func (qs *QuadStore) indexLink(tx kv.Tx, p *proto.Primitive) error {
 			return err
 		}
 	}
+	if p.Expires != 0 {
+		if err = tx.Put(expiringKey(p), uint64toBytes(p.ID)); err != nil {
+			return err
+		}
+	}
 	qs.bloomAdd(p)
 	err = qs.indexSchema(tx, p)
 	if err != nil {
@@ -798,8 +920,21 @@ func (qs *QuadStore) indexLink(tx kv.Tx, p *proto.Primitive) error {
 	return qs.addToLog(tx, p)
 }
 
-func (qs *QuadStore) markAsDead(tx kv.Tx, p *proto.Primitive) error {
+// genDeletion allocates a position in the log for deletions made by a transaction.
+// Snapshots pinned to an earlier horizon still see primitives deleted at this position.
+func (qs *QuadStore) genDeletion(ctx context.Context, tx kv.Tx) (uint64, error) {
+	return qs.genIDs(ctx, tx, 1)
+}
+
+func (qs *QuadStore) markAsDead(tx kv.Tx, p *proto.Primitive, pos uint64) error {
+	if p.Expires != 0 {
+		if err := tx.Del(expiringKey(p)); err != nil {
+			return err
+		}
+	}
 	p.Deleted = true
+	p.DeletedAt = time.Now().UnixNano()
+	p.DeletedHorizon = pos
 	//TODO(barakmich): Add tombstone?
 	qs.bloomRemove(p)         // 从布隆过滤器中移除这条边
 	return qs.addToLog(tx, p) // 追加log
@@ -810,12 +945,22 @@ func (qs *QuadStore) delLog(tx kv.Tx, id uint64) error {
 }
 
 func (qs *QuadStore) markLinksDead(ctx context.Context, tx kv.Tx, links []proto.Primitive) error {
+	if len(links) == 0 {
+		return nil
+	}
+	pos, err := qs.genDeletion(ctx, tx)
+	if err != nil {
+		return err
+	}
 	for _, p := range links {
 		// p.Deleted = true
-		if err := qs.markAsDead(tx, &p); err != nil {
+		if err := qs.markAsDead(tx, &p, pos); err != nil {
 			return err
 		}
 	}
+	if err := qs.updateStats(ctx, tx, links, -1); err != nil {
+		return err
+	}
 	// 修改meta的size值
 	return qs.incSize(ctx, tx, -int64(len(links)))
 }
@@ -1199,7 +1344,7 @@ func (qs *QuadStore) resolveQuadValues(ctx context.Context, tx kv.Tx, vals []qua
 	inds := make([]int, 0, len(vals))
 	keys := make([]kv.Key, 0, len(vals))
 	for i, v := range vals {
-		if iri, ok := v.(quad.IRI); ok {
+		if iri, ok := v.(quad.IRI); ok && !qs.isView() {
 			// 从lru获取
 			if x, ok := qs.valueLRU.Get(string(iri)); ok {
 				out[i] = x.(uint64)
@@ -1224,10 +1369,15 @@ func (qs *QuadStore) resolveQuadValues(ctx context.Context, tx kv.Tx, vals []qua
 		}
 		ind := inds[i]
 		out[ind], _ = binary.Uvarint(b)
-		if iri, ok := vals[ind].(quad.IRI); ok && out[ind] != 0 {
+		if iri, ok := vals[ind].(quad.IRI); ok && out[ind] != 0 && !qs.isView() {
 			qs.valueLRU.Put(string(iri), uint64(out[ind])) // 更新lru cache
 		}
 	}
+	if qs.isView() {
+		if err := qs.resolvePastValues(ctx, tx, vals, out); err != nil {
+			return out, err
+		}
+	}
 	return out, nil
 }
 
@@ -40,7 +40,7 @@ func (qs *QuadStore) indexSize(ctx context.Context, ind QuadIndex, vals []uint64
 	if len(ind.Dirs) == len(vals) {
 		return refs.Size{
 			Value: sz,
-			Exact: true,
+			Exact: !qs.isView(),
 		}, nil
 	}
 	return refs.Size{
@@ -62,6 +62,13 @@ func (qs *QuadStore) QuadIteratorSize(ctx context.Context, d quad.Direction, v g
 			return qs.indexSize(ctx, ind, []uint64{uint64(vi)})
 		}
 	}
+	if d == quad.Predicate && qs.stats {
+		ps, err := qs.PredicateStats(ctx, v)
+		if err != nil {
+			return refs.Size{}, err
+		}
+		return ps.Quads, nil
+	}
 	st, err := qs.Stats(ctx, false)
 	if err != nil {
 		return refs.Size{}, err
@@ -129,7 +136,7 @@ type IndexScan struct {
 }
 
 func (s IndexScan) BuildIterator(qs graph.QuadStore) iterator.Shape {
-	kqs, ok := qs.(*QuadStore)
+	kqs, ok := graph.Base(qs).(*QuadStore)
 	if !ok {
 		return iterator.NewError(fmt.Errorf("expected KV quadstore, got: %T", qs))
 	}
@@ -1,9 +1,15 @@
 package kvtest
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"io/ioutil"
+	"os"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/cayleygraph/cayley/graph"
 	"github.com/cayleygraph/cayley/graph/graphtest"
@@ -30,7 +36,10 @@ func (c Config) quadStore() *graphtest.Config {
 
 func newQuadStoreFunc(gen DatabaseFunc, bloom bool) testutil.DatabaseFunc {
 	return func(t testing.TB) (graph.QuadStore, graph.Options, func()) {
-		return newQuadStore(t, gen, bloom)
+		if !bloom {
+			return newQuadStore(t, gen, graph.Options{kv.OptNoBloom: true})
+		}
+		return newQuadStore(t, gen, nil)
 	}
 }
 
@@ -38,13 +47,14 @@ func NewQuadStoreFunc(gen DatabaseFunc) testutil.DatabaseFunc {
 	return newQuadStoreFunc(gen, true)
 }
 
-func newQuadStore(t testing.TB, gen DatabaseFunc, bloom bool) (graph.QuadStore, graph.Options, func()) {
+// newQuadStore creates a QuadStore with options of the database and additional ones.
+func newQuadStore(t testing.TB, gen DatabaseFunc, extra graph.Options) (graph.QuadStore, graph.Options, func()) {
 	db, opt, closer := gen(t)
 	if opt == nil {
 		opt = make(graph.Options)
 	}
-	if !bloom {
-		opt[kv.OptNoBloom] = true
+	for k, v := range extra {
+		opt[k] = v
 	}
 	err := kv.Init(db, opt)
 	if err != nil {
@@ -65,7 +75,7 @@ func newQuadStore(t testing.TB, gen DatabaseFunc, bloom bool) (graph.QuadStore,
 }
 
 func NewQuadStore(t testing.TB, gen DatabaseFunc) (graph.QuadStore, graph.Options, func()) {
-	return newQuadStore(t, gen, true)
+	return newQuadStore(t, gen, nil)
 }
 
 func TestAll(t *testing.T, gen DatabaseFunc, conf *Config) {
@@ -83,6 +93,42 @@ func TestAll(t *testing.T, gen DatabaseFunc, conf *Config) {
 	t.Run("optimize", func(t *testing.T) {
 		testOptimize(t, gen, conf)
 	})
+	t.Run("compact", func(t *testing.T) {
+		testCompact(t, gen, conf)
+	})
+	t.Run("compact readers", func(t *testing.T) {
+		testCompactReaders(t, gen, conf)
+	})
+	t.Run("compact keep", func(t *testing.T) {
+		testCompactKeep(t, gen, conf)
+	})
+	t.Run("indexes", func(t *testing.T) {
+		testIndexes(t, gen, conf)
+	})
+	t.Run("stats", func(t *testing.T) {
+		testStats(t, gen, conf)
+	})
+	t.Run("snapshot", func(t *testing.T) {
+		testSnapshot(t, gen, conf)
+	})
+	t.Run("check", func(t *testing.T) {
+		testCheck(t, gen, conf)
+	})
+	t.Run("backup", func(t *testing.T) {
+		testBackup(t, gen, conf)
+	})
+	t.Run("bulk", func(t *testing.T) {
+		testBulkLoad(t, gen, conf)
+	})
+	t.Run("expiry", func(t *testing.T) {
+		testExpiry(t, gen, conf)
+	})
+	t.Run("expiry labels", func(t *testing.T) {
+		testExpiryLabels(t, gen, conf)
+	})
+	t.Run("has quad", func(t *testing.T) {
+		testHasQuad(t, gen, conf)
+	})
 }
 
 func testOptimize(t *testing.T, gen DatabaseFunc, _ *Config) {
@@ -128,6 +174,431 @@ func testOptimize(t *testing.T, gen DatabaseFunc, _ *Config) {
 	}
 }
 
+func testCompact(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, opts, closer := newQuadStore(t, gen, graph.Options{kv.OptChangesKeep: 0})
+	defer closer()
+
+	w := testutil.MakeWriter(t, qs, opts,
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+		quad.Make("B", "follows", "C", nil),
+	)
+	// delete and recreate a node, so there are two node primitives for the same value
+	err := w.RemoveQuad(quad.Make("A", "follows", "C", nil))
+	require.NoError(t, err)
+	err = w.RemoveQuad(quad.Make("A", "follows", "B", nil))
+	require.NoError(t, err)
+	err = w.AddQuad(quad.Make("A", "follows", "D", nil))
+	require.NoError(t, err)
+	err = w.RemoveQuad(quad.Make("A", "follows", "D", nil))
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	past := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	err = w.AddQuad(quad.Make("C", "follows", "D", nil))
+	require.NoError(t, err)
+
+	kqs := qs.(*kv.QuadStore)
+	st, err := kqs.Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, kv.CompactStats{Quads: 3, Nodes: 3, Changes: 5}, st)
+
+	exp := []quad.Quad{
+		quad.Make("B", "follows", "C", nil),
+		quad.Make("C", "follows", "D", nil),
+	}
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), exp, true)
+	v, err := qs.ValueOf(quad.String("A"))
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	// deleted quads are no longer visible in the past
+	view, err := graph.AsOf(ctx, qs, past)
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, view, view.QuadsAllIterator(), exp[:1], true)
+	v, err = view.ValueOf(quad.String("A"))
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	// the change feed starts after the last batch that referred to removed quads
+	feed, err := graph.Watch(ctx, qs, 0)
+	require.NoError(t, err)
+	require.False(t, feed.Next(ctx))
+	require.Equal(t, kv.ErrChangesCompacted, feed.Err())
+	feed.Close()
+
+	feed, err = graph.Watch(ctx, qs, 5)
+	require.NoError(t, err)
+	defer feed.Close()
+	require.True(t, feed.Next(ctx), "%v", feed.Err())
+	require.Equal(t, []graph.Delta{
+		{Quad: quad.Make("C", "follows", "D", nil), Action: graph.Add},
+	}, feed.Result().Deltas)
+
+	// removed quads can be added again
+	err = w.AddQuad(quad.Make("A", "follows", "B", nil))
+	require.NoError(t, err)
+	v, err = qs.ValueOf(quad.String("A"))
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, v), []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+	}, false)
+
+	st, err = kqs.Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, kv.CompactStats{}, st)
+
+	// next compactions only remove primitives deleted since the last one
+	err = w.RemoveQuad(quad.Make("A", "follows", "B", nil))
+	require.NoError(t, err)
+	st, err = kqs.Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, kv.CompactStats{Quads: 1, Nodes: 1, Changes: 3}, st)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), exp, true)
+
+	rep, err := kqs.Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+}
+
+func testCompactReaders(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, opts, closer := newQuadStore(t, gen, graph.Options{kv.OptChangesKeep: 0})
+	defer closer()
+
+	exp := []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+	}
+	w := testutil.MakeWriter(t, qs, opts, exp...)
+	sn, err := graph.Snapshot(ctx, qs)
+	require.NoError(t, err)
+	feed, err := graph.Watch(ctx, qs, 0)
+	require.NoError(t, err)
+	err = w.RemoveQuad(exp[0])
+	require.NoError(t, err)
+
+	// nothing is removed while the snapshot is open
+	kqs := qs.(*kv.QuadStore)
+	st, err := kqs.Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, kv.CompactStats{}, st)
+	graphtest.ExpectIteratedQuads(t, sn, sn.QuadsAllIterator(), exp, true)
+
+	// idle feeds don't keep changes they have not read yet
+	require.NoError(t, sn.Close())
+	st, err = kqs.Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), st.Quads)
+	require.Equal(t, int64(2), st.Changes)
+	require.False(t, feed.Next(ctx))
+	require.Equal(t, kv.ErrChangesCompacted, feed.Err())
+	require.NoError(t, feed.Close())
+}
+
+func testCompactKeep(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, _, closer := newQuadStore(t, gen, graph.Options{kv.OptChangesKeep: 2})
+	defer closer()
+
+	quads := []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+		quad.Make("B", "follows", "C", nil),
+		quad.Make("C", "follows", "D", nil),
+	}
+	apply := func(q quad.Quad, a graph.Procedure) {
+		require.NoError(t, qs.ApplyDeltas([]graph.Delta{{Quad: q, Action: a}}, graph.IgnoreOpts{}))
+	}
+	apply(quads[0], graph.Add)
+	apply(quads[1], graph.Add)
+	apply(quads[0], graph.Delete)
+	apply(quads[2], graph.Add)
+	apply(quads[3], graph.Add)
+
+	// the last batches are kept for feeds that are not reading now
+	kqs := qs.(*kv.QuadStore)
+	st, err := kqs.Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, kv.CompactStats{Quads: 1, Nodes: 1, Changes: 3}, st)
+	feed, err := graph.Watch(ctx, qs, 3)
+	require.NoError(t, err)
+	require.True(t, feed.Next(ctx), "%v", feed.Err())
+	require.Equal(t, int64(4), feed.Result().Position)
+	require.NoError(t, feed.Close())
+
+	// as well as primitives deleted by them
+	apply(quads[1], graph.Delete)
+	st, err = kqs.Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, kv.CompactStats{}, st)
+
+	// until newer batches are written
+	more := []quad.Quad{
+		quad.Make("D", "follows", "E", nil),
+		quad.Make("E", "follows", "F", nil),
+	}
+	apply(more[0], graph.Add)
+	apply(more[1], graph.Add)
+	st, err = kqs.Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, kv.CompactStats{Quads: 1, Nodes: 1, Changes: 3}, st)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), append(quads[2:], more...), true)
+
+	rep, err := kqs.Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+}
+
+func testCheck(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	db, opts, closer := gen(t)
+	defer closer()
+	err := kv.Init(db, opts)
+	require.NoError(t, err)
+	h, err := kv.New(db, opts)
+	require.NoError(t, err)
+	defer h.Close()
+	qs := h.(*kv.QuadStore)
+
+	exp := []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+		quad.Make("B", "follows", "C", nil),
+	}
+	w := testutil.MakeWriter(t, qs, opts, exp...)
+	err = w.AddQuad(quad.Make("C", "follows", "D", nil))
+	require.NoError(t, err)
+	err = w.RemoveQuad(quad.Make("C", "follows", "D", nil))
+	require.NoError(t, err)
+
+	rep, err := qs.Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+	require.Equal(t, int64(4), rep.Quads)
+
+	// break the size counter and remove one of the index entries
+	err = hkv.Update(ctx, db, func(tx hkv.Tx) error {
+		if err := tx.Put(hkv.Key{[]byte("meta"), []byte("size")}, make([]byte, 8)); err != nil {
+			return err
+		}
+		it := tx.Scan(hkv.Key{[]byte("sp"), {}})
+		defer it.Close()
+		for it.Next(ctx) {
+			if k := it.Key(); len(k) == 2 && len(k[1]) != 0 {
+				return tx.Del(k.Clone())
+			}
+		}
+		return it.Err()
+	})
+	require.NoError(t, err)
+
+	rep, err = qs.Check(ctx, false)
+	require.NoError(t, err)
+	require.True(t, len(rep.Problems) >= 2, "%v", rep.Problems)
+	require.Equal(t, 0, rep.Fixed)
+
+	rep, err = qs.Check(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, len(rep.Problems), rep.Fixed, "%v", rep.Problems)
+
+	rep, err = qs.Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+	require.Equal(t, int64(3), qs.Size())
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), exp, true)
+	// the index is used for lookups by subject
+	a, err := qs.ValueOf(quad.String("A"))
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, a), exp[:2], true)
+	b, err := qs.ValueOf(quad.String("B"))
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, b), exp[2:], true)
+}
+
+func testStats(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, opts, closer := NewQuadStore(t, gen)
+	defer closer()
+
+	w := testutil.MakeWriter(t, qs, opts,
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+		quad.Make("B", "follows", "C", "g"),
+		quad.Make("B", "follows", "C", nil),
+		quad.Make("A", "name", "Alice", nil),
+	)
+	expect := func(pred string, quads, subjects, objects int64) {
+		t.Helper()
+		v, err := qs.ValueOf(quad.String(pred))
+		require.NoError(t, err)
+		st, err := graph.GetPredicateStats(ctx, qs, v)
+		require.NoError(t, err)
+		require.True(t, st.Quads.Exact)
+		require.Equal(t, [3]int64{quads, subjects, objects},
+			[3]int64{st.Quads.Value, st.Subjects.Value, st.Objects.Value}, pred)
+		sz, err := qs.QuadIteratorSize(ctx, quad.Predicate, v)
+		require.NoError(t, err)
+		require.Equal(t, st.Quads, sz)
+	}
+	expect("follows", 4, 2, 2)
+	expect("name", 1, 1, 1)
+
+	all, err := graph.GetAllPredicateStats(ctx, qs)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+
+	// HasA uses the number of distinct subjects to estimate the cost of lookups
+	follows, err := qs.ValueOf(quad.String("follows"))
+	require.NoError(t, err)
+	sub := qs.QuadIterator(quad.Predicate, follows)
+	subSt, err := sub.Stats(ctx)
+	require.NoError(t, err)
+	plain, err := graph.NewHasA(qs, sub, quad.Subject).Stats(ctx)
+	require.NoError(t, err)
+	hasa, err := graph.NewHasAWithPredicate(qs, sub, quad.Subject, follows).Stats(ctx)
+	require.NoError(t, err)
+	// 4 quads with 2 distinct subjects
+	require.Equal(t, 2*2*subSt.ContainsCost, hasa.ContainsCost)
+	require.True(t, hasa.ContainsCost < plain.ContainsCost)
+
+	err = w.RemoveQuad(quad.Make("A", "follows", "C", nil))
+	require.NoError(t, err)
+	expect("follows", 3, 2, 2)
+	err = w.RemoveQuad(quad.Make("B", "follows", "C", "g"))
+	require.NoError(t, err)
+	expect("follows", 2, 2, 2)
+	err = w.RemoveQuad(quad.Make("B", "follows", "C", nil))
+	require.NoError(t, err)
+	expect("follows", 1, 1, 1)
+
+	err = w.RemoveQuad(quad.Make("A", "name", "Alice", nil))
+	require.NoError(t, err)
+	all, err = graph.GetAllPredicateStats(ctx, qs)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+}
+
+func testSnapshot(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, opts, closer := NewQuadStore(t, gen)
+	defer closer()
+
+	w := testutil.MakeWriter(t, qs, opts, graphtest.MakeQuadSet()...)
+
+	// snapshots must not depend on the wall clock, thus take many of them in a quick succession
+	var (
+		snaps  []graph.QuadStore
+		expect [][]quad.Quad
+	)
+	quads := graphtest.MakeQuadSet()
+	for i := 0; i < 5; i++ {
+		sn, err := graph.Snapshot(ctx, qs)
+		require.NoError(t, err)
+		snaps = append(snaps, sn)
+		expect = append(expect, append([]quad.Quad{}, quads...))
+
+		err = w.RemoveQuad(quads[0])
+		require.NoError(t, err)
+		quads = quads[1:]
+	}
+	for i, sn := range snaps {
+		graphtest.ExpectIteratedQuads(t, sn, sn.QuadsAllIterator(), expect[i], true)
+
+		st, err := sn.Stats(ctx, false)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(expect[i])), st.Quads.Value)
+		require.True(t, st.Quads.Exact)
+	}
+}
+
+func testIndexes(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, opts, closer := NewQuadStore(t, gen)
+	defer closer()
+
+	g1, g2 := quad.IRI("g1"), quad.IRI("g2")
+	w := testutil.MakeWriter(t, qs, opts,
+		quad.Make("A", "follows", "B", g1),
+		quad.Make("B", "follows", "C", g1),
+		quad.Make("C", "follows", "D", g2),
+	)
+	err := w.RemoveQuad(quad.Make("B", "follows", "C", g1))
+	require.NoError(t, err)
+
+	kqs := qs.(*kv.QuadStore)
+	indexNames := func() []string {
+		list, err := kqs.ListIndexes(ctx)
+		require.NoError(t, err)
+		var names []string
+		for _, ind := range list {
+			require.False(t, ind.Building)
+			names = append(names, ind.String())
+		}
+		return names
+	}
+	require.Equal(t, []string{"sp", "ops"}, indexNames())
+
+	ind, err := kv.ParseQuadIndex("c")
+	require.NoError(t, err)
+	err = kqs.AddIndex(ctx, ind)
+	require.NoError(t, err)
+	require.Equal(t, []string{"sp", "ops", "c"}, indexNames())
+	require.Equal(t, kv.ErrIndexExists, kqs.AddIndex(ctx, ind))
+
+	// new quads are added to the index
+	err = w.AddQuad(quad.Make("D", "follows", "E", g1))
+	require.NoError(t, err)
+
+	expectLabel := func(label quad.Value, exact bool, exp ...quad.Quad) {
+		v, err := qs.ValueOf(label)
+		require.NoError(t, err)
+		sz, err := qs.QuadIteratorSize(ctx, quad.Label, v)
+		require.NoError(t, err)
+		require.Equal(t, exact, sz.Exact)
+		graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Label, v), exp, true)
+	}
+	// single-direction index gives an exact size
+	expectLabel(g1, true,
+		quad.Make("A", "follows", "B", g1),
+		quad.Make("D", "follows", "E", g1),
+	)
+	expectLabel(g2, true,
+		quad.Make("C", "follows", "D", g2),
+	)
+
+	err = kqs.DropIndex(ctx, ind)
+	require.NoError(t, err)
+	require.Equal(t, []string{"sp", "ops"}, indexNames())
+	require.Equal(t, kv.ErrIndexNotFound, kqs.DropIndex(ctx, ind))
+	expectLabel(g1, false,
+		quad.Make("A", "follows", "B", g1),
+		quad.Make("D", "follows", "E", g1),
+	)
+
+	ind, err = kv.ParseQuadIndex("sp")
+	require.NoError(t, err)
+	err = kqs.DropIndex(ctx, ind)
+	require.NoError(t, err)
+	ind, err = kv.ParseQuadIndex("ops")
+	require.NoError(t, err)
+	err = kqs.DropIndex(ctx, ind)
+	require.Error(t, err, "the last index cannot be dropped")
+	require.Equal(t, []string{"ops"}, indexNames())
+
+	v, err := qs.ValueOf(quad.String("A"))
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, v), []quad.Quad{
+		quad.Make("A", "follows", "B", g1),
+	}, false)
+
+	_, err = kv.ParseQuadIndex("ss")
+	require.Error(t, err)
+	_, err = kv.ParseQuadIndex("x")
+	require.Error(t, err)
+}
+
 func BenchmarkAll(t *testing.B, gen DatabaseFunc, conf *Config) {
 	if conf == nil {
 		conf = &Config{}
@@ -137,3 +608,323 @@ func BenchmarkAll(t *testing.B, gen DatabaseFunc, conf *Config) {
 		graphtest.BenchmarkAll(t, qsgen, conf.quadStore())
 	})
 }
+
+func testBackup(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, opts, closer := NewQuadStore(t, gen)
+	defer closer()
+
+	exp := []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+		quad.Make("B", "follows", "C", "g"),
+	}
+	w := testutil.MakeWriter(t, qs, opts, exp...)
+	err := w.AddQuad(quad.Make("C", "follows", "D", nil))
+	require.NoError(t, err)
+	err = w.RemoveQuad(quad.Make("C", "follows", "D", nil))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = graph.Backup(ctx, qs, &buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	m, err := kv.VerifyBackup(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.True(t, m.Keys > 0)
+
+	// corrupted backup must not initialize the database
+	db, opts2, closer2 := gen(t)
+	defer closer2()
+	bad := append([]byte{}, data...)
+	bad[len(bad)/2] ^= 0xff
+	_, err = kv.Restore(ctx, db, bytes.NewReader(bad))
+	require.Error(t, err)
+	// and nothing is written, even if the backup cannot be read twice
+	_, err = kv.Restore(ctx, db, struct{ io.Reader }{bytes.NewReader(bad)})
+	require.Error(t, err)
+	err = hkv.View(db, func(tx hkv.Tx) error {
+		it := tx.Scan(nil)
+		defer it.Close()
+		require.False(t, it.Next(ctx))
+		return it.Err()
+	})
+	require.NoError(t, err)
+	_, err = kv.New(db, opts2)
+	require.Equal(t, graph.ErrNotInitialized, err)
+	db.Close()
+
+	db2, opts2, closer2 := gen(t)
+	defer closer2()
+	_, err = kv.Restore(ctx, db2, struct{ io.Reader }{bytes.NewReader(data)})
+	require.NoError(t, err)
+	qs2, err := kv.New(db2, opts2)
+	require.NoError(t, err)
+	defer qs2.Close()
+	require.Equal(t, qs.(*kv.QuadStore).Size(), qs2.(*kv.QuadStore).Size())
+	graphtest.ExpectIteratedQuads(t, qs2, qs2.QuadsAllIterator(), exp, true)
+
+	// restoring to an existing database fails
+	_, err = kv.Restore(ctx, db2, bytes.NewReader(data))
+	require.Equal(t, graph.ErrDatabaseExists, err)
+
+	rep, err := qs2.(*kv.QuadStore).Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+}
+
+func testBulkLoad(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	db, opts, closer := gen(t)
+	defer closer()
+
+	exp := []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+		quad.Make("B", "follows", "C", "g"),
+		quad.Make("B", "follows", "C", nil),
+		quad.Make("A", "name", "Alice", nil),
+	}
+	in := append([]quad.Quad{}, exp...)
+	in = append(in, exp[1], exp[2])
+
+	_, err := kv.BulkLoad(ctx, db, quad.NewReader(in), kv.BulkOptions{})
+	require.Equal(t, graph.ErrNotInitialized, err)
+	err = kv.Init(db, opts)
+	require.NoError(t, err)
+	// small runs are spilled to disk and merged
+	tmp, err := ioutil.TempDir("", "cayley_test_bulk")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	st, err := kv.BulkLoad(ctx, db, quad.NewReader(in), kv.BulkOptions{TempDir: tmp, RunSize: 2})
+	require.NoError(t, err)
+	require.Equal(t, kv.BulkStats{Nodes: 7, Quads: 5, Duplicates: 2}, *st)
+	files, err := ioutil.ReadDir(tmp)
+	require.NoError(t, err)
+	require.Empty(t, files, "temporary files are not removed")
+
+	_, err = kv.BulkLoad(ctx, db, quad.NewReader(in), kv.BulkOptions{})
+	require.Error(t, err)
+
+	h, err := kv.New(db, opts)
+	require.NoError(t, err)
+	defer h.Close()
+	qs := h.(*kv.QuadStore)
+	require.Equal(t, int64(5), qs.Size())
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), exp, true)
+	a, err := qs.ValueOf(quad.String("A"))
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, a), []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+		quad.Make("A", "name", "Alice", nil),
+	}, true)
+
+	follows, err := qs.ValueOf(quad.String("follows"))
+	require.NoError(t, err)
+	ps, err := graph.GetPredicateStats(ctx, qs, follows)
+	require.NoError(t, err)
+	require.Equal(t, [3]int64{4, 2, 2}, [3]int64{ps.Quads.Value, ps.Subjects.Value, ps.Objects.Value})
+
+	rep, err := qs.Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+
+	// the store can be written to after the load
+	w := testutil.MakeWriter(t, qs, opts)
+	err = w.RemoveQuad(quad.Make("A", "follows", "B", nil))
+	require.NoError(t, err)
+	err = w.AddQuad(quad.Make("C", "follows", "A", nil))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), qs.Size())
+	rep, err = qs.Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+}
+
+func testExpiry(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, _, closer := NewQuadStore(t, gen)
+	defer closer()
+
+	var (
+		now     = time.Now()
+		keep    = quad.Make("A", "follows", "B", nil)
+		expired = quad.Make("A", "follows", "C", nil)
+		later   = quad.Make("A", "follows", "D", nil)
+	)
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: keep, Action: graph.Add},
+		{Quad: expired, Action: graph.Add, Expires: now.Add(-time.Second)},
+		{Quad: later, Action: graph.Add, Expires: now.Add(time.Hour)},
+	}, graph.IgnoreOpts{}))
+
+	// expired quads are hidden at once
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{keep, later}, true)
+	a, err := qs.ValueOf(quad.String("A"))
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, a), []quad.Quad{keep, later}, true)
+	st, err := qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Quads.Value)
+
+	// expired quad is treated as missing
+	err = qs.ApplyDeltas([]graph.Delta{{Quad: expired, Action: graph.Delete}}, graph.IgnoreOpts{})
+	require.True(t, graph.IsQuadNotExist(err), "%v", err)
+	// and expiration time of an existing quad can be updated
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: later, Action: graph.Add, Expires: now.Add(time.Millisecond)},
+	}, graph.IgnoreOpts{}))
+
+	feed, err := graph.Watch(ctx, qs, -1)
+	require.NoError(t, err)
+	defer feed.Close()
+
+	// the reaper deletes quads through the change feed
+	reaped, err := graph.ExpireQuads(ctx, qs, now.Add(time.Minute))
+	require.NoError(t, err)
+	sort.Sort(quad.ByQuadString(reaped))
+	require.Equal(t, []quad.Quad{expired, later}, reaped)
+	require.True(t, feed.Next(ctx), "%v", feed.Err())
+	var deleted []quad.Quad
+	for _, d := range feed.Result().Deltas {
+		require.Equal(t, graph.Delete, d.Action)
+		deleted = append(deleted, d.Quad)
+	}
+	sort.Sort(quad.ByQuadString(deleted))
+	require.Equal(t, []quad.Quad{expired, later}, deleted)
+
+	reaped, err = graph.ExpireQuads(ctx, qs, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, reaped)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{keep}, true)
+
+	// an expired quad is replaced when it is added again without an expiration time
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: later, Action: graph.Add, Expires: now.Add(-time.Second)},
+	}, graph.IgnoreOpts{}))
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: later, Action: graph.Add},
+	}, graph.IgnoreOpts{}))
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{keep, later}, true)
+	reaped, err = graph.ExpireQuads(ctx, qs, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, reaped)
+
+	// adding a quad without an expiration time over an expiring one clears the expiration time
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: later, Action: graph.Add, Expires: now.Add(time.Hour)},
+	}, graph.IgnoreOpts{}))
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: later, Action: graph.Add},
+	}, graph.IgnoreOpts{}))
+	reaped, err = graph.ExpireQuads(ctx, qs, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, reaped)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{keep, later}, true)
+
+	// views observe expiration at their own time
+	soon := quad.Make("A", "follows", "E", nil)
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: soon, Action: graph.Add, Expires: time.Now().Add(100 * time.Millisecond)},
+	}, graph.IgnoreOpts{}))
+	past := time.Now()
+	sn, err := graph.Snapshot(ctx, qs)
+	require.NoError(t, err)
+	defer sn.Close()
+	time.Sleep(200 * time.Millisecond)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{keep, later}, true)
+	view, err := graph.AsOf(ctx, qs, past)
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, view, view.QuadsAllIterator(), []quad.Quad{keep, later, soon}, true)
+	graphtest.ExpectIteratedQuads(t, sn, sn.QuadsAllIterator(), []quad.Quad{keep, later, soon}, true)
+	st, err = sn.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), st.Quads.Value)
+	require.True(t, st.Quads.Exact)
+
+	rep, err := qs.(*kv.QuadStore).Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+}
+
+func testExpiryLabels(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, _, closer := NewQuadStore(t, gen)
+	defer closer()
+
+	var (
+		now = time.Now()
+		g1  = quad.String("g1")
+		g2  = quad.String("g2")
+		g3  = quad.String("g3")
+	)
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: quad.Make("A", "follows", "B", g1), Action: graph.Add, Expires: now.Add(time.Hour)},
+		{Quad: quad.Make("B", "follows", "C", g1), Action: graph.Add},
+	}, graph.IgnoreOpts{}))
+
+	// copied and moved quads keep their expiration time
+	require.NoError(t, graph.CopyLabel(ctx, qs, g1, g2))
+	require.NoError(t, graph.MoveLabel(ctx, qs, g2, g3))
+	reaped, err := graph.ExpireQuads(ctx, qs, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, reaped)
+
+	reaped, err = graph.ExpireQuads(ctx, qs, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	sort.Sort(quad.ByQuadString(reaped))
+	require.Equal(t, []quad.Quad{
+		quad.Make("A", "follows", "B", g1),
+		quad.Make("A", "follows", "B", g3),
+	}, reaped)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{
+		quad.Make("B", "follows", "C", g1),
+		quad.Make("B", "follows", "C", g3),
+	}, true)
+
+	rep, err := qs.(*kv.QuadStore).Check(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+}
+
+func testHasQuad(t *testing.T, gen DatabaseFunc, _ *Config) {
+	ctx := context.TODO()
+	qs, opts, closer := NewQuadStore(t, gen)
+	defer closer()
+
+	quads := graphtest.MakeQuadSet()
+	testutil.MakeWriter(t, qs, opts, quads...)
+	c := qs.(graph.QuadChecker)
+	for _, q := range quads {
+		ok, err := c.HasQuad(ctx, q)
+		require.NoError(t, err)
+		require.True(t, ok, "%v", q)
+	}
+	for _, q := range []quad.Quad{
+		quad.Make("A", "follows", "D", nil),   // all nodes exist
+		quad.Make("A", "follows", "Z", nil),   // unknown node
+		quad.Make("B", "status", "cool", nil), // different label
+	} {
+		ok, err := c.HasQuad(ctx, q)
+		require.NoError(t, err)
+		require.False(t, ok, "%v", q)
+	}
+
+	// views are checked by iterating over quads
+	sn, err := graph.Snapshot(ctx, qs)
+	require.NoError(t, err)
+	defer sn.Close()
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: quads[0], Action: graph.Delete},
+	}, graph.IgnoreOpts{}))
+	ok, err := c.HasQuad(ctx, quads[0])
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, err = sn.(graph.QuadChecker).HasQuad(ctx, quads[0])
+	require.Equal(t, graph.ErrOperationNotSupported, err)
+	ok, err = graph.HasQuad(ctx, sn, quads[0])
+	require.NoError(t, err)
+	require.True(t, ok)
+}
@@ -0,0 +1,285 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/cayley/graph"
+	graphlog "github.com/cayleygraph/cayley/graph/log"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+)
+
+var _ graph.LabelManager = (*QuadStore)(nil)
+
+// ListLabels implements graph.LabelManager.
+//
+// It scans all primitives in the log, but doesn't decode any values except the labels.
+func (qs *QuadStore) ListLabels(ctx context.Context) ([]quad.Value, error) {
+	var ids []graph.Ref
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		seen := make(map[uint64]struct{})
+		it := tx.Scan(logIndex)
+		defer it.Close()
+		for it.Next(ctx) {
+			var p proto.Primitive
+			if err := p.Unmarshal(it.Val()); err != nil {
+				return err
+			}
+			if p.IsNode() || p.Label == 0 || !qs.isAlive(&p) {
+				continue
+			}
+			if _, ok := seen[p.Label]; !ok {
+				seen[p.Label] = struct{}{}
+				ids = append(ids, Int64Value(p.Label))
+			}
+		}
+		return it.Err()
+	})
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	labels, err := qs.ValuesOf(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	graph.SortLabels(labels)
+	return labels, nil
+}
+
+// DropLabel implements graph.LabelManager.
+func (qs *QuadStore) DropLabel(ctx context.Context, label quad.Value) error {
+	return qs.relabel(ctx, label, nil, false, true)
+}
+
+// CopyLabel implements graph.LabelManager.
+func (qs *QuadStore) CopyLabel(ctx context.Context, from, to quad.Value) error {
+	return qs.relabel(ctx, from, to, true, false)
+}
+
+// MoveLabel implements graph.LabelManager.
+func (qs *QuadStore) MoveLabel(ctx context.Context, from, to quad.Value) error {
+	return qs.relabel(ctx, from, to, true, true)
+}
+
+// labelLinks returns all live quad primitives of a graph. Zero label refers to the default graph.
+//
+// An index that starts with the label direction is used, if there is one. Otherwise, the whole log is scanned.
+func (qs *QuadStore) labelLinks(ctx context.Context, tx kv.Tx, label uint64) ([]proto.Primitive, error) {
+	var out []proto.Primitive
+	if inds := qs.bestIndexes([]quad.Direction{quad.Label}); len(inds) == 1 {
+		it := tx.Scan(inds[0].Key([]uint64{label}))
+		defer it.Close()
+		for it.Next(ctx) {
+			ids, err := decodeIndex(it.Val())
+			if err != nil {
+				return nil, err
+			}
+			prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range prims {
+				// index entries are not removed for deleted quads
+				if p != nil && !p.Deleted && p.Label == label {
+					out = append(out, *p)
+				}
+			}
+		}
+		return out, it.Err()
+	}
+	it := tx.Scan(logIndex)
+	defer it.Close()
+	for it.Next(ctx) {
+		var p proto.Primitive
+		if err := p.Unmarshal(it.Val()); err != nil {
+			return nil, err
+		}
+		// skip the bucket marker and nodes
+		if p.ID != 0 && !p.IsNode() && !p.Deleted && p.Label == label {
+			out = append(out, p)
+		}
+	}
+	return out, it.Err()
+}
+
+// relabel copies quads of one graph to another one and/or removes them from the source graph.
+// All changes are done in a single transaction, and nodes are resolved only once.
+func (qs *QuadStore) relabel(ctx context.Context, from, to quad.Value, add, del bool) error {
+	if qs.isView() {
+		return graph.ErrReadOnly
+	}
+	if add && refs.HashOf(from) == refs.HashOf(to) {
+		return nil // same graph
+	}
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	tx, err := qs.db.Tx(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+	tx = wrapTx(tx)
+
+	var fromID uint64
+	if from != nil {
+		fromID, err = qs.resolveQuadValue(ctx, tx, from)
+		if err != nil {
+			return err
+		} else if fromID == 0 {
+			return nil // no such graph
+		}
+	}
+	links, err := qs.labelLinks(ctx, tx, fromID)
+	if err != nil || len(links) == 0 {
+		return err
+	}
+	// changes of reference counters for existing nodes
+	cnt := make(map[uint64]int)
+	var added []proto.Primitive
+	if add {
+		var (
+			toID     uint64
+			newLabel bool
+		)
+		if to != nil {
+			toID, err = qs.resolveQuadValue(ctx, tx, to)
+			if err != nil {
+				return err
+			}
+		}
+		if to != nil && toID == 0 {
+			// all quads are new, so we know the reference count upfront
+			h := refs.HashOf(to)
+			nodes, err := qs.incNodes(ctx, tx, []graphlog.NodeUpdate{
+				{Hash: h, Val: to, RefInc: len(links)},
+			})
+			if err != nil {
+				return err
+			}
+			toID, newLabel = nodes[h].ID, true
+		}
+		added = make([]proto.Primitive, 0, len(links))
+		for _, p := range links {
+			link := proto.Primitive{
+				Subject:   p.Subject,
+				Predicate: p.Predicate,
+				Object:    p.Object,
+				Label:     toID,
+				Expires:   p.Expires,
+			}
+			if !newLabel {
+				e, err := qs.hasPrimitive(ctx, tx, &link, true)
+				if err != nil {
+					return err
+				} else if e != nil {
+					continue // already in the destination graph
+				}
+				if toID != 0 {
+					cnt[toID]++
+				}
+			}
+			for _, d := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object} {
+				cnt[link.GetDirection(d)]++
+			}
+			added = append(added, link)
+		}
+		start, err := qs.genIDs(ctx, tx, len(added))
+		if err != nil {
+			return err
+		}
+		now := time.Now().UnixNano()
+		for i := range added {
+			added[i].ID = start + uint64(i)
+			added[i].Timestamp = now
+		}
+		if err := qs.indexLinks(ctx, tx, added); err != nil {
+			return err
+		}
+	}
+	change := appendChange(nil, added, false)
+	added = nil
+	if del {
+		qs.mapNodes = nil
+		if err := qs.markLinksDead(ctx, tx, links); err != nil {
+			return err
+		}
+		for _, p := range links {
+			for _, d := range quad.Directions {
+				if id := p.GetDirection(d); id != 0 {
+					cnt[id]--
+				}
+			}
+		}
+		change = appendChange(change, links, true)
+	}
+	links = nil
+	if err := qs.updateNodeRefs(ctx, tx, cnt); err != nil {
+		return err
+	}
+	if err := qs.logChange(ctx, tx, change); err != nil {
+		return err
+	}
+	if err := qs.flushMapBucket(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	qs.changes.Notify()
+	return nil
+}
+
+// updateNodeRefs changes reference counters of nodes with given IDs.
+// Nodes with no references left are removed.
+func (qs *QuadStore) updateNodeRefs(ctx context.Context, tx kv.Tx, cnt map[uint64]int) error {
+	ids := make([]uint64, 0, len(cnt))
+	for id, n := range cnt {
+		if n != 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Sort(Int64Set(ids))
+	prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+	deltas := make([]graphlog.NodeUpdate, 0, len(ids))
+	nodes := make(map[refs.ValueHash]uint64, len(ids))
+	for i, p := range prims {
+		if p == nil {
+			return fmt.Errorf("kv: node %d is missing from the log", ids[i])
+		}
+		v, err := pquads.UnmarshalValue(p.Value)
+		if err != nil {
+			return err
+		}
+		h := refs.HashOf(v)
+		deltas = append(deltas, graphlog.NodeUpdate{Hash: h, Val: v, RefInc: cnt[ids[i]]})
+		nodes[h] = ids[i]
+	}
+	return qs.decNodes(ctx, tx, deltas, nodes)
+}
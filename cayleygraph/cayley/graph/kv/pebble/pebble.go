@@ -0,0 +1,278 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pebble
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/cockroachdb/pebble"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/kv"
+	hkv "github.com/hidal-go/hidalgo/kv"
+	"github.com/hidal-go/hidalgo/kv/flat"
+)
+
+func init() {
+	kv.Register(Type, kv.Registration{
+		NewFunc:      Open,
+		InitFunc:     Create,
+		IsPersistent: true,
+	})
+}
+
+const (
+	Type = "pebble"
+)
+
+const (
+	defaultCacheSize   = 8
+	defaultWriteBuffer = 4
+)
+
+func options(m graph.Options) (*pebble.Options, bool, error) {
+	nosync, err := m.BoolKey("nosync", false)
+	if err != nil {
+		return nil, false, err
+	}
+	cacheSize, err := m.IntKey("cache_size_mb", defaultCacheSize)
+	if err != nil {
+		return nil, false, err
+	}
+	writeBuffer, err := m.IntKey("write_buffer_mb", defaultWriteBuffer)
+	if err != nil {
+		return nil, false, err
+	}
+	if nosync {
+		clog.Infof("Running in nosync mode")
+	}
+	return &pebble.Options{
+		Cache:        pebble.NewCache(int64(cacheSize) << 20),
+		MemTableSize: uint64(writeBuffer) << 20,
+	}, nosync, nil
+}
+
+func open(path string, m graph.Options, create bool) (hkv.KV, error) {
+	opts, nosync, err := options(m)
+	if err != nil {
+		return nil, err
+	}
+	// the cache is referenced by the database while it is open
+	defer opts.Cache.Unref()
+	opts.ErrorIfExists = create
+	opts.ErrorIfNotExists = !create
+	db, err := pebble.Open(path, opts)
+	if errors.Is(err, pebble.ErrDBAlreadyExists) {
+		return nil, graph.ErrDatabaseExists
+	} else if errors.Is(err, pebble.ErrDBDoesNotExist) {
+		return nil, graph.ErrNotInitialized
+	} else if err != nil {
+		return nil, err
+	}
+	wo := pebble.Sync
+	if nosync {
+		wo = pebble.NoSync
+	}
+	return flat.Upgrade(&DB{db: db, wo: wo}), nil
+}
+
+func Create(path string, m graph.Options) (hkv.KV, error) {
+	if path == "" {
+		return nil, kv.ErrEmptyPath
+	}
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return open(path, m, true)
+}
+
+func Open(path string, m graph.Options) (hkv.KV, error) {
+	if path == "" {
+		return nil, kv.ErrEmptyPath
+	}
+	return open(path, m, false)
+}
+
+var _ flat.KV = (*DB)(nil)
+
+// DB implements a flat key-value store on top of Pebble.
+type DB struct {
+	db     *pebble.DB
+	wo     *pebble.WriteOptions
+	closed bool
+}
+
+// Close closes the database. Unlike Pebble itself, it allows Close to be called multiple times.
+func (db *DB) Close() error {
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	return db.db.Close()
+}
+
+// Tx starts a new transaction. Read-only transactions are served from a snapshot,
+// while read-write transactions buffer all writes in an indexed batch until commit.
+func (db *DB) Tx(rw bool) (flat.Tx, error) {
+	if rw {
+		return &Tx{db: db, b: db.db.NewIndexedBatch()}, nil
+	}
+	return &Tx{db: db, sn: db.db.NewSnapshot()}, nil
+}
+
+type Tx struct {
+	db  *DB
+	sn  *pebble.Snapshot
+	b   *pebble.Batch
+	err error
+}
+
+func (tx *Tx) Commit(ctx context.Context) error {
+	if tx.err != nil {
+		return tx.err
+	}
+	if tx.b != nil {
+		tx.err = tx.b.Commit(tx.db.wo)
+	}
+	if err := tx.Close(); tx.err == nil {
+		tx.err = err
+	}
+	return tx.err
+}
+
+func (tx *Tx) Close() error {
+	var err error
+	if tx.b != nil {
+		err = tx.b.Close()
+		tx.b = nil
+	} else if tx.sn != nil {
+		err = tx.sn.Close()
+		tx.sn = nil
+	}
+	if tx.err != nil {
+		return tx.err
+	}
+	return err
+}
+
+func (tx *Tx) Get(ctx context.Context, key flat.Key) (flat.Value, error) {
+	var (
+		val    []byte
+		closer io.Closer
+		err    error
+	)
+	if tx.b != nil {
+		val, closer, err = tx.b.Get(key)
+	} else {
+		val, closer, err = tx.sn.Get(key)
+	}
+	if err == pebble.ErrNotFound {
+		return nil, flat.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	// the value is only valid until the closer is called
+	out := make(flat.Value, len(val))
+	copy(out, val)
+	return out, closer.Close()
+}
+
+func (tx *Tx) GetBatch(ctx context.Context, keys []flat.Key) ([]flat.Value, error) {
+	return flat.GetBatch(ctx, tx, keys)
+}
+
+func (tx *Tx) Put(k flat.Key, v flat.Value) error {
+	if tx.b == nil {
+		return flat.ErrReadOnly
+	}
+	return tx.b.Set(k, v, nil)
+}
+
+func (tx *Tx) Del(k flat.Key) error {
+	if tx.b == nil {
+		return flat.ErrReadOnly
+	}
+	return tx.b.Delete(k, nil)
+}
+
+func (tx *Tx) Scan(pref flat.Key) flat.Iterator {
+	opts := &pebble.IterOptions{}
+	if len(pref) != 0 {
+		opts.LowerBound = pref
+		opts.UpperBound = prefixEnd(pref)
+	}
+	var (
+		it  *pebble.Iterator
+		err error
+	)
+	if tx.b != nil {
+		it, err = tx.b.NewIter(opts)
+	} else {
+		it, err = tx.sn.NewIter(opts)
+	}
+	if err != nil {
+		return &Iterator{err: err}
+	}
+	return &Iterator{it: it, first: true}
+}
+
+// prefixEnd returns the smallest key that is larger than all keys with a given prefix,
+// or nil if there is no such key.
+func prefixEnd(pref []byte) []byte {
+	end := make([]byte, len(pref))
+	copy(end, pref)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+type Iterator struct {
+	it    *pebble.Iterator
+	first bool
+	err   error
+}
+
+func (it *Iterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	} else if it.first {
+		it.first = false
+		return it.it.First()
+	}
+	return it.it.Next()
+}
+func (it *Iterator) Key() flat.Key   { return it.it.Key() }
+func (it *Iterator) Val() flat.Value { return it.it.Value() }
+func (it *Iterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Error()
+}
+func (it *Iterator) Close() error {
+	if it.it == nil {
+		return it.err
+	}
+	return it.it.Close()
+}
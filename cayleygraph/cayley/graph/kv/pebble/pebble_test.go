@@ -0,0 +1,64 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pebble
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/kv/kvtest"
+	hkv "github.com/hidal-go/hidalgo/kv"
+)
+
+func makePebble(t testing.TB) (hkv.KV, graph.Options, func()) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "cayley_test_"+Type)
+	if err != nil {
+		t.Fatalf("Could not create working directory: %v", err)
+	}
+	db, err := Create(tmpDir, graph.Options{"nosync": true})
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatal("Failed to create Pebble database.", err)
+	}
+	return db, nil, func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestPebble(t *testing.T) {
+	kvtest.TestAll(t, makePebble, nil)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for _, c := range []struct {
+		pref, end string
+	}{
+		{"a", "b"},
+		{"a\xff", "b"},
+		{"ab\xff\xff", "ac"},
+		{"\xff\xff", ""},
+	} {
+		if got := string(prefixEnd([]byte(c.pref))); got != c.end {
+			t.Errorf("unexpected prefix end for %q: %q vs %q", c.pref, got, c.end)
+		}
+	}
+}
+
+func BenchmarkPebble(b *testing.B) {
+	kvtest.BenchmarkAll(b, makePebble, nil)
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/cayleygraph/quad"
+	"github.com/hidal-go/hidalgo/kv"
+)
+
+var (
+	_ graph.ConditionalWriter = (*QuadStore)(nil)
+	_ graph.Versioner         = (*QuadStore)(nil)
+)
+
+// Version implements graph.Versioner. The version is the horizon of the log,
+// which is advanced by every transaction that adds or deletes quads.
+func (qs *QuadStore) Version(ctx context.Context) (int64, error) {
+	if qs.upto != 0 {
+		return int64(qs.upto) - 1, nil
+	} else if qs.isView() {
+		return 0, graph.ErrOperationNotSupported
+	}
+	h, err := qs.getMetaInt(ctx, "horizon")
+	if err == ErrNoBucket {
+		return 0, nil
+	}
+	return h, err
+}
+
+// ApplyDeltasIf implements graph.ConditionalWriter.
+//
+// Preconditions are checked in the same write transaction that applies the deltas.
+func (qs *QuadStore) ApplyDeltasIf(conds []graph.Precondition, in []graph.Delta, opts graph.IgnoreOpts) error {
+	return qs.applyDeltas(conds, in, opts)
+}
+
+// checkPreconditions returns a PreconditionError for the first precondition that does not hold.
+func (qs *QuadStore) checkPreconditions(ctx context.Context, tx kv.Tx, conds []graph.Precondition) error {
+	for _, c := range conds {
+		ok, err := qs.checkPrecondition(ctx, tx, c)
+		if err != nil {
+			return &graph.PreconditionError{Precondition: c, Err: err}
+		} else if !ok {
+			return &graph.PreconditionError{Precondition: c, Err: graph.ErrPreconditionFailed}
+		}
+	}
+	return nil
+}
+
+func (qs *QuadStore) checkPrecondition(ctx context.Context, tx kv.Tx, c graph.Precondition) (bool, error) {
+	switch c := c.(type) {
+	case graph.QuadExists:
+		ok, err := qs.hasQuad(ctx, tx, c.Quad)
+		return ok, err
+	case graph.QuadNotExists:
+		ok, err := qs.hasQuad(ctx, tx, c.Quad)
+		return !ok, err
+	case graph.NoLinks:
+		if c.Dir < quad.Subject || c.Dir > quad.Label {
+			return false, fmt.Errorf("invalid direction: %v", c.Dir)
+		}
+		ok, err := qs.hasLinks(ctx, tx, c.Node, c.Dir, c.Via)
+		return !ok, err
+	case graph.VersionEquals:
+		h, err := qs.getMetaIntTx(ctx, tx, "horizon")
+		if err == kv.ErrNotFound {
+			h, err = 0, nil
+		}
+		return h == c.Version, err
+	}
+	return false, graph.ErrOperationNotSupported
+}
+
+// hasQuad checks if a quad exists, and is neither deleted nor expired.
+func (qs *QuadStore) hasQuad(ctx context.Context, tx kv.Tx, q quad.Quad) (bool, error) {
+	vals := []quad.Value{q.Subject, q.Predicate, q.Object, q.Label}
+	ids, err := qs.resolveQuadValues(ctx, tx, vals)
+	if err != nil {
+		return false, err
+	}
+	var p proto.Primitive
+	for i, dir := range quad.Directions {
+		if vals[i] == nil {
+			continue
+		} else if ids[i] == 0 {
+			return false, nil
+		}
+		p.SetDirection(dir, ids[i])
+	}
+	prim, err := qs.hasPrimitive(ctx, tx, &p, true)
+	return prim != nil && !isExpired(prim, time.Now().UnixNano()), err
+}
+
+// hasLinks checks if a node has any quads in a given direction, optionally with a given predicate.
+//
+// It scans the best index for the direction, or the whole first index if none of the indexes
+// starts with that direction.
+func (qs *QuadStore) hasLinks(ctx context.Context, tx kv.Tx, node quad.Value, d quad.Direction, via quad.Value) (bool, error) {
+	ids, err := qs.resolveQuadValues(ctx, tx, []quad.Value{node, via})
+	if err != nil {
+		return false, err
+	}
+	id, pid := ids[0], ids[1]
+	if id == 0 || (via != nil && pid == 0) {
+		return false, nil
+	}
+	want := map[quad.Direction]uint64{d: id}
+	dirs := []quad.Direction{d}
+	if via != nil && d != quad.Predicate {
+		want[quad.Predicate] = pid
+		dirs = append(dirs, quad.Predicate)
+	}
+	var prefix kv.Key
+	if inds := qs.bestIndexes(dirs); len(inds) != 0 {
+		ind := inds[0]
+		var vals []uint64
+		for _, d := range ind.Dirs {
+			v, ok := want[d]
+			if !ok {
+				break
+			}
+			vals = append(vals, v)
+		}
+		prefix = ind.Key(vals)
+	} else {
+		qs.indexes.RLock()
+		all := qs.indexes.all
+		qs.indexes.RUnlock()
+		if len(all) == 0 {
+			return false, fmt.Errorf("no indexes defined")
+		}
+		prefix = all[0].bucket()
+	}
+	it := tx.Scan(prefix)
+	defer it.Close()
+	for it.Next(ctx) {
+		keys, err := decodeIndex(it.Val())
+		if err != nil {
+			return false, err
+		}
+		prims, err := qs.getPrimitivesFromLog(ctx, tx, keys)
+		if err != nil {
+			return false, err
+		}
+		for _, p := range prims {
+			if p == nil || !qs.isAlive(p) || p.GetDirection(d) != id {
+				continue
+			} else if via != nil && p.Predicate != pid {
+				continue
+			}
+			return true, nil
+		}
+	}
+	return false, it.Err()
+}
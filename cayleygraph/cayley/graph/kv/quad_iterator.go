@@ -202,7 +202,7 @@ func (it *quadIteratorNext) Next(ctx context.Context) bool {
 		}
 		for ; len(it.buf) > 0; it.buf, it.off = it.buf[1:], it.off+1 {
 			p := it.buf[0]
-			if p == nil || p.Deleted {
+			if p == nil || !it.qs.isAlive(p) {
 				continue
 			}
 			// TODO(dennwc): shouldn't this check the horizon?
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/cayleygraph/cayley/graph"
 	"github.com/cayleygraph/cayley/graph/proto"
@@ -48,7 +49,11 @@ type Registration struct {
 type InitFunc func(string, graph.Options) (kv.KV, error)
 type NewFunc func(string, graph.Options) (kv.KV, error)
 
+// registry contains all registered key-value backends, see RestoreTo.
+var registry = make(map[string]Registration)
+
 func Register(name string, r Registration) {
+	registry[name] = r
 	graph.RegisterQuadStore(name, graph.QuadStoreRegistration{
 		InitFunc: func(addr string, opt graph.Options) error {
 			if !r.IsPersistent { // 不需要持久化
@@ -97,9 +102,22 @@ var (
 type QuadStore struct {
 	db kv.KV
 
+	// asOf is set to a timestamp in nanoseconds for read-only views of the past state of the store.
+	asOf int64
+	// upto is set to the first log position after the horizon for read-only snapshots of the store, see Snapshot.
+	upto uint64
+	// size is the number of quads in the snapshot.
+	size int64
+	// taken is the time when the snapshot was taken, in Unix nanoseconds. Quads that expire later are visible in it.
+	taken int64
+	// stats is set if the database tracks per-predicate statistics, see PredicateStats.
+	stats bool
+
 	indexes struct {
 		sync.RWMutex
 		all []QuadIndex
+		// indexes that are being built, see AddIndex
+		building []indexBuild
 		// indexes used to detect duplicate quads
 		exists []QuadIndex
 	}
@@ -107,10 +125,23 @@ type QuadStore struct {
 	valueLRU *lru.Cache
 
 	writer    sync.Mutex
+	changes   graph.ChangeNotifier
 	mapBucket map[string]map[string][]uint64
 	mapBloom  map[string]*boom.BloomFilter
 	mapNodes  *boom.BloomFilter
 
+	// changesKeep is the number of the last batches of the change feed that Compact never removes
+	changesKeep int64
+
+	// readers tracks views and change feeds in use, so Compact keeps everything they can still read
+	readers struct {
+		sync.Mutex
+		// views counts views in use by the time after which deleted primitives are visible to them
+		views map[int64]int
+		// feeds counts change feeds that are reading by the last position they have read
+		feeds map[int64]int
+	}
+
 	exists struct {
 		disabled bool
 		sync.Mutex
@@ -159,11 +190,17 @@ func Init(kv kv.KV, opt graph.Options) error {
 	if err := qs.writeIndexesMeta(ctx); err != nil {
 		return err
 	}
-	return nil
+	// new databases track per-predicate statistics from the start
+	return qs.enableStats(ctx)
 }
 
 const (
 	OptNoBloom = "no_bloom"
+	// OptChangesKeep is the number of the last batches of the change feed that are never removed by Compact,
+	// so feeds that are not reading at the moment, for example replicas that are disconnected, can continue.
+	OptChangesKeep = "changes_keep"
+
+	defaultChangesKeep = 10000
 )
 
 // New : Important!!! : 将kv的DB结构体转成graph.QuadStore
@@ -184,10 +221,25 @@ func New(kv kv.KV, opt graph.Options) (graph.QuadStore, error) {
 		return nil, err
 	}
 	qs.indexes.all = list
+	qs.indexes.building, err = qs.readBuildingMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	qs.stats, err = qs.hasStats(ctx)
+	if err != nil {
+		return nil, err
+	}
 	// 初始化lru
 	qs.valueLRU = lru.New(2000)
 	// 是否开启布隆过滤器
 	qs.exists.disabled, _ = opt.BoolKey(OptNoBloom, false)
+	keep, err := opt.IntKey(OptChangesKeep, defaultChangesKeep)
+	if err != nil {
+		return nil, err
+	} else if keep < 0 {
+		return nil, fmt.Errorf("kv: invalid %s: %d", OptChangesKeep, keep)
+	}
+	qs.changesKeep = int64(keep)
 	if err := qs.initBloomFilter(ctx); err != nil {
 		return nil, err
 	}
@@ -233,6 +285,9 @@ func (qs *QuadStore) getMetaInt(ctx context.Context, key string) (int64, error)
 }
 
 func (qs *QuadStore) getSize() (int64, error) {
+	if qs.upto != 0 {
+		return qs.size, nil
+	}
 	// 从metaBucket中取出size的key
 	sz, err := qs.getMetaInt(context.TODO(), "size")
 	if err == ErrNoBucket {
@@ -247,9 +302,21 @@ func (qs *QuadStore) Size() int64 {
 }
 
 func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
-	sz, err := qs.getMetaInt(ctx, "size")
-	if err != nil {
-		return graph.Stats{}, err
+	sz := qs.size
+	if qs.upto == 0 {
+		var err error
+		sz, err = qs.getMetaInt(ctx, "size")
+		if err != nil {
+			return graph.Stats{}, err
+		}
+	}
+	if !qs.isView() {
+		// expired quads are counted until they are deleted; snapshots exclude them when they are taken
+		expired, err := qs.numExpired(ctx)
+		if err != nil {
+			return graph.Stats{}, err
+		}
+		sz -= expired
 	}
 	st := graph.Stats{
 		Nodes: refs.Size{
@@ -258,9 +325,23 @@ func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error)
 		},
 		Quads: refs.Size{
 			Value: sz,
-			Exact: true,
+			// size is only tracked for the current state; snapshots capture it when they are taken,
+			// while AsOf views only know the current size
+			Exact: qs.upto != 0 || !qs.isView(),
 		},
 	}
+	if exact && !st.Quads.Exact {
+		st.Quads.Value = 0
+		it := qs.QuadsAllIterator().Iterate()
+		defer it.Close()
+		for it.Next(ctx) {
+			st.Quads.Value++
+		}
+		if err := it.Err(); err != nil {
+			return st, err
+		}
+		st.Quads.Exact = true
+	}
 	if exact {
 		// calculate the exact number of nodes
 		st.Nodes.Value = 0
@@ -434,6 +515,42 @@ func (qs *QuadStore) Quad(k graph.Ref) (quad.Quad, error) {
 	return v, err
 }
 
+var _ graph.QuadChecker = (*QuadStore)(nil)
+
+// HasQuad implements graph.QuadChecker by looking up the quad primitive in the indexes.
+//
+// Views return graph.ErrOperationNotSupported, since indexes only contain quads that exist now.
+func (qs *QuadStore) HasQuad(ctx context.Context, q quad.Quad) (bool, error) {
+	if qs.isView() {
+		return false, graph.ErrOperationNotSupported
+	}
+	vals := make([]quad.Value, len(quad.Directions))
+	for i, d := range quad.Directions {
+		vals[i] = q.Get(d)
+	}
+	var found bool
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		ids, err := qs.resolveQuadValues(ctx, tx, vals)
+		if err != nil {
+			return err
+		}
+		var link proto.Primitive
+		for i, d := range quad.Directions {
+			if vals[i] != nil && ids[i] == 0 {
+				return nil // no such node
+			}
+			link.SetDirection(d, ids[i])
+		}
+		p, err := qs.hasPrimitive(ctx, tx, &link, true)
+		if err != nil {
+			return err
+		}
+		found = p != nil && !isExpired(p, time.Now().UnixNano())
+		return nil
+	})
+	return found, err
+}
+
 func (qs *QuadStore) primitiveToQuad(ctx context.Context, tx kv.Tx, p *proto.Primitive) (quad.Quad, error) {
 	q := &quad.Quad{}
 	for _, dir := range quad.Directions {
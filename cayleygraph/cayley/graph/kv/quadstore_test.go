@@ -55,6 +55,10 @@ func be(v ...uint64) []byte {
 	}
 	return b
 }
+func pstat(typ byte, v ...uint64) []byte {
+	return append([]byte{typ}, be(v...)...)
+}
+
 func le(v uint64) []byte {
 	var b [8]byte
 	binary.LittleEndian.PutUint64(b[:], uint64(v))
@@ -72,7 +76,9 @@ var (
 
 	vAuto = []byte("auto")
 
-	kIndexes = []byte("indexes")
+	kIndexes  = []byte("indexes")
+	kBuilding = []byte("indexes_building")
+	kStats    = []byte("pstats")
 )
 
 type Ops []kvOp
@@ -136,6 +142,7 @@ func TestApplyDeltas(t *testing.T) {
 		{opPut, key("ops", []byte{}), nil, nil},
 		{opPut, key(bMeta, kVers), vVers, nil},
 		{opPut, key(bMeta, kIndexes), []byte(`[{"dirs":"AQI=","unique":false},{"dirs":"AwIB","unique":false}]`), nil},
+		{opPut, key(bMeta, kStats), hex("01"), nil},
 	})
 
 	qs, err := kv.New(hook, nil)
@@ -145,6 +152,8 @@ func TestApplyDeltas(t *testing.T) {
 	expect(Ops{
 		{opGet, key(bMeta, kVers), vVers, nil},
 		{opGet, key(bMeta, kIndexes), []byte(`[{"dirs":"AQI=","unique":false},{"dirs":"AwIB","unique":false}]`), nil},
+		{opGet, key(bMeta, kBuilding), nil, hkv.ErrNotFound},
+		{opGet, key(bMeta, kStats), hex("01"), nil},
 		{opGet, key(bMeta, []byte("size")), nil, hkv.ErrNotFound},
 	})
 
@@ -171,8 +180,17 @@ func TestApplyDeltas(t *testing.T) {
 		{opGet, key(bMeta, []byte("horizon")), le(3), nil},
 		{opPut, key(bMeta, []byte("horizon")), le(4), nil},
 		{opPut, key(bLog, be(4)), vAuto, nil},
+		{opGet, key("pstats", pstat('o', 2, 3)), nil, nil},
+		{opGet, key("pstats", pstat('s', 2, 1)), nil, nil},
+		{opPut, key("pstats", pstat('o', 2, 3)), hex("01"), nil},
+		{opPut, key("pstats", pstat('s', 2, 1)), hex("01"), nil},
+		{opGet, key("pstats", pstat('p', 2)), nil, nil},
+		{opPut, key("pstats", pstat('p', 2)), hex("010101"), nil},
 		{opGet, key(bMeta, []byte("size")), nil, hkv.ErrNotFound},
 		{opPut, key(bMeta, []byte("size")), le(1), nil},
+		{opGet, key(bMeta, []byte("changes")), nil, hkv.ErrNotFound},
+		{opPut, key(bMeta, []byte("changes")), le(1), nil},
+		{opPut, key("changes", be(1)), vAuto, nil},
 		{opPut, key("ops", be(3, 2, 1)), hex("04"), nil},
 		{opPut, key("sp", be(1, 2)), hex("04"), nil},
 	})
@@ -198,8 +216,17 @@ func TestApplyDeltas(t *testing.T) {
 		{opGet, key(bMeta, []byte("horizon")), le(5), nil},
 		{opPut, key(bMeta, []byte("horizon")), le(6), nil},
 		{opPut, key(bLog, be(6)), vAuto, nil},
+		{opGet, key("pstats", pstat('o', 2, 5)), nil, nil},
+		{opGet, key("pstats", pstat('s', 2, 1)), hex("01"), nil},
+		{opPut, key("pstats", pstat('o', 2, 5)), hex("01"), nil},
+		{opPut, key("pstats", pstat('s', 2, 1)), hex("02"), nil},
+		{opGet, key("pstats", pstat('p', 2)), hex("010101"), nil},
+		{opPut, key("pstats", pstat('p', 2)), hex("020102"), nil},
 		{opGet, key(bMeta, []byte("size")), le(1), nil},
 		{opPut, key(bMeta, []byte("size")), le(2), nil},
+		{opGet, key(bMeta, []byte("changes")), le(1), nil},
+		{opPut, key(bMeta, []byte("changes")), le(2), nil},
+		{opPut, key("changes", be(2)), vAuto, nil},
 		{opPut, key("ops", be(5, 2, 1)), hex("06"), nil},
 		{opGet, key("sp", be(1, 2)), hex("04"), nil},
 		{opPut, key("sp", be(1, 2)), hex("0406"), nil},
@@ -210,7 +237,15 @@ func TestApplyDeltas(t *testing.T) {
 		{opGet, key("sp", be(1, 2)), hex("0406"), nil},
 		{opGet, key("ops", be(3, 2, 1)), hex("04"), nil},
 		{opGet, key(bLog, be(4)), vAuto, nil},
+		{opGet, key(bMeta, []byte("horizon")), le(6), nil},
+		{opPut, key(bMeta, []byte("horizon")), le(7), nil},
 		{opPut, key(bLog, be(4)), vAuto, nil},
+		{opGet, key("pstats", pstat('o', 2, 3)), hex("01"), nil},
+		{opGet, key("pstats", pstat('s', 2, 1)), hex("02"), nil},
+		{opDel, key("pstats", pstat('o', 2, 3)), nil, nil},
+		{opPut, key("pstats", pstat('s', 2, 1)), hex("01"), nil},
+		{opGet, key("pstats", pstat('p', 2)), hex("020102"), nil},
+		{opPut, key("pstats", pstat('p', 2)), hex("010101"), nil},
 		{opGet, key(bMeta, []byte("size")), le(2), nil},
 		{opPut, key(bMeta, []byte("size")), le(1), nil},
 		{opGet, key(iric("a"), irih("a")), hex("02"), nil},
@@ -219,8 +254,16 @@ func TestApplyDeltas(t *testing.T) {
 		{opPut, key(iric("a"), irih("a")), hex("01"), nil},
 		{opPut, key(iric("b"), irih("b")), hex("01"), nil},
 		{opDel, key(iric("c"), irih("c")), nil, nil},
+		{opGet, key(bMeta, []byte("horizon")), le(7), nil},
+		{opPut, key(bMeta, []byte("horizon")), le(8), nil},
 		{opDel, key(irib("c"), irih("c")), nil, nil},
-		{opDel, key(bLog, be(3)), nil, nil},
+		{opGet, key(bLog, be(3)), vAuto, nil},
+		{opGet, key("dead", irih("c")), nil, hkv.ErrNotFound},
+		{opPut, key(bLog, be(3)), vAuto, nil},
+		{opPut, key("dead", irih("c")), hex("03"), nil},
+		{opGet, key(bMeta, []byte("changes")), le(2), nil},
+		{opPut, key(bMeta, []byte("changes")), le(3), nil},
+		{opPut, key("changes", be(3)), vAuto, nil},
 	})
 	require.NoError(t, err)
 }
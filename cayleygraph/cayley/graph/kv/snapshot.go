@@ -0,0 +1,74 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/hidal-go/hidalgo/kv"
+)
+
+var _ graph.Snapshotter = (*QuadStore)(nil)
+
+// Snapshot implements graph.Snapshotter.
+//
+// The snapshot is pinned to the log horizon: it observes primitives with IDs up to the horizon
+// at the time it was taken, and deletions are visible only if they happened before that, since
+// each deleting transaction allocates a new position in the log (see DeletedHorizon). The size
+// of the store is captured as well, excluding quads expired at that time, so the snapshot reports the exact
+// number of quads without counting them (see QuadStore.upto).
+// Quads that expire after the snapshot was taken remain visible in it. It waits for
+// in-progress writes to finish, so the horizon and the size are consistent.
+//
+// No read transaction is kept open by the snapshot: bolt cannot grow the database file while
+// a read transaction is in progress, and btree doesn't isolate reads from writes at all.
+// Instead, Compact keeps primitives visible to the snapshot until it's closed.
+func (qs *QuadStore) Snapshot(ctx context.Context) (graph.QuadStore, error) {
+	if qs.isView() {
+		// views never change
+		return qs, nil
+	}
+	var horizon, size int64
+	qs.writer.Lock()
+	taken := time.Now().UnixNano()
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		var err error
+		horizon, err = qs.getMetaIntTx(ctx, tx, "horizon")
+		if err != nil && err != kv.ErrNotFound {
+			return err
+		}
+		size, err = qs.getMetaIntTx(ctx, tx, "size")
+		if err != nil && err != kv.ErrNotFound {
+			return err
+		}
+		return scanExpired(ctx, tx, taken, func(id uint64) error {
+			size--
+			return nil
+		})
+	})
+	qs.writer.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	// primitives deleted after the snapshot was taken are visible in it
+	v := qs.newView(taken - 1)
+	v.db.(*viewKV).hold()
+	v.upto = uint64(horizon) + 1
+	v.size = size
+	v.taken = taken
+	return v, nil
+}
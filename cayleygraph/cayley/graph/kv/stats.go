@@ -0,0 +1,265 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/cayleygraph/cayley/graph/refs"
+)
+
+var _ graph.StatsProvider = (*QuadStore)(nil)
+
+var (
+	// statsBucket stores per-predicate statistics. Keys are prefixed with a single letter:
+	//
+	//	'p' + predicate ID: number of quads, distinct subjects and objects, as uvarints
+	//	's' + predicate ID + subject ID: number of quads with this predicate and subject
+	//	'o' + predicate ID + object ID: number of quads with this predicate and object
+	statsBucket = kv.Key{[]byte("pstats")}
+
+	// keyMetaStats is set for databases that track per-predicate statistics.
+	// Databases created by older versions have no statistics for existing quads.
+	keyMetaStats = metaBucket.AppendBytes([]byte("pstats"))
+)
+
+// predStats is a set of per-predicate counters stored in statsBucket.
+type predStats struct {
+	Quads, Subjects, Objects int64
+}
+
+func statsKey(typ byte, ids ...uint64) kv.Key {
+	b := make([]byte, 1+8*len(ids))
+	b[0] = typ
+	for i, id := range ids {
+		binary.BigEndian.PutUint64(b[1+8*i:], id)
+	}
+	return statsBucket.AppendBytes(b)
+}
+
+func decodePredStats(b []byte) (predStats, error) {
+	var (
+		st   predStats
+		vals = [3]*int64{&st.Quads, &st.Subjects, &st.Objects}
+	)
+	for _, v := range vals {
+		x, n := binary.Uvarint(b)
+		if n <= 0 {
+			return st, fmt.Errorf("kv: corrupted predicate stats")
+		}
+		*v = int64(x)
+		b = b[n:]
+	}
+	return st, nil
+}
+
+func (st predStats) encode() []byte {
+	buf := make([]byte, 0, 3*binary.MaxVarintLen64)
+	var tmp [binary.MaxVarintLen64]byte
+	for _, v := range []int64{st.Quads, st.Subjects, st.Objects} {
+		n := binary.PutUvarint(tmp[:], uint64(v))
+		buf = append(buf, tmp[:n]...)
+	}
+	return buf
+}
+
+func (st predStats) toGraph(pred uint64, exact bool) graph.PredicateStats {
+	return graph.PredicateStats{
+		Predicate: Int64Value(pred),
+		Quads:     refs.Size{Value: st.Quads, Exact: exact},
+		Subjects:  refs.Size{Value: st.Subjects, Exact: exact},
+		Objects:   refs.Size{Value: st.Objects, Exact: exact},
+	}
+}
+
+// hasStats checks if the database tracks per-predicate statistics.
+func (qs *QuadStore) hasStats(ctx context.Context) (bool, error) {
+	var ok bool
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		_, err := tx.Get(ctx, keyMetaStats)
+		if err == kv.ErrNotFound {
+			return nil
+		}
+		ok = err == nil
+		return err
+	})
+	return ok, err
+}
+
+// enableStats marks an empty database as the one that tracks per-predicate statistics.
+func (qs *QuadStore) enableStats(ctx context.Context) error {
+	return kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		return tx.Put(keyMetaStats, []byte{1})
+	})
+}
+
+// updateStats adds (n > 0) or removes (n < 0) quads from per-predicate statistics.
+func (qs *QuadStore) updateStats(ctx context.Context, tx kv.Tx, links []proto.Primitive, n int64) error {
+	if !qs.stats || len(links) == 0 {
+		return nil
+	}
+	preds := make(map[uint64]*predStats)
+	// changes of counters for subject-predicate and object-predicate pairs
+	pairs := make(map[string]int64)
+	for _, p := range links {
+		st := preds[p.Predicate]
+		if st == nil {
+			st = new(predStats)
+			preds[p.Predicate] = st
+		}
+		st.Quads += n
+		pairs[string(statsKey('s', p.Predicate, p.Subject)[1])] += n
+		pairs[string(statsKey('o', p.Predicate, p.Object)[1])] += n
+	}
+	keys := make([]kv.Key, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, statsBucket.AppendBytes([]byte(k)))
+	}
+	sort.Sort(kv.ByKey(keys))
+	vals, err := tx.GetBatch(ctx, keys)
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		var cnt int64
+		if len(vals[i]) != 0 {
+			v, _ := binary.Uvarint(vals[i])
+			cnt = int64(v)
+		}
+		next := cnt + pairs[string(k[1])]
+		if next < 0 {
+			next = 0
+		}
+		st := preds[binary.BigEndian.Uint64(k[1][1:])]
+		d := &st.Subjects
+		if k[1][0] == 'o' {
+			d = &st.Objects
+		}
+		switch {
+		case cnt == 0 && next > 0:
+			*d++
+		case cnt > 0 && next == 0:
+			*d--
+		}
+		if next == 0 {
+			err = tx.Del(k)
+		} else {
+			var tmp [binary.MaxVarintLen64]byte
+			m := binary.PutUvarint(tmp[:], uint64(next))
+			err = tx.Put(k, append([]byte{}, tmp[:m]...))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make([]uint64, 0, len(preds))
+	for id := range preds {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	keys = keys[:0]
+	for _, id := range ids {
+		keys = append(keys, statsKey('p', id))
+	}
+	vals, err = tx.GetBatch(ctx, keys)
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		var cur predStats
+		if len(vals[i]) != 0 {
+			cur, err = decodePredStats(vals[i])
+			if err != nil {
+				return err
+			}
+		}
+		d := preds[ids[i]]
+		cur.Quads += d.Quads
+		cur.Subjects += d.Subjects
+		cur.Objects += d.Objects
+		if cur.Quads <= 0 {
+			err = tx.Del(k)
+		} else {
+			err = tx.Put(k, cur.encode())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PredicateStats implements graph.StatsProvider.
+//
+// Statistics are only tracked for the current state of the store, thus they are not exact in views
+// of the past state (see AsOf). It returns graph.ErrOperationNotSupported for databases created by
+// older versions.
+func (qs *QuadStore) PredicateStats(ctx context.Context, pred graph.Ref) (graph.PredicateStats, error) {
+	if !qs.stats {
+		return graph.PredicateStats{}, graph.ErrOperationNotSupported
+	}
+	id, ok := pred.(Int64Value)
+	if !ok {
+		return graph.PredicateStats{Predicate: pred}, nil
+	}
+	var st predStats
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		b, err := tx.Get(ctx, statsKey('p', uint64(id)))
+		if err == kv.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		st, err = decodePredStats(b)
+		return err
+	})
+	if err != nil {
+		return graph.PredicateStats{}, err
+	}
+	return st.toGraph(uint64(id), !qs.isView()), nil
+}
+
+// AllPredicateStats implements graph.StatsProvider.
+func (qs *QuadStore) AllPredicateStats(ctx context.Context) ([]graph.PredicateStats, error) {
+	if !qs.stats {
+		return nil, graph.ErrOperationNotSupported
+	}
+	var out []graph.PredicateStats
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		it := tx.Scan(statsBucket.AppendBytes([]byte{'p'}))
+		defer it.Close()
+		for it.Next(ctx) {
+			k := it.Key()
+			if len(k) != 2 || len(k[1]) != 9 {
+				continue
+			}
+			st, err := decodePredStats(it.Val())
+			if err != nil {
+				return err
+			}
+			out = append(out, st.toGraph(binary.BigEndian.Uint64(k[1][1:]), !qs.isView()))
+		}
+		return it.Err()
+	})
+	return out, err
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/hidal-go/hidalgo/kv"
+)
+
+var _ graph.Watcher = (*QuadStore)(nil)
+
+var (
+	// changesBucket maps positions in the change feed to a commit time and a list of
+	// quad primitives that were added or deleted in a single transaction.
+	changesBucket = kv.Key{[]byte("changes")}
+
+	// ErrChangesCompacted is returned by a change feed that starts at a position that was removed by Compact.
+	ErrChangesCompacted = graph.ErrChangesCompacted
+)
+
+const (
+	metaChanges = "changes"
+	// metaChangesStart is the last position removed from the change feed by Compact.
+	metaChangesStart = "changes_start"
+	// metaCompacted is the last position of the change feed whose deletions were removed from the log by Compact.
+	metaCompacted = "compacted"
+
+	// changesPerRead is the number of batches that a change feed reads at once.
+	changesPerRead = 64
+)
+
+// appendChange encodes IDs of quad primitives that were added or deleted by a transaction.
+// Each ID is shifted left by one, with the lowest bit set for deleted quads.
+func appendChange(buf []byte, links []proto.Primitive, del bool) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	for _, p := range links {
+		v := p.ID << 1
+		if del {
+			v |= 1
+		}
+		n := binary.PutUvarint(tmp[:], v)
+		buf = append(buf, tmp[:n]...)
+	}
+	return buf
+}
+
+// decodeChange decodes IDs of quad primitives encoded by appendChange, and flags for deleted ones.
+func decodeChange(b []byte) (ids []uint64, dels []bool, _ error) {
+	for len(b) != 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		b = b[n:]
+		ids = append(ids, v>>1)
+		dels = append(dels, v&1 != 0)
+	}
+	return ids, dels, nil
+}
+
+// logChange records a batch of changes in the change feed.
+func (qs *QuadStore) logChange(ctx context.Context, tx kv.Tx, change []byte) error {
+	if len(change) == 0 {
+		return nil
+	}
+	pos, err := qs.incMetaInt(ctx, tx, metaChanges, 1)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(change))
+	n := binary.PutUvarint(buf, uint64(time.Now().UnixNano()))
+	buf = append(buf[:n], change...)
+	return tx.Put(changesBucket.Append(uint64KeyBytes(uint64(pos+1))), buf)
+}
+
+// Watch implements graph.Watcher.
+//
+// Each batch corresponds to a committed transaction. Batches refer to primitives in the log,
+// thus the feed only includes quads that were added or deleted, and ignores duplicates.
+// Compact keeps batches while a feed reads them, but it may remove batches that idle feeds have not
+// read yet, and such feeds fail with ErrChangesCompacted.
+func (qs *QuadStore) Watch(ctx context.Context, from int64) (graph.ChangeFeed, error) {
+	if qs.isView() {
+		return nil, graph.ErrOperationNotSupported
+	}
+	if from < 0 {
+		var err error
+		from, err = qs.getMetaInt(ctx, metaChanges)
+		if err == ErrNoBucket {
+			from = 0
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return graph.NewChangeFeed(&qs.changes, from, qs.readFeed), nil
+}
+
+// readFeed reads changes for a change feed. Compact keeps the changes until the read is done.
+func (qs *QuadStore) readFeed(ctx context.Context, after int64) ([]graph.Change, error) {
+	qs.pinFeed(after)
+	defer qs.unpinFeed(after)
+	return qs.readChanges(ctx, after)
+}
+
+// readChanges reads batches of changes with positions after a given one.
+func (qs *QuadStore) readChanges(ctx context.Context, after int64) ([]graph.Change, error) {
+	tx, err := qs.db.Tx(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close()
+	tx = wrapTx(tx)
+
+	if start, err := qs.getMetaIntTx(ctx, tx, metaChangesStart); err != nil && err != kv.ErrNotFound {
+		return nil, err
+	} else if after < start {
+		return nil, ErrChangesCompacted
+	}
+	keys := make([]kv.Key, changesPerRead)
+	for i := range keys {
+		keys[i] = changesBucket.Append(uint64KeyBytes(uint64(after + 1 + int64(i))))
+	}
+	vals, err := tx.GetBatch(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	var out []graph.Change
+	for i, b := range vals {
+		if b == nil {
+			// positions are sequential, no more changes
+			break
+		}
+		pos := after + 1 + int64(i)
+		ts, n := binary.Uvarint(b)
+		if n <= 0 {
+			return out, fmt.Errorf("kv: corrupted change at position %d", pos)
+		}
+		ids, dels, err := decodeChange(b[n:])
+		if err != nil {
+			return out, fmt.Errorf("kv: corrupted change at position %d", pos)
+		}
+		prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
+		if err != nil {
+			return out, err
+		}
+		c := graph.Change{
+			Position: pos,
+			Time:     time.Unix(0, int64(ts)),
+			Deltas:   make([]graph.Delta, 0, len(prims)),
+		}
+		for j, p := range prims {
+			if p == nil {
+				return out, fmt.Errorf("kv: primitive %d of change %d is missing from the log", ids[j], c.Position)
+			}
+			q, err := qs.primitiveToQuad(ctx, tx, p)
+			if err != nil {
+				return out, err
+			}
+			d := graph.Delta{Quad: q, Action: graph.Add}
+			if dels[j] {
+				d.Action = graph.Delete
+			} else if p.Expires != 0 {
+				d.Expires = time.Unix(0, p.Expires)
+			}
+			c.Deltas = append(c.Deltas, d)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"context"
+	"sort"
+
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+)
+
+// LabelManager is an optional interface for QuadStores that can manage named graphs natively,
+// without reading and rewriting each quad on the caller side.
+//
+// Named graphs are identified by quad labels. A nil label refers to the default graph.
+type LabelManager interface {
+	// ListLabels returns labels of all named graphs in the store. The default graph is not included.
+	ListLabels(ctx context.Context) ([]quad.Value, error)
+	// DropLabel removes all quads of a named graph.
+	DropLabel(ctx context.Context, label quad.Value) error
+	// CopyLabel adds all quads of a named graph to another graph.
+	// Quads that already exist in the destination graph are skipped.
+	CopyLabel(ctx context.Context, from, to quad.Value) error
+	// MoveLabel moves all quads of a named graph to another graph.
+	// It works as CopyLabel followed by DropLabel on the source graph, but in a single transaction.
+	MoveLabel(ctx context.Context, from, to quad.Value) error
+}
+
+func labelManager(qs QuadStore) (LabelManager, bool) {
+	if h, ok := qs.(*Handle); ok {
+		// writer may forbid writes to the store
+		if m, ok := h.QuadWriter.(LabelManager); ok {
+			return m, true
+		}
+	}
+	m, ok := Unwrap(qs).(LabelManager)
+	return m, ok
+}
+
+// ListLabels returns labels of all named graphs in the QuadStore, except the default graph.
+//
+// If the backend does not implement LabelManager, all quads are scanned.
+func ListLabels(ctx context.Context, qs QuadStore) ([]quad.Value, error) {
+	if m, ok := labelManager(qs); ok {
+		return m.ListLabels(ctx)
+	}
+	qs = Unwrap(qs)
+	seen := make(map[refs.ValueHash]struct{})
+	var labels []quad.Value
+	err := iterateQuads(ctx, qs, qs.QuadsAllIterator(), func(q quad.Quad) {
+		if q.Label == nil {
+			return
+		}
+		k := refs.HashOf(q.Label)
+		if _, ok := seen[k]; ok {
+			return
+		}
+		seen[k] = struct{}{}
+		labels = append(labels, q.Label)
+	})
+	if err != nil {
+		return nil, err
+	}
+	SortLabels(labels)
+	return labels, nil
+}
+
+// SortLabels sorts a list of labels by their string representation.
+func SortLabels(labels []quad.Value) {
+	sort.Slice(labels, func(i, j int) bool {
+		return quad.StringOf(labels[i]) < quad.StringOf(labels[j])
+	})
+}
+
+// DropLabel removes all quads of a named graph.
+//
+// If the backend does not implement LabelManager, quads are removed one by one in a single batch.
+func DropLabel(ctx context.Context, qs QuadStore, label quad.Value) error {
+	if m, ok := labelManager(qs); ok {
+		return m.DropLabel(ctx, label)
+	}
+	return relabelQuads(ctx, Unwrap(qs), label, nil, false, true)
+}
+
+// CopyLabel adds all quads of a named graph to another graph.
+//
+// If the backend does not implement LabelManager, quads are copied one by one in a single batch.
+func CopyLabel(ctx context.Context, qs QuadStore, from, to quad.Value) error {
+	if m, ok := labelManager(qs); ok {
+		return m.CopyLabel(ctx, from, to)
+	}
+	return relabelQuads(ctx, Unwrap(qs), from, to, true, false)
+}
+
+// MoveLabel moves all quads of a named graph to another graph.
+//
+// If the backend does not implement LabelManager, quads are moved one by one in a single batch.
+func MoveLabel(ctx context.Context, qs QuadStore, from, to quad.Value) error {
+	if m, ok := labelManager(qs); ok {
+		return m.MoveLabel(ctx, from, to)
+	}
+	return relabelQuads(ctx, Unwrap(qs), from, to, true, true)
+}
+
+// relabelQuads copies and/or removes all quads with a given label using ApplyDeltas.
+func relabelQuads(ctx context.Context, qs QuadStore, from, to quad.Value, add, del bool) error {
+	if add && refs.HashOf(from) == refs.HashOf(to) {
+		return nil // same graph
+	}
+	var it iterator.Shape
+	if from == nil {
+		it = qs.QuadsAllIterator()
+	} else {
+		ref, err := qs.ValueOf(from)
+		if err != nil {
+			return err
+		} else if ref == nil {
+			return nil
+		}
+		it = qs.QuadIterator(quad.Label, ref)
+	}
+	var deltas []Delta
+	err := iterateQuads(ctx, qs, it, func(q quad.Quad) {
+		if from == nil && q.Label != nil {
+			return
+		}
+		if del {
+			deltas = append(deltas, Delta{Quad: q, Action: Delete})
+		}
+		if add {
+			q.Label = to
+			deltas = append(deltas, Delta{Quad: q, Action: Add})
+		}
+	})
+	if err != nil || len(deltas) == 0 {
+		return err
+	}
+	return qs.ApplyDeltas(deltas, IgnoreOpts{IgnoreDup: true})
+}
+
+func iterateQuads(ctx context.Context, qs QuadStore, s iterator.Shape, fnc func(q quad.Quad)) error {
+	it := s.Iterate()
+	defer it.Close()
+	for it.Next(ctx) {
+		q, err := qs.Quad(it.Result())
+		if err != nil {
+			return err
+		}
+		fnc(q)
+	}
+	return it.Err()
+}
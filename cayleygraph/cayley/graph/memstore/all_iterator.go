@@ -26,24 +26,29 @@ var _ iterator.Shape = (*allIterator)(nil)
 
 type allIterator struct {
 	qs    *QuadStore
-	all   []*Primitive
-	maxid int64 // id of last observed insert (prim id)
 	nodes bool
+	ver   int64 // version of the store, or latest
+	size  refs.Size
 }
 
-func (qs *QuadStore) newAllIterator(nodes bool, maxid int64) *allIterator {
+func (qs *QuadStore) newAllIterator(nodes bool, ver int64, size refs.Size) *allIterator {
 	return &allIterator{
-		qs: qs, all: qs.cloneAll(), nodes: nodes,
-		maxid: maxid,
+		qs: qs, nodes: nodes,
+		ver: ver, size: size,
 	}
 }
 
 func (it *allIterator) Iterate() iterator.Scanner {
-	return it.qs.newAllIteratorNext(it.nodes, it.maxid, it.all)
+	ver, release := it.qs.readVersion(it.ver)
+	it.qs.mu.RLock()
+	all := it.qs.all
+	it.qs.mu.RUnlock()
+	return it.qs.newAllIteratorNext(it.nodes, ver, release, all)
 }
 
 func (it *allIterator) Lookup() iterator.Index {
-	return it.qs.newAllIteratorContains(it.nodes, it.maxid)
+	ver, release := it.qs.readVersion(it.ver)
+	return it.qs.newAllIteratorContains(it.nodes, ver, release)
 }
 
 func (it *allIterator) SubIterators() []iterator.Shape { return nil }
@@ -59,45 +64,43 @@ func (it *allIterator) Stats(ctx context.Context) (iterator.Costs, error) {
 	return iterator.Costs{
 		NextCost:     1,
 		ContainsCost: 1,
-		Size: refs.Size{
-			// TODO(dennwc): use maxid?
-			Value: int64(len(it.all)),
-			Exact: true,
-		},
+		Size:         it.size,
 	}, nil
 }
 
-func (p *Primitive) filter(isNode bool, maxid int64) bool {
-	if p.ID > maxid {
+// filter checks if the primitive is a node or a quad that exists in a given version of the store.
+func (p *Primitive) filter(isNode bool, ver int64) bool {
+	if !p.visible(ver) {
 		return false
 	} else if isNode && p.Value != nil {
 		return true
 	} else if !isNode && !p.Quad.Zero() {
-		return true
+		return !p.expired()
 	}
 	return false
 }
 
 type allIteratorNext struct {
-	qs    *QuadStore
-	all   []*Primitive
-	maxid int64 // id of last observed insert (prim id)
-	nodes bool
+	qs      *QuadStore
+	all     []*Primitive
+	ver     int64
+	release func()
+	nodes   bool
 
 	i    int // index into qs.all
 	cur  *Primitive
 	done bool
 }
 
-func (qs *QuadStore) newAllIteratorNext(nodes bool, maxid int64, all []*Primitive) *allIteratorNext {
+func (qs *QuadStore) newAllIteratorNext(nodes bool, ver int64, release func(), all []*Primitive) *allIteratorNext {
 	return &allIteratorNext{
 		qs: qs, all: all, nodes: nodes,
-		i: -1, maxid: maxid,
+		i: -1, ver: ver, release: release,
 	}
 }
 
 func (it *allIteratorNext) ok(p *Primitive) bool {
-	return p.filter(it.nodes, it.maxid)
+	return p.filter(it.nodes, it.ver)
 }
 
 func (it *allIteratorNext) Next(ctx context.Context) bool {
@@ -113,9 +116,6 @@ func (it *allIteratorNext) Next(ctx context.Context) bool {
 	it.i++
 	for ; it.i < len(all); it.i++ {
 		p := all[it.i]
-		if p.ID > it.maxid {
-			break
-		}
 		if it.ok(p) {
 			it.cur = p
 			return true
@@ -139,6 +139,7 @@ func (it *allIteratorNext) Err() error { return nil }
 func (it *allIteratorNext) Close() error {
 	it.done = true
 	it.all = nil
+	it.release()
 	return nil
 }
 
@@ -150,23 +151,24 @@ func (it *allIteratorNext) String() string {
 func (it *allIteratorNext) NextPath(ctx context.Context) bool { return false }
 
 type allIteratorContains struct {
-	qs    *QuadStore
-	maxid int64 // id of last observed insert (prim id)
-	nodes bool
+	qs      *QuadStore
+	ver     int64
+	release func()
+	nodes   bool
 
 	cur  *Primitive
 	done bool
 }
 
-func (qs *QuadStore) newAllIteratorContains(nodes bool, maxid int64) *allIteratorContains {
+func (qs *QuadStore) newAllIteratorContains(nodes bool, ver int64, release func()) *allIteratorContains {
 	return &allIteratorContains{
 		qs: qs, nodes: nodes,
-		maxid: maxid,
+		ver: ver, release: release,
 	}
 }
 
 func (it *allIteratorContains) ok(p *Primitive) bool {
-	return p.filter(it.nodes, it.maxid)
+	return p.filter(it.nodes, it.ver)
 }
 
 func (it *allIteratorContains) Contains(ctx context.Context, v graph.Ref) bool {
@@ -174,15 +176,16 @@ func (it *allIteratorContains) Contains(ctx context.Context, v graph.Ref) bool {
 	if it.done {
 		return false
 	}
-	id, ok := asID(v)
-	if !ok {
-		return false
-	}
-	p := it.qs.prim[id]
-	if p.ID > it.maxid {
-		return false
+	var p *Primitive
+	switch v := v.(type) {
+	case bnode:
+		it.qs.mu.RLock()
+		p = it.qs.primitive(int64(v))
+		it.qs.mu.RUnlock()
+	case qprim:
+		p = v.p
 	}
-	if !it.ok(p) {
+	if p == nil || !it.ok(p) {
 		return false
 	}
 	it.cur = p
@@ -201,6 +204,7 @@ func (it *allIteratorContains) Result() graph.Ref {
 func (it *allIteratorContains) Err() error { return nil }
 func (it *allIteratorContains) Close() error {
 	it.done = true
+	it.release()
 	return nil
 }
 
@@ -0,0 +1,492 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/proto"
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+)
+
+const (
+	snapshotFile = "snapshot"
+	walFile      = "wal"
+
+	snapshotMagic = "cayley-memstore\n"
+
+	// OptPersist enables the durable store in the directory set as a path.
+	// Without it, the path is a quad file loaded into a store that is not persisted.
+	OptPersist = "persist"
+	// OptSnapshotInterval is the number of batches written to the log before a new snapshot is made.
+	OptSnapshotInterval = "snapshot_interval"
+	// OptNoSync disables syncing the log to disk after each batch.
+	OptNoSync = "nosync"
+	// OptChangesLimit is the number of the last batches kept in the change feed.
+	OptChangesLimit = "changes_limit"
+
+	defaultSnapshotInterval = 10000
+	defaultChangesLimit     = 10000
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorruptedSnapshot = errors.New("memstore: snapshot is corrupted")
+	errNoDirectory       = errors.New("memstore: a directory must be set to persist the store")
+)
+
+// durable keeps the state of the quad store on disk. The state consists of a snapshot of all primitives
+// and a write-ahead log of batches applied after the snapshot was made.
+type durable struct {
+	dir      string
+	wal      *os.File
+	size     int64 // size of complete records in the log
+	batches  int   // number of batches in the log
+	interval int
+	nosync   bool
+}
+
+// Init creates an empty durable quad store in a given directory.
+func Init(dir string) error {
+	if dir == "" {
+		return nil // in-memory only
+	}
+	if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+		return fmt.Errorf("memstore: %q is not a directory", dir)
+	}
+	for _, name := range []string{snapshotFile, walFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return graph.ErrDatabaseExists
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return newQuadStore().writeSnapshot(dir)
+}
+
+// Open loads a durable quad store from a given directory. It reads the last snapshot and replays the log
+// written after it. All batches applied to the store are appended to the log, and a snapshot of the store
+// is made periodically and when the store is closed.
+//
+// Quads added directly with AddQuad, as well as values added with AddValue and AddBNode are not persisted.
+func Open(dir string, opt graph.Options) (*QuadStore, error) {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil, graph.ErrNotInitialized
+	} else if err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		// a quad file that should be loaded into an in-memory store
+		return nil, graph.ErrQuadStoreNotPersistent
+	}
+	d := &durable{dir: dir}
+	if d.interval, err = opt.IntKey(OptSnapshotInterval, defaultSnapshotInterval); err != nil {
+		return nil, err
+	}
+	if d.nosync, err = opt.BoolKey(OptNoSync, false); err != nil {
+		return nil, err
+	}
+	qs, err := readSnapshot(dir)
+	if os.IsNotExist(err) {
+		return nil, graph.ErrNotInitialized
+	} else if err != nil {
+		return nil, err
+	} else if err = qs.setOptions(opt); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if d.batches, err = qs.replayLog(filepath.Join(dir, walFile)); err != nil {
+		return nil, err
+	}
+	if d.batches != 0 {
+		clog.Infof("memstore: replayed %d batches in %v", d.batches, time.Since(start))
+	}
+	// batches written before the store was loaded are not in the change feed
+	qs.changes.start = qs.horizon
+	d.wal, err = os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err = d.wal.Stat()
+	if err != nil {
+		d.wal.Close()
+		return nil, err
+	}
+	d.size = fi.Size()
+	qs.durable = d
+	return qs, nil
+}
+
+// setOptions applies options that are not specific to durable stores.
+func (qs *QuadStore) setOptions(opt graph.Options) error {
+	limit, err := opt.IntKey(OptChangesLimit, defaultChangesLimit)
+	if err != nil {
+		return err
+	}
+	qs.changes.limit = limit
+	return nil
+}
+
+// writeSnapshot atomically replaces the snapshot in a given directory with the current state of the store.
+//
+// The snapshot contains the last assigned ID, the horizon and all primitives in the order of the "all" slice.
+// Each primitive is written as its ID, reference count, IDs of quad directions, a value and an expiration
+// time of the quad, all as uvarints.
+// The file ends with a CRC32 of the preceding content.
+func (qs *QuadStore) writeSnapshot(dir string) error {
+	tmp := filepath.Join(dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	h := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(f, h))
+	var buf [binary.MaxVarintLen64]byte
+	writeUint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		bw.Write(buf[:n])
+	}
+	bw.WriteString(snapshotMagic)
+	writeUint(uint64(qs.last))
+	writeUint(uint64(qs.horizon))
+	// deleted primitives might be kept in the slice for readers
+	var n int
+	for _, p := range qs.all {
+		if !p.isDeleted() {
+			n++
+		}
+	}
+	writeUint(uint64(n))
+	for _, p := range qs.all {
+		if p.isDeleted() {
+			continue
+		}
+		writeUint(uint64(p.ID))
+		writeUint(uint64(p.refs))
+		for _, id := range [4]int64{p.Quad.S, p.Quad.P, p.Quad.O, p.Quad.L} {
+			writeUint(uint64(id))
+		}
+		var data []byte
+		if p.Value != nil {
+			if data, err = pquads.MarshalValue(p.Value); err != nil {
+				return err
+			}
+		}
+		writeUint(uint64(len(data)))
+		bw.Write(data)
+		writeUint(uint64(p.expires))
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(buf[:4], h.Sum32())
+	if _, err = f.Write(buf[:4]); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, snapshotFile))
+}
+
+// readSnapshot loads the snapshot from a given directory and rebuilds all indexes of the store.
+func readSnapshot(dir string) (*QuadStore, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(snapshotMagic)+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errCorruptedSnapshot
+	}
+	n := len(data) - 4
+	if crc32.Checksum(data[:n], crcTable) != binary.LittleEndian.Uint32(data[n:]) {
+		return nil, errCorruptedSnapshot
+	}
+	data = data[len(snapshotMagic):n]
+	readUint := func() uint64 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			err = errCorruptedSnapshot
+			return 0
+		}
+		data = data[n:]
+		return v
+	}
+	qs := newQuadStore()
+	qs.last = int64(readUint())
+	qs.horizon = int64(readUint())
+	cnt := readUint()
+	if err != nil {
+		return nil, err
+	}
+	qs.all = make([]*Primitive, 0, cnt)
+	for i := uint64(0); i < cnt; i++ {
+		p := &Primitive{ID: int64(readUint()), refs: int(readUint())}
+		for _, d := range quad.Directions {
+			p.Quad.SetDir(d, int64(readUint()))
+		}
+		sz := readUint()
+		if err != nil {
+			return nil, err
+		} else if uint64(len(data)) < sz {
+			return nil, errCorruptedSnapshot
+		}
+		if sz != 0 {
+			if p.Value, err = pquads.UnmarshalValue(data[:sz]); err != nil {
+				return nil, err
+			}
+			data = data[sz:]
+		}
+		p.expires = int64(readUint())
+		if err != nil {
+			return nil, err
+		}
+		qs.prim[p.ID] = p
+		qs.all = append(qs.all, p)
+		if p.Value != nil {
+			qs.vals[p.Value.String()] = p.ID
+		}
+		if !p.Quad.Zero() {
+			qs.quads[p.Quad] = p.ID
+			for _, t := range qs.indexesForQuad(p.Quad) {
+				t.Set(p.ID, p)
+			}
+			if p.expires != 0 {
+				qs.expiring[p.ID] = p
+			}
+		}
+	}
+	return qs, nil
+}
+
+// Each record of the log contains a single batch. The record starts with the length of the payload and its
+// CRC32, as 4-byte little-endian integers. The payload contains the horizon of the store after the batch
+// and a list of deltas, each encoded as a length-prefixed proto.LogDelta followed by the expiration time
+// of the quad as a uvarint of Unix nanoseconds (zero if not set). The time of the batch is stored in
+// the Timestamp of each delta.
+
+// encodeBatch encodes deltas applied by a single batch at a given time (in Unix nanoseconds) as a record of the log.
+func encodeBatch(horizon, now int64, deltas []graph.Delta) ([]byte, error) {
+	buf := make([]byte, 8, 64*len(deltas)+16)
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(horizon))
+	buf = append(buf, tmp[:n]...)
+	for _, d := range deltas {
+		ld := proto.LogDelta{
+			ID:        uint64(horizon),
+			Quad:      pquads.MakeQuad(d.Quad),
+			Action:    int32(d.Action),
+			Timestamp: now,
+		}
+		data, err := ld.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		n = binary.PutUvarint(tmp[:], uint64(len(data)))
+		buf = append(buf, tmp[:n]...)
+		buf = append(buf, data...)
+		var exp int64
+		if !d.Expires.IsZero() {
+			exp = d.Expires.UnixNano()
+		}
+		n = binary.PutUvarint(tmp[:], uint64(exp))
+		buf = append(buf, tmp[:n]...)
+	}
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(buf)-8))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(buf[8:], crcTable))
+	return buf, nil
+}
+
+// decodeBatch decodes the payload of a log record. It returns the horizon, the time of the batch and its deltas.
+func decodeBatch(data []byte) (int64, int64, []graph.Delta, error) {
+	horizon, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, 0, nil, errors.New("memstore: corrupted log record")
+	}
+	data = data[n:]
+	var (
+		now    int64
+		deltas []graph.Delta
+	)
+	for len(data) != 0 {
+		sz, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < sz {
+			return 0, 0, nil, errors.New("memstore: corrupted log record")
+		}
+		var ld proto.LogDelta
+		if err := ld.Unmarshal(data[n : n+int(sz)]); err != nil {
+			return 0, 0, nil, err
+		}
+		data = data[n+int(sz):]
+		exp, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, 0, nil, errors.New("memstore: corrupted log record")
+		}
+		now = ld.Timestamp
+		data = data[n:]
+		d := graph.Delta{
+			Quad:   ld.Quad.ToNative(),
+			Action: graph.Procedure(ld.Action),
+		}
+		if exp != 0 {
+			d.Expires = time.Unix(0, int64(exp))
+		}
+		deltas = append(deltas, d)
+	}
+	return int64(horizon), now, deltas, nil
+}
+
+// replayLog applies all batches from the log that are not yet included into the snapshot.
+// An incomplete record at the end of the log, left by an interrupted write, is truncated.
+func (qs *QuadStore) replayLog(path string) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var (
+		r       = bufio.NewReader(f)
+		off     int64
+		batches int
+		hdr     [8]byte
+	)
+	for {
+		if _, err = io.ReadFull(r, hdr[:]); err == io.EOF {
+			return batches, nil
+		} else if err != nil {
+			break
+		}
+		data := make([]byte, binary.LittleEndian.Uint32(hdr[0:]))
+		if _, err = io.ReadFull(r, data); err != nil {
+			break
+		} else if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(hdr[4:]) {
+			err = errors.New("checksum mismatch")
+			break
+		}
+		horizon, now, deltas, derr := decodeBatch(data)
+		if derr != nil {
+			return batches, derr
+		}
+		off += int64(len(hdr) + len(data))
+		batches++
+		if horizon <= qs.horizon {
+			continue // already in the snapshot
+		}
+		qs.applyLogged(now, deltas)
+		qs.horizon = horizon
+	}
+	clog.Warningf("memstore: truncating the log at offset %d: %v", off, err)
+	return batches, f.Truncate(off)
+}
+
+// applyLogged applies deltas of a batch that was read from the log. The result of the replay is exactly the same as
+// the result of the original batch, since IDs are assigned by the store in the order of writes, and expiration
+// of quads is checked at the logged time of the batch.
+func (qs *QuadStore) applyLogged(now int64, deltas []graph.Delta) {
+	qs.lock()
+	defer qs.unlock()
+	for _, d := range deltas {
+		switch d.Action {
+		case graph.Add:
+			qs.addQuadExpiring(d.Quad, d.Expires, now)
+		case graph.Delete:
+			if id, _, ok := qs.findQuad(d.Quad); ok {
+				qs.delete(id)
+			}
+		}
+	}
+}
+
+// logBatch appends deltas of a batch with a given horizon and time to the log. It's called before the batch is applied.
+// If the write fails, a partially written record is removed, thus the following records can be replayed.
+func (d *durable) logBatch(horizon, now int64, deltas []graph.Delta) error {
+	data, err := encodeBatch(horizon, now, deltas)
+	if err != nil {
+		return err
+	}
+	_, err = d.wal.Write(data)
+	if err == nil && !d.nosync {
+		err = d.wal.Sync()
+	}
+	if err != nil {
+		if err2 := d.wal.Truncate(d.size); err2 != nil {
+			clog.Errorf("memstore: cannot truncate the log after a failed write: %v", err2)
+		}
+		return err
+	}
+	d.size += int64(len(data))
+	d.batches++
+	return nil
+}
+
+// maybeSnapshot makes a new snapshot after a given number of batches were written to the log.
+// The batches are already in the log, thus an error is only reported, and the snapshot is retried
+// after the next batch.
+func (d *durable) maybeSnapshot(qs *QuadStore) {
+	if d.interval <= 0 || d.batches < d.interval {
+		return
+	}
+	if err := d.snapshot(qs); err != nil {
+		clog.Errorf("memstore: cannot write a snapshot: %v", err)
+	}
+}
+
+// snapshot writes a new snapshot and starts a new log.
+func (d *durable) snapshot(qs *QuadStore) error {
+	if err := qs.writeSnapshot(d.dir); err != nil {
+		return err
+	}
+	// batches from the old log are skipped on replay, if the process stops before the log is truncated
+	if err := d.wal.Truncate(0); err != nil {
+		return err
+	}
+	d.size = 0
+	d.batches = 0
+	return nil
+}
+
+// close writes a final snapshot and closes the log.
+func (d *durable) close(qs *QuadStore) error {
+	var err error
+	if d.batches != 0 {
+		err = d.snapshot(qs)
+	}
+	if err2 := d.wal.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -19,36 +19,60 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sync"
 
 	"github.com/cayleygraph/cayley/graph"
 	"github.com/cayleygraph/cayley/graph/iterator"
-	"github.com/cayleygraph/cayley/graph/refs"
 	"github.com/cayleygraph/quad"
 )
 
+// latest is used instead of the version of the store by iterators that read the latest version.
+const latest = -1
+
+// Iterator iterates over quads with a given node in a given direction.
+//
+// Each scanner and index created from the iterator reads the version of the store that was the latest one
+// when it was created, or a fixed version of a snapshot, thus it's not affected by concurrent writes.
 type Iterator struct {
 	qs    *QuadStore
-	tree  *Tree
 	d     quad.Direction
 	value int64
+	ver   int64
 }
 
-func (qs *QuadStore) newIterator(tree *Tree, d quad.Direction, value int64) *Iterator {
+func (qs *QuadStore) newIterator(d quad.Direction, value int64, ver int64) *Iterator {
 	return &Iterator{
 		qs:    qs,
-		tree:  tree,
 		d:     d,
 		value: value,
+		ver:   ver,
 	}
 }
 
 func (it *Iterator) Iterate() iterator.Scanner {
 	// TODO(dennwc): it doesn't check the direction and value, while Contains does; is it expected?
-	return it.qs.newIteratorNext(it.tree, it.d)
+	ver, release := it.qs.readVersion(it.ver)
+	return it.qs.newIteratorNext(it.d, it.value, ver, release)
 }
 
 func (it *Iterator) Lookup() iterator.Index {
-	return it.qs.newIteratorContains(it.tree, it.d, it.value)
+	ver, release := it.qs.readVersion(it.ver)
+	return it.qs.newIteratorContains(it.d, it.value, ver, release)
+}
+
+// readVersion returns a version that should be read by an iterator. For the latest version, primitives
+// of the version are kept until the returned function is called.
+func (qs *QuadStore) readVersion(ver int64) (int64, func()) {
+	if ver != latest {
+		return ver, func() {}
+	}
+	qs.mu.RLock()
+	ver = qs.acquire()
+	qs.mu.RUnlock()
+	var once sync.Once
+	return ver, func() {
+		once.Do(func() { qs.release(ver) })
+	}
 }
 
 func (it *Iterator) SubIterators() []iterator.Shape {
@@ -66,47 +90,70 @@ func (it *Iterator) Optimize(ctx context.Context) (iterator.Shape, bool) {
 }
 
 func (it *Iterator) Stats(ctx context.Context) (iterator.Costs, error) {
+	sz, err := it.qs.QuadIteratorSize(ctx, it.d, bnode(it.value))
+	if err != nil {
+		return iterator.Costs{}, err
+	}
 	return iterator.Costs{
-		ContainsCost: int64(math.Log(float64(it.tree.Len()))) + 1,
+		ContainsCost: int64(math.Log(float64(sz.Value))) + 1,
 		NextCost:     1,
-		Size: refs.Size{
-			Value: int64(it.tree.Len()),
-			Exact: true,
-		},
+		Size:         sz,
 	}, nil
 }
 
 type iteratorNext struct {
-	nodes bool
-	qs    *QuadStore
-	tree  *Tree
-	d     quad.Direction
+	nodes   bool
+	qs      *QuadStore
+	d       quad.Direction
+	value   int64
+	ver     int64
+	release func()
 
 	iter *Enumerator
 	cur  *Primitive
 	err  error
+	done bool
 }
 
-func (qs *QuadStore) newIteratorNext(tree *Tree, d quad.Direction) *iteratorNext {
+func (qs *QuadStore) newIteratorNext(d quad.Direction, value int64, ver int64, release func()) *iteratorNext {
 	return &iteratorNext{
-		nodes: d == 0,
-		d:     d,
-		qs:    qs,
-		tree:  tree,
+		nodes:   d == 0,
+		d:       d,
+		value:   value,
+		qs:      qs,
+		ver:     ver,
+		release: release,
 	}
 }
 
 func (it *iteratorNext) TagResults(dst map[string]graph.Ref) {}
 
 func (it *iteratorNext) Close() error {
+	it.done = true
+	it.cur = nil
+	it.release()
 	return nil
 }
 
 func (it *iteratorNext) Next(ctx context.Context) bool {
+	it.cur = nil
+	if it.done {
+		return false
+	}
+	// the tree is modified by writers, thus the lock is held for each step;
+	// the enumerator continues from the last key after the tree was modified
+	it.qs.mu.RLock()
+	defer it.qs.mu.RUnlock()
 	if it.iter == nil {
-		it.iter, it.err = it.tree.SeekFirst()
+		tree, ok := it.qs.index.Get(it.d, it.value)
+		if !ok {
+			it.done = true
+			return false
+		}
+		it.iter, it.err = tree.SeekFirst()
 		if it.err == io.EOF || it.iter == nil {
 			it.err = nil
+			it.done = true
 			return false
 		} else if it.err != nil {
 			return false
@@ -118,7 +165,10 @@ func (it *iteratorNext) Next(ctx context.Context) bool {
 			if err != io.EOF {
 				it.err = err
 			}
+			it.done = true
 			return false
+		} else if !p.visible(it.ver) || p.expired() {
+			continue
 		}
 		it.cur = p
 		return true
@@ -147,9 +197,10 @@ func (it *iteratorNext) String() string {
 func (it *iteratorNext) Sorted() bool { return true }
 
 type iteratorContains struct {
-	nodes bool
-	qs    *QuadStore
-	tree  *Tree
+	nodes   bool
+	qs      *QuadStore
+	ver     int64
+	release func()
 
 	cur *Primitive
 
@@ -157,19 +208,22 @@ type iteratorContains struct {
 	value int64
 }
 
-func (qs *QuadStore) newIteratorContains(tree *Tree, d quad.Direction, value int64) *iteratorContains {
+func (qs *QuadStore) newIteratorContains(d quad.Direction, value int64, ver int64, release func()) *iteratorContains {
 	return &iteratorContains{
-		nodes: d == 0,
-		qs:    qs,
-		tree:  tree,
-		d:     d,
-		value: value,
+		nodes:   d == 0,
+		qs:      qs,
+		ver:     ver,
+		release: release,
+		d:       d,
+		value:   value,
 	}
 }
 
 func (it *iteratorContains) TagResults(dst map[string]graph.Ref) {}
 
 func (it *iteratorContains) Close() error {
+	it.cur = nil
+	it.release()
 	return nil
 }
 
@@ -192,17 +246,18 @@ func (it *iteratorContains) Contains(ctx context.Context, v graph.Ref) bool {
 	if v == nil {
 		return false
 	}
+	var p *Primitive
 	switch v := v.(type) {
 	case bnode:
-		if p, ok := it.tree.Get(int64(v)); ok {
-			it.cur = p
-			return true
-		}
+		it.qs.mu.RLock()
+		p = it.qs.primitive(int64(v))
+		it.qs.mu.RUnlock()
 	case qprim:
-		if v.p.Quad.Dir(it.d) == it.value {
-			it.cur = v.p
-			return true
-		}
+		p = v.p
+	}
+	if p != nil && p.Quad.Dir(it.d) == it.value && p.visible(it.ver) && !p.expired() {
+		it.cur = p
+		return true
 	}
 	return false
 }
@@ -0,0 +1,165 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+)
+
+var _ graph.LabelManager = (*QuadStore)(nil)
+
+// ListLabels implements graph.LabelManager.
+func (qs *QuadStore) ListLabels(ctx context.Context) ([]quad.Value, error) {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	var labels []quad.Value
+	for id, tree := range qs.index.index[quad.Label-1] {
+		if hasAlive(tree) {
+			labels = append(labels, qs.lookupVal(id))
+		}
+	}
+	graph.SortLabels(labels)
+	return labels, nil
+}
+
+// DropLabel implements graph.LabelManager.
+func (qs *QuadStore) DropLabel(ctx context.Context, label quad.Value) error {
+	return qs.relabel(label, nil, false, true)
+}
+
+// CopyLabel implements graph.LabelManager.
+func (qs *QuadStore) CopyLabel(ctx context.Context, from, to quad.Value) error {
+	return qs.relabel(from, to, true, false)
+}
+
+// MoveLabel implements graph.LabelManager.
+func (qs *QuadStore) MoveLabel(ctx context.Context, from, to quad.Value) error {
+	return qs.relabel(from, to, true, true)
+}
+
+// hasAlive checks if the tree contains quads that were not deleted.
+func hasAlive(tree *Tree) bool {
+	if tree.Len() == 0 {
+		return false
+	}
+	e, err := tree.SeekFirst()
+	if err != nil {
+		return false // only io.EOF is possible
+	}
+	defer e.Close()
+	for {
+		_, p, err := e.Next()
+		if err != nil {
+			return false
+		} else if !p.isDeleted() {
+			return true
+		}
+	}
+}
+
+// labelQuads returns all quad primitives of a graph. Nil label refers to the default graph.
+func (qs *QuadStore) labelQuads(label quad.Value) []*Primitive {
+	var out []*Primitive
+	if label == nil {
+		for _, p := range qs.all {
+			if !p.Quad.Zero() && p.Quad.L == 0 && !p.isDeleted() {
+				out = append(out, p)
+			}
+		}
+		return out
+	}
+	id, ok := qs.resolveVal(label, false)
+	if !ok {
+		return nil
+	}
+	tree, ok := qs.index.Get(quad.Label, id)
+	if !ok || tree.Len() == 0 {
+		return nil
+	}
+	out = make([]*Primitive, 0, tree.Len())
+	e, err := tree.SeekFirst()
+	if err != nil {
+		return nil
+	}
+	defer e.Close()
+	for {
+		_, p, err := e.Next()
+		if err != nil {
+			break // only io.EOF is possible
+		} else if !p.isDeleted() {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// relabel copies quads of one graph to another one and/or removes them from the source graph.
+// All changes are recorded as a single batch in the change feed.
+func (qs *QuadStore) relabel(from, to quad.Value, add, del bool) error {
+	qs.lock()
+	defer qs.unlock()
+	if add && refs.HashOf(from) == refs.HashOf(to) {
+		return nil // same graph
+	}
+	prims := qs.labelQuads(from)
+	if len(prims) == 0 {
+		return nil
+	}
+	var deltas []graph.Delta
+	if add {
+		for _, p := range prims {
+			q := qs.lookupQuadDirs(p.Quad)
+			q.Label = to
+			if qs.hasQuad(q) {
+				continue // already in the destination graph
+			}
+			d := graph.Delta{Quad: q, Action: graph.Add}
+			if p.expires != 0 {
+				// copies expire together with the source quads
+				d.Expires = time.Unix(0, p.expires)
+			}
+			deltas = append(deltas, d)
+		}
+	}
+	if del {
+		for _, p := range prims {
+			deltas = append(deltas, graph.Delta{Quad: qs.lookupQuadDirs(p.Quad), Action: graph.Delete})
+		}
+	}
+	now, err := qs.writeAhead(deltas)
+	if err != nil {
+		return err
+	}
+	var applied []graph.Delta
+	for _, d := range deltas {
+		switch d.Action {
+		case graph.Add:
+			if _, ok := qs.addQuadExpiring(d.Quad, d.Expires, now); ok {
+				applied = append(applied, d)
+			}
+		case graph.Delete:
+			if id, _, ok := qs.findQuad(d.Quad); ok && qs.delete(id) {
+				applied = append(applied, d)
+			}
+		}
+	}
+	qs.commit(applied)
+	return nil
+}
@@ -17,8 +17,12 @@ package memstore
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cayleygraph/cayley/graph"
 	"github.com/cayleygraph/cayley/graph/iterator"
@@ -30,12 +34,42 @@ const QuadStoreType = "memstore"
 
 func init() {
 	graph.RegisterQuadStore(QuadStoreType, graph.QuadStoreRegistration{
-		NewFunc: func(string, graph.Options) (graph.QuadStore, error) {
-			return newQuadStore(), nil
+		NewFunc: func(path string, opt graph.Options) (graph.QuadStore, error) {
+			persist, err := opt.BoolKey(OptPersist, false)
+			if err != nil {
+				return nil, err
+			} else if path == "" {
+				if persist {
+					return nil, errNoDirectory
+				}
+				qs := newQuadStore()
+				if err := qs.setOptions(opt); err != nil {
+					return nil, err
+				}
+				return qs, nil
+			} else if !persist {
+				// a quad file that should be loaded into an in-memory store
+				return nil, graph.ErrQuadStoreNotPersistent
+			}
+			return Open(path, opt)
+		},
+		UpgradeFunc: nil,
+		InitFunc: func(path string, opt graph.Options) error {
+			persist, err := opt.BoolKey(OptPersist, false)
+			if err != nil {
+				return err
+			} else if path == "" {
+				if persist {
+					return errNoDirectory
+				}
+				return nil // in-memory only
+			} else if !persist {
+				return graph.ErrQuadStoreNotPersistent
+			}
+			return Init(path)
 		},
-		UpgradeFunc:  nil,
-		InitFunc:     nil,
-		IsPersistent: false,
+		// the store is persistent only with the persist option, see Open
+		IsPersistent: true,
 	})
 }
 
@@ -96,6 +130,38 @@ type Primitive struct {
 	Quad  internalQuad
 	Value quad.Value
 	refs  int
+	// expires is an expiration time of the quad in Unix nanoseconds, or zero if it never expires.
+	// It never changes: a new primitive replaces the quad when its expiration time is updated.
+	expires int64
+	// added is the version of the store that added the primitive.
+	added int64
+	// deleted is the version of the store that deleted the primitive, or zero if it's alive.
+	// It's accessed atomically, since readers check it without holding the lock.
+	deleted int64
+}
+
+// visible checks if the primitive exists in a given version of the store.
+func (p *Primitive) visible(ver int64) bool {
+	if p.added > ver {
+		return false
+	}
+	d := atomic.LoadInt64(&p.deleted)
+	return d == 0 || d > ver
+}
+
+// isDeleted checks if the primitive was deleted from the latest version of the store.
+func (p *Primitive) isDeleted() bool {
+	return atomic.LoadInt64(&p.deleted) != 0
+}
+
+// expiredAt checks if the quad is expired at a given time (in Unix nanoseconds).
+func (p *Primitive) expiredAt(now int64) bool {
+	return p.expires != 0 && p.expires <= now
+}
+
+// expired checks if the quad is expired now.
+func (p *Primitive) expired() bool {
+	return p.expires != 0 && p.expires <= time.Now().UnixNano()
 }
 
 type internalQuad struct {
@@ -135,17 +201,50 @@ func (q internalQuad) Dir(d quad.Direction) int64 {
 	return n
 }
 
+// QuadStore is an in-memory quad store.
+//
+// Primitives are versioned: each write batch increments the version of the store, and primitives record
+// versions that added and deleted them. Iterators read a fixed version of the store, thus they never see
+// a partially applied batch. Deleted primitives are kept in the index trees and in the "all" slice until
+// no open iterator or snapshot can see them, and are purged by the next write after that.
 type QuadStore struct {
+	// mu is held by writers for the whole batch, and by readers for each lookup or iteration step.
+	mu sync.RWMutex
+
 	last int64 // 累加的id数?
 	// TODO: string -> quad.Value once Raw -> typed resolution is unnecessary
 
-	vals    map[string]int64
-	quads   map[internalQuad]int64
-	prim    map[int64]*Primitive
-	all     []*Primitive // might not be sorted by id
-	reading bool         // someone else might be reading "all" slice - next insert/delete should clone it
+	vals  map[string]int64
+	quads map[internalQuad]int64
+	prim  map[int64]*Primitive
+	// all contains all primitives, including deleted ones that were not yet purged; it might not be
+	// sorted by id. Elements of the slice are never modified, thus readers can use it without the lock.
+	all     []*Primitive
 	index   QuadDirectionIndex
 	horizon int64 // used only to assign ids to tx
+
+	// version is the version of the last write batch
+	version int64
+	// dead contains deleted primitives that were not yet purged
+	dead map[int64]*Primitive
+	// readers counts open readers for each version of the store
+	readers struct {
+		sync.Mutex
+		vers map[int64]int
+	}
+
+	// expiring contains all quads with an expiration time, including expired ones that were not yet deleted
+	expiring map[int64]*Primitive
+
+	changes struct {
+		sync.Mutex
+		log   []graph.Change
+		start int64 // position of the last batch removed from the log
+		limit int   // maximal number of batches in the log; zero means no limit
+		graph.ChangeNotifier
+	}
+	// durable is set for stores that persist their state to disk, see Open
+	durable *durable
 	// vip_index map[string]map[int64]map[string]map[int64]*b.Tree
 }
 
@@ -159,17 +258,102 @@ func New(quads ...quad.Quad) *QuadStore {
 }
 
 func newQuadStore() *QuadStore {
-	return &QuadStore{
-		vals:  make(map[string]int64),
-		quads: make(map[internalQuad]int64),
-		prim:  make(map[int64]*Primitive),
-		index: NewQuadDirectionIndex(),
+	qs := &QuadStore{
+		vals:     make(map[string]int64),
+		quads:    make(map[internalQuad]int64),
+		prim:     make(map[int64]*Primitive),
+		index:    NewQuadDirectionIndex(),
+		dead:     make(map[int64]*Primitive),
+		expiring: make(map[int64]*Primitive),
 	}
+	qs.readers.vers = make(map[int64]int)
+	qs.changes.limit = defaultChangesLimit
+	return qs
 }
 
-func (qs *QuadStore) cloneAll() []*Primitive {
-	qs.reading = true // 设置reading开关
-	return qs.all
+// lock starts a write batch.
+func (qs *QuadStore) lock() {
+	qs.mu.Lock()
+}
+
+// unlock finishes a write batch: it makes the new version visible to readers and purges deleted
+// primitives that are no longer visible to any of them.
+func (qs *QuadStore) unlock() {
+	qs.version++
+	qs.purge()
+	qs.mu.Unlock()
+}
+
+// acquire returns the latest version of the store and keeps primitives of this version until release
+// is called. The caller must hold the read lock.
+func (qs *QuadStore) acquire() int64 {
+	ver := qs.version
+	qs.readers.Lock()
+	qs.readers.vers[ver]++
+	qs.readers.Unlock()
+	return ver
+}
+
+// release allows to purge primitives of a given version that were deleted later.
+func (qs *QuadStore) release(ver int64) {
+	qs.readers.Lock()
+	if qs.readers.vers[ver]--; qs.readers.vers[ver] <= 0 {
+		delete(qs.readers.vers, ver)
+	}
+	qs.readers.Unlock()
+}
+
+// purge removes deleted primitives that are not visible to any reader from indexes.
+// The caller must hold the write lock.
+func (qs *QuadStore) purge() {
+	if len(qs.dead) == 0 {
+		return
+	}
+	qs.readers.Lock()
+	vers := make([]int64, 0, len(qs.readers.vers))
+	for ver := range qs.readers.vers {
+		vers = append(vers, ver)
+	}
+	qs.readers.Unlock()
+	inUse := func(p *Primitive) bool {
+		for _, ver := range vers {
+			if p.visible(ver) {
+				return true
+			}
+		}
+		return false
+	}
+	n := 0
+	for id, p := range qs.dead {
+		if !inUse(p) {
+			for dir := quad.Subject; dir <= quad.Label; dir++ {
+				if t, ok := qs.index.Get(dir, p.Quad.Dir(dir)); ok {
+					t.Delete(id)
+				}
+			}
+			delete(qs.dead, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	// readers might use the old slice, thus a new one is allocated
+	all := make([]*Primitive, 0, len(qs.all)-n)
+	for _, p := range qs.all {
+		if !p.isDeleted() || inUse(p) {
+			all = append(all, p)
+		}
+	}
+	qs.all = all
+}
+
+// primitive returns a primitive with a given id, including deleted primitives that were not yet purged.
+func (qs *QuadStore) primitive(id int64) *Primitive {
+	if p := qs.prim[id]; p != nil {
+		return p
+	}
+	return qs.dead[id]
 }
 
 func (qs *QuadStore) addPrimitive(p *Primitive) int64 {
@@ -182,14 +366,10 @@ func (qs *QuadStore) addPrimitive(p *Primitive) int64 {
 }
 
 func (qs *QuadStore) appendPrimitive(p *Primitive) {
+	p.added = qs.version + 1
 	qs.prim[p.ID] = p
-	if !qs.reading { // 没有人在读，直接修改
-		qs.all = append(qs.all, p)
-	} else {
-		n := len(qs.all)
-		qs.all = append(qs.all[:n:n], p) // reallocate slice，重新分配切片
-		qs.reading = false               // this is a new slice
-	}
+	// readers never look past the length of the slice they got, thus it's safe to append in place
+	qs.all = append(qs.all, p)
 }
 
 const internalBNodePrefix = "memnode"
@@ -246,9 +426,13 @@ func (qs *QuadStore) resolveQuad(q quad.Quad, add bool) (internalQuad, bool) {
 }
 
 // 查找qs.prim（通过id查找)
+// lookupVal returns a value of the node. Blank nodes without a value get an internal name,
+// while nil is returned for nodes that were purged.
 func (qs *QuadStore) lookupVal(id int64) quad.Value {
-	pv := qs.prim[id]
-	if pv == nil || pv.Value == nil {
+	pv := qs.primitive(id)
+	if pv == nil {
+		return nil
+	} else if pv.Value == nil {
 		return quad.BNode(internalBNodePrefix + strconv.FormatInt(id, 10))
 	}
 	return pv.Value
@@ -271,13 +455,17 @@ func (qs *QuadStore) lookupQuadDirs(p internalQuad) quad.Quad {
 // AddNode adds a blank node (with no value) to quad store. It returns an id of the node.
 // 往图中添加一个空节点
 func (qs *QuadStore) AddBNode() int64 {
-	return qs.addPrimitive(&Primitive{})  // 插入空节点，qs.last++
+	qs.lock()
+	defer qs.unlock()
+	return qs.addPrimitive(&Primitive{}) // 插入空节点，qs.last++
 }
 
 // AddNode adds a value to quad store. It returns an id of the value.
 // False is returned as a second parameter if value exists already.
 // 往图中追加一个值（其实相当于插入一个点，但是如果这个点已经存在，则返回）
 func (qs *QuadStore) AddValue(v quad.Value) (int64, bool) {
+	qs.lock()
+	defer qs.unlock()
 	id, exists := qs.resolveVal(v, true)
 	return id, !exists
 }
@@ -299,15 +487,44 @@ func (qs *QuadStore) indexesForQuad(q internalQuad) []*Tree {
 // False is returned as a second parameter if quad exists already.
 // 把quad.Quad加入到QuadStore中，最多有可能往4棵B+树上添加节点
 func (qs *QuadStore) AddQuad(q quad.Quad) (int64, bool) {
+	qs.lock()
+	defer qs.unlock()
+	return qs.addQuad(q, time.Now().UnixNano())
+}
+
+// addQuad adds a quad that never expires. See addQuadExpiring for the meaning of now.
+func (qs *QuadStore) addQuad(q quad.Quad, now int64) (int64, bool) {
+	return qs.addQuadExpiring(q, time.Time{}, now)
+}
+
+// addQuadExpiring adds a quad that expires at a given time, or never expires if the time is zero.
+// An existing quad is replaced if it has already expired, or if the expiration time differs, thus adding
+// a quad that never expires over an expiring one clears the expiration time.
+// Expiration is checked at the time of the batch (in Unix nanoseconds), see writeAhead.
+func (qs *QuadStore) addQuadExpiring(q quad.Quad, expires time.Time, now int64) (int64, bool) {
+	var exp int64
+	if !expires.IsZero() {
+		exp = expires.UnixNano()
+	}
 	// quad.Quad -> internalQuad
 	p, _ := qs.resolveQuad(q, false)
-	if id := qs.quads[p]; id != 0 {
-		return id, false
+	old := qs.quads[p]
+	if old != 0 {
+		if op := qs.prim[old]; !op.expiredAt(now) && op.expires == exp {
+			return old, false
+		}
 	}
+	// references to nodes are taken before the old quad is deleted, thus nodes are kept
 	p, _ = qs.resolveQuad(q, true)
-	pr := &Primitive{Quad: p}
+	if old != 0 {
+		qs.delete(old)
+	}
+	pr := &Primitive{Quad: p, expires: exp}
 	id := qs.addPrimitive(pr)
 	qs.quads[p] = id
+	if exp != 0 {
+		qs.expiring[id] = pr
+	}
 	for _, t := range qs.indexesForQuad(p) {
 		t.Set(id, pr) // B+树操作，把q这个点添加到B+树上
 	}
@@ -319,17 +536,14 @@ func (qs *QuadStore) AddQuad(q quad.Quad) (int64, bool) {
 //
 // Deprecated: use AddQuad instead.
 func (qs *QuadStore) WriteQuad(q quad.Quad) error {
-	qs.AddQuad(q)
-	return nil
+	_, err := qs.WriteQuads([]quad.Quad{q})
+	return err
 }
 
-// WriteQuads implements quad.Writer.
+// WriteQuads implements quad.Writer. Quads are added as a single batch, the same way as with NewQuadWriter.
 // 批量写入点
 func (qs *QuadStore) WriteQuads(buf []quad.Quad) (int, error) {
-	for _, q := range buf {
-		qs.AddQuad(q)
-	}
-	return len(buf), nil
+	return (&quadWriter{qs: qs}).WriteQuads(buf)
 }
 
 // wrapper装饰器模式
@@ -342,14 +556,28 @@ type quadWriter struct {
 }
 
 func (w *quadWriter) WriteQuad(q quad.Quad) error {
-	w.qs.AddQuad(q)
-	return nil
+	_, err := w.WriteQuads([]quad.Quad{q})
+	return err
 }
 
 func (w *quadWriter) WriteQuads(buf []quad.Quad) (int, error) {
+	w.qs.lock()
+	defer w.qs.unlock()
+	deltas := make([]graph.Delta, 0, len(buf))
 	for _, q := range buf {
-		w.qs.AddQuad(q)
+		deltas = append(deltas, graph.Delta{Quad: q, Action: graph.Add})
+	}
+	now, err := w.qs.writeAhead(deltas)
+	if err != nil {
+		return 0, err
+	}
+	var added []graph.Delta
+	for _, d := range deltas {
+		if _, ok := w.qs.addQuad(d.Quad, now); ok {
+			added = append(added, d)
+		}
 	}
+	w.qs.commit(added)
 	return len(buf), nil
 }
 
@@ -371,12 +599,20 @@ func (qs *QuadStore) deleteQuadNodes(q internalQuad) {
 				panic("remove of deleted node")
 			} else if p.refs == 0 {
 				// 引用计数为0，删除该点
-				qs.Delete(id)
+				qs.delete(id)
 			}
 		}
 	}
 }
+
+// Delete removes a primitive with a given id from the quad store.
 func (qs *QuadStore) Delete(id int64) bool {
+	qs.lock()
+	defer qs.unlock()
+	return qs.delete(id)
+}
+
+func (qs *QuadStore) delete(id int64) bool {
 	p := qs.prim[id]
 	if p == nil {
 		return false
@@ -386,36 +622,15 @@ func (qs *QuadStore) Delete(id int64) bool {
 		// 删除对应的vals字典
 		delete(qs.vals, p.Value.String())
 	}
-	// remove from quad indexes
-	// 在对应的B+树上面进行删除
-	for _, t := range qs.indexesForQuad(p.Quad) {
-		t.Delete(id)
-	}
 	// 在对应的字典上面进行删除
-	delete(qs.quads, p.Quad)
-	// remove primitive
-	delete(qs.prim, id)
-	di := -1
-	// 在all列表中删除对应的节点
-	for i, p2 := range qs.all {
-		if p == p2 {
-			di = i
-			break
-		}
-	}
-	if di >= 0 {
-		// 没有人在读，直接append去挪动切片
-		if !qs.reading {
-			qs.all = append(qs.all[:di], qs.all[di+1:]...)
-		} else {
-			// 有的话，另外拷贝新的切片
-			all := make([]*Primitive, 0, len(qs.all)-1)
-			all = append(all, qs.all[:di]...)
-			all = append(all, qs.all[di+1:]...)
-			qs.all = all
-			qs.reading = false // this is a new slice
-		}
+	if !p.Quad.Zero() {
+		delete(qs.quads, p.Quad)
 	}
+	// remove primitive; it's kept in quad indexes and in the "all" slice until it's purged
+	delete(qs.prim, id)
+	delete(qs.expiring, id)
+	atomic.StoreInt64(&p.deleted, qs.version+1)
+	qs.dead[id] = p
 	// 把它的对应关系进行删除（引用计数变为0）
 	qs.deleteQuadNodes(p.Quad)
 	return true
@@ -430,49 +645,263 @@ func (qs *QuadStore) findQuad(q quad.Quad) (int64, internalQuad, bool) {
 	return id, p, id != 0
 }
 
+// hasQuad checks if a quad exists and is not expired.
+func (qs *QuadStore) hasQuad(q quad.Quad) bool {
+	id, _, ok := qs.findQuad(q)
+	return ok && !qs.prim[id].expired()
+}
+
+var _ graph.QuadChecker = (*QuadStore)(nil)
+
+// HasQuad implements graph.QuadChecker.
+func (qs *QuadStore) HasQuad(ctx context.Context, q quad.Quad) (bool, error) {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	return qs.hasQuad(q), nil
+}
+
 func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
-	// Precheck the whole transaction (if required)
+	qs.lock()
+	defer qs.unlock()
+	return qs.applyDeltas(deltas, ignoreOpts)
+}
+
+func (qs *QuadStore) applyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
+	// Precheck the whole transaction, thus it's either applied completely or not applied at all
 	// 不能接受重复或者不能接受丢失
-	if !ignoreOpts.IgnoreDup || !ignoreOpts.IgnoreMissing {
-		for _, d := range deltas {
-			switch d.Action {
-			case graph.Add: // 添加操作
-				if !ignoreOpts.IgnoreDup {
-					if _, _, ok := qs.findQuad(d.Quad); ok {
-						return &graph.DeltaError{Delta: d, Err: graph.ErrQuadExists}
-					}
-				}
-			case graph.Delete: // 删除操作
-				if !ignoreOpts.IgnoreMissing {
-					if _, _, ok := qs.findQuad(d.Quad); !ok {
-						return &graph.DeltaError{Delta: d, Err: graph.ErrQuadNotExist}
-					}
+	for _, d := range deltas {
+		switch d.Action {
+		case graph.Add: // 添加操作
+			// adding a quad with an expiration time updates the existing quad,
+			// and adding it without one over an expiring quad clears the expiration time
+			if !ignoreOpts.IgnoreDup && d.Expires.IsZero() {
+				if id, _, ok := qs.findQuad(d.Quad); ok && qs.prim[id].expires == 0 {
+					return &graph.DeltaError{Delta: d, Err: graph.ErrQuadExists}
 				}
-			default:
-				return &graph.DeltaError{Delta: d, Err: graph.ErrInvalidAction}
 			}
+		case graph.Delete: // 删除操作
+			if !ignoreOpts.IgnoreMissing && !qs.hasQuad(d.Quad) {
+				return &graph.DeltaError{Delta: d, Err: graph.ErrQuadNotExist}
+			}
+		default:
+			return &graph.DeltaError{Delta: d, Err: graph.ErrInvalidAction}
 		}
 	}
+	now, err := qs.writeAhead(deltas)
+	if err != nil {
+		return err
+	}
 
 	// 事务操作（本质就是一个batch操作）-- start
+	var applied []graph.Delta
 	for _, d := range deltas {
 		switch d.Action {
 		case graph.Add:
-			qs.AddQuad(d.Quad)
+			if _, ok := qs.addQuadExpiring(d.Quad, d.Expires, now); ok {
+				applied = append(applied, d)
+			}
 		case graph.Delete:
+			// expired quads are deleted as well
 			if id, _, ok := qs.findQuad(d.Quad); ok {
-				qs.Delete(id)
+				qs.delete(id)
+				applied = append(applied, d)
 			}
-		default:
-			// TODO: ideally we should rollback it
-			return &graph.DeltaError{Delta: d, Err: graph.ErrInvalidAction}
 		}
 	}
 	// 事务操作（本质就是一个batch操作）-- end
-	qs.horizon++ // used only to assign ids to tx
+	qs.commit(applied)
+	return nil
+}
+
+var (
+	_ graph.ConditionalWriter = (*QuadStore)(nil)
+	_ graph.Versioner         = (*QuadStore)(nil)
+)
+
+// Version implements graph.Versioner. The version is the position of the last transaction in the change feed.
+func (qs *QuadStore) Version(ctx context.Context) (int64, error) {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	return qs.horizon, nil
+}
+
+// ApplyDeltasIf implements graph.ConditionalWriter.
+func (qs *QuadStore) ApplyDeltasIf(conds []graph.Precondition, deltas []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
+	qs.lock()
+	defer qs.unlock()
+	for _, c := range conds {
+		if err := qs.checkPrecondition(c); err != nil {
+			return err
+		}
+	}
+	return qs.applyDeltas(deltas, ignoreOpts)
+}
+
+// checkPrecondition returns a PreconditionError if the precondition does not hold.
+func (qs *QuadStore) checkPrecondition(c graph.Precondition) error {
+	var ok bool
+	switch c := c.(type) {
+	case graph.QuadExists:
+		ok = qs.hasQuad(c.Quad)
+	case graph.QuadNotExists:
+		ok = !qs.hasQuad(c.Quad)
+	case graph.NoLinks:
+		if c.Dir < quad.Subject || c.Dir > quad.Label {
+			return &graph.PreconditionError{Precondition: c, Err: fmt.Errorf("invalid direction: %v", c.Dir)}
+		}
+		ok = !qs.hasLinks(c.Node, c.Dir, c.Via)
+	case graph.VersionEquals:
+		ok = qs.horizon == c.Version
+	default:
+		return &graph.PreconditionError{Precondition: c, Err: graph.ErrOperationNotSupported}
+	}
+	if !ok {
+		return &graph.PreconditionError{Precondition: c, Err: graph.ErrPreconditionFailed}
+	}
 	return nil
 }
 
+// hasLinks checks if a node has any quads that are not expired in a given direction, optionally
+// with a given predicate.
+func (qs *QuadStore) hasLinks(node quad.Value, d quad.Direction, via quad.Value) bool {
+	id, ok := qs.resolveVal(node, false)
+	if !ok {
+		return false
+	}
+	var pid int64
+	if via != nil {
+		if pid, ok = qs.resolveVal(via, false); !ok {
+			return false
+		}
+	}
+	tree, ok := qs.index.Get(d, id)
+	if !ok {
+		return false
+	}
+	e, err := tree.SeekFirst()
+	if err != nil {
+		return false // only io.EOF is possible
+	}
+	defer e.Close()
+	for {
+		_, p, err := e.Next()
+		if err != nil {
+			return false
+		} else if (via == nil || p.Quad.P == pid) && !p.isDeleted() && !p.expired() {
+			return true
+		}
+	}
+}
+
+// writeAhead writes deltas of the next batch to the log of a durable store before they are applied,
+// thus the store is not modified if the write fails. Deltas that turn out to be no-ops are logged
+// as well, since the replay skips them in the same way.
+//
+// It returns the time of the batch in Unix nanoseconds, which is logged with it. The batch must check
+// expiration of quads at this time, so the replay gives the same result.
+func (qs *QuadStore) writeAhead(deltas []graph.Delta) (int64, error) {
+	now := time.Now().UnixNano()
+	if qs.durable == nil || len(deltas) == 0 {
+		return now, nil
+	}
+	return now, qs.durable.logBatch(qs.horizon+1, now, deltas)
+}
+
+// commit finishes a batch of writes: it records applied deltas in the change feed
+// and makes a new snapshot of a durable store if necessary.
+func (qs *QuadStore) commit(applied []graph.Delta) {
+	qs.horizon++ // used only to assign ids to tx
+	qs.logChange(applied)
+	if qs.durable != nil {
+		qs.durable.maybeSnapshot(qs)
+	}
+}
+
+// logChange records deltas applied by the last transaction in the change feed.
+// The oldest batches are removed from the feed if it grows over the limit.
+func (qs *QuadStore) logChange(deltas []graph.Delta) {
+	if len(deltas) == 0 {
+		return
+	}
+	qs.changes.Lock()
+	log := append(qs.changes.log, graph.Change{
+		Position: qs.horizon,
+		Time:     time.Now(),
+		Deltas:   deltas,
+	})
+	if n := len(log) - qs.changes.limit; qs.changes.limit > 0 && n > 0 {
+		qs.changes.start = log[n-1].Position
+		// readers hold sub-slices of the log, thus removed batches are not cleared
+		log = log[n:]
+	}
+	qs.changes.log = log
+	qs.changes.Unlock()
+	qs.changes.Notify()
+}
+
+var _ graph.Expirer = (*QuadStore)(nil)
+
+// ExpireQuads implements graph.Expirer.
+//
+// Expired quads are deleted in a single batch, which is recorded in the change feed
+// and in the log of a durable store.
+func (qs *QuadStore) ExpireQuads(ctx context.Context, now time.Time) ([]quad.Quad, error) {
+	qs.lock()
+	defer qs.unlock()
+	var (
+		deltas []graph.Delta
+		quads  []quad.Quad
+	)
+	t := now.UnixNano()
+	for _, p := range qs.expiring {
+		if p.expiredAt(t) {
+			q := qs.lookupQuadDirs(p.Quad)
+			deltas = append(deltas, graph.Delta{Quad: q, Action: graph.Delete})
+			quads = append(quads, q)
+		}
+	}
+	if len(deltas) == 0 {
+		return nil, nil
+	}
+	if err := qs.applyDeltas(deltas, graph.IgnoreOpts{IgnoreMissing: true}); err != nil {
+		return nil, err
+	}
+	return quads, nil
+}
+
+var _ graph.Watcher = (*QuadStore)(nil)
+
+// Watch implements graph.Watcher.
+//
+// The change feed keeps a limited number of the last batches in memory (see OptChangesLimit).
+// Feeds that start before the oldest of them fail with graph.ErrChangesCompacted, as well as feeds
+// that start before the store was loaded from disk. Quads added directly with AddQuad are not included.
+func (qs *QuadStore) Watch(ctx context.Context, from int64) (graph.ChangeFeed, error) {
+	if from < 0 {
+		qs.changes.Lock()
+		from = qs.changes.start
+		if n := len(qs.changes.log); n != 0 {
+			from = qs.changes.log[n-1].Position
+		}
+		qs.changes.Unlock()
+	}
+	return graph.NewChangeFeed(&qs.changes.ChangeNotifier, from, qs.readChanges), nil
+}
+
+func (qs *QuadStore) readChanges(ctx context.Context, after int64) ([]graph.Change, error) {
+	qs.changes.Lock()
+	defer qs.changes.Unlock()
+	if after < qs.changes.start {
+		return nil, graph.ErrChangesCompacted
+	}
+	log := qs.changes.log
+	i := sort.Search(len(log), func(i int) bool {
+		return log[i].Position > after
+	})
+	// the log is append-only, thus it's safe to return a sub-slice of it
+	return log[i:len(log):len(log)], nil
+}
+
 func asID(v graph.Ref) (int64, bool) {
 	switch v := v.(type) {
 	case bnode:
@@ -488,7 +917,7 @@ func asID(v graph.Ref) (int64, bool) {
 func (qs *QuadStore) quad(v graph.Ref) (q internalQuad, ok bool) {
 	switch v := v.(type) {
 	case bnode:
-		p := qs.prim[int64(v)]
+		p := qs.primitive(int64(v))
 		if p == nil {
 			return
 		}
@@ -502,6 +931,8 @@ func (qs *QuadStore) quad(v graph.Ref) (q internalQuad, ok bool) {
 }
 
 func (qs *QuadStore) Quad(index graph.Ref) (quad.Quad, error) {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
 	q, ok := qs.quad(index)
 	if !ok {
 		return quad.Quad{}, nil
@@ -514,10 +945,12 @@ func (qs *QuadStore) QuadIterator(d quad.Direction, value graph.Ref) iterator.Sh
 	if !ok {
 		return iterator.NewNull()
 	}
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
 	// 找对应的B+树--index
 	index, ok := qs.index.Get(d, id)
 	if ok && index.Len() != 0 {
-		return qs.newIterator(index, d, id) //创建一个迭代器
+		return qs.newIterator(d, id, latest) //创建一个迭代器
 	}
 	return iterator.NewNull()
 }
@@ -527,14 +960,31 @@ func (qs *QuadStore) QuadIteratorSize(ctx context.Context, d quad.Direction, v g
 	if !ok {
 		return refs.Size{Value: 0, Exact: true}, nil
 	}
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
 	index, ok := qs.index.Get(d, id)
 	if !ok {
 		return refs.Size{Value: 0, Exact: true}, nil
 	}
-	return refs.Size{Value: int64(index.Len()), Exact: true}, nil
+	// the index may contain expired quads and deleted quads that were not yet purged
+	return refs.Size{Value: int64(index.Len()), Exact: len(qs.expiring) == 0 && len(qs.dead) == 0}, nil
+}
+
+// numExpired returns the number of expired quads that were not yet deleted.
+func (qs *QuadStore) numExpired() int64 {
+	var n int64
+	now := time.Now().UnixNano()
+	for _, p := range qs.expiring {
+		if p.expiredAt(now) {
+			n++
+		}
+	}
+	return n
 }
 
 func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
 	return graph.Stats{
 		// 返回有多少个节点Node
 		Nodes: refs.Size{
@@ -543,7 +993,7 @@ func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error)
 		},
 		// 多少个边关系
 		Quads: refs.Size{
-			Value: int64(len(qs.quads)),
+			Value: int64(len(qs.quads)) - qs.numExpired(),
 			Exact: true,
 		},
 	}, nil
@@ -553,7 +1003,9 @@ func (qs *QuadStore) ValueOf(name quad.Value) (graph.Ref, error) {
 	if name == nil {
 		return nil, nil
 	}
+	qs.mu.RLock()
 	id := qs.vals[name.String()]
+	qs.mu.RUnlock()
 	if id == 0 {
 		return nil, nil
 	}
@@ -570,18 +1022,21 @@ func (qs *QuadStore) NameOf(v graph.Ref) (quad.Value, error) {
 	if !ok {
 		return nil, nil
 	}
-	if _, ok = qs.prim[n]; !ok {
-		return nil, nil
-	}
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
 	return qs.lookupVal(n), nil
 }
 
 // all切片的迭代器
 func (qs *QuadStore) QuadsAllIterator() iterator.Shape {
-	return qs.newAllIterator(false, qs.last)
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	return qs.newAllIterator(false, latest, refs.Size{Value: int64(len(qs.quads)), Exact: len(qs.expiring) == 0})
 }
 
 func (qs *QuadStore) QuadDirection(val graph.Ref, d quad.Direction) (graph.Ref, error) {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
 	q, ok := qs.quad(val)
 	if !ok {
 		return nil, nil
@@ -594,7 +1049,18 @@ func (qs *QuadStore) QuadDirection(val graph.Ref, d quad.Direction) (graph.Ref,
 }
 
 func (qs *QuadStore) NodesAllIterator() iterator.Shape {
-	return qs.newAllIterator(true, qs.last)
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	return qs.newAllIterator(true, latest, refs.Size{Value: int64(len(qs.vals)), Exact: true})
 }
 
-func (qs *QuadStore) Close() error { return nil }
+func (qs *QuadStore) Close() error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if qs.durable == nil {
+		return nil
+	}
+	d := qs.durable
+	qs.durable = nil
+	return d.close(qs)
+}
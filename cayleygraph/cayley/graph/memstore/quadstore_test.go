@@ -16,9 +16,15 @@ package memstore
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -90,6 +96,289 @@ func TestMemstore(t *testing.T) {
 	})
 }
 
+func TestMemstoreDurable(t *testing.T) {
+	graphtest.TestAll(t, func(t testing.TB) (graph.QuadStore, graph.Options, func()) {
+		dir, err := ioutil.TempDir("", "cayley_test_memstore")
+		require.NoError(t, err)
+		err = Init(dir)
+		require.NoError(t, err)
+		opts := graph.Options{OptNoSync: true, OptSnapshotInterval: 3}
+		qs, err := Open(dir, opts)
+		require.NoError(t, err)
+		return qs, opts, func() {
+			qs.Close()
+			os.RemoveAll(dir)
+		}
+	}, &graphtest.Config{
+		AlwaysRunIntegration: true,
+	})
+}
+
+func TestPersistOption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cayley_test_memstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the path is a quad file, unless the store is persisted explicitly
+	_, err = graph.NewQuadStore(QuadStoreType, dir, nil)
+	require.Equal(t, graph.ErrQuadStoreNotPersistent, err)
+	require.Equal(t, graph.ErrQuadStoreNotPersistent, graph.InitQuadStore(QuadStoreType, dir, nil))
+
+	opts := graph.Options{OptPersist: true}
+	_, err = graph.NewQuadStore(QuadStoreType, "", opts)
+	require.Error(t, err)
+	_, err = graph.NewQuadStore(QuadStoreType, dir, opts)
+	require.Equal(t, graph.ErrNotInitialized, err)
+	require.NoError(t, graph.InitQuadStore(QuadStoreType, dir, opts))
+	qs, err := graph.NewQuadStore(QuadStoreType, dir, opts)
+	require.NoError(t, err)
+	require.NoError(t, qs.Close())
+}
+
+func TestDurableRestart(t *testing.T) {
+	ctx := context.TODO()
+	dir, err := ioutil.TempDir("", "cayley_test_memstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = Open(dir, nil)
+	require.Equal(t, graph.ErrNotInitialized, err)
+	require.NoError(t, Init(dir))
+	require.Equal(t, graph.ErrDatabaseExists, Init(dir))
+
+	opts := graph.Options{OptSnapshotInterval: 4}
+	qs, err := Open(dir, opts)
+	require.NoError(t, err)
+	w, err := writer.NewSingleReplication(qs, nil)
+	require.NoError(t, err)
+	for _, q := range simpleGraph {
+		require.NoError(t, w.AddQuad(q))
+	}
+	require.NoError(t, w.RemoveQuad(quad.MakeRaw("E", "follows", "F", "")))
+	require.NoError(t, qs.MoveLabel(ctx, quad.Raw("status_graph"), quad.Raw("g")))
+
+	var exp []quad.Quad
+	for _, q := range simpleGraph {
+		if q.Label != nil {
+			q.Label = quad.Raw("g")
+		} else if q.Subject == quad.Raw("E") {
+			continue
+		}
+		exp = append(exp, q)
+	}
+	st, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+
+	// reopen without closing, the state is restored from the last snapshot and the log
+	qs2, err := Open(dir, opts)
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, qs2, qs2.QuadsAllIterator(), exp, true)
+	st2, err := qs2.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, st, st2)
+	require.Equal(t, qs.last, qs2.last)
+	require.Equal(t, qs.horizon, qs2.horizon)
+	require.NoError(t, qs2.Close())
+	qs.durable.wal.Close()
+
+	// incomplete record at the end of the log is discarded
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{10, 0, 0, 0, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	qs3, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer qs3.Close()
+	graphtest.ExpectIteratedQuads(t, qs3, qs3.QuadsAllIterator(), exp, true)
+	// index trees are rebuilt
+	b, err := qs3.ValueOf(quad.Raw("B"))
+	require.NoError(t, err)
+	sz, err := qs3.QuadIteratorSize(ctx, quad.Subject, b)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), sz.Value)
+}
+
+func TestDurableWriteFailure(t *testing.T) {
+	ctx := context.TODO()
+	dir, err := ioutil.TempDir("", "cayley_test_memstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, Init(dir))
+
+	qs, err := Open(dir, nil)
+	require.NoError(t, err)
+	defer qs.Close()
+	w, err := writer.NewSingleReplication(qs, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.AddQuad(quad.MakeRaw("A", "follows", "B", "")))
+	st, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+
+	// the log is written before the store is modified
+	require.NoError(t, qs.durable.wal.Close())
+	require.Error(t, w.AddQuad(quad.MakeRaw("A", "follows", "C", "")))
+	require.Error(t, w.RemoveQuad(quad.MakeRaw("A", "follows", "B", "")))
+	require.Error(t, qs.DropLabel(ctx, nil))
+	st2, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, st, st2)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{
+		quad.MakeRaw("A", "follows", "B", ""),
+	}, false)
+
+	// nothing was written to the log
+	qs2, err := Open(dir, nil)
+	require.NoError(t, err)
+	defer qs2.Close()
+	require.Equal(t, qs.horizon, qs2.horizon)
+	graphtest.ExpectIteratedQuads(t, qs2, qs2.QuadsAllIterator(), []quad.Quad{
+		quad.MakeRaw("A", "follows", "B", ""),
+	}, false)
+}
+
+func TestStableIterators(t *testing.T) {
+	ctx := context.TODO()
+	qs, w, _ := makeTestStore(simpleGraph)
+	follows, err := qs.ValueOf(quad.Raw("follows"))
+	require.NoError(t, err)
+
+	it := qs.QuadIterator(quad.Predicate, follows).Iterate()
+	defer it.Close()
+	all := qs.QuadsAllIterator().Iterate()
+	defer all.Close()
+	require.True(t, it.Next(ctx))
+	require.True(t, all.Next(ctx))
+	n, na := 1, 1
+
+	// writes are not visible to iterators that were already created
+	tx := graph.NewTransaction()
+	tx.RemoveQuad(quad.MakeRaw("A", "follows", "B", ""))
+	tx.RemoveQuad(quad.MakeRaw("E", "follows", "F", ""))
+	tx.AddQuad(quad.MakeRaw("X", "follows", "Y", ""))
+	require.NoError(t, w.ApplyTransaction(tx))
+
+	for it.Next(ctx) {
+		n++
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, 8, n)
+	for all.Next(ctx) {
+		na++
+	}
+	require.Equal(t, len(simpleGraph), na)
+
+	// new iterators see the last version
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Predicate, follows), []quad.Quad{
+		quad.MakeRaw("C", "follows", "B", ""),
+		quad.MakeRaw("C", "follows", "D", ""),
+		quad.MakeRaw("D", "follows", "B", ""),
+		quad.MakeRaw("B", "follows", "F", ""),
+		quad.MakeRaw("F", "follows", "G", ""),
+		quad.MakeRaw("D", "follows", "G", ""),
+		quad.MakeRaw("X", "follows", "Y", ""),
+	}, true)
+}
+
+func TestConcurrentReads(t *testing.T) {
+	ctx := context.TODO()
+	qs, w, _ := makeTestStore(simpleGraph)
+	follows, err := qs.ValueOf(quad.Raw("follows"))
+	require.NoError(t, err)
+
+	target := func(i int) quad.Quad {
+		return quad.MakeRaw("X", "follows", fmt.Sprint("Y", i), "")
+	}
+	require.NoError(t, w.AddQuad(target(0)))
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			n, x := 0, 0
+			it := qs.QuadIterator(quad.Predicate, follows).Iterate()
+			for it.Next(ctx) {
+				q, err := qs.Quad(it.Result())
+				if err != nil {
+					errc <- err
+					it.Close()
+					return
+				}
+				// nodes of quads deleted by concurrent writes must still be resolved
+				if q.Predicate != quad.Raw("follows") || q.Subject == nil || q.Object == nil ||
+					strings.HasPrefix(q.Object.String(), "_:"+internalBNodePrefix) {
+					errc <- fmt.Errorf("unexpected quad: %v", q)
+					it.Close()
+					return
+				}
+				if q.Subject == quad.Raw("X") {
+					x++
+				}
+				n++
+			}
+			it.Close()
+			// every batch below replaces one quad
+			if n != 9 || x != 1 {
+				errc <- fmt.Errorf("unexpected number of quads: %d (%d from X)", n, x)
+				return
+			}
+		}
+	}()
+	for i := 1; i <= 200; i++ {
+		tx := graph.NewTransaction()
+		tx.RemoveQuad(target(i - 1))
+		tx.AddQuad(target(i))
+		require.NoError(t, w.ApplyTransaction(tx))
+	}
+	close(done)
+	require.NoError(t, <-errc)
+
+	// deleted primitives are purged by the next write once all readers are closed
+	require.NoError(t, w.AddQuad(quad.MakeRaw("X", "follows", "Z", "")))
+	require.Empty(t, qs.dead)
+	for _, p := range qs.all {
+		require.False(t, p.isDeleted(), "%v", p)
+	}
+}
+
+func TestSnapshotVersion(t *testing.T) {
+	ctx := context.TODO()
+	qs, w, _ := makeTestStore(simpleGraph)
+	snap, err := qs.Snapshot(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, w.RemoveQuad(quad.MakeRaw("A", "follows", "B", "")))
+	require.NoError(t, w.AddQuad(quad.MakeRaw("A", "follows", "Z", "")))
+
+	b, err := snap.ValueOf(quad.Raw("B"))
+	require.NoError(t, err)
+	require.NotNil(t, b)
+	a, err := snap.ValueOf(quad.Raw("A"))
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, snap, snap.QuadIterator(quad.Subject, a), []quad.Quad{
+		quad.MakeRaw("A", "follows", "B", ""),
+	}, true)
+	// the node was deleted with its last quad and added again with a new id
+	a2, err := qs.ValueOf(quad.Raw("A"))
+	require.NoError(t, err)
+	require.NotEqual(t, a, a2)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, a2), []quad.Quad{
+		quad.MakeRaw("A", "follows", "Z", ""),
+	}, true)
+	require.NotEmpty(t, qs.dead)
+
+	require.NoError(t, snap.Close())
+	require.NoError(t, w.AddQuad(quad.MakeRaw("A", "follows", "Y", "")))
+	require.Empty(t, qs.dead)
+}
+
 func BenchmarkMemstore(b *testing.B) {
 	graphtest.BenchmarkAll(b, func(t testing.TB) (graph.QuadStore, graph.Options, func()) {
 		return New(), nil, func() {}
@@ -239,6 +528,27 @@ func TestRemoveQuad(t *testing.T) {
 	}
 }
 
+func TestHasQuad(t *testing.T) {
+	ctx := context.TODO()
+	qs, w, _ := makeTestStore(simpleGraph)
+	for _, q := range simpleGraph {
+		ok, err := qs.HasQuad(ctx, q)
+		require.NoError(t, err)
+		require.True(t, ok, "%v", q)
+	}
+	require.NoError(t, w.RemoveQuad(quad.MakeRaw("E", "follows", "F", "")))
+	for _, q := range []quad.Quad{
+		quad.MakeRaw("E", "follows", "F", ""),      // removed
+		quad.MakeRaw("A", "follows", "Z", ""),      // unknown node
+		quad.MakeRaw("B", "status", "cool", ""),    // different label
+		quad.MakeRaw("A", "follows", "B", "other"), // unknown label
+	} {
+		ok, err := qs.HasQuad(ctx, q)
+		require.NoError(t, err)
+		require.False(t, ok, "%v", q)
+	}
+}
+
 func TestTransaction(t *testing.T) {
 	qs, w, _ := makeTestStore(simpleGraph)
 	st, err := qs.Stats(context.Background(), true)
@@ -264,3 +574,198 @@ func TestTransaction(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, st, st2, "Appended a new quad in a failed transaction")
 }
+
+func TestExpiry(t *testing.T) {
+	ctx := context.TODO()
+	dir, err := ioutil.TempDir("", "cayley_test_memstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, Init(dir))
+	qs, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	var (
+		now     = time.Now()
+		keep    = quad.MakeRaw("A", "follows", "B", "")
+		expired = quad.MakeRaw("A", "follows", "C", "")
+		later   = quad.MakeRaw("A", "follows", "D", "")
+	)
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: keep, Action: graph.Add},
+		{Quad: expired, Action: graph.Add, Expires: now.Add(-time.Second)},
+		{Quad: later, Action: graph.Add, Expires: now.Add(time.Hour)},
+	}, graph.IgnoreOpts{}))
+
+	// expired quads are hidden at once
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{keep, later}, true)
+	a, err := qs.ValueOf(quad.Raw("A"))
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, a), []quad.Quad{keep, later}, true)
+	st, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Quads.Value)
+
+	// expired quad is treated as missing
+	err = qs.ApplyDeltas([]graph.Delta{{Quad: expired, Action: graph.Delete}}, graph.IgnoreOpts{})
+	require.True(t, graph.IsQuadNotExist(err), "%v", err)
+	// and expiration time of an existing quad can be updated
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: later, Action: graph.Add, Expires: now.Add(time.Millisecond)},
+	}, graph.IgnoreOpts{}))
+
+	// the reaper deletes quads through the change feed
+	reaped, err := qs.ExpireQuads(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	sort.Sort(quad.ByQuadString(reaped))
+	require.Equal(t, []quad.Quad{expired, later}, reaped)
+	changes, err := qs.readChanges(ctx, 0)
+	require.NoError(t, err)
+	last := changes[len(changes)-1].Deltas
+	var deleted []quad.Quad
+	for _, d := range last {
+		require.Equal(t, graph.Delete, d.Action)
+		deleted = append(deleted, d.Quad)
+	}
+	sort.Sort(quad.ByQuadString(deleted))
+	require.Equal(t, []quad.Quad{expired, later}, deleted)
+	require.Empty(t, qs.expiring)
+
+	// adding a quad without an expiration time over an expiring one clears the expiration time
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: later, Action: graph.Add, Expires: now.Add(time.Hour)},
+	}, graph.IgnoreOpts{}))
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: later, Action: graph.Add},
+	}, graph.IgnoreOpts{}))
+	require.Empty(t, qs.expiring)
+	err = qs.ApplyDeltas([]graph.Delta{{Quad: later, Action: graph.Add}}, graph.IgnoreOpts{})
+	require.True(t, graph.IsQuadExist(err), "%v", err)
+	reaped, err = qs.ExpireQuads(ctx, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, reaped)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{keep, later}, true)
+
+	// expiration time survives a restart
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: later, Action: graph.Add, Expires: now.Add(time.Hour)},
+	}, graph.IgnoreOpts{}))
+	qs2, err := Open(dir, nil)
+	require.NoError(t, err)
+	require.Len(t, qs2.expiring, 1)
+	require.NoError(t, qs2.Close())
+	qs.durable.wal.Close()
+}
+
+func TestExpiryReplay(t *testing.T) {
+	ctx := context.TODO()
+	dir, err := ioutil.TempDir("", "cayley_test_memstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, Init(dir))
+	qs, err := Open(dir, nil)
+	require.NoError(t, err)
+	defer qs.durable.wal.Close()
+
+	q := quad.MakeRaw("A", "follows", "B", "")
+	exp := time.Now().Add(200 * time.Millisecond)
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: q, Action: graph.Add, Expires: exp},
+	}, graph.IgnoreOpts{}))
+	// written while the first copy is still alive
+	require.NoError(t, qs.ApplyDeltas([]graph.Delta{
+		{Quad: q, Action: graph.Add},
+	}, graph.IgnoreOpts{IgnoreDup: true}))
+
+	// the log is replayed after the first copy expired, but the result is the same
+	time.Sleep(time.Until(exp) + 10*time.Millisecond)
+	st, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+	qs2, err := Open(dir, nil)
+	require.NoError(t, err)
+	defer qs2.Close()
+	require.Equal(t, qs.last, qs2.last)
+	require.Equal(t, qs.horizon, qs2.horizon)
+	require.Equal(t, len(qs.expiring), len(qs2.expiring))
+	st2, err := qs2.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, st, st2)
+}
+
+func TestChangesLimit(t *testing.T) {
+	ctx := context.TODO()
+	dir, err := ioutil.TempDir("", "cayley_test_memstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, Init(dir))
+	qs, err := Open(dir, graph.Options{OptChangesLimit: 2})
+	require.NoError(t, err)
+
+	for _, o := range []string{"B", "C", "D"} {
+		_, err = qs.WriteQuads([]quad.Quad{quad.MakeRaw("A", "follows", o, "")})
+		require.NoError(t, err)
+	}
+	// only the last batches are kept
+	_, err = qs.readChanges(ctx, 0)
+	require.Equal(t, graph.ErrChangesCompacted, err)
+	changes, err := qs.readChanges(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, quad.MakeRaw("A", "follows", "D", ""), changes[1].Deltas[0].Quad)
+
+	// batches replayed from the log are not in the feed
+	qs2, err := Open(dir, nil)
+	require.NoError(t, err)
+	_, err = qs2.readChanges(ctx, 2)
+	require.Equal(t, graph.ErrChangesCompacted, err)
+	changes, err = qs2.readChanges(ctx, 3)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+	require.NoError(t, qs2.Close())
+	qs.durable.wal.Close()
+}
+
+func TestPreconditions(t *testing.T) {
+	ctx := context.TODO()
+	qs, w, _ := makeTestStore(simpleGraph)
+
+	ver, err := graph.Version(ctx, qs)
+	require.NoError(t, err)
+
+	// two writers read the same version, only the first one wins
+	tx1 := graph.NewTransaction()
+	tx1.Require(graph.VersionEquals{Version: ver})
+	tx1.AddQuad(quad.MakeRaw("A", "status", "cool", ""))
+	tx2 := graph.NewTransaction()
+	tx2.Require(graph.VersionEquals{Version: ver})
+	tx2.AddQuad(quad.MakeRaw("A", "status", "smart", ""))
+
+	require.NoError(t, w.ApplyTransaction(tx1))
+	err = w.ApplyTransaction(tx2)
+	require.True(t, graph.IsPreconditionFailed(err), "%v", err)
+	require.Equal(t, graph.VersionEquals{Version: ver}, err.(*graph.PreconditionError).Precondition)
+	require.False(t, qs.hasQuad(quad.MakeRaw("A", "status", "smart", "")))
+
+	for _, c := range []struct {
+		cond graph.Precondition
+		ok   bool
+	}{
+		{graph.QuadExists{Quad: quad.MakeRaw("A", "follows", "B", "")}, true},
+		{graph.QuadExists{Quad: quad.MakeRaw("A", "follows", "Z", "")}, false},
+		{graph.QuadNotExists{Quad: quad.MakeRaw("A", "follows", "Z", "")}, true},
+		{graph.QuadNotExists{Quad: quad.MakeRaw("A", "follows", "B", "")}, false},
+		{graph.NoLinks{Node: quad.Raw("A"), Dir: quad.Subject, Via: quad.Raw("follows")}, false},
+		{graph.NoLinks{Node: quad.Raw("A"), Dir: quad.Object, Via: quad.Raw("follows")}, true},
+		{graph.NoLinks{Node: quad.Raw("A"), Dir: quad.Subject, Via: quad.Raw("unknown")}, true},
+		{graph.NoLinks{Node: quad.Raw("B"), Dir: quad.Subject}, false},
+		{graph.NoLinks{Node: quad.Raw("Z"), Dir: quad.Subject}, true},
+	} {
+		q := quad.MakeRaw("X", "checked", "Y", "")
+		err := qs.ApplyDeltasIf([]graph.Precondition{c.cond}, []graph.Delta{{Quad: q, Action: graph.Add}}, graph.IgnoreOpts{})
+		if c.ok {
+			require.NoError(t, err, "%v", c.cond)
+			require.NoError(t, qs.ApplyDeltas([]graph.Delta{{Quad: q, Action: graph.Delete}}, graph.IgnoreOpts{}))
+		} else {
+			require.True(t, graph.IsPreconditionFailed(err), "%v: %v", c.cond, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+)
+
+var _ graph.Snapshotter = (*QuadStore)(nil)
+
+// Snapshot implements graph.Snapshotter.
+//
+// The snapshot reads a fixed version of the store and shares primitives and indexes with it; only the value
+// lookup table is copied. Primitives deleted after the snapshot was made are kept until it's closed.
+func (qs *QuadStore) Snapshot(ctx context.Context) (graph.QuadStore, error) {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	s := &snapshot{
+		qs:       qs,
+		ver:      qs.acquire(),
+		vals:     make(map[string]int64, len(qs.vals)),
+		quads:    int64(len(qs.quads)),
+		expiring: make([]*Primitive, 0, len(qs.expiring)),
+	}
+	for k, v := range qs.vals {
+		s.vals[k] = v
+	}
+	for _, p := range qs.expiring {
+		s.expiring = append(s.expiring, p)
+	}
+	return s, nil
+}
+
+// snapshot is a read-only view of a fixed version of a memstore.
+type snapshot struct {
+	qs       *QuadStore
+	ver      int64
+	vals     map[string]int64
+	quads    int64
+	expiring []*Primitive
+	close    sync.Once
+}
+
+func (s *snapshot) ValueOf(v quad.Value) (graph.Ref, error) {
+	if v == nil {
+		return nil, nil
+	}
+	id := s.vals[v.String()]
+	if id == 0 {
+		return nil, nil
+	}
+	return bnode(id), nil
+}
+
+func (s *snapshot) NameOf(v graph.Ref) (quad.Value, error) {
+	return s.qs.NameOf(v)
+}
+
+func (s *snapshot) Quad(r graph.Ref) (quad.Quad, error) {
+	return s.qs.Quad(r)
+}
+
+func (s *snapshot) QuadIterator(d quad.Direction, v graph.Ref) iterator.Shape {
+	id, ok := asID(v)
+	if !ok {
+		return iterator.NewNull()
+	}
+	s.qs.mu.RLock()
+	defer s.qs.mu.RUnlock()
+	if index, ok := s.qs.index.Get(d, id); ok && index.Len() != 0 {
+		return s.qs.newIterator(d, id, s.ver)
+	}
+	return iterator.NewNull()
+}
+
+func (s *snapshot) QuadIteratorSize(ctx context.Context, d quad.Direction, v graph.Ref) (refs.Size, error) {
+	sz, err := s.qs.QuadIteratorSize(ctx, d, v)
+	if err != nil {
+		return sz, err
+	}
+	s.qs.mu.RLock()
+	changed := s.qs.version != s.ver
+	s.qs.mu.RUnlock()
+	if changed {
+		// the index includes quads added after the snapshot was made
+		sz.Exact = false
+	}
+	return sz, nil
+}
+
+func (s *snapshot) QuadDirection(r graph.Ref, d quad.Direction) (graph.Ref, error) {
+	return s.qs.QuadDirection(r, d)
+}
+
+func (s *snapshot) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
+	var expired int64
+	now := time.Now().UnixNano()
+	for _, p := range s.expiring {
+		if p.expiredAt(now) {
+			expired++
+		}
+	}
+	return graph.Stats{
+		Nodes: refs.Size{Value: int64(len(s.vals)), Exact: true},
+		Quads: refs.Size{Value: s.quads - expired, Exact: true},
+	}, nil
+}
+
+func (s *snapshot) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
+	return graph.ErrReadOnly
+}
+
+func (s *snapshot) NewQuadWriter() (quad.WriteCloser, error) {
+	return nil, graph.ErrReadOnly
+}
+
+func (s *snapshot) NodesAllIterator() iterator.Shape {
+	return s.qs.newAllIterator(true, s.ver, refs.Size{Value: int64(len(s.vals)), Exact: true})
+}
+
+func (s *snapshot) QuadsAllIterator() iterator.Shape {
+	return s.qs.newAllIterator(false, s.ver, refs.Size{Value: s.quads, Exact: len(s.expiring) == 0})
+}
+
+func (s *snapshot) Snapshot(ctx context.Context) (graph.QuadStore, error) {
+	return s, nil
+}
+
+func (s *snapshot) Close() error {
+	s.close.Do(func() { s.qs.release(s.ver) })
+	return nil
+}
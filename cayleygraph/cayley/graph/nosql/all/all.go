@@ -4,4 +4,5 @@ import (
 	_ "github.com/hidal-go/hidalgo/legacy/nosql/all"
 
 	_ "github.com/cayleygraph/cayley/graph/nosql"
+	_ "github.com/cayleygraph/cayley/graph/nosql/memnosql"
 )
@@ -0,0 +1,775 @@
+// Package memnosql implements an in-memory document database for the nosql QuadStore.
+//
+// It is used to test the nosql QuadStore without external databases, and can serve
+// small embedded deployments that do not need persistence.
+package memnosql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hidal-go/hidalgo/legacy/nosql"
+
+	"github.com/cayleygraph/cayley/graph"
+	gnosql "github.com/cayleygraph/cayley/graph/nosql"
+)
+
+const Type = "memnosql"
+
+// Traits of the database. All values are stored as is.
+var Traits = nosql.Traits{}
+
+func init() {
+	gnosql.Register(Type, gnosql.Registration{
+		NewFunc:      Open,
+		InitFunc:     Open,
+		IsPersistent: false,
+		Traits:       Traits,
+	})
+}
+
+// Open creates a new empty database. The address is ignored.
+func Open(_ string, _ graph.Options) (nosql.Database, error) {
+	return New(), nil
+}
+
+var (
+	errClosed = errors.New("memnosql: database is closed")
+	errExists = errors.New("memnosql: document already exists")
+)
+
+var _ nosql.Database = (*DB)(nil)
+
+// DB is an in-memory document database. It is safe for concurrent use.
+type DB struct {
+	mu    sync.RWMutex
+	colls map[string]*collection // nil if closed
+}
+
+// New creates a new empty database.
+func New() *DB {
+	return &DB{colls: make(map[string]*collection)}
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	db.colls = nil
+	db.mu.Unlock()
+	return nil
+}
+
+// collection returns an existing collection. It must be called with the lock held.
+func (db *DB) collection(col string) (*collection, error) {
+	if db.colls == nil {
+		return nil, errClosed
+	}
+	c := db.colls[col]
+	if c == nil {
+		return nil, fmt.Errorf("collection %q not found", col)
+	}
+	return c, nil
+}
+
+func (db *DB) EnsureIndex(ctx context.Context, col string, primary nosql.Index, secondary []nosql.Index) error {
+	if len(primary.Fields) == 0 {
+		return fmt.Errorf("primary index of %q has no fields", col)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.colls == nil {
+		return errClosed
+	}
+	c := db.colls[col]
+	if c == nil {
+		c = &collection{
+			docs: make(map[string]nosql.Document),
+		}
+		c.indexes = append(c.indexes, &index{fields: primary.Fields})
+		db.colls[col] = c
+	} else if !sameFields(c.primary().fields, primary.Fields) {
+		return fmt.Errorf("cannot change primary index of %q", col)
+	}
+	for _, ind := range secondary {
+		if len(ind.Fields) == 0 || c.hasIndex(ind.Fields) {
+			continue
+		}
+		si := &index{fields: ind.Fields}
+		for k, d := range c.docs {
+			si.insert(d, k)
+		}
+		c.indexes = append(c.indexes, si)
+	}
+	return nil
+}
+
+func (db *DB) Insert(ctx context.Context, col string, key nosql.Key, d nosql.Document) (nosql.Key, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, err := db.collection(col)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		key = nosql.GenKey()
+	}
+	if err = c.insert(key, copyDoc(d)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (db *DB) FindByKey(ctx context.Context, col string, key nosql.Key) (nosql.Document, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, err := db.collection(col)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := c.docs[keyString(key)]
+	if !ok {
+		return nil, nosql.ErrNotFound
+	}
+	return copyDoc(d), nil
+}
+
+func (db *DB) Query(col string) nosql.Query {
+	return &Query{db: db, col: col}
+}
+
+func (db *DB) Update(col string, key nosql.Key) nosql.Update {
+	return &Update{db: db, col: col, key: key}
+}
+
+func (db *DB) Delete(col string) nosql.Delete {
+	return &Delete{db: db, col: col}
+}
+
+// collection stores documents by their primary key. The first index is always the primary one.
+type collection struct {
+	docs    map[string]nosql.Document
+	indexes []*index
+}
+
+func (c *collection) primary() *index {
+	return c.indexes[0]
+}
+
+func (c *collection) hasIndex(fields []string) bool {
+	for _, ind := range c.indexes {
+		if sameFields(ind.fields, fields) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *collection) insert(key nosql.Key, d nosql.Document) error {
+	fields := c.primary().fields
+	if len(key) != len(fields) {
+		return fmt.Errorf("expected key with %d fields, got %d", len(fields), len(key))
+	}
+	k := keyString(key)
+	if _, ok := c.docs[k]; ok {
+		return errExists
+	}
+	for i, f := range fields {
+		d[f] = nosql.String(key[i])
+	}
+	c.put(k, d)
+	return nil
+}
+
+// put stores a document and adds it to all indexes.
+func (c *collection) put(k string, d nosql.Document) {
+	c.docs[k] = d
+	for _, ind := range c.indexes {
+		ind.insert(d, k)
+	}
+}
+
+// remove deletes a document and removes it from all indexes.
+func (c *collection) remove(k string) {
+	d, ok := c.docs[k]
+	if !ok {
+		return
+	}
+	for _, ind := range c.indexes {
+		ind.remove(d, k)
+	}
+	delete(c.docs, k)
+}
+
+// find calls fnc for each document that matches all filters, until it returns false.
+// The collection must not be modified by fnc. Documents are visited in the order of the most selective index
+// that can serve the filters.
+func (c *collection) find(filters []filter, fnc func(k string, d nosql.Document) bool) {
+	ind, lo, hi := c.primary(), 0, len(c.primary().items)
+	for _, in := range c.indexes {
+		for _, f := range filters {
+			if l, h, ok := in.bounds(f); ok && h-l < hi-lo {
+				ind, lo, hi = in, l, h
+			}
+		}
+	}
+	for _, it := range ind.items[lo:hi] {
+		if d := c.docs[it.key]; matchesAll(filters, d) && !fnc(it.key, d) {
+			return
+		}
+	}
+}
+
+// index is an ordered list of documents, sorted by the values of index fields and the primary key.
+type index struct {
+	fields []string
+	items  []indexItem
+}
+
+type indexItem struct {
+	vals []nosql.Value // nil if the field is not set
+	key  string
+}
+
+func (ind *index) item(d nosql.Document, k string) indexItem {
+	vals := make([]nosql.Value, len(ind.fields))
+	for i, f := range ind.fields {
+		vals[i] = d[f]
+	}
+	return indexItem{vals: vals, key: k}
+}
+
+func compareItems(a, b indexItem) int {
+	for i := range a.vals {
+		if c := compareValues(a.vals[i], b.vals[i]); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.key, b.key)
+}
+
+func (ind *index) search(it indexItem) int {
+	return sort.Search(len(ind.items), func(i int) bool {
+		return compareItems(ind.items[i], it) >= 0
+	})
+}
+
+func (ind *index) insert(d nosql.Document, k string) {
+	it := ind.item(d, k)
+	i := ind.search(it)
+	ind.items = append(ind.items, indexItem{})
+	copy(ind.items[i+1:], ind.items[i:])
+	ind.items[i] = it
+}
+
+func (ind *index) remove(d nosql.Document, k string) {
+	it := ind.item(d, k)
+	i := ind.search(it)
+	if i < len(ind.items) && ind.items[i].key == k {
+		ind.items = append(ind.items[:i], ind.items[i+1:]...)
+	}
+}
+
+// bounds returns a range of index items that may match the filter. Only filters on the first field of the index
+// can be served by it.
+func (ind *index) bounds(f filter) (lo, hi int, ok bool) {
+	if len(f.Path) != 1 || f.Path[0] != ind.fields[0] || f.Value == nil {
+		return 0, 0, false
+	}
+	first := func(fnc func(c int) bool) int {
+		return sort.Search(len(ind.items), func(i int) bool {
+			return fnc(compareValues(ind.items[i].vals[0], f.Value))
+		})
+	}
+	lo, hi = 0, len(ind.items)
+	switch f.Filter {
+	case nosql.Equal:
+		lo = first(func(c int) bool { return c >= 0 })
+		hi = first(func(c int) bool { return c > 0 })
+	case nosql.GT:
+		lo = first(func(c int) bool { return c > 0 })
+	case nosql.GTE:
+		lo = first(func(c int) bool { return c >= 0 })
+	case nosql.LT:
+		hi = first(func(c int) bool { return c >= 0 })
+	case nosql.LTE:
+		hi = first(func(c int) bool { return c > 0 })
+	default:
+		return 0, 0, false
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi, true
+}
+
+// filter is a field filter with a compiled regular expression.
+type filter struct {
+	nosql.FieldFilter
+	re *regexp.Regexp
+}
+
+func compileFilters(filters []nosql.FieldFilter) ([]filter, error) {
+	out := make([]filter, 0, len(filters))
+	for _, f := range filters {
+		cf := filter{FieldFilter: f}
+		switch f.Filter {
+		case nosql.Equal, nosql.NotEqual, nosql.GT, nosql.GTE, nosql.LT, nosql.LTE:
+		case nosql.Regexp:
+			s, ok := f.Value.(nosql.String)
+			if !ok {
+				return nil, fmt.Errorf("unsupported regexp: %T", f.Value)
+			}
+			re, err := regexp.Compile(string(s))
+			if err != nil {
+				return nil, err
+			}
+			cf.re = re
+		default:
+			return nil, fmt.Errorf("unsupported operation: %v", f.Filter)
+		}
+		out = append(out, cf)
+	}
+	return out, nil
+}
+
+func lookup(d nosql.Document, path []string) (nosql.Value, bool) {
+	var v nosql.Value = d
+	for _, p := range path {
+		sub, ok := v.(nosql.Document)
+		if !ok {
+			return nil, false
+		}
+		v, ok = sub[p]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func (f filter) matches(d nosql.Document) bool {
+	v, ok := lookup(d, f.Path)
+	if f.Filter == nosql.NotEqual {
+		// allows the field to not exist
+		return !ok || compareValues(v, f.Value) != 0
+	} else if !ok {
+		return false
+	}
+	switch f.Filter {
+	case nosql.Equal:
+		return compareValues(v, f.Value) == 0
+	case nosql.Regexp:
+		s, ok := v.(nosql.String)
+		return ok && f.re.MatchString(string(s))
+	}
+	// only values of the same kind can be compared
+	if valueRank(v) != valueRank(f.Value) {
+		return false
+	}
+	c := compareValues(v, f.Value)
+	switch f.Filter {
+	case nosql.GT:
+		return c > 0
+	case nosql.GTE:
+		return c >= 0
+	case nosql.LT:
+		return c < 0
+	case nosql.LTE:
+		return c <= 0
+	}
+	return false
+}
+
+func matchesAll(filters []filter, d nosql.Document) bool {
+	for _, f := range filters {
+		if !f.matches(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// valueRank defines the order of values of different types. Ints and floats are compared as numbers.
+func valueRank(v nosql.Value) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case nosql.Bool:
+		return 1
+	case nosql.Int, nosql.Float:
+		return 2
+	case nosql.String:
+		return 3
+	case nosql.Bytes:
+		return 4
+	case nosql.Time:
+		return 5
+	case nosql.Strings:
+		return 6
+	case nosql.Document:
+		return 7
+	}
+	return 8
+}
+
+func compareNums(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return +1
+	}
+	return 0
+}
+
+// compareValues defines a total order of values.
+func compareValues(a, b nosql.Value) int {
+	if ra, rb := valueRank(a), valueRank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case nosql.Bool:
+		b := b.(nosql.Bool)
+		if a == b {
+			return 0
+		} else if !a {
+			return -1
+		}
+		return +1
+	case nosql.Int:
+		if b, ok := b.(nosql.Int); ok {
+			if a == b {
+				return 0
+			} else if a < b {
+				return -1
+			}
+			return +1
+		}
+		return compareNums(float64(a), float64(b.(nosql.Float)))
+	case nosql.Float:
+		if b, ok := b.(nosql.Int); ok {
+			return compareNums(float64(a), float64(b))
+		}
+		return compareNums(float64(a), float64(b.(nosql.Float)))
+	case nosql.String:
+		return strings.Compare(string(a), string(b.(nosql.String)))
+	case nosql.Bytes:
+		return bytes.Compare(a, b.(nosql.Bytes))
+	case nosql.Time:
+		t1, t2 := time.Time(a), time.Time(b.(nosql.Time))
+		if t1.Equal(t2) {
+			return 0
+		} else if t1.Before(t2) {
+			return -1
+		}
+		return +1
+	case nosql.Strings:
+		b := b.(nosql.Strings)
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(b)
+	case nosql.Document:
+		return compareDocs(a, b.(nosql.Document))
+	}
+	return 0
+}
+
+func sortedFields(d nosql.Document) []string {
+	fields := make([]string, 0, len(d))
+	for f := range d {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func compareDocs(a, b nosql.Document) int {
+	fa, fb := sortedFields(a), sortedFields(b)
+	for i := 0; i < len(fa) && i < len(fb); i++ {
+		if c := strings.Compare(fa[i], fb[i]); c != 0 {
+			return c
+		}
+		if c := compareValues(a[fa[i]], b[fb[i]]); c != 0 {
+			return c
+		}
+	}
+	return len(fa) - len(fb)
+}
+
+func sameFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// keyString encodes the key, so it can be used as a map key.
+func keyString(key nosql.Key) string {
+	var sb strings.Builder
+	for _, s := range key {
+		sb.WriteString(strconv.Itoa(len(s)))
+		sb.WriteByte(':')
+		sb.WriteString(s)
+	}
+	return sb.String()
+}
+
+func copyValue(v nosql.Value) nosql.Value {
+	switch v := v.(type) {
+	case nosql.Document:
+		return copyDoc(v)
+	case nosql.Strings:
+		return append(nosql.Strings{}, v...)
+	case nosql.Bytes:
+		return append(nosql.Bytes{}, v...)
+	}
+	return v
+}
+
+func copyDoc(d nosql.Document) nosql.Document {
+	out := make(nosql.Document, len(d))
+	for k, v := range d {
+		out[k] = copyValue(v)
+	}
+	return out
+}
+
+// Query is a query over documents of a collection.
+type Query struct {
+	db      *DB
+	col     string
+	filters []nosql.FieldFilter
+	limit   int
+}
+
+func (q *Query) WithFields(filters ...nosql.FieldFilter) nosql.Query {
+	q.filters = append(q.filters, filters...)
+	return q
+}
+
+func (q *Query) Limit(n int) nosql.Query {
+	q.limit = n
+	return q
+}
+
+// run calls fnc for each document that matches the query. It returns fields of the primary key.
+func (q *Query) run(fnc func(d nosql.Document)) ([]string, error) {
+	filters, err := compileFilters(q.filters)
+	if err != nil {
+		return nil, err
+	}
+	q.db.mu.RLock()
+	defer q.db.mu.RUnlock()
+	c, err := q.db.collection(q.col)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	c.find(filters, func(_ string, d nosql.Document) bool {
+		fnc(d)
+		n++
+		return q.limit <= 0 || n < q.limit
+	})
+	return c.primary().fields, nil
+}
+
+func (q *Query) Count(ctx context.Context) (int64, error) {
+	var n int64
+	_, err := q.run(func(_ nosql.Document) {
+		n++
+	})
+	return n, err
+}
+
+func (q *Query) One(ctx context.Context) (nosql.Document, error) {
+	var out nosql.Document
+	one := *q
+	one.limit = 1
+	_, err := one.run(func(d nosql.Document) {
+		out = copyDoc(d)
+	})
+	if err != nil {
+		return nil, err
+	} else if out == nil {
+		return nil, nosql.ErrNotFound
+	}
+	return out, nil
+}
+
+func (q *Query) Iterate() nosql.DocIterator {
+	return &Iterator{q: q}
+}
+
+// Iterator iterates over the documents that matched a query when the iteration started.
+type Iterator struct {
+	q      *Query
+	fields []string
+	docs   []nosql.Document
+	ran    bool
+	cur    int
+	err    error
+}
+
+func (it *Iterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	} else if !it.ran {
+		it.ran = true
+		// documents are never modified in place, thus it is safe to keep them
+		it.fields, it.err = it.q.run(func(d nosql.Document) {
+			it.docs = append(it.docs, d)
+		})
+		return it.err == nil && len(it.docs) != 0
+	} else if it.cur < len(it.docs) {
+		it.cur++
+	}
+	return it.cur < len(it.docs)
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) Close() error {
+	it.docs = nil
+	return it.err
+}
+
+func (it *Iterator) Key() nosql.Key {
+	if it.cur >= len(it.docs) {
+		return nil
+	}
+	return nosql.KeyFrom(it.fields, it.docs[it.cur])
+}
+
+func (it *Iterator) Doc() nosql.Document {
+	if it.cur >= len(it.docs) {
+		return nil
+	}
+	return copyDoc(it.docs[it.cur])
+}
+
+// Update is an update request for a single document.
+type Update struct {
+	db     *DB
+	col    string
+	key    nosql.Key
+	inc    map[string]int
+	upsert nosql.Document
+}
+
+func (u *Update) Inc(field string, dn int) nosql.Update {
+	if u.inc == nil {
+		u.inc = make(map[string]int)
+	}
+	u.inc[field] += dn
+	return u
+}
+
+func (u *Update) Upsert(d nosql.Document) nosql.Update {
+	u.upsert = d
+	return u
+}
+
+func (u *Update) Do(ctx context.Context) error {
+	u.db.mu.Lock()
+	defer u.db.mu.Unlock()
+	c, err := u.db.collection(u.col)
+	if err != nil {
+		return err
+	}
+	k := keyString(u.key)
+	old, ok := c.docs[k]
+	if !ok {
+		if u.upsert == nil {
+			return nil
+		}
+		d := copyDoc(u.upsert)
+		for f, dn := range u.inc {
+			d[f] = nosql.Int(dn)
+		}
+		return c.insert(u.key, d)
+	}
+	// documents are never modified in place, since iterators may still reference them
+	d := copyDoc(old)
+	for f, dn := range u.inc {
+		switch v := d[f].(type) {
+		case nil:
+			d[f] = nosql.Int(dn)
+		case nosql.Int:
+			d[f] = v + nosql.Int(dn)
+		case nosql.Float:
+			d[f] = v + nosql.Float(dn)
+		default:
+			return fmt.Errorf("cannot increment field %q of type %T", f, v)
+		}
+	}
+	c.remove(k)
+	c.put(k, d)
+	return nil
+}
+
+// Delete is a request to delete documents matching filters.
+type Delete struct {
+	db      *DB
+	col     string
+	filters []nosql.FieldFilter
+	keys    []nosql.Key
+	byKeys  bool
+}
+
+func (d *Delete) WithFields(filters ...nosql.FieldFilter) nosql.Delete {
+	d.filters = append(d.filters, filters...)
+	return d
+}
+
+func (d *Delete) Keys(keys ...nosql.Key) nosql.Delete {
+	d.keys = append(d.keys, keys...)
+	d.byKeys = true
+	return d
+}
+
+func (d *Delete) Do(ctx context.Context) error {
+	filters, err := compileFilters(d.filters)
+	if err != nil {
+		return err
+	}
+	d.db.mu.Lock()
+	defer d.db.mu.Unlock()
+	c, err := d.db.collection(d.col)
+	if err != nil {
+		return err
+	}
+	if d.byKeys {
+		for _, key := range d.keys {
+			k := keyString(key)
+			if doc, ok := c.docs[k]; ok && matchesAll(filters, doc) {
+				c.remove(k)
+			}
+		}
+		return nil
+	}
+	var del []string
+	c.find(filters, func(k string, _ nosql.Document) bool {
+		del = append(del, k)
+		return true
+	})
+	for _, k := range del {
+		c.remove(k)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package memnosql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hidal-go/hidalgo/legacy/nosql"
+	hnosqltest "github.com/hidal-go/hidalgo/legacy/nosql/nosqltest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/cayley/graph/nosql/nosqltest"
+)
+
+var gen = hnosqltest.Database{
+	Traits: Traits,
+	Run: func(t testing.TB) (nosql.Database, func()) {
+		db := New()
+		return db, func() {
+			db.Close()
+		}
+	},
+}
+
+func TestNoSQL(t *testing.T) {
+	hnosqltest.TestNoSQL(t, gen)
+}
+
+func TestQuadStore(t *testing.T) {
+	nosqltest.TestAll(t, gen)
+}
+
+func BenchmarkQuadStore(b *testing.B) {
+	nosqltest.BenchmarkAll(b, gen)
+}
+
+func TestRangeQuery(t *testing.T) {
+	ctx := context.TODO()
+	db := New()
+	defer db.Close()
+
+	err := db.EnsureIndex(ctx, "col", nosql.Index{
+		Fields: []string{"id"}, Type: nosql.StringExact,
+	}, []nosql.Index{
+		{Fields: []string{"n"}},
+	})
+	require.NoError(t, err)
+	for i, v := range []nosql.Value{
+		nosql.Int(1), nosql.Float(2.5), nosql.Int(3), nosql.String("4"), nil,
+	} {
+		d := nosql.Document{"i": nosql.Int(i)}
+		if v != nil {
+			d["n"] = v
+		}
+		_, err = db.Insert(ctx, "col", nosql.Key{string('a' + rune(i))}, d)
+		require.NoError(t, err)
+	}
+	query := func(filters ...nosql.FieldFilter) []string {
+		it := db.Query("col").WithFields(filters...).Iterate()
+		defer it.Close()
+		var out []string
+		for it.Next(ctx) {
+			out = append(out, it.Key()[0])
+		}
+		require.NoError(t, it.Err())
+		return out
+	}
+	n := func(op nosql.FilterOp, v nosql.Value) nosql.FieldFilter {
+		return nosql.FieldFilter{Path: []string{"n"}, Filter: op, Value: v}
+	}
+
+	require.Equal(t, []string{"b", "c"}, query(n(nosql.GT, nosql.Int(1))))
+	require.Equal(t, []string{"a", "b"}, query(n(nosql.LTE, nosql.Float(2.5))))
+	require.Equal(t, []string{"b"}, query(n(nosql.GT, nosql.Int(1)), n(nosql.LT, nosql.Int(3))))
+	require.Equal(t, []string{"c"}, query(n(nosql.Equal, nosql.Float(3))))
+	require.Equal(t, []string{"a", "b", "d", "e"}, query(n(nosql.NotEqual, nosql.Int(3))))
+	require.Equal(t, []string{"d"}, query(n(nosql.Regexp, nosql.String("^[0-9]$"))))
+
+	// index must follow updates
+	err = db.Update("col", nosql.Key{"a"}).Inc("n", 5).Do(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "a"}, query(n(nosql.GTE, nosql.Int(3))))
+
+	cnt, err := db.Query("col").WithFields(n(nosql.LT, nosql.Int(100))).Limit(2).Count(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cnt)
+}
@@ -389,6 +389,9 @@ func (w *quadWriter) Close() error {
 }
 
 func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
+	if err := graph.CheckNoExpiry(deltas); err != nil {
+		return err
+	}
 	ctx := context.TODO()
 	ids := make(map[quad.Value]int)
 
@@ -710,7 +713,10 @@ func (qs *QuadStore) NameOf(v graph.Ref) (quad.Value, error) {
 		return val.(quad.Value), nil
 	}
 	nd, err := qs.db.FindByKey(context.TODO(), colNodes, hash.key())
-	if err != nil {
+	if err == nosql.ErrNotFound {
+		// ValueOf does not check if the node exists
+		return nil, nil
+	} else if err != nil {
 		clog.Errorf("couldn't retrieve node %v: %v", v, err)
 		return nil, err
 	}
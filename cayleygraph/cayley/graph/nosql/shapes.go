@@ -40,7 +40,7 @@ type Shape struct {
 }
 
 func (s Shape) BuildIterator(qs graph.QuadStore) iterator.Shape {
-	db, ok := qs.(*QuadStore)
+	db, ok := graph.Base(qs).(*QuadStore)
 	if !ok {
 		return iterator.NewError(fmt.Errorf("not a nosql database: %T", qs))
 	}
@@ -58,7 +58,7 @@ type Quads struct {
 }
 
 func (s Quads) BuildIterator(qs graph.QuadStore) iterator.Shape {
-	db, ok := qs.(*QuadStore)
+	db, ok := graph.Base(qs).(*QuadStore)
 	if !ok {
 		return iterator.NewError(fmt.Errorf("not a nosql database: %T", qs))
 	}
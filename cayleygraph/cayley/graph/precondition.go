@@ -0,0 +1,146 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cayleygraph/quad"
+)
+
+// Precondition is a condition that must hold for a transaction to be applied.
+// It is one of QuadExists, QuadNotExists, NoLinks or VersionEquals.
+type Precondition interface {
+	String() string
+	isPrecondition()
+}
+
+// QuadExists requires a quad to exist in the QuadStore.
+type QuadExists struct {
+	Quad quad.Quad
+}
+
+func (QuadExists) isPrecondition() {}
+
+func (c QuadExists) String() string {
+	return "exists " + c.Quad.String()
+}
+
+// QuadNotExists requires a quad to be missing from the QuadStore.
+type QuadNotExists struct {
+	Quad quad.Quad
+}
+
+func (QuadNotExists) isPrecondition() {}
+
+func (c QuadNotExists) String() string {
+	return "not exists " + c.Quad.String()
+}
+
+// NoLinks requires a node to have no quads in a given direction with a given predicate.
+// If the predicate is nil, the node must have no quads in that direction at all.
+//
+// For example, NoLinks{Node: n, Dir: quad.Subject, Via: p} means that n has no outgoing edges via p.
+type NoLinks struct {
+	Node quad.Value
+	Dir  quad.Direction
+	Via  quad.Value
+}
+
+func (NoLinks) isPrecondition() {}
+
+func (c NoLinks) String() string {
+	if c.Via == nil {
+		return fmt.Sprintf("no links of %v in %v", c.Node, c.Dir)
+	}
+	return fmt.Sprintf("no links of %v in %v via %v", c.Node, c.Dir, c.Via)
+}
+
+// VersionEquals requires the QuadStore to be at a given version, as returned by Version.
+type VersionEquals struct {
+	Version int64
+}
+
+func (VersionEquals) isPrecondition() {}
+
+func (c VersionEquals) String() string {
+	return fmt.Sprintf("version is %d", c.Version)
+}
+
+// ErrPreconditionFailed is stored in PreconditionError when a precondition does not hold.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// PreconditionError records a precondition of the transaction that was not met.
+type PreconditionError struct {
+	Precondition Precondition
+	Err          error
+}
+
+func (e *PreconditionError) Error() string {
+	return e.Precondition.String() + ": " + e.Err.Error()
+}
+
+// IsPreconditionFailed returns whether an error is a PreconditionError
+// with the Err field equal to ErrPreconditionFailed.
+func IsPreconditionFailed(err error) bool {
+	if err == ErrPreconditionFailed {
+		return true
+	}
+	pe, ok := err.(*PreconditionError)
+	return ok && pe.Err == ErrPreconditionFailed
+}
+
+// ConditionalWriter is an optional interface for QuadStores that can check preconditions
+// of a transaction atomically with applying its deltas.
+type ConditionalWriter interface {
+	// ApplyDeltasIf checks all preconditions and applies deltas only if all of them hold.
+	// Otherwise, it returns a PreconditionError for the first precondition that does not hold.
+	//
+	// Preconditions are checked against the state of the QuadStore before the deltas are applied.
+	ApplyDeltasIf(conds []Precondition, in []Delta, opts IgnoreOpts) error
+}
+
+// ApplyDeltasIf applies deltas only if all preconditions hold. It falls back to ApplyDeltas
+// if there are no preconditions.
+//
+// It returns ErrOperationNotSupported if the backend cannot check preconditions.
+func ApplyDeltasIf(qs QuadStore, conds []Precondition, in []Delta, opts IgnoreOpts) error {
+	if len(conds) == 0 {
+		return qs.ApplyDeltas(in, opts)
+	}
+	if w, ok := Unwrap(qs).(ConditionalWriter); ok {
+		return w.ApplyDeltasIf(conds, in, opts)
+	}
+	return ErrOperationNotSupported
+}
+
+// Versioner is an optional interface for QuadStores that track a version of the data.
+type Versioner interface {
+	// Version returns the current version of the QuadStore.
+	// The version changes every time a transaction is applied.
+	Version(ctx context.Context) (int64, error)
+}
+
+// Version returns the current version of the QuadStore, that can be used in VersionEquals.
+//
+// It returns ErrOperationNotSupported if the backend does not track versions.
+func Version(ctx context.Context, qs QuadStore) (int64, error) {
+	if v, ok := Unwrap(qs).(Versioner); ok {
+		return v.Version(ctx)
+	}
+	return 0, ErrOperationNotSupported
+}
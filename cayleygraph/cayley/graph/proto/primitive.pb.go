@@ -86,6 +86,11 @@ type Primitive struct {
 	Timestamp int64  `protobuf:"varint,7,opt,name=Timestamp,json=timestamp,proto3" json:"Timestamp,omitempty"`
 	Value     []byte `protobuf:"bytes,8,opt,name=Value,json=value,proto3" json:"Value,omitempty"`
 	Deleted   bool   `protobuf:"varint,9,opt,name=Deleted,json=deleted,proto3" json:"Deleted,omitempty"`
+	DeletedAt int64  `protobuf:"varint,10,opt,name=DeletedAt,json=deletedAt,proto3" json:"DeletedAt,omitempty"`
+	// DeletedHorizon is the log horizon at the time of deletion.
+	DeletedHorizon uint64 `protobuf:"varint,11,opt,name=DeletedHorizon,json=deletedHorizon,proto3" json:"DeletedHorizon,omitempty"`
+	// Expires is the expiration time of the quad in Unix nanoseconds, or zero if it never expires.
+	Expires int64 `protobuf:"varint,12,opt,name=Expires,json=expires,proto3" json:"Expires,omitempty"`
 }
 
 func (m *Primitive) Reset()                    { *m = Primitive{} }
@@ -156,6 +161,27 @@ func (m *Primitive) GetDeleted() bool {
 	return false
 }
 
+func (m *Primitive) GetDeletedAt() int64 {
+	if m != nil {
+		return m.DeletedAt
+	}
+	return 0
+}
+
+func (m *Primitive) GetDeletedHorizon() uint64 {
+	if m != nil {
+		return m.DeletedHorizon
+	}
+	return 0
+}
+
+func (m *Primitive) GetExpires() int64 {
+	if m != nil {
+		return m.Expires
+	}
+	return 0
+}
+
 func init() {
 	proto1.RegisterType((*Primitive)(nil), "proto.Primitive")
 	proto1.RegisterEnum("proto.PrimitiveType", PrimitiveType_name, PrimitiveType_value)
@@ -226,6 +252,21 @@ func (m *Primitive) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i++
 	}
+	if m.DeletedAt != 0 {
+		dAtA[i] = 0x50
+		i++
+		i = encodeVarintPrimitive(dAtA, i, uint64(m.DeletedAt))
+	}
+	if m.DeletedHorizon != 0 {
+		dAtA[i] = 0x58
+		i++
+		i = encodeVarintPrimitive(dAtA, i, uint64(m.DeletedHorizon))
+	}
+	if m.Expires != 0 {
+		dAtA[i] = 0x60
+		i++
+		i = encodeVarintPrimitive(dAtA, i, uint64(m.Expires))
+	}
 	return i, nil
 }
 
@@ -287,6 +328,15 @@ func (m *Primitive) ProtoSize() (n int) {
 	if m.Deleted {
 		n += 2
 	}
+	if m.DeletedAt != 0 {
+		n += 1 + sovPrimitive(uint64(m.DeletedAt))
+	}
+	if m.DeletedHorizon != 0 {
+		n += 1 + sovPrimitive(uint64(m.DeletedHorizon))
+	}
+	if m.Expires != 0 {
+		n += 1 + sovPrimitive(uint64(m.Expires))
+	}
 	return n
 }
 
@@ -516,6 +566,63 @@ func (m *Primitive) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.Deleted = bool(v != 0)
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DeletedAt", wireType)
+			}
+			m.DeletedAt = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPrimitive
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.DeletedAt |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DeletedHorizon", wireType)
+			}
+			m.DeletedHorizon = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPrimitive
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.DeletedHorizon |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Expires", wireType)
+			}
+			m.Expires = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPrimitive
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Expires |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPrimitive(dAtA[iNdEx:])
@@ -645,28 +752,28 @@ var (
 func init() { proto1.RegisterFile("primitive.proto", fileDescriptorPrimitive) }
 
 var fileDescriptorPrimitive = []byte{
-	// 357 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x3c, 0x91, 0xdf, 0x4a, 0xeb, 0x30,
-	0x1c, 0xc7, 0x4f, 0xfa, 0xbf, 0x61, 0x3b, 0x27, 0x84, 0x83, 0x84, 0x21, 0xa3, 0x78, 0x55, 0x04,
-	0xb7, 0x0b, 0x9f, 0x60, 0xa3, 0x73, 0x14, 0xbb, 0x76, 0xa4, 0x41, 0xf0, 0x4a, 0xda, 0x2e, 0xce,
-	0x4a, 0x4b, 0xcb, 0x96, 0x0e, 0xbc, 0xf6, 0x45, 0x7c, 0x1c, 0x2f, 0x7d, 0x86, 0x79, 0xe9, 0x4b,
-	0x48, 0x52, 0xe7, 0x55, 0xf2, 0xf9, 0xfe, 0xf8, 0x7c, 0x93, 0x10, 0xf8, 0xaf, 0xdd, 0x95, 0x75,
-	0x29, 0xca, 0x03, 0x9f, 0xb4, 0xbb, 0x46, 0x34, 0xd8, 0x54, 0xcb, 0xe8, 0x6a, 0x5b, 0x8a, 0xa7,
-	0x2e, 0x9f, 0x14, 0x4d, 0x3d, 0xdd, 0x36, 0xdb, 0x66, 0xaa, 0xe2, 0xbc, 0x7b, 0x54, 0xa4, 0x40,
-	0xed, 0x7a, 0xeb, 0xe2, 0x0b, 0x40, 0x77, 0x7d, 0x6a, 0xc2, 0x7f, 0xa1, 0x16, 0x06, 0x04, 0x78,
-	0xc0, 0x37, 0xa8, 0x56, 0x06, 0x98, 0x40, 0x3b, 0xed, 0xf2, 0x67, 0x5e, 0x08, 0xa2, 0xa9, 0xd0,
-	0xde, 0xf7, 0x88, 0xcf, 0xa5, 0xc6, 0x37, 0x65, 0x91, 0x09, 0x4e, 0x74, 0x35, 0x73, 0xdb, 0x53,
-	0x80, 0xcf, 0xa0, 0x95, 0xf4, 0x9a, 0xa1, 0x46, 0x56, 0xd3, 0x5b, 0xff, 0xa1, 0x19, 0x65, 0x39,
-	0xaf, 0x88, 0xa9, 0x62, 0xb3, 0x92, 0x80, 0x47, 0xd0, 0xa1, 0xbc, 0xad, 0xb2, 0x82, 0xef, 0x89,
-	0xa5, 0x06, 0xce, 0xee, 0x87, 0xe5, 0x39, 0xac, 0xac, 0xf9, 0x5e, 0x64, 0x75, 0x4b, 0x6c, 0x0f,
-	0xf8, 0x3a, 0x75, 0xc5, 0x29, 0x90, 0x7d, 0x77, 0x59, 0xd5, 0x71, 0xe2, 0x78, 0xc0, 0x1f, 0x50,
-	0xf3, 0x20, 0x41, 0xde, 0x3a, 0xe0, 0x15, 0x17, 0x7c, 0x43, 0x5c, 0x0f, 0xf8, 0x0e, 0xb5, 0x37,
-	0x3d, 0x5e, 0xbe, 0x02, 0x38, 0xfc, 0x7d, 0x2d, 0x7b, 0x69, 0x39, 0x76, 0xa0, 0x11, 0x85, 0xf1,
-	0x2d, 0xfa, 0x83, 0x6d, 0xa8, 0x87, 0x34, 0x44, 0x00, 0x43, 0x68, 0xa5, 0x8c, 0x86, 0xf1, 0x12,
-	0x69, 0xd8, 0x85, 0xe6, 0x3c, 0x4e, 0x82, 0x05, 0xd2, 0xf1, 0x10, 0xba, 0xec, 0x7e, 0xbd, 0x08,
-	0x1e, 0x52, 0x46, 0x91, 0x81, 0x07, 0xd0, 0x89, 0x66, 0xf1, 0x52, 0x91, 0xa9, 0xe4, 0x98, 0x21,
-	0x4b, 0x0a, 0x37, 0x51, 0x32, 0x63, 0xc8, 0x96, 0xd5, 0xf3, 0x24, 0x89, 0x90, 0xa3, 0xd4, 0x70,
-	0xb5, 0x48, 0xd9, 0x6c, 0xb5, 0x46, 0xee, 0x7c, 0xf0, 0x7e, 0x1c, 0x83, 0x8f, 0xe3, 0x18, 0xbc,
-	0x7d, 0x8e, 0x41, 0x6e, 0xa9, 0x8f, 0xb8, 0xfe, 0x0e, 0x00, 0x00, 0xff, 0xff, 0x0a, 0x44, 0x49,
-	0x16, 0xd1, 0x01, 0x00, 0x00,
+	// 365 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x3c, 0x91, 0xcd, 0xaa, 0xd3, 0x40,
+	0x1c, 0xc5, 0x9d, 0x7c, 0xe7, 0x4f, 0xaf, 0x0e, 0x83, 0xc8, 0x70, 0x91, 0x12, 0x5c, 0x05, 0xc1,
+	0x7b, 0x17, 0x3e, 0x41, 0x4a, 0x6a, 0x09, 0xa6, 0x49, 0x99, 0x0c, 0x82, 0x2b, 0xc9, 0xc7, 0x58,
+	0x23, 0x09, 0x09, 0xed, 0xa4, 0xe0, 0xda, 0x95, 0x6f, 0xe1, 0xe3, 0xb8, 0xf4, 0x19, 0xea, 0x8b,
+	0xc8, 0x4c, 0x9a, 0xbb, 0x4a, 0x7e, 0xe7, 0xf0, 0x3b, 0x09, 0x33, 0xf0, 0x62, 0x3c, 0xb5, 0x7d,
+	0x2b, 0xdb, 0x8b, 0x78, 0x18, 0x4f, 0x83, 0x1c, 0x88, 0xad, 0x1f, 0xf7, 0xef, 0x8e, 0xad, 0xfc,
+	0x36, 0x55, 0x0f, 0xf5, 0xd0, 0x3f, 0x1e, 0x87, 0xe3, 0xf0, 0xa8, 0xe3, 0x6a, 0xfa, 0xaa, 0x49,
+	0x83, 0x7e, 0x9b, 0xad, 0x37, 0xbf, 0x0c, 0xf0, 0x0f, 0xcb, 0x12, 0x79, 0x0e, 0x46, 0x12, 0x53,
+	0x14, 0xa0, 0xd0, 0x62, 0x46, 0x1b, 0x13, 0x0a, 0x6e, 0x31, 0x55, 0xdf, 0x45, 0x2d, 0xa9, 0xa1,
+	0x43, 0xf7, 0x3c, 0x23, 0x79, 0xad, 0x34, 0xd1, 0xb4, 0x75, 0x29, 0x05, 0x35, 0x75, 0xe7, 0x8f,
+	0x4b, 0x40, 0x5e, 0x81, 0x93, 0xcf, 0x9a, 0xa5, 0x2b, 0x67, 0x98, 0xad, 0x97, 0x60, 0xa7, 0x65,
+	0x25, 0x3a, 0x6a, 0xeb, 0xd8, 0xee, 0x14, 0x90, 0x7b, 0xf0, 0x98, 0x18, 0xbb, 0xb2, 0x16, 0x67,
+	0xea, 0xe8, 0xc2, 0x3b, 0xdd, 0x58, 0x7d, 0x87, 0xb7, 0xbd, 0x38, 0xcb, 0xb2, 0x1f, 0xa9, 0x1b,
+	0xa0, 0xd0, 0x64, 0xbe, 0x5c, 0x02, 0xb5, 0xf7, 0xa9, 0xec, 0x26, 0x41, 0xbd, 0x00, 0x85, 0x2b,
+	0x66, 0x5f, 0x14, 0xa8, 0xbf, 0x8e, 0x45, 0x27, 0xa4, 0x68, 0xa8, 0x1f, 0xa0, 0xd0, 0x63, 0x6e,
+	0x33, 0xa3, 0x5a, 0xbb, 0x35, 0x91, 0xa4, 0x30, 0xaf, 0x35, 0x4b, 0xf0, 0xf6, 0x27, 0x82, 0xbb,
+	0xa7, 0xb3, 0xe0, 0x3f, 0x46, 0x41, 0x3c, 0xb0, 0xd2, 0x24, 0xfb, 0x88, 0x9f, 0x11, 0x17, 0xcc,
+	0x84, 0x25, 0x18, 0x11, 0x00, 0xa7, 0xe0, 0x2c, 0xc9, 0x76, 0xd8, 0x20, 0x3e, 0xd8, 0x9b, 0x2c,
+	0x8f, 0xb7, 0xd8, 0x24, 0x77, 0xe0, 0xf3, 0xcf, 0x87, 0x6d, 0xfc, 0xa5, 0xe0, 0x0c, 0x5b, 0x64,
+	0x05, 0x5e, 0x1a, 0x65, 0x3b, 0x4d, 0xb6, 0x96, 0x33, 0x8e, 0x1d, 0x25, 0x7c, 0x48, 0xf3, 0x88,
+	0x63, 0x57, 0x4d, 0x6f, 0xf2, 0x3c, 0xc5, 0x9e, 0x56, 0x93, 0xfd, 0xb6, 0xe0, 0xd1, 0xfe, 0x80,
+	0xfd, 0xcd, 0xea, 0xcf, 0x75, 0x8d, 0xfe, 0x5e, 0xd7, 0xe8, 0xf7, 0xbf, 0x35, 0xaa, 0x1c, 0x7d,
+	0x4d, 0xef, 0xff, 0x0f, 0x00, 0x29, 0x97, 0x6a, 0x3e, 0xef, 0x01, 0x00, 0x00,
 }
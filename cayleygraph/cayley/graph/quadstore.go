@@ -26,6 +26,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/cayleygraph/cayley/graph/iterator"
 	"github.com/cayleygraph/cayley/graph/refs"
@@ -119,8 +120,68 @@ type QuadStore interface {
 	Close() error
 }
 
+// Snapshotter is an optional interface for QuadStores that can provide
+// a consistent point-in-time view of the data.
+type Snapshotter interface {
+	// Snapshot opens a read-only view of the QuadStore. Iterators, NameOf, ValueOf
+	// and Stats of the view ignore all writes committed after this call.
+	//
+	// Caller must close the snapshot to release resources held by it.
+	Snapshot(ctx context.Context) (QuadStore, error)
+}
+
+// Snapshot opens a consistent read-only view of the QuadStore.
+//
+// It returns ErrOperationNotSupported if the backend cannot provide snapshots.
+func Snapshot(ctx context.Context, qs QuadStore) (QuadStore, error) {
+	if s, ok := Unwrap(qs).(Snapshotter); ok {
+		return s.Snapshot(ctx)
+	}
+	return nil, ErrOperationNotSupported
+}
+
+// TimeTraveler is an optional interface for QuadStores that keep the history of changes.
+type TimeTraveler interface {
+	// AsOf returns a read-only view of the QuadStore as it was at a given time.
+	//
+	// The view shares resources with the QuadStore and closing it is optional.
+	AsOf(ctx context.Context, t time.Time) (QuadStore, error)
+}
+
+// AsOf returns a read-only view of the QuadStore as it was at a given time.
+//
+// It returns ErrOperationNotSupported if the backend does not keep the history.
+func AsOf(ctx context.Context, qs QuadStore, t time.Time) (QuadStore, error) {
+	if s, ok := Unwrap(qs).(TimeTraveler); ok {
+		return s.AsOf(ctx, t)
+	}
+	return nil, ErrOperationNotSupported
+}
+
 type Options map[string]interface{}
 
+// AsOptions converts a nested object of the configuration to Options.
+// Besides Options, it accepts string-keyed maps, and maps with interface keys returned by the YAML decoder.
+func AsOptions(o interface{}) (Options, bool) {
+	switch o := o.(type) {
+	case Options:
+		return o, true
+	case map[string]interface{}:
+		return o, true
+	case map[interface{}]interface{}:
+		m := make(Options, len(o))
+		for k, v := range o {
+			s, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			m[s] = v
+		}
+		return m, true
+	}
+	return nil, false
+}
+
 var (
 	typeInt = reflect.TypeOf(int(0))
 )
@@ -165,4 +226,54 @@ func (d Options) BoolKey(key string, def bool) (bool, error) {
 var (
 	ErrDatabaseExists = errors.New("quadstore: cannot init; database already exists")
 	ErrNotInitialized = errors.New("quadstore: not initialized")
+	ErrReadOnly       = errors.New("quadstore: read-only")
 )
+
+// QuadChecker is an optional interface for QuadStores that can find a quad by its values directly,
+// instead of iterating over quads of its nodes.
+type QuadChecker interface {
+	// HasQuad checks if the QuadStore contains a given quad.
+	// It may return ErrOperationNotSupported, in which case quads of its nodes are iterated instead.
+	HasQuad(ctx context.Context, q quad.Quad) (bool, error)
+}
+
+// HasQuad checks if the QuadStore contains a given quad.
+//
+// Backends that do not implement QuadChecker are checked by iterating over quads that share all nodes with it.
+func HasQuad(ctx context.Context, qs QuadStore, q quad.Quad) (bool, error) {
+	if c, ok := Unwrap(qs).(QuadChecker); ok {
+		ok, err := c.HasQuad(ctx, q)
+		if err != ErrOperationNotSupported {
+			return ok, err
+		}
+	}
+	var its []iterator.Shape
+	for _, d := range quad.Directions {
+		v := q.Get(d)
+		if v == nil {
+			continue
+		}
+		ref, err := qs.ValueOf(v)
+		if err != nil {
+			return false, err
+		} else if ref == nil {
+			return false, nil
+		}
+		its = append(its, qs.QuadIterator(d, ref))
+	}
+	if len(its) == 0 {
+		return false, nil
+	}
+	s, _ := iterator.NewAnd(its...).Optimize(ctx)
+	it := s.Iterate()
+	defer it.Close()
+	for it.Next(ctx) {
+		q2, err := qs.Quad(it.Result())
+		if err != nil {
+			return false, err
+		} else if q2 == q {
+			return true, nil
+		}
+	}
+	return false, it.Err()
+}
@@ -23,8 +23,10 @@ package graph
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
+	"time"
 
 	"github.com/cayleygraph/cayley/graph/iterator"
 	"github.com/cayleygraph/quad"
@@ -43,6 +45,28 @@ func (p Procedure) String() string {
 	}
 }
 
+// MarshalText implements encoding.TextMarshaler.
+func (p Procedure) MarshalText() ([]byte, error) {
+	switch p {
+	case Add, Delete:
+		return []byte(p.String()), nil
+	}
+	return nil, ErrInvalidAction
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Procedure) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "add":
+		*p = Add
+	case "delete":
+		*p = Delete
+	default:
+		return ErrInvalidAction
+	}
+	return nil
+}
+
 // The different types of actions a transaction can do.
 const (
 	Add    Procedure = +1
@@ -50,8 +74,28 @@ const (
 )
 
 type Delta struct {
-	Quad   quad.Quad
-	Action Procedure
+	Quad   quad.Quad `json:"quad"`
+	Action Procedure `json:"action"`
+	// Expires is an optional expiration time of an added quad. Adding a quad that already exists
+	// with the expiration time set is not an error, and replaces the expiration time of the quad.
+	// Adding a quad without the expiration time over an expiring one makes it permanent.
+	// It is ignored for other actions.
+	//
+	// Backends that do not implement Expirer reject deltas with the expiration time set.
+	Expires time.Time `json:"expires"`
+}
+
+// MarshalJSON omits the expiration time if it is not set.
+func (d Delta) MarshalJSON() ([]byte, error) {
+	type delta Delta // no methods
+	out := struct {
+		delta
+		Expires *time.Time `json:"expires,omitempty"`
+	}{delta: delta(d)}
+	if !d.Expires.IsZero() {
+		out.Expires = &d.Expires
+	}
+	return json.Marshal(out)
 }
 
 // Unwrap returns an original QuadStore value if it was wrapped by Handle.
@@ -64,6 +108,25 @@ func Unwrap(qs QuadStore) QuadStore {
 	return qs
 }
 
+// Wrapper is an optional interface for QuadStores that add features to another QuadStore.
+type Wrapper interface {
+	// Underlying returns the wrapped QuadStore.
+	Underlying() QuadStore
+}
+
+// Base returns the QuadStore of the backend, removing the Handle and all wrappers.
+// Shapes and tools that work only with a specific backend use it instead of Unwrap.
+func Base(qs QuadStore) QuadStore {
+	for {
+		qs = Unwrap(qs)
+		w, ok := qs.(Wrapper)
+		if !ok {
+			return qs
+		}
+		qs = w.Underlying()
+	}
+}
+
 type Handle struct {
 	QuadStore
 	QuadWriter
@@ -79,6 +142,12 @@ func (h *Handle) Close() error {
 	return err
 }
 
+// Watch opens a feed of changes committed to the QuadStore after a given position.
+// See Watcher for details.
+func (h *Handle) Watch(ctx context.Context, from int64) (ChangeFeed, error) {
+	return Watch(ctx, h.QuadStore, from)
+}
+
 var (
 	ErrQuadExists    = errors.New("quad exists")
 	ErrQuadNotExist  = errors.New("quad does not exist")
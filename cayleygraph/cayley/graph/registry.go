@@ -102,3 +102,27 @@ func QuadStores() []string {
 	sort.Strings(t)
 	return t
 }
+
+// UnionFunc creates a read-only QuadStore that merges quads of a number of QuadStores.
+type UnionFunc func(members ...QuadStore) QuadStore
+
+var unionFunc UnionFunc
+
+// RegisterUnion sets the implementation of Union. It is called by the graph/union package.
+func RegisterUnion(fnc UnionFunc) {
+	if unionFunc != nil {
+		panic("already registered Union")
+	}
+	unionFunc = fnc
+}
+
+// Union creates a read-only QuadStore that merges quads of a number of QuadStores with the same value semantics.
+// Quads that are present in more than one member are returned only once.
+//
+// The graph/union package must be imported to register the implementation; graph/all imports it as well.
+func Union(members ...QuadStore) (QuadStore, error) {
+	if unionFunc == nil {
+		return nil, ErrQuadStoreNotRegistred
+	}
+	return unionFunc(members...), nil
+}
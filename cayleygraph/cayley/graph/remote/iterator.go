@@ -0,0 +1,332 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/cayleygraph/quad"
+
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/graph/refs"
+)
+
+var (
+	_ iterator.Shape = (*quadsIterator)(nil)
+	_ iterator.Shape = (*nodesIterator)(nil)
+)
+
+// quadsIterator streams quads with a given value in a given direction from the server.
+// If the value is nil, all quads are returned.
+type quadsIterator struct {
+	qs   *QuadStore
+	dir  quad.Direction
+	val  quad.Value
+	size *refs.Size // cached
+}
+
+func newQuadsIterator(qs *QuadStore, d quad.Direction, v quad.Value) *quadsIterator {
+	return &quadsIterator{qs: qs, dir: d, val: v}
+}
+
+func (it *quadsIterator) Iterate() iterator.Scanner {
+	return &quadsNext{qs: it.qs, dir: it.dir, val: it.val}
+}
+
+func (it *quadsIterator) Lookup() iterator.Index {
+	return &quadsContains{dir: it.dir, val: it.val, h: refs.HashOf(it.val)}
+}
+
+func (it *quadsIterator) SubIterators() []iterator.Shape {
+	return nil
+}
+
+func (it *quadsIterator) Optimize(ctx context.Context) (iterator.Shape, bool) {
+	return it, false
+}
+
+// Stats asks the server for the size of the index. Contains checks are done locally, thus they are cheap
+// and the optimizer will prefer to send a request only for the most selective index.
+func (it *quadsIterator) Stats(ctx context.Context) (iterator.Costs, error) {
+	if it.size == nil {
+		var ref refs.Ref
+		if it.val != nil {
+			ref = refs.PreFetched(it.val)
+		}
+		sz, err := it.qs.QuadIteratorSize(ctx, it.dir, ref)
+		if err != nil {
+			return iterator.Costs{}, err
+		}
+		it.size = &sz
+	}
+	return iterator.Costs{
+		NextCost:     2,
+		ContainsCost: 1,
+		Size:         *it.size,
+	}, nil
+}
+
+func (it *quadsIterator) String() string {
+	if it.val == nil {
+		return "RemoteQuadsAll"
+	}
+	return fmt.Sprintf("RemoteQuads(%v, %v)", it.dir, it.val)
+}
+
+type quadsNext struct {
+	qs     *QuadStore
+	dir    quad.Direction
+	val    quad.Value
+	r      quad.ReadCloser
+	done   bool
+	result refs.Ref
+	err    error
+}
+
+func (it *quadsNext) TagResults(dst map[string]refs.Ref) {}
+
+func (it *quadsNext) Next(ctx context.Context) bool {
+	it.result = nil
+	if it.done || it.err != nil {
+		return false
+	}
+	if it.r == nil {
+		it.r, it.err = it.qs.openQuads(ctx, it.dir, it.val)
+		if it.err != nil {
+			return false
+		}
+	}
+	q, err := it.r.ReadQuad()
+	if err == io.EOF {
+		it.done = true
+		return false
+	} else if err != nil {
+		it.err = err
+		return false
+	}
+	it.result = newQuadRef(q)
+	return true
+}
+
+func (it *quadsNext) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *quadsNext) Result() refs.Ref {
+	return it.result
+}
+
+func (it *quadsNext) Err() error {
+	return it.err
+}
+
+func (it *quadsNext) Close() error {
+	it.done = true
+	if it.r == nil {
+		return nil
+	}
+	err := it.r.Close()
+	it.r = nil
+	return err
+}
+
+func (it *quadsNext) String() string {
+	return "RemoteQuadsNext"
+}
+
+// quadsContains checks quad refs locally, since they carry quads.
+type quadsContains struct {
+	dir    quad.Direction
+	val    quad.Value
+	h      refs.ValueHash
+	result refs.Ref
+}
+
+func (it *quadsContains) TagResults(dst map[string]refs.Ref) {}
+
+func (it *quadsContains) Contains(ctx context.Context, v refs.Ref) bool {
+	it.result = nil
+	r, ok := v.(quadRef)
+	if !ok {
+		return false
+	} else if it.val != nil && r.h.Get(it.dir) != it.h {
+		return false
+	}
+	it.result = r
+	return true
+}
+
+func (it *quadsContains) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *quadsContains) Result() refs.Ref {
+	return it.result
+}
+
+func (it *quadsContains) Err() error {
+	return nil
+}
+
+func (it *quadsContains) Close() error {
+	return nil
+}
+
+func (it *quadsContains) String() string {
+	return "RemoteQuadsContains"
+}
+
+// nodesIterator streams all nodes from the server.
+type nodesIterator struct {
+	qs *QuadStore
+}
+
+func newNodesIterator(qs *QuadStore) *nodesIterator {
+	return &nodesIterator{qs: qs}
+}
+
+func (it *nodesIterator) Iterate() iterator.Scanner {
+	return &nodesNext{qs: it.qs}
+}
+
+func (it *nodesIterator) Lookup() iterator.Index {
+	return &nodesContains{qs: it.qs}
+}
+
+func (it *nodesIterator) SubIterators() []iterator.Shape {
+	return nil
+}
+
+func (it *nodesIterator) Optimize(ctx context.Context) (iterator.Shape, bool) {
+	return it, false
+}
+
+func (it *nodesIterator) Stats(ctx context.Context) (iterator.Costs, error) {
+	st, err := it.qs.Stats(ctx, false)
+	return iterator.Costs{
+		NextCost:     2,
+		ContainsCost: 10, // a request per check
+		Size:         st.Nodes,
+	}, err
+}
+
+func (it *nodesIterator) String() string {
+	return "RemoteNodesAll"
+}
+
+type nodesNext struct {
+	qs     *QuadStore
+	rc     io.ReadCloser
+	r      *bufio.Reader
+	done   bool
+	result refs.Ref
+	err    error
+}
+
+func (it *nodesNext) TagResults(dst map[string]refs.Ref) {}
+
+func (it *nodesNext) Next(ctx context.Context) bool {
+	it.result = nil
+	if it.done || it.err != nil {
+		return false
+	}
+	if it.rc == nil {
+		it.rc, it.err = it.qs.openNodes(ctx, nil)
+		if it.err != nil {
+			return false
+		}
+		it.r = bufio.NewReader(it.rc)
+	}
+	v, err := ReadValue(it.r)
+	if err == io.EOF {
+		it.done = true
+		return false
+	} else if err != nil {
+		it.err = err
+		return false
+	}
+	it.result = refs.PreFetched(v)
+	return true
+}
+
+func (it *nodesNext) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *nodesNext) Result() refs.Ref {
+	return it.result
+}
+
+func (it *nodesNext) Err() error {
+	return it.err
+}
+
+func (it *nodesNext) Close() error {
+	it.done = true
+	if it.rc == nil {
+		return nil
+	}
+	err := it.rc.Close()
+	it.rc, it.r = nil, nil
+	return err
+}
+
+func (it *nodesNext) String() string {
+	return "RemoteNodesAllNext"
+}
+
+type nodesContains struct {
+	qs     *QuadStore
+	result refs.Ref
+	err    error
+}
+
+func (it *nodesContains) TagResults(dst map[string]refs.Ref) {}
+
+func (it *nodesContains) Contains(ctx context.Context, v refs.Ref) bool {
+	it.result = nil
+	ref, err := it.qs.valueOf(ctx, valueOfRef(v))
+	if err != nil {
+		it.err = err
+		return false
+	} else if ref == nil {
+		return false
+	}
+	it.result = ref
+	return true
+}
+
+func (it *nodesContains) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *nodesContains) Result() refs.Ref {
+	return it.result
+}
+
+func (it *nodesContains) Err() error {
+	return it.err
+}
+
+func (it *nodesContains) Close() error {
+	return nil
+}
+
+func (it *nodesContains) String() string {
+	return "RemoteNodesAllContains"
+}
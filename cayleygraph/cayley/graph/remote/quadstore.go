@@ -0,0 +1,388 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remote implements a QuadStore backed by a Cayley server, accessed through the HTTP API.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/graph/refs"
+)
+
+const QuadStoreType = "remote"
+
+const (
+	pathStats  = "/api/v2/stats"
+	pathQuads  = "/api/v2/index/quads"
+	pathNodes  = "/api/v2/index/nodes"
+	pathSize   = "/api/v2/index/size"
+	pathDeltas = "/api/v2/index/deltas"
+)
+
+func init() {
+	graph.RegisterQuadStore(QuadStoreType, graph.QuadStoreRegistration{
+		NewFunc: func(addr string, _ graph.Options) (graph.QuadStore, error) {
+			return Dial(context.Background(), addr, nil)
+		},
+		// the address of the server is passed as a path
+		IsPersistent: true,
+	})
+}
+
+var _ graph.QuadStore = (*QuadStore)(nil)
+
+// QuadStore forwards all requests to a Cayley server.
+//
+// Node refs carry values and quad refs carry quads, thus NameOf, Quad and QuadDirection
+// do not send any requests, and Contains checks of quad iterators are done locally.
+type QuadStore struct {
+	addr string
+	cli  *http.Client
+}
+
+// Dial connects to the Cayley server with a given address. Default HTTP client is used, if cli is nil.
+func Dial(ctx context.Context, addr string, cli *http.Client) (*QuadStore, error) {
+	addr = strings.TrimSuffix(addr, "/")
+	if addr == "" {
+		return nil, errors.New("remote: server address is not set")
+	} else if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+	qs := &QuadStore{addr: addr, cli: cli}
+	// check that the server supports the index API
+	if _, err := qs.QuadIteratorSize(ctx, quad.Any, nil); err != nil {
+		return nil, fmt.Errorf("remote: cannot connect to %s: %v", addr, err)
+	}
+	return qs, nil
+}
+
+// Addr returns the address of the server.
+func (qs *QuadStore) Addr() string {
+	return qs.addr
+}
+
+func (qs *QuadStore) url(path string, params url.Values) string {
+	addr := qs.addr + path
+	if len(params) != 0 {
+		addr += "?" + params.Encode()
+	}
+	return addr
+}
+
+// responseError reads an error returned by the server.
+func responseError(resp *http.Response) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var e struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &e); err == nil && e.Error != "" {
+		if resp.StatusCode == http.StatusForbidden {
+			return graph.ErrReadOnly
+		}
+		return fmt.Errorf("remote: %s", e.Error)
+	}
+	return fmt.Errorf("remote: request failed: %s", resp.Status)
+}
+
+// get sends a GET request and checks the response status. Caller must close the response body.
+func (qs *QuadStore) get(ctx context.Context, path string, params url.Values) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, qs.url(path, params), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := qs.cli.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+func (qs *QuadStore) getJSON(ctx context.Context, path string, params url.Values, out interface{}) error {
+	resp, err := qs.get(ctx, path, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// indexParams returns request parameters for an index of a given direction and value.
+func indexParams(d quad.Direction, v quad.Value) (url.Values, error) {
+	if v == nil {
+		return nil, nil
+	}
+	s, err := EncodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return url.Values{"dir": {d.String()}, "value": {s}}, nil
+}
+
+// openQuads starts reading quads with a value in a given direction, or all quads if the value is nil.
+func (qs *QuadStore) openQuads(ctx context.Context, d quad.Direction, v quad.Value) (quad.ReadCloser, error) {
+	params, err := indexParams(d, v)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := qs.get(ctx, pathQuads, params)
+	if err != nil {
+		return nil, err
+	}
+	r := pquads.NewReader(resp.Body, pquads.DefaultMaxSize)
+	r.SetCloser(resp.Body)
+	return r, nil
+}
+
+// openNodes starts reading all nodes, or only a given node, if it exists.
+func (qs *QuadStore) openNodes(ctx context.Context, v quad.Value) (io.ReadCloser, error) {
+	var params url.Values
+	if v != nil {
+		s, err := EncodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		params = url.Values{"value": {s}}
+	}
+	resp, err := qs.get(ctx, pathNodes, params)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (qs *QuadStore) valueOf(ctx context.Context, v quad.Value) (graph.Ref, error) {
+	if v == nil {
+		return nil, nil
+	}
+	rc, err := qs.openNodes(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	} else if len(data) == 0 {
+		return nil, nil
+	}
+	return refs.PreFetched(v), nil
+}
+
+func (qs *QuadStore) ValueOf(v quad.Value) (graph.Ref, error) {
+	return qs.valueOf(context.TODO(), v)
+}
+
+// valueOfRef returns a value of a node ref.
+func valueOfRef(ref graph.Ref) quad.Value {
+	if v, ok := ref.(refs.PreFetchedValue); ok {
+		return v.NameOf()
+	}
+	return nil
+}
+
+func (qs *QuadStore) NameOf(ref graph.Ref) (quad.Value, error) {
+	return valueOfRef(ref), nil
+}
+
+// quadRef carries a quad returned by the server.
+type quadRef struct {
+	q quad.Quad
+	h refs.QuadHash
+}
+
+func newQuadRef(q quad.Quad) quadRef {
+	return quadRef{q: q, h: refs.QuadHash{
+		Subject:   refs.HashOf(q.Subject),
+		Predicate: refs.HashOf(q.Predicate),
+		Object:    refs.HashOf(q.Object),
+		Label:     refs.HashOf(q.Label),
+	}}
+}
+
+func (r quadRef) Key() interface{} { return r.h }
+
+func (qs *QuadStore) Quad(ref graph.Ref) (quad.Quad, error) {
+	r, ok := ref.(quadRef)
+	if !ok {
+		return quad.Quad{}, nil
+	}
+	return r.q, nil
+}
+
+func (qs *QuadStore) QuadDirection(ref graph.Ref, d quad.Direction) (graph.Ref, error) {
+	r, ok := ref.(quadRef)
+	if !ok {
+		return nil, nil
+	}
+	v := r.q.Get(d)
+	if v == nil {
+		return nil, nil
+	}
+	return refs.PreFetched(v), nil
+}
+
+func (qs *QuadStore) QuadIterator(d quad.Direction, ref graph.Ref) iterator.Shape {
+	v := valueOfRef(ref)
+	if v == nil {
+		return iterator.NewNull()
+	}
+	return newQuadsIterator(qs, d, v)
+}
+
+// QuadIteratorSize returns the size of the index on the server. If the value is nil, the number of all quads is returned.
+func (qs *QuadStore) QuadIteratorSize(ctx context.Context, d quad.Direction, ref graph.Ref) (refs.Size, error) {
+	var v quad.Value
+	if ref != nil {
+		if v = valueOfRef(ref); v == nil {
+			return refs.Size{Value: 0, Exact: true}, nil
+		}
+	}
+	params, err := indexParams(d, v)
+	if err != nil {
+		return refs.Size{}, err
+	}
+	var resp SizeResponse
+	if err = qs.getJSON(ctx, pathSize, params, &resp); err != nil {
+		return refs.Size{}, err
+	}
+	return refs.Size{Value: resp.Size, Exact: resp.Exact}, nil
+}
+
+func (qs *QuadStore) QuadsAllIterator() iterator.Shape {
+	return newQuadsIterator(qs, quad.Any, nil)
+}
+
+func (qs *QuadStore) NodesAllIterator() iterator.Shape {
+	return newNodesIterator(qs)
+}
+
+func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
+	var resp struct {
+		Nodes int64 `json:"nodes"`
+		Quads int64 `json:"quads"`
+	}
+	err := qs.getJSON(ctx, pathStats, url.Values{"exact": {strconv.FormatBool(exact)}}, &resp)
+	if err != nil {
+		return graph.Stats{}, err
+	}
+	return graph.Stats{
+		Nodes: refs.Size{Value: resp.Nodes, Exact: exact},
+		Quads: refs.Size{Value: resp.Quads, Exact: exact},
+	}, nil
+}
+
+// ApplyDeltas applies deltas on the server in a single transaction.
+func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
+	if len(in) == 0 {
+		return nil
+	}
+	req := DeltasRequest{
+		Deltas:        make([]Delta, 0, len(in)),
+		IgnoreDup:     opts.IgnoreDup,
+		IgnoreMissing: opts.IgnoreMissing,
+	}
+	for _, d := range in {
+		if d.Action != graph.Add && d.Action != graph.Delete {
+			return &graph.DeltaError{Delta: d, Err: graph.ErrInvalidAction}
+		}
+		wd, err := EncodeDelta(d)
+		if err != nil {
+			return err
+		}
+		req.Deltas = append(req.Deltas, wd)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := qs.cli.Post(qs.url(pathDeltas, nil), contentTypeJSON, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		var e DeltaErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+			return fmt.Errorf("remote: cannot decode error: %v", err)
+		}
+		derr := errorByCode(e.Code)
+		if derr == nil || e.Delta < 0 || e.Delta >= len(in) {
+			return fmt.Errorf("remote: %s", e.Error)
+		}
+		return &graph.DeltaError{Delta: in[e.Delta], Err: derr}
+	}
+	return responseError(resp)
+}
+
+const contentTypeJSON = "application/json"
+
+func (qs *QuadStore) NewQuadWriter() (quad.WriteCloser, error) {
+	return &quadWriter{qs: qs}, nil
+}
+
+type quadWriter struct {
+	qs     *QuadStore
+	deltas []graph.Delta
+}
+
+func (w *quadWriter) WriteQuad(q quad.Quad) error {
+	_, err := w.WriteQuads([]quad.Quad{q})
+	return err
+}
+
+func (w *quadWriter) WriteQuads(buf []quad.Quad) (int, error) {
+	w.deltas = w.deltas[:0]
+	for _, q := range buf {
+		w.deltas = append(w.deltas, graph.Delta{Quad: q, Action: graph.Add})
+	}
+	if err := w.qs.ApplyDeltas(w.deltas, graph.IgnoreOpts{IgnoreDup: true}); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (w *quadWriter) Close() error {
+	return nil
+}
+
+// Close does nothing, since the store holds no connections to the server except the ones managed by the HTTP client.
+func (qs *QuadStore) Close() error {
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/graphtest"
+	"github.com/cayleygraph/cayley/graph/memstore"
+	"github.com/cayleygraph/cayley/graph/remote"
+	"github.com/cayleygraph/cayley/query/path"
+	cayleyhttp "github.com/cayleygraph/cayley/server/http"
+	"github.com/cayleygraph/cayley/writer"
+	"github.com/cayleygraph/quad"
+)
+
+func makeServer(t testing.TB, quads ...quad.Quad) (*httptest.Server, *graph.Handle) {
+	qs := memstore.New(quads...)
+	qw, err := writer.NewSingleReplication(qs, nil)
+	require.NoError(t, err)
+	h := &graph.Handle{QuadStore: qs, QuadWriter: qw}
+	return httptest.NewServer(cayleyhttp.NewAPIv2(h)), h
+}
+
+func TestRemote(t *testing.T) {
+	graphtest.TestAll(t, func(t testing.TB) (graph.QuadStore, graph.Options, func()) {
+		srv, _ := makeServer(t)
+		qs, err := remote.Dial(context.Background(), srv.URL, nil)
+		require.NoError(t, err)
+		return qs, nil, func() {
+			qs.Close()
+			srv.Close()
+		}
+	}, &graphtest.Config{
+		AlwaysRunIntegration: true,
+	})
+}
+
+func TestRemotePath(t *testing.T) {
+	ctx := context.TODO()
+	srv, _ := makeServer(t,
+		quad.MakeIRI("alice", "follows", "bob", ""),
+		quad.MakeIRI("bob", "follows", "carol", ""),
+		quad.MakeIRI("carol", "status", "cool", ""),
+	)
+	defer srv.Close()
+
+	qs, err := graph.NewQuadStore(remote.QuadStoreType, srv.URL, nil)
+	require.NoError(t, err)
+	defer qs.Close()
+
+	p := path.StartPath(qs, quad.IRI("alice")).Out(quad.IRI("follows")).Out(quad.IRI("follows")).Has(quad.IRI("status"), quad.IRI("cool"))
+	vals, err := p.Iterate(ctx).AllValues(qs)
+	require.NoError(t, err)
+	require.Equal(t, []quad.Value{quad.IRI("carol")}, vals)
+}
+
+func TestRemoteDeltaError(t *testing.T) {
+	q := quad.MakeIRI("alice", "follows", "bob", "")
+	srv, _ := makeServer(t, q)
+	defer srv.Close()
+
+	qs, err := remote.Dial(context.Background(), srv.URL, nil)
+	require.NoError(t, err)
+	defer qs.Close()
+
+	err = qs.ApplyDeltas([]graph.Delta{
+		{Quad: quad.MakeIRI("bob", "follows", "alice", ""), Action: graph.Add},
+		{Quad: q, Action: graph.Add},
+	}, graph.IgnoreOpts{})
+	require.True(t, graph.IsQuadExist(err), "%v", err)
+	require.Equal(t, q, err.(*graph.DeltaError).Delta.Quad)
+
+	_, err = remote.Dial(context.Background(), srv.URL+"/nothing", nil)
+	require.Error(t, err)
+}
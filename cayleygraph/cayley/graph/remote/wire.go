@@ -0,0 +1,278 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+// This file defines the wire format of the index API of the HTTP server.
+// Values are encoded with pquads, thus types of values are preserved.
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+
+	"github.com/cayleygraph/cayley/graph"
+)
+
+// maxValueSize limits the size of a single value in a stream of values.
+const maxValueSize = 16 * 1024 * 1024
+
+// Error codes of DeltaErrorResponse.
+const (
+	CodeQuadExists    = "quad_exists"
+	CodeQuadNotExist  = "quad_not_exist"
+	CodeInvalidAction = "invalid_action"
+	CodeNotSupported  = "not_supported"
+	// CodeChangesCompacted is reported by the change feed if the requested position is no longer available.
+	CodeChangesCompacted = "changes_compacted"
+)
+
+// EncodeValue encodes a value to be passed as a request parameter.
+func EncodeValue(v quad.Value) (string, error) {
+	data, err := pquads.MarshalValue(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeValue decodes a value encoded by EncodeValue.
+func DecodeValue(s string) (quad.Value, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return pquads.UnmarshalValue(data)
+}
+
+// ParseDirection parses a name of the quad direction, as returned by quad.Direction.String.
+func ParseDirection(s string) (quad.Direction, error) {
+	switch s {
+	case "subject":
+		return quad.Subject, nil
+	case "predicate":
+		return quad.Predicate, nil
+	case "object":
+		return quad.Object, nil
+	case "label":
+		return quad.Label, nil
+	}
+	return quad.Any, fmt.Errorf("invalid direction: %q", s)
+}
+
+// WriteValue writes a value to a stream of values. Each value is prefixed with its size.
+func WriteValue(w io.Writer, v quad.Value) error {
+	data, err := pquads.MarshalValue(v)
+	if err != nil {
+		return err
+	}
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(data)))
+	if _, err = w.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadValue reads the next value written by WriteValue. It returns io.EOF at the end of the stream.
+func ReadValue(r *bufio.Reader) (quad.Value, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	} else if n > maxValueSize {
+		return nil, errors.New("value is too large")
+	}
+	data := make([]byte, n)
+	if _, err = io.ReadFull(r, data); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return pquads.UnmarshalValue(data)
+}
+
+// Delta is a graph.Delta with values encoded by EncodeValue.
+type Delta struct {
+	Action    graph.Procedure `json:"action"`
+	Subject   string          `json:"subject"`
+	Predicate string          `json:"predicate"`
+	Object    string          `json:"object"`
+	Label     string          `json:"label,omitempty"`
+	// Expires is an expiration time of the quad in RFC 3339 format.
+	Expires string `json:"expires,omitempty"`
+}
+
+// EncodeDelta encodes values of the delta.
+func EncodeDelta(d graph.Delta) (Delta, error) {
+	out := Delta{Action: d.Action}
+	for _, p := range []struct {
+		d   quad.Direction
+		dst *string
+	}{
+		{quad.Subject, &out.Subject},
+		{quad.Predicate, &out.Predicate},
+		{quad.Object, &out.Object},
+		{quad.Label, &out.Label},
+	} {
+		v := d.Quad.Get(p.d)
+		if v == nil {
+			continue
+		}
+		s, err := EncodeValue(v)
+		if err != nil {
+			return out, err
+		}
+		*p.dst = s
+	}
+	if !d.Expires.IsZero() {
+		out.Expires = d.Expires.Format(time.RFC3339Nano)
+	}
+	return out, nil
+}
+
+// Decode decodes values of the delta.
+func (d Delta) Decode() (graph.Delta, error) {
+	out := graph.Delta{Action: d.Action}
+	var err error
+	if out.Quad.Subject, err = DecodeValue(d.Subject); err != nil {
+		return out, err
+	}
+	if out.Quad.Predicate, err = DecodeValue(d.Predicate); err != nil {
+		return out, err
+	}
+	if out.Quad.Object, err = DecodeValue(d.Object); err != nil {
+		return out, err
+	}
+	if out.Quad.Label, err = DecodeValue(d.Label); err != nil {
+		return out, err
+	}
+	if d.Expires != "" {
+		if out.Expires, err = time.Parse(time.RFC3339Nano, d.Expires); err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// Change is a graph.Change with deltas encoded by EncodeDelta.
+// It is sent as a data of server-sent events by the change feed API.
+type Change struct {
+	Position int64     `json:"position"`
+	Time     time.Time `json:"time"`
+	Deltas   []Delta   `json:"deltas"`
+}
+
+// EncodeChange encodes values of all deltas of the change.
+func EncodeChange(c graph.Change) (Change, error) {
+	out := Change{Position: c.Position, Time: c.Time, Deltas: make([]Delta, 0, len(c.Deltas))}
+	for _, d := range c.Deltas {
+		ed, err := EncodeDelta(d)
+		if err != nil {
+			return out, err
+		}
+		out.Deltas = append(out.Deltas, ed)
+	}
+	return out, nil
+}
+
+// Decode decodes values of all deltas of the change.
+func (c Change) Decode() (graph.Change, error) {
+	out := graph.Change{Position: c.Position, Time: c.Time, Deltas: make([]graph.Delta, 0, len(c.Deltas))}
+	for _, d := range c.Deltas {
+		dd, err := d.Decode()
+		if err != nil {
+			return out, err
+		}
+		out.Deltas = append(out.Deltas, dd)
+	}
+	return out, nil
+}
+
+// ErrorResponse is sent in an error event of the change feed API.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// Err returns an error with a given code, or an error with the message, if the code is unknown.
+func (e ErrorResponse) Err() error {
+	if err := errorByCode(e.Code); err != nil {
+		return err
+	}
+	return errors.New(e.Error)
+}
+
+// DeltasRequest is a request to apply deltas in a single transaction.
+type DeltasRequest struct {
+	Deltas        []Delta `json:"deltas"`
+	IgnoreDup     bool    `json:"ignore_dup,omitempty"`
+	IgnoreMissing bool    `json:"ignore_missing,omitempty"`
+}
+
+// DeltaErrorResponse is returned when a transaction fails because of a specific delta.
+type DeltaErrorResponse struct {
+	Error string `json:"error"`
+	Delta int    `json:"delta"` // index of the delta in the request
+	Code  string `json:"code"`
+}
+
+// ErrorCode returns an error code for errors stored in graph.DeltaError.
+func ErrorCode(err error) string {
+	switch err {
+	case graph.ErrQuadExists:
+		return CodeQuadExists
+	case graph.ErrQuadNotExist:
+		return CodeQuadNotExist
+	case graph.ErrInvalidAction:
+		return CodeInvalidAction
+	case graph.ErrOperationNotSupported:
+		return CodeNotSupported
+	case graph.ErrChangesCompacted:
+		return CodeChangesCompacted
+	}
+	return ""
+}
+
+func errorByCode(code string) error {
+	switch code {
+	case CodeQuadExists:
+		return graph.ErrQuadExists
+	case CodeQuadNotExist:
+		return graph.ErrQuadNotExist
+	case CodeInvalidAction:
+		return graph.ErrInvalidAction
+	case CodeNotSupported:
+		return graph.ErrOperationNotSupported
+	case CodeChangesCompacted:
+		return graph.ErrChangesCompacted
+	}
+	return nil
+}
+
+// SizeResponse is the size of an index, as returned by graph.QuadIndexer.QuadIteratorSize.
+type SizeResponse struct {
+	Size  int64 `json:"size"`
+	Exact bool  `json:"exact"`
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"context"
+
+	"github.com/cayleygraph/quad"
+)
+
+// TextHit is a single result of a full-text search.
+type TextHit struct {
+	Value quad.Value
+	Score float64
+}
+
+// TextSearcher is an optional interface for QuadStores that maintain a full-text index of string literals.
+type TextSearcher interface {
+	// SearchText returns string literals matching a free-form text query, ordered by descending score.
+	// If lang is set, only literals with a matching language tag are returned.
+	SearchText(ctx context.Context, query, lang string) ([]TextHit, error)
+}
+
+// SearchText runs a full-text search over string literals of the QuadStore.
+//
+// It returns ErrOperationNotSupported if the QuadStore has no full-text index.
+func SearchText(ctx context.Context, qs QuadStore, query, lang string) ([]TextHit, error) {
+	if s, ok := Unwrap(qs).(TextSearcher); ok {
+		return s.SearchText(ctx, query, lang)
+	}
+	return nil, ErrOperationNotSupported
+}
@@ -0,0 +1,488 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sharded implements a QuadStore that partitions quads between a number of underlying QuadStores.
+package sharded
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/cayley/internal/fanout"
+	"github.com/cayleygraph/quad"
+)
+
+const QuadStoreType = "sharded"
+
+const (
+	// OptShards is a list of shards. Each shard is an object with "backend", "address" and "options" keys.
+	OptShards = "shards"
+	// OptShardBy selects a quad direction used for routing: "subject" (default) or "label".
+	OptShardBy = "shard_by"
+)
+
+func init() {
+	graph.RegisterQuadStore(QuadStoreType, graph.QuadStoreRegistration{
+		NewFunc: func(_ string, opt graph.Options) (graph.QuadStore, error) {
+			return Open(opt)
+		},
+		InitFunc: func(_ string, opt graph.Options) error {
+			return forEachShard(opt, graph.InitQuadStore)
+		},
+		UpgradeFunc: func(_ string, opt graph.Options) error {
+			return forEachShard(opt, graph.UpgradeQuadStore)
+		},
+		IsPersistent: true,
+	})
+}
+
+var _ graph.QuadStore = (*QuadStore)(nil)
+
+// Shard describes a single underlying QuadStore.
+type Shard struct {
+	Backend string
+	Address string
+	Options graph.Options
+}
+
+// ParseShards reads a list of shards from the options.
+func ParseShards(opt graph.Options) ([]Shard, error) {
+	list, ok := opt[OptShards].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("sharded: %q option must be a non-empty list", OptShards)
+	}
+	out := make([]Shard, 0, len(list))
+	for i, o := range list {
+		sopt, ok := graph.AsOptions(o)
+		if !ok {
+			return nil, fmt.Errorf("sharded: invalid shard %d: %T", i, o)
+		}
+		var (
+			s   Shard
+			err error
+		)
+		if s.Backend, err = sopt.StringKey("backend", ""); err != nil {
+			return nil, err
+		} else if s.Backend == "" {
+			return nil, fmt.Errorf("sharded: backend is not set for shard %d", i)
+		} else if !graph.IsRegistered(s.Backend) {
+			return nil, fmt.Errorf("sharded: unknown backend for shard %d: %q", i, s.Backend)
+		}
+		if s.Address, err = sopt.StringKey("address", ""); err != nil {
+			return nil, err
+		}
+		if o, ok := sopt["options"]; ok {
+			if s.Options, ok = graph.AsOptions(o); !ok {
+				return nil, fmt.Errorf("sharded: invalid options for shard %d: %T", i, o)
+			}
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func forEachShard(opt graph.Options, fnc func(name, addr string, opt graph.Options) error) error {
+	shards, err := ParseShards(opt)
+	if err != nil {
+		return err
+	}
+	for _, s := range shards {
+		if !graph.IsPersistent(s.Backend) {
+			continue
+		}
+		if err = fnc(s.Backend, s.Address, s.Options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open opens all shards listed in the options and combines them into a single QuadStore.
+func Open(opt graph.Options) (*QuadStore, error) {
+	by, err := opt.StringKey(OptShardBy, "subject")
+	if err != nil {
+		return nil, err
+	}
+	dir, err := parseDirection(by)
+	if err != nil {
+		return nil, err
+	}
+	list, err := ParseShards(opt)
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]graph.QuadStore, 0, len(list))
+	for _, s := range list {
+		qs, err := graph.NewQuadStore(s.Backend, s.Address, s.Options)
+		if err != nil {
+			for _, qs := range shards {
+				qs.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, qs)
+	}
+	return New(dir, shards...)
+}
+
+func parseDirection(s string) (quad.Direction, error) {
+	switch s {
+	case "subject":
+		return quad.Subject, nil
+	case "label":
+		return quad.Label, nil
+	}
+	return quad.Any, fmt.Errorf("sharded: unsupported %s: %q", OptShardBy, s)
+}
+
+// New creates a QuadStore that routes quads to shards by the hash of a value in a given direction.
+// Only quad.Subject and quad.Label directions are supported.
+//
+// The order of shards must not change between restarts, since it defines the placement of quads.
+func New(by quad.Direction, shards ...graph.QuadStore) (*QuadStore, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("sharded: no shards")
+	} else if by != quad.Subject && by != quad.Label {
+		return nil, fmt.Errorf("sharded: cannot route by %v", by)
+	}
+	return &QuadStore{by: by, shards: shards}, nil
+}
+
+// QuadStore partitions quads between a number of shards by the hash of a subject or a label.
+//
+// Each quad is stored in exactly one shard, while the same node may be present in multiple shards.
+// Thus, node refs are not tied to a particular shard and carry the value instead,
+// while quad refs are qualified with the shard index.
+type QuadStore struct {
+	by     quad.Direction
+	shards fanout.Members
+}
+
+// Shards returns underlying QuadStores.
+func (qs *QuadStore) Shards() []graph.QuadStore {
+	return qs.shards
+}
+
+// shardOf returns an index of a shard the quad belongs to.
+func (qs *QuadStore) shardOf(q quad.Quad) int {
+	if len(qs.shards) == 1 {
+		return 0
+	}
+	h := refs.HashOf(q.Get(qs.by))
+	return int(binary.BigEndian.Uint64(h[:8]) % uint64(len(qs.shards)))
+}
+
+// ApplyDeltas splits deltas between shards and applies them in the same order as shards are listed.
+// Deltas of a quad that is changed more than once in the batch are applied in order, see collapseDeltas.
+//
+// Transactions are atomic only if all deltas belong to the same shard. Otherwise, deltas are checked
+// against all shards first, but a failure in one of the shards will not roll back changes in other shards.
+func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
+	ctx := context.TODO()
+	for _, d := range in {
+		if d.Action != graph.Add && d.Action != graph.Delete {
+			return &graph.DeltaError{Delta: d, Err: graph.ErrInvalidAction}
+		}
+	}
+	in, err := qs.collapseDeltas(ctx, in, opts)
+	if err != nil {
+		return err
+	}
+	perShard := make([][]graph.Delta, len(qs.shards))
+	n := 0
+	for _, d := range in {
+		i := qs.shardOf(d.Quad)
+		if len(perShard[i]) == 0 {
+			n++
+		}
+		perShard[i] = append(perShard[i], d)
+	}
+	if n > 1 && (!opts.IgnoreDup || !opts.IgnoreMissing) {
+		for i, deltas := range perShard {
+			for _, d := range deltas {
+				if (d.Action == graph.Add && (opts.IgnoreDup || !d.Expires.IsZero())) || (d.Action == graph.Delete && opts.IgnoreMissing) {
+					continue
+				}
+				ok, err := graph.HasQuad(ctx, qs.shards[i], d.Quad)
+				if err != nil {
+					return err
+				}
+				if d.Action == graph.Add && ok {
+					return &graph.DeltaError{Delta: d, Err: graph.ErrQuadExists}
+				} else if d.Action == graph.Delete && !ok {
+					return &graph.DeltaError{Delta: d, Err: graph.ErrQuadNotExist}
+				}
+			}
+		}
+	}
+	for i, deltas := range perShard {
+		if len(deltas) == 0 {
+			continue
+		}
+		if err := qs.shards[i].ApplyDeltas(deltas, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collapseDeltas replaces deltas of each quad that is changed more than once in a batch with a single delta
+// that has the same effect as applying them in order. Deltas are checked against the state of the quad left
+// by the previous deltas, thus adding and deleting a quad in the same batch leaves the store unchanged,
+// and the result does not depend on the shards the batch is split between.
+func (qs *QuadStore) collapseDeltas(ctx context.Context, in []graph.Delta, opts graph.IgnoreOpts) ([]graph.Delta, error) {
+	type change struct {
+		n, last       int  // number of deltas and the index of the last one
+		existed, seen bool // quad existed before the batch; the existence is checked
+		exists        bool
+		add           graph.Delta // last add delta
+	}
+	changes := make(map[quad.Quad]*change, len(in))
+	for i, d := range in {
+		c := changes[d.Quad]
+		if c == nil {
+			c = &change{}
+			changes[d.Quad] = c
+		}
+		c.n++
+		c.last = i
+	}
+	if len(changes) == len(in) {
+		return in, nil
+	}
+	out := make([]graph.Delta, 0, len(changes))
+	for i, d := range in {
+		c := changes[d.Quad]
+		if c.n == 1 {
+			out = append(out, d)
+			continue
+		}
+		if !c.seen {
+			ok, err := graph.HasQuad(ctx, qs.shards[qs.shardOf(d.Quad)], d.Quad)
+			if err != nil {
+				return nil, err
+			}
+			c.existed, c.exists, c.seen = ok, ok, true
+		}
+		switch d.Action {
+		case graph.Add:
+			if c.exists && d.Expires.IsZero() && !opts.IgnoreDup {
+				return nil, &graph.DeltaError{Delta: d, Err: graph.ErrQuadExists}
+			}
+			c.exists, c.add = true, d
+		case graph.Delete:
+			if !c.exists && !opts.IgnoreMissing {
+				return nil, &graph.DeltaError{Delta: d, Err: graph.ErrQuadNotExist}
+			}
+			c.exists = false
+		}
+		if i != c.last {
+			continue
+		}
+		if c.exists && (!c.existed || !c.add.Expires.IsZero()) {
+			// expiring quads replace existing ones
+			out = append(out, c.add)
+		} else if !c.exists && c.existed {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (qs *QuadStore) NewQuadWriter() (quad.WriteCloser, error) {
+	return &quadWriter{qs: qs, w: make([]quad.WriteCloser, len(qs.shards))}, nil
+}
+
+type quadWriter struct {
+	qs  *QuadStore
+	w   []quad.WriteCloser
+	buf [][]quad.Quad
+}
+
+func (w *quadWriter) writer(i int) (quad.WriteCloser, error) {
+	if w.w[i] != nil {
+		return w.w[i], nil
+	}
+	qw, err := w.qs.shards[i].NewQuadWriter()
+	if err != nil {
+		return nil, err
+	}
+	w.w[i] = qw
+	return qw, nil
+}
+
+func (w *quadWriter) WriteQuad(q quad.Quad) error {
+	_, err := w.WriteQuads([]quad.Quad{q})
+	return err
+}
+
+func (w *quadWriter) WriteQuads(buf []quad.Quad) (int, error) {
+	if w.buf == nil {
+		w.buf = make([][]quad.Quad, len(w.qs.shards))
+	}
+	for i := range w.buf {
+		w.buf[i] = w.buf[i][:0]
+	}
+	for _, q := range buf {
+		i := w.qs.shardOf(q)
+		w.buf[i] = append(w.buf[i], q)
+	}
+	for i, sbuf := range w.buf {
+		if len(sbuf) == 0 {
+			continue
+		}
+		qw, err := w.writer(i)
+		if err != nil {
+			return 0, err
+		}
+		if _, err = qw.WriteQuads(sbuf); err != nil {
+			return 0, err
+		}
+	}
+	return len(buf), nil
+}
+
+func (w *quadWriter) Close() error {
+	var last error
+	for _, qw := range w.w {
+		if qw == nil {
+			continue
+		}
+		if err := qw.Close(); err != nil {
+			last = err
+		}
+	}
+	return last
+}
+
+func (qs *QuadStore) NameOf(ref graph.Ref) (quad.Value, error) {
+	return fanout.ValueOf(ref), nil
+}
+
+func (qs *QuadStore) ValueOf(v quad.Value) (graph.Ref, error) {
+	return qs.shards.ValueOf(v)
+}
+
+func (qs *QuadStore) Quad(ref graph.Ref) (quad.Quad, error) {
+	return qs.shards.Quad(ref)
+}
+
+func (qs *QuadStore) QuadDirection(ref graph.Ref, d quad.Direction) (graph.Ref, error) {
+	return qs.shards.QuadDirection(ref, d)
+}
+
+// QuadIterator returns quads of all shards that have a given node, one shard after another.
+func (qs *QuadStore) QuadIterator(d quad.Direction, ref graph.Ref) iterator.Shape {
+	subs := make([]iterator.Shape, len(qs.shards))
+	for i, s := range qs.shards {
+		sref, err := qs.shards.MemberRef(i, ref)
+		if err != nil {
+			return iterator.NewError(err)
+		} else if sref != nil {
+			subs[i] = s.QuadIterator(d, sref)
+		}
+	}
+	return qs.shards.Quads(subs, nil)
+}
+
+func (qs *QuadStore) QuadIteratorSize(ctx context.Context, d quad.Direction, ref graph.Ref) (refs.Size, error) {
+	sz := refs.Size{Exact: true}
+	for i, s := range qs.shards {
+		sref, err := qs.shards.MemberRef(i, ref)
+		if err != nil {
+			return sz, err
+		} else if sref == nil {
+			continue
+		}
+		ssz, err := s.QuadIteratorSize(ctx, d, sref)
+		if err != nil {
+			return sz, err
+		}
+		sz.Value += ssz.Value
+		sz.Exact = sz.Exact && ssz.Exact
+	}
+	return sz, nil
+}
+
+func (qs *QuadStore) QuadsAllIterator() iterator.Shape {
+	subs := make([]iterator.Shape, 0, len(qs.shards))
+	for _, s := range qs.shards {
+		subs = append(subs, s.QuadsAllIterator())
+	}
+	return qs.shards.Quads(subs, nil)
+}
+
+// NodesAllIterator lists nodes of all shards. Nodes are usually shared between shards,
+// thus the largest shard is used to estimate the number of nodes.
+func (qs *QuadStore) NodesAllIterator() iterator.Shape {
+	return qs.shards.Nodes(true)
+}
+
+// Stats returns a sum of stats of all shards. Since the same node may be stored in multiple shards,
+// nodes are counted exactly only if requested.
+func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
+	var st graph.Stats
+	st.Nodes.Exact = true
+	st.Quads.Exact = true
+	for _, s := range qs.shards {
+		sst, err := s.Stats(ctx, exact)
+		if err != nil {
+			return st, err
+		}
+		st.Nodes.Value += sst.Nodes.Value
+		st.Nodes.Exact = st.Nodes.Exact && sst.Nodes.Exact
+		st.Quads.Value += sst.Quads.Value
+		st.Quads.Exact = st.Quads.Exact && sst.Quads.Exact
+	}
+	if len(qs.shards) == 1 {
+		return st, nil
+	} else if !exact {
+		st.Nodes.Exact = false
+		return st, nil
+	}
+	n, err := iterator.Iterate(ctx, qs.NodesAllIterator()).Paths(false).Count()
+	if err != nil {
+		return st, err
+	}
+	st.Nodes = refs.Size{Value: n, Exact: true}
+	return st, nil
+}
+
+var _ graph.Expirer = (*QuadStore)(nil)
+
+// ExpireQuads implements graph.Expirer. Shards that do not support expiration are skipped.
+func (qs *QuadStore) ExpireQuads(ctx context.Context, now time.Time) ([]quad.Quad, error) {
+	var out []quad.Quad
+	for _, s := range qs.shards {
+		deleted, err := graph.ExpireQuads(ctx, s, now)
+		if err == graph.ErrOperationNotSupported {
+			continue
+		} else if err != nil {
+			return out, err
+		}
+		out = append(out, deleted...)
+	}
+	return out, nil
+}
+
+// Close closes all shards.
+func (qs *QuadStore) Close() error {
+	return qs.shards.Close()
+}
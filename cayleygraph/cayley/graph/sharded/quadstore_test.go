@@ -0,0 +1,152 @@
+package sharded
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/graphtest"
+	"github.com/cayleygraph/cayley/graph/memstore"
+	"github.com/cayleygraph/quad"
+)
+
+func makeSharded(t testing.TB, by quad.Direction, n int) *QuadStore {
+	shards := make([]graph.QuadStore, 0, n)
+	for i := 0; i < n; i++ {
+		shards = append(shards, memstore.New())
+	}
+	qs, err := New(by, shards...)
+	require.NoError(t, err)
+	return qs
+}
+
+func TestShardedBySubject(t *testing.T) {
+	graphtest.TestAll(t, func(t testing.TB) (graph.QuadStore, graph.Options, func()) {
+		return makeSharded(t, quad.Subject, 3), nil, func() {}
+	}, &graphtest.Config{
+		AlwaysRunIntegration: true,
+	})
+}
+
+func TestShardedByLabel(t *testing.T) {
+	graphtest.TestAll(t, func(t testing.TB) (graph.QuadStore, graph.Options, func()) {
+		return makeSharded(t, quad.Label, 3), nil, func() {}
+	}, &graphtest.Config{
+		AlwaysRunIntegration: true,
+	})
+}
+
+func TestRouting(t *testing.T) {
+	ctx := context.TODO()
+	qs := makeSharded(t, quad.Subject, 4)
+	var quads []quad.Quad
+	for _, s := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		quads = append(quads, quad.MakeIRI(s, "follows", "z", ""))
+	}
+	w, err := qs.NewQuadWriter()
+	require.NoError(t, err)
+	_, err = w.WriteQuads(quads)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	used := 0
+	for i, s := range qs.Shards() {
+		st, err := s.Stats(ctx, true)
+		require.NoError(t, err)
+		if st.Quads.Value != 0 {
+			used++
+		}
+		graphtest.ExpectIteratedQuads(t, s, s.QuadsAllIterator(), filterShard(qs, quads, i), true)
+	}
+	require.True(t, used > 1, "all quads were written to a single shard")
+
+	st, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(quads)), st.Quads.Value)
+	require.Equal(t, int64(len(quads)+2), st.Nodes.Value)
+
+	z, err := qs.ValueOf(quad.IRI("z"))
+	require.NoError(t, err)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Object, z), quads, true)
+}
+
+func filterShard(qs *QuadStore, quads []quad.Quad, shard int) []quad.Quad {
+	var out []quad.Quad
+	for _, q := range quads {
+		if qs.shardOf(q) == shard {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+func TestOpen(t *testing.T) {
+	_, err := Open(graph.Options{})
+	require.Error(t, err)
+	_, err = Open(graph.Options{OptShards: []interface{}{
+		map[string]interface{}{"backend": "memstore"},
+	}, OptShardBy: "object"})
+	require.Error(t, err)
+
+	qs, err := graph.NewQuadStore(QuadStoreType, "", graph.Options{
+		OptShardBy: "label",
+		OptShards: []interface{}{
+			map[string]interface{}{"backend": "memstore"},
+			map[interface{}]interface{}{"backend": "memstore", "options": map[string]interface{}{}},
+		},
+	})
+	require.NoError(t, err)
+	defer qs.Close()
+	require.Len(t, qs.(*QuadStore).Shards(), 2)
+}
+
+func TestRepeatedDeltas(t *testing.T) {
+	ctx := context.TODO()
+	qs := makeSharded(t, quad.Subject, 4)
+	// quads from different shards
+	var quads []quad.Quad
+	for _, s := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		q := quad.MakeIRI(s, "follows", "z", "")
+		if len(quads) == 0 || qs.shardOf(q) != qs.shardOf(quads[len(quads)-1]) {
+			quads = append(quads, q)
+		}
+	}
+	require.True(t, len(quads) >= 3, "all quads belong to a single shard")
+	q, r, p := quads[0], quads[1], quads[2]
+
+	has := func(q quad.Quad) bool {
+		ok, err := graph.HasQuad(ctx, qs, q)
+		require.NoError(t, err)
+		return ok
+	}
+	apply := func(deltas ...graph.Delta) error {
+		return qs.ApplyDeltas(deltas, graph.IgnoreOpts{})
+	}
+	add := func(q quad.Quad) graph.Delta { return graph.Delta{Quad: q, Action: graph.Add} }
+	del := func(q quad.Quad) graph.Delta { return graph.Delta{Quad: q, Action: graph.Delete} }
+
+	// add and delete in the same batch, in a single shard and across shards
+	require.NoError(t, apply(add(q), del(q)))
+	require.False(t, has(q))
+	require.NoError(t, apply(add(q), del(q), add(r)))
+	require.False(t, has(q))
+	require.True(t, has(r))
+
+	// delete and add in the same batch
+	require.NoError(t, apply(del(r), add(r)))
+	require.True(t, has(r))
+	require.NoError(t, apply(del(r), add(r), add(p)))
+	require.True(t, has(r))
+	require.True(t, has(p))
+
+	// deltas are checked in order, and nothing is applied on failure
+	err := apply(add(q), del(p), add(q))
+	require.Equal(t, graph.ErrQuadExists, err.(*graph.DeltaError).Err)
+	err = apply(add(q), del(r), del(r))
+	require.Equal(t, graph.ErrQuadNotExist, err.(*graph.DeltaError).Err)
+	require.False(t, has(q))
+	require.True(t, has(r))
+	require.True(t, has(p))
+}
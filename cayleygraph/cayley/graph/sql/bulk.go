@@ -0,0 +1,223 @@
+package sql
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+)
+
+// BulkRegistration describes how a database loads batches of quads through staging tables.
+//
+// Nodes and quads of a batch are written to bulk_nodes and bulk_quads tables first. Both are unique within
+// the batch, but might already exist in the database, thus the deduplication is done by the database on merge.
+//
+// Databases that cannot create tables in a transaction can set Load instead.
+type BulkRegistration struct {
+	// Tables create empty staging tables in the current transaction.
+	Tables []string
+	// Copy loads rows into a staging table. If not set, multi-row INSERT statements are used.
+	Copy func(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error
+	// Merge moves quads that do not exist yet from staging tables to the quads table, adds missing nodes,
+	// increments reference counters of nodes and drops staging tables, if necessary.
+	Merge []string
+	// Load writes rows of staging tables to nodes and quads tables directly, without staging tables.
+	// It must skip quads that already exist and count references only for quads that were added.
+	// Rows have BulkNodeColumns and BulkQuadColumns.
+	Load func(tx *sql.Tx, nodes, quads [][]interface{}) error
+}
+
+// Columns of staging tables.
+var (
+	BulkNodeColumns = []string{
+		"hash", "value", "value_string", "datatype", "language", "iri", "bnode",
+		"value_int", "value_bool", "value_float", "value_time",
+	}
+	BulkQuadColumns = []string{
+		"subject_hash", "predicate_hash", "object_hash", "label_hash",
+	}
+)
+
+// maxBulkParams is a maximal number of parameters in a single INSERT statement.
+// Older versions of sqlite do not allow more than 999 parameters.
+const maxBulkParams = 999
+
+// bulkWriter loads each batch of quads in a single transaction through staging tables.
+type bulkWriter struct {
+	qs *QuadStore
+}
+
+func (w *bulkWriter) WriteQuad(q quad.Quad) error {
+	_, err := w.WriteQuads([]quad.Quad{q})
+	return err
+}
+
+func (w *bulkWriter) WriteQuads(buf []quad.Quad) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	if err := w.qs.bulkLoad(buf); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (w *bulkWriter) Close() error {
+	return nil
+}
+
+// bulkRows converts quads to rows of staging tables. Duplicate nodes and quads are skipped.
+func bulkRows(buf []quad.Quad) (nodes, quads [][]interface{}, _ error) {
+	col := make(map[string]int, len(BulkNodeColumns))
+	for i, name := range BulkNodeColumns {
+		col[name] = i
+	}
+	seenNodes := make(map[refs.ValueHash]struct{})
+	seenQuads := make(map[refs.QuadHash]struct{}, len(buf))
+	for _, q := range buf {
+		var qh refs.QuadHash
+		for _, d := range quad.Directions {
+			qh.Set(d, refs.HashOf(q.Get(d)))
+		}
+		if _, ok := seenQuads[qh]; ok {
+			continue
+		}
+		seenQuads[qh] = struct{}{}
+		row := make([]interface{}, 0, len(BulkQuadColumns))
+		for _, d := range quad.Directions {
+			h := NodeHash{qh.Get(d)}
+			row = append(row, h.SQLValue())
+			if !h.Valid() {
+				continue
+			} else if _, ok := seenNodes[h.ValueHash]; ok {
+				continue
+			}
+			seenNodes[h.ValueHash] = struct{}{}
+			typ, values, err := NodeValues(h, q.Get(d))
+			if err != nil {
+				return nil, nil, err
+			}
+			nrow := make([]interface{}, len(BulkNodeColumns))
+			nrow[0] = values[0]
+			for i, name := range typ.Columns() {
+				nrow[col[name]] = values[i+1]
+			}
+			nodes = append(nodes, nrow)
+		}
+		quads = append(quads, row)
+	}
+	return nodes, quads, nil
+}
+
+func (qs *QuadStore) bulkLoad(buf []quad.Quad) error {
+	if qs.sn != nil {
+		return graph.ErrReadOnly
+	}
+	nodes, quads, err := bulkRows(buf)
+	if err != nil {
+		return err
+	}
+	bulk := qs.flavor.Bulk
+	tx, err := qs.db.Begin()
+	if err != nil {
+		clog.Errorf("couldn't begin write transaction: %v", err)
+		return err
+	}
+	if bulk.Load != nil {
+		retry := qs.flavor.TxRetry
+		if retry == nil {
+			retry = func(tx *sql.Tx, stmts func() error) error {
+				return stmts()
+			}
+		}
+		err = retry(tx, func() error {
+			return bulk.Load(tx, nodes, quads)
+		})
+		if err != nil {
+			clog.Errorf("couldn't load quads: %v", err)
+		}
+	} else {
+		err = qs.bulkStage(tx, nodes, quads)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	qs.mu.Lock()
+	qs.quads = -1
+	qs.nodes = -1
+	qs.mu.Unlock()
+	return tx.Commit()
+}
+
+// bulkStage loads rows through staging tables.
+func (qs *QuadStore) bulkStage(tx *sql.Tx, nodes, quads [][]interface{}) error {
+	bulk := qs.flavor.Bulk
+	copyRows := bulk.Copy
+	if copyRows == nil {
+		copyRows = qs.insertRows
+	}
+	for _, stmt := range bulk.Tables {
+		if _, err := tx.Exec(stmt); err != nil {
+			clog.Errorf("couldn't create staging table: %v", err)
+			return err
+		}
+	}
+	if err := copyRows(tx, "bulk_nodes", BulkNodeColumns, nodes); err != nil {
+		clog.Errorf("couldn't load nodes: %v", err)
+		return err
+	}
+	if err := copyRows(tx, "bulk_quads", BulkQuadColumns, quads); err != nil {
+		clog.Errorf("couldn't load quads: %v", err)
+		return err
+	}
+	for _, stmt := range bulk.Merge {
+		if _, err := tx.Exec(stmt); err != nil {
+			clog.Errorf("couldn't merge staging tables: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// insertRows writes rows to a table with multi-row INSERT statements.
+func (qs *QuadStore) insertRows(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	per := maxBulkParams / len(columns)
+	prefix := `INSERT INTO ` + table + `(` + strings.Join(columns, ", ") + `) VALUES `
+	for len(rows) > 0 {
+		batch := rows
+		if len(batch) > per {
+			batch = batch[:per]
+		}
+		rows = rows[len(batch):]
+
+		var (
+			sb   strings.Builder
+			args = make([]interface{}, 0, len(batch)*len(columns))
+		)
+		sb.WriteString(prefix)
+		for i, row := range batch {
+			if i != 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString("(")
+			for j, v := range row {
+				if j != 0 {
+					sb.WriteString(", ")
+				}
+				args = append(args, v)
+				sb.WriteString(qs.flavor.Placeholder(len(args)))
+			}
+			sb.WriteString(")")
+		}
+		sb.WriteString(";")
+		if _, err := tx.Exec(sb.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
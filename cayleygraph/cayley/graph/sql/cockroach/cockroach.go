@@ -39,13 +39,21 @@ func init() {
 			},
 		},
 		NoForeignKeys: true,
-		Error:         convError,
+		RecursiveCTE:  true,
+		ByteOrder: func(expr string) string {
+			return expr // strings are compared byte by byte
+		},
+		Error: convError,
 		//Estimated: func(table string) string{
 		//	return "SELECT reltuples::BIGINT AS estimate FROM pg_class WHERE relname='"+table+"';"
 		//},
 		RunTx:               runTxCockroach,
 		TxRetry:             retryTxCockroach,
 		NoSchemaChangesInTx: true,
+		Bulk: &csql.BulkRegistration{
+			Load: bulkLoadCockroach,
+		},
+		SnapshotRows: csql.SnapshotCursor,
 	})
 }
 
@@ -221,3 +229,100 @@ func runTxCockroach(tx *sql.Tx, nodes []graphlog.NodeUpdate, quads []graphlog.Qu
 	}
 	return nil
 }
+
+// maxBulkParams is a maximal number of parameters in a single statement.
+const maxBulkParams = 65535
+
+// bulkLoadCockroach writes nodes and quads with multi-row statements, since CockroachDB cannot
+// create staging tables in a transaction. Quads are inserted first, thus reference counters
+// of nodes are incremented only for quads that were added.
+func bulkLoadCockroach(tx *sql.Tx, nodes, quads [][]interface{}) error {
+	refs := make(map[string]int)
+	qcols := strings.Join(csql.BulkQuadColumns, ", ")
+	err := bulkBatches(quads, len(csql.BulkQuadColumns), func(batch [][]interface{}) error {
+		query, args := bulkValues("INSERT INTO quads ("+qcols+", ts) VALUES ", batch, "now()")
+		query += " ON CONFLICT (subject_hash, predicate_hash, object_hash) DO NOTHING RETURNING " + qcols + ";"
+		rows, err := tx.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		hashes := make([]csql.NodeHash, len(csql.BulkQuadColumns))
+		dst := make([]interface{}, len(hashes))
+		for i := range hashes {
+			dst[i] = &hashes[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(dst...); err != nil {
+				return err
+			}
+			for _, h := range hashes {
+				if h.Valid() {
+					refs[string(h.ValueHash[:])]++
+				}
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+	// nodes that are used only by existing quads are not referenced by the batch
+	add := make([][]interface{}, 0, len(nodes))
+	for _, row := range nodes {
+		h, _ := row[0].([]byte)
+		if n := refs[string(h)]; n != 0 {
+			add = append(add, append([]interface{}{n}, row...))
+		}
+	}
+	ncols := strings.Join(csql.BulkNodeColumns, ", ")
+	return bulkBatches(add, 1+len(csql.BulkNodeColumns), func(batch [][]interface{}) error {
+		query, args := bulkValues("INSERT INTO nodes (refs, "+ncols+") VALUES ", batch, "")
+		query += " ON CONFLICT (hash) DO UPDATE SET refs = nodes.refs + EXCLUDED.refs RETURNING NOTHING;"
+		_, err := tx.Exec(query, args...)
+		return err
+	})
+}
+
+// bulkBatches splits rows into batches that fit into a single statement.
+func bulkBatches(rows [][]interface{}, cols int, fnc func(batch [][]interface{}) error) error {
+	per := maxBulkParams / cols
+	for len(rows) > 0 {
+		batch := rows
+		if len(batch) > per {
+			batch = batch[:per]
+		}
+		rows = rows[len(batch):]
+		if err := fnc(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkValues builds a multi-row VALUES statement. If extra is set, it is appended to each row.
+func bulkValues(prefix string, rows [][]interface{}, extra string) (string, []interface{}) {
+	var (
+		query bytes.Buffer
+		args  []interface{}
+	)
+	query.WriteString(prefix)
+	for i, row := range rows {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for j, v := range row {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			args = append(args, v)
+			fmt.Fprintf(&query, "$%d", len(args))
+		}
+		if extra != "" {
+			query.WriteString(", " + extra)
+		}
+		query.WriteString(")")
+	}
+	return query.String(), args
+}
@@ -60,8 +60,9 @@ func makeCockroach(t testing.TB) (string, graph.Options, func()) {
 }
 
 var conf = &sqltest.Config{
-	TimeRound: true,
-	TimeInMcs: true,
+	TimeRound:     true,
+	TimeInMcs:     true,
+	UniqueTriples: true,
 }
 
 func TestCockroach(t *testing.T) {
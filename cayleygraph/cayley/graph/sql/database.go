@@ -34,12 +34,17 @@ type Registration struct {
 
 	QueryDialect
 	NoOffsetWithoutLimit bool // SELECT ... OFFSET can be used only with LIMIT
+	RecursiveCTE         bool // database supports WITH RECURSIVE queries
+	// ByteOrder makes a string expression compare byte by byte. Sorting is done in Go if it is not set.
+	ByteOrder func(expr string) string
 
 	Error               func(error) error         // error conversion function
 	Estimated           func(table string) string // query that string that returns an estimated number of rows in table
 	RunTx               func(tx *sql.Tx, nodes []graphlog.NodeUpdate, quads []graphlog.QuadUpdate, opts graph.IgnoreOpts) error
 	TxRetry             func(tx *sql.Tx, stmts func() error) error
 	NoSchemaChangesInTx bool
+	Bulk                *BulkRegistration // enables bulk loading of quads with NewQuadWriter
+	SnapshotRows        SnapshotRows      // how snapshots read query results, see QuadStore.Snapshot
 }
 
 func (r Registration) nodesTable() string {
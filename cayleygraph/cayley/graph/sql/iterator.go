@@ -49,18 +49,34 @@ func (qs *QuadStore) prepareQuery(s Shape) (string, []interface{}) {
 func (qs *QuadStore) QueryRow(ctx context.Context, s Shape) *sql.Row {
 	// 根据s形态查询数据?
 	qu, vals := qs.prepareQuery(s)
-	return qs.db.QueryRowContext(ctx, qu, vals...)
+	return qs.conn().QueryRowContext(ctx, qu, vals...)
 }
 
 func (qs *QuadStore) Query(ctx context.Context, s Shape) (*sql.Rows, error) {
 	qu, vals := qs.prepareQuery(s)
-	rows, err := qs.db.QueryContext(ctx, qu, vals...)
+	rows, err := qs.conn().QueryContext(ctx, qu, vals...)
 	if err != nil {
 		return nil, fmt.Errorf("sql query failed: %v\nquery: %v", err, qu)
 	}
 	return rows, nil
 }
 
+// queryShape is the same as Query, but results might be buffered if the store is a snapshot.
+func (qs *QuadStore) queryShape(ctx context.Context, s Shape) (rowsCursor, error) {
+	qu, vals := qs.prepareQuery(s)
+	rows, err := qs.query(ctx, qu, vals...)
+	if err != nil {
+		return nil, fmt.Errorf("sql query failed: %v\nquery: %v", err, qu)
+	}
+	return rows, nil
+}
+
+// queryShapeRow is the same as QueryRow, but results might be buffered if the store is a snapshot.
+func (qs *QuadStore) queryShapeRow(ctx context.Context, s Shape) rowScanner {
+	qu, vals := qs.prepareQuery(s)
+	return qs.queryRow(ctx, qu, vals...)
+}
+
 func (qs *QuadStore) newIterator(s Select) *Iterator {
 	return &Iterator{
 		qs:    qs,
@@ -181,14 +197,19 @@ func (it *iteratorBase) ensureColumns() {
 }
 
 // todo: 还是不清楚这个函数是干什么的
-func (it *iteratorBase) scanValue(r *sql.Rows) bool {
+func (it *iteratorBase) scanValue(r rowsCursor) bool {
 	// 初始化cols和cind
 	it.ensureColumns()
 	// 这里相当于抽象反射
 	nodes := make([]NodeHash, len(it.cols))
+	ints := make([]sql.NullInt64, len(it.cols))
 	pointers := make([]interface{}, len(nodes))
 	for i := range pointers {
-		pointers[i] = &nodes[i]
+		if it.query.Fields[i].Int {
+			pointers[i] = &ints[i]
+		} else {
+			pointers[i] = &nodes[i]
+		}
 	}
 	if err := r.Scan(pointers...); err != nil {
 		it.err = err
@@ -196,7 +217,14 @@ func (it *iteratorBase) scanValue(r *sql.Rows) bool {
 	}
 	it.tags = make(map[string]graph.Ref)
 	for i, name := range it.cols {
-		if !strings.Contains(name, tagPref) {
+		if strings.Contains(name, tagPref) {
+			continue
+		}
+		if it.query.Fields[i].Int {
+			if ints[i].Valid {
+				it.tags[name] = refs.PreFetched(quad.Int(ints[i].Int64))
+			}
+		} else {
 			it.tags[name] = nodes[i].ValueHash
 		}
 	}
@@ -218,6 +246,10 @@ func (it *iteratorBase) scanValue(r *sql.Rows) bool {
 		it.err = fmt.Errorf("cannot find node hash in query output (columns: %v, cind: %v)", it.cols, it.cind)
 		return false
 	}
+	if it.query.Fields[i].Int {
+		it.res = refs.PreFetched(quad.Int(ints[i].Int64))
+		return true
+	}
 	it.res = nodes[i]
 	return true
 }
@@ -238,7 +270,7 @@ func (qs *QuadStore) newIteratorNext(s Select) *iteratorNext {
 
 type iteratorNext struct {
 	iteratorBase
-	cursor *sql.Rows
+	cursor rowsCursor
 	// TODO(dennwc): nextPath workaround; remove when we get rid of NextPath in general
 	nextPathRes  graph.Ref
 	nextPathTags map[string]graph.Ref
@@ -249,7 +281,7 @@ func (it *iteratorNext) Next(ctx context.Context) bool {
 		return false
 	}
 	if it.cursor == nil {
-		it.cursor, it.err = it.qs.Query(ctx, it.query)
+		it.cursor, it.err = it.qs.queryShape(ctx, it.query)
 	}
 	// TODO(dennwc): this loop exists only because of nextPath workaround
 	for {
@@ -329,13 +361,17 @@ func (qs *QuadStore) newIteratorContains(s Select) *iteratorContains {
 type iteratorContains struct {
 	iteratorBase
 	// TODO(dennwc): nextPath workaround; remove when we get rid of NextPath in general
-	nextPathRows *sql.Rows
+	nextPathRows rowsCursor
 }
 
 func (it *iteratorContains) Contains(ctx context.Context, v graph.Ref) bool {
 	it.ensureColumns()
 	sel := it.query
 	sel.Where = append([]Where{}, sel.Where...)
+	if i, ok := it.cind[quad.Any]; ok && sel.Fields[i].Int {
+		// the value is calculated by the query, so we can only compare it
+		return it.containsInt(ctx, v)
+	}
 	switch v := v.(type) {
 	case NodeHash:
 		i, ok := it.cind[quad.Any]
@@ -361,7 +397,7 @@ func (it *iteratorContains) Contains(ctx context.Context, v graph.Ref) bool {
 		return false
 	}
 
-	rows, err := it.qs.Query(ctx, sel)
+	rows, err := it.qs.queryShape(ctx, sel)
 	if err != nil {
 		it.err = err
 		return false
@@ -381,6 +417,25 @@ func (it *iteratorContains) Contains(ctx context.Context, v graph.Ref) bool {
 	return it.scanValue(rows)
 }
 
+func (it *iteratorContains) containsInt(ctx context.Context, v graph.Ref) bool {
+	rows, err := it.qs.queryShape(ctx, it.query)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if !it.scanValue(rows) {
+			return false
+		}
+		if it.res.Key() == v.Key() {
+			return true
+		}
+	}
+	it.err = rows.Err()
+	return false
+}
+
 func (it *iteratorContains) NextPath(ctx context.Context) bool {
 	if it.err != nil {
 		return false
@@ -401,3 +456,110 @@ func (it *iteratorContains) Close() error {
 	}
 	return nil
 }
+
+func (qs *QuadStore) newSortIterator(namer refs.Namer, s Sort) *SortIterator {
+	return &SortIterator{
+		qs:    qs,
+		namer: namer,
+		query: s,
+	}
+}
+
+// SortIterator returns results of a query sorted by the database, or falls back to iterator.Sort
+// if the database cannot sort them.
+type SortIterator struct {
+	qs    *QuadStore
+	namer refs.Namer
+	query Sort
+}
+
+func (it *SortIterator) Iterate() iterator.Scanner {
+	return &sortNext{it: it}
+}
+
+// Lookup returns an index of the unsorted query, since the order does not matter for it.
+func (it *SortIterator) Lookup() iterator.Index {
+	return it.qs.newIteratorContains(it.query.From)
+}
+
+func (it *SortIterator) Stats(ctx context.Context) (iterator.Costs, error) {
+	return it.qs.newIterator(it.query.From).Stats(ctx)
+}
+
+func (it *SortIterator) Optimize(ctx context.Context) (iterator.Shape, bool) {
+	return it, false
+}
+
+func (it *SortIterator) SubIterators() []iterator.Shape {
+	return nil
+}
+
+func (it *SortIterator) String() string {
+	return "SQLSort(" + it.query.Query.SQL(NewBuilder(it.qs.flavor.QueryDialect)) + ")"
+}
+
+// sortNext runs the check query on the first call to Next and selects the sorted or the unsorted query.
+type sortNext struct {
+	it  *SortIterator
+	sub iterator.Scanner
+	err error
+}
+
+func (it *sortNext) init(ctx context.Context) bool {
+	if it.sub != nil {
+		return true
+	} else if it.err != nil {
+		return false
+	}
+	var one int64
+	err := it.it.qs.queryShapeRow(ctx, it.it.query.Check).Scan(&one)
+	switch err {
+	case sql.ErrNoRows:
+		it.sub = it.it.qs.newIteratorNext(it.it.query.Query)
+	case nil:
+		it.sub = iterator.NewSort(it.it.namer, it.it.qs.newIterator(it.it.query.From)).Iterate()
+	default:
+		it.err = err
+		return false
+	}
+	return true
+}
+
+func (it *sortNext) TagResults(dst map[string]graph.Ref) {
+	if it.sub != nil {
+		it.sub.TagResults(dst)
+	}
+}
+
+func (it *sortNext) Next(ctx context.Context) bool {
+	return it.init(ctx) && it.sub.Next(ctx)
+}
+
+func (it *sortNext) NextPath(ctx context.Context) bool {
+	return it.sub != nil && it.sub.NextPath(ctx)
+}
+
+func (it *sortNext) Result() graph.Ref {
+	if it.sub == nil {
+		return nil
+	}
+	return it.sub.Result()
+}
+
+func (it *sortNext) Err() error {
+	if it.sub == nil {
+		return it.err
+	}
+	return it.sub.Err()
+}
+
+func (it *sortNext) Close() error {
+	if it.sub == nil {
+		return nil
+	}
+	return it.sub.Close()
+}
+
+func (it *sortNext) String() string {
+	return "SQLSortNext"
+}
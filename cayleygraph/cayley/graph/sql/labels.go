@@ -0,0 +1,264 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	graphlog "github.com/cayleygraph/cayley/graph/log"
+	"github.com/cayleygraph/quad"
+)
+
+var _ graph.LabelManager = (*QuadStore)(nil)
+
+// errUniqueTriples is returned when a quad cannot be copied to another graph, because the
+// database has a unique index on triples.
+var errUniqueTriples = errors.New("sql: database does not allow the same triple in multiple graphs")
+
+// ListLabels implements graph.LabelManager.
+func (qs *QuadStore) ListLabels(ctx context.Context) ([]quad.Value, error) {
+	rows, err := qs.query(ctx, `SELECT DISTINCT label_hash FROM quads WHERE label_hash IS NOT NULL;`)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []NodeHash
+	for rows.Next() {
+		var h NodeHash
+		if err := rows.Scan(&h); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]quad.Value, 0, len(hashes))
+	for _, h := range hashes {
+		v, err := qs.NameOf(h)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, v)
+	}
+	graph.SortLabels(labels)
+	return labels, nil
+}
+
+// DropLabel implements graph.LabelManager.
+func (qs *QuadStore) DropLabel(ctx context.Context, label quad.Value) error {
+	return qs.relabel(ctx, label, nil, false, true)
+}
+
+// CopyLabel implements graph.LabelManager.
+//
+// Databases without conditional indexes cannot store the same triple in different graphs,
+// thus copying a non-empty graph fails for them.
+func (qs *QuadStore) CopyLabel(ctx context.Context, from, to quad.Value) error {
+	return qs.relabel(ctx, from, to, true, false)
+}
+
+// MoveLabel implements graph.LabelManager.
+func (qs *QuadStore) MoveLabel(ctx context.Context, from, to quad.Value) error {
+	return qs.relabel(ctx, from, to, true, true)
+}
+
+// queryArgs collects arguments for a query and returns placeholders for them.
+type queryArgs struct {
+	placeholder func(n int) string
+	args        []interface{}
+}
+
+func (a *queryArgs) add(v interface{}) string {
+	a.args = append(a.args, v)
+	return a.placeholder(len(a.args))
+}
+
+// label returns a condition that matches a given label. Nil label refers to the default graph.
+func (a *queryArgs) label(table string, v quad.Value) string {
+	if v == nil {
+		return table + "label_hash IS NULL"
+	}
+	return table + "label_hash = " + a.add(HashOf(v).SQLValue())
+}
+
+// existsIn returns a condition that matches quads with a triple that also exists in a given graph.
+func (a *queryArgs) existsIn(table string, v quad.Value) string {
+	return `EXISTS (SELECT 1 FROM quads d WHERE d.subject_hash = ` + table + `subject_hash` +
+		` AND d.predicate_hash = ` + table + `predicate_hash AND d.object_hash = ` + table + `object_hash` +
+		` AND ` + a.label("d.", v) + `)`
+}
+
+func (qs *QuadStore) newArgs() *queryArgs {
+	return &queryArgs{placeholder: qs.flavor.Placeholder}
+}
+
+// relabel copies quads of one graph to another one and/or removes them from the source graph.
+func (qs *QuadStore) relabel(ctx context.Context, from, to quad.Value, add, del bool) error {
+	if qs.sn != nil {
+		return graph.ErrReadOnly
+	}
+	if add && HashOf(from) == HashOf(to) {
+		return nil // same graph
+	}
+	tx, err := qs.db.BeginTx(ctx, nil)
+	if err != nil {
+		clog.Errorf("couldn't begin write transaction: %v", err)
+		return err
+	}
+	retry := qs.flavor.TxRetry
+	if retry == nil {
+		retry = func(tx *sql.Tx, stmts func() error) error {
+			return stmts()
+		}
+	}
+	err = retry(tx, func() error {
+		return qs.relabelTx(ctx, tx, from, to, add, del)
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	qs.mu.Lock()
+	qs.quads = -1
+	qs.nodes = -1
+	qs.mu.Unlock()
+	return tx.Commit()
+}
+
+func (qs *QuadStore) relabelTx(ctx context.Context, tx *sql.Tx, from, to quad.Value, add, del bool) error {
+	// triples of the source graph might already exist in the destination graph only
+	// if the unique index includes the label
+	dups := add && qs.flavor.ConditionalIndexes
+
+	// calculate reference counter changes for all nodes of the source graph
+	a := qs.newArgs()
+	qu := `SELECT q.subject_hash, q.predicate_hash, q.object_hash`
+	if dups {
+		qu += `, CASE WHEN ` + a.existsIn("q.", to) + ` THEN 1 ELSE 0 END`
+	}
+	qu += ` FROM quads q WHERE ` + a.label("q.", from) + `;`
+	rows, err := tx.QueryContext(ctx, qu, a.args...)
+	if err != nil {
+		return err
+	}
+	var (
+		cnt          = make(map[NodeHash]int)
+		total, added int
+	)
+	for rows.Next() {
+		var (
+			s, p, o NodeHash
+			exists  int
+		)
+		dest := []interface{}{&s, &p, &o}
+		if dups {
+			dest = append(dest, &exists)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return err
+		}
+		total++
+		inc := 0
+		if add && exists == 0 {
+			added++
+			inc++
+		}
+		if del {
+			inc--
+		}
+		if inc != 0 {
+			cnt[s] += inc
+			cnt[p] += inc
+			cnt[o] += inc
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	} else if total == 0 {
+		return nil
+	}
+	if add && !del && !qs.flavor.ConditionalIndexes {
+		return errUniqueTriples
+	}
+	if del && from != nil {
+		cnt[HashOf(from)] -= total
+	}
+	if add && to != nil && added != 0 {
+		// insert the node for the destination graph, if necessary
+		h := HashOf(to)
+		err = qs.flavor.RunTx(tx, []graphlog.NodeUpdate{{Hash: h.ValueHash, Val: to, RefInc: added}}, nil, graph.IgnoreOpts{})
+		if err != nil {
+			return err
+		}
+	}
+
+	// update quads
+	a = qs.newArgs()
+	switch {
+	case add && !del:
+		qu = `INSERT INTO quads(subject_hash, predicate_hash, object_hash, label_hash, ts) SELECT q.subject_hash, q.predicate_hash, q.object_hash, `
+		if to == nil {
+			qu += `NULL`
+		} else {
+			qu += a.add(HashOf(to).SQLValue())
+		}
+		qu += `, q.ts FROM quads q WHERE ` + a.label("q.", from) + ` AND NOT ` + a.existsIn("q.", to) + `;`
+	case add && del:
+		qu = `UPDATE quads SET label_hash = `
+		if to == nil {
+			qu += `NULL`
+		} else {
+			qu += a.add(HashOf(to).SQLValue())
+		}
+		qu += ` WHERE ` + a.label("", from)
+		if dups {
+			qu += ` AND NOT ` + a.existsIn("quads.", to)
+		}
+		qu += `;`
+	default:
+		qu = `DELETE FROM quads WHERE ` + a.label("", from) + `;`
+	}
+	if _, err = tx.ExecContext(ctx, qu, a.args...); err != nil {
+		clog.Errorf("couldn't update quads of a graph: %v", err)
+		return err
+	}
+	if add && del && added != total {
+		// remove quads that already existed in the destination graph
+		a = qs.newArgs()
+		_, err = tx.ExecContext(ctx, `DELETE FROM quads WHERE `+a.label("", from)+`;`, a.args...)
+		if err != nil {
+			clog.Errorf("couldn't exec DELETE statement: %v", err)
+			return err
+		}
+	}
+
+	// update reference counters and remove unused nodes
+	p := qs.flavor.Placeholder
+	updateNode, err := tx.PrepareContext(ctx, `UPDATE nodes SET refs = refs + `+p(1)+` WHERE hash = `+p(2)+`;`)
+	if err != nil {
+		return err
+	}
+	defer updateNode.Close()
+	for h, n := range cnt {
+		if n == 0 {
+			continue
+		}
+		if _, err := updateNode.ExecContext(ctx, n, h.SQLValue()); err != nil {
+			clog.Errorf("couldn't exec UPDATE statement: %v", err)
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM nodes WHERE refs <= 0;`)
+	if err != nil {
+		clog.Errorf("couldn't exec DELETE nodes statement: %v", err)
+	}
+	return err
+}
@@ -37,9 +37,61 @@ func init() {
 		},
 		Estimated: nil,
 		RunTx:     runTxMysql,
+		Bulk: &csql.BulkRegistration{
+			Tables: []string{
+				`DROP TEMPORARY TABLE IF EXISTS bulk_nodes, bulk_quads, bulk_refs;`,
+				fmt.Sprintf(`CREATE TEMPORARY TABLE bulk_nodes (
+	hash BINARY(%d) PRIMARY KEY,
+	value BLOB,
+	value_string TEXT,
+	datatype TEXT,
+	language TEXT,
+	iri BOOLEAN,
+	bnode BOOLEAN,
+	value_int BIGINT,
+	value_bool BOOLEAN,
+	value_float double precision,
+	value_time DATETIME(6)
+);`, quad.HashSize),
+				fmt.Sprintf(`CREATE TEMPORARY TABLE bulk_quads (
+	subject_hash BINARY(%d) NOT NULL,
+	predicate_hash BINARY(%d) NOT NULL,
+	object_hash BINARY(%d) NOT NULL,
+	label_hash BINARY(%d)
+);`, quad.HashSize, quad.HashSize, quad.HashSize, quad.HashSize),
+				fmt.Sprintf(`CREATE TEMPORARY TABLE bulk_refs (
+	hash BINARY(%d) PRIMARY KEY,
+	n BIGINT NOT NULL
+);`, quad.HashSize),
+			},
+			Merge: bulkMerge,
+		},
 	})
 }
 
+// temporary tables cannot be referenced twice in one query, thus references are counted for each direction separately
+var bulkMerge = []string{
+	`DELETE b FROM bulk_quads AS b JOIN quads AS q
+	ON q.subject_hash = b.subject_hash AND q.predicate_hash = b.predicate_hash
+	AND q.object_hash = b.object_hash AND q.label_hash <=> b.label_hash;`,
+	`INSERT IGNORE INTO nodes(refs, hash, value, value_string, datatype, language, iri, bnode,
+	value_int, value_bool, value_float, value_time)
+	SELECT 0, hash, value, value_string, datatype, language, iri, bnode,
+	value_int, value_bool, value_float, value_time FROM bulk_nodes;`,
+	`INSERT INTO bulk_refs(hash, n) SELECT subject_hash, COUNT(*) FROM bulk_quads GROUP BY subject_hash
+	ON DUPLICATE KEY UPDATE n = n + VALUES(n);`,
+	`INSERT INTO bulk_refs(hash, n) SELECT predicate_hash, COUNT(*) FROM bulk_quads GROUP BY predicate_hash
+	ON DUPLICATE KEY UPDATE n = n + VALUES(n);`,
+	`INSERT INTO bulk_refs(hash, n) SELECT object_hash, COUNT(*) FROM bulk_quads GROUP BY object_hash
+	ON DUPLICATE KEY UPDATE n = n + VALUES(n);`,
+	`INSERT INTO bulk_refs(hash, n) SELECT label_hash, COUNT(*) FROM bulk_quads WHERE label_hash IS NOT NULL GROUP BY label_hash
+	ON DUPLICATE KEY UPDATE n = n + VALUES(n);`,
+	`UPDATE nodes AS n JOIN bulk_refs AS r ON r.hash = n.hash SET n.refs = n.refs + r.n;`,
+	`INSERT INTO quads(subject_hash, predicate_hash, object_hash, label_hash, ts)
+	SELECT subject_hash, predicate_hash, object_hash, label_hash, now() FROM bulk_quads;`,
+	`DROP TEMPORARY TABLE bulk_nodes, bulk_quads, bulk_refs;`,
+}
+
 // nodes -- deltas.IncNode
 // quads -- deltas.QuadAdd
 func runTxMysql(tx *sql.Tx, nodes []graphlog.NodeUpdate, quads []graphlog.QuadUpdate, opts graph.IgnoreOpts) error {
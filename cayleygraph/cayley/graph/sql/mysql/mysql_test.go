@@ -34,18 +34,23 @@ const (
 	mariadbImage = "mariadb:10"
 )
 
+var conf = &sqltest.Config{
+	TimeInMcs:     true,
+	UniqueTriples: true,
+}
+
 func TestMysql(t *testing.T) {
-	sqltest.TestAll(t, Type, makeMysqlVersion(mysqlImage), nil)
+	sqltest.TestAll(t, Type, makeMysqlVersion(mysqlImage), conf)
 }
 
 func TestMariaDB(t *testing.T) {
-	sqltest.TestAll(t, Type, makeMysqlVersion(mariadbImage), nil)
+	sqltest.TestAll(t, Type, makeMysqlVersion(mariadbImage), conf)
 }
 
 func BenchmarkMysql(t *testing.B) {
-	sqltest.BenchmarkAll(t, Type, makeMysqlVersion(mysqlImage), nil)
+	sqltest.BenchmarkAll(t, Type, makeMysqlVersion(mysqlImage), conf)
 }
 
 func BenchmarkMariadb(t *testing.B) {
-	sqltest.BenchmarkAll(t, Type, makeMysqlVersion(mariadbImage), nil)
+	sqltest.BenchmarkAll(t, Type, makeMysqlVersion(mariadbImage), conf)
 }
@@ -34,6 +34,8 @@ type Optimizer struct {
 
 	regexpOp             CmpOp
 	noOffsetWithoutLimit bool // blame mysql
+	recursiveCTE         bool
+	byteOrder            func(expr string) string
 }
 
 func (opt *Optimizer) SetRegexpOp(op CmpOp) {
@@ -44,6 +46,16 @@ func (opt *Optimizer) NoOffsetWithoutLimit() {
 	opt.noOffsetWithoutLimit = true
 }
 
+func (opt *Optimizer) RecursiveCTE() {
+	opt.recursiveCTE = true
+}
+
+// SetByteOrder sets a function that makes a string expression compare byte by byte.
+// Sorting is pushed down to the database only if it is set.
+func (opt *Optimizer) SetByteOrder(fnc func(expr string) string) {
+	opt.byteOrder = fnc
+}
+
 func (opt *Optimizer) nextTable() string {
 	opt.tableInd++
 	return fmt.Sprintf("t_%d", opt.tableInd)
@@ -100,6 +112,18 @@ func (opt *Optimizer) OptimizeShape(ctx context.Context, s shape.Shape) (shape.S
 		return opt.optimizeSave(s)
 	case shape.Page:
 		return opt.optimizePage(s)
+	case shape.Recursive:
+		return opt.optimizeRecursive(ctx, s)
+	case shape.Union:
+		return opt.optimizeUnion(s)
+	case shape.Sort:
+		return opt.optimizeSort(s)
+	case shape.Except:
+		return opt.optimizeExcept(s)
+	case shape.Count:
+		return opt.optimizeCount(s)
+	case shape.Unique:
+		return opt.optimizeUnique(s)
 	default:
 		return s, false
 	}
@@ -329,7 +353,10 @@ func (opt *Optimizer) optimizeQuads(s shape.Quads) (shape.Shape, bool) {
 			wr.Value = sel.AppendParam(fv[0].(Value))
 			sel.Where = append(sel.Where, wr)
 		case Select:
-			if len(fv.Fields) == 1 {
+			if len(fv.Fields) == 1 && fv.Fields[0].Int {
+				// not a node
+				return s, false
+			} else if len(fv.Fields) == 1 {
 				// simple case - just add subquery to FROM
 				tbl := opt.nextTable()
 				sel.From = append(sel.From, Subquery{
@@ -568,3 +595,383 @@ func (opt *Optimizer) optimizeIntersect(s shape.Intersect) (shape.Shape, bool) {
 	other[0] = pri
 	return other, true
 }
+
+const (
+	cteNode  = "node"
+	cteDepth = "depth"
+	tagDepth = tagPref + cteDepth
+)
+
+// recursiveStep binds shape.RecursiveBase to a table of recursive CTE.
+type recursiveStep struct {
+	opt  *Optimizer
+	base Select
+}
+
+func (r recursiveStep) OptimizeShape(ctx context.Context, s shape.Shape) (shape.Shape, bool) {
+	if _, ok := s.(shape.RecursiveBase); ok {
+		return r.base, true
+	}
+	return r.opt.OptimizeShape(ctx, s)
+}
+
+// tableRefs counts references to a given table from FROM of the query and all its subqueries.
+func tableRefs(s Select, name string) (direct, all int) {
+	for _, c := range s.With {
+		_, n := tableRefs(c.Base, name)
+		all += n
+		_, n = tableRefs(c.Step, name)
+		all += n
+	}
+	for _, src := range s.From {
+		switch src := src.(type) {
+		case Table:
+			if src.Name == name {
+				direct++
+				all++
+			}
+		case Subquery:
+			_, n := tableRefs(src.Query, name)
+			all += n
+		}
+	}
+	return direct, all
+}
+
+func (opt *Optimizer) optimizeRecursive(ctx context.Context, s shape.Recursive) (shape.Shape, bool) {
+	if !opt.recursiveCTE || s.Step == nil {
+		return s, false
+	}
+	depth := s.MaxDepth
+	if depth == 0 {
+		depth = iterator.DefaultMaxRecursiveSteps
+	} else if depth < 0 {
+		// unlimited recursion cannot be expressed with UNION, since it returns nodes at different depths
+		return s, false
+	}
+	from, ok := s.From.(Select)
+	if !ok || len(from.Fields) != 1 || from.Fields[0].Alias != tagNode || from.Fields[0].Int {
+		// tags from the source cannot be passed through the recursion
+		return s, false
+	}
+	nested := false
+	shape.Walk(s.Step, func(s shape.Shape) bool {
+		if _, ok := s.(shape.Recursive); ok {
+			nested = true
+		}
+		return !nested
+	})
+	if nested {
+		// RecursiveBase of the inner recursion will be bound to the wrong table
+		return s, false
+	}
+	cte, alias := opt.nextTable(), opt.nextTable()
+	// the base must be merged into the step, since the CTE cannot be referenced from a subquery;
+	// an additional field prevents it from being added as a subquery
+	step, _ := s.Step.Optimize(ctx, recursiveStep{opt: opt, base: Select{
+		Fields: []Field{
+			{Table: alias, Name: cteNode, Alias: tagNode},
+			{Table: alias, Name: cteDepth, Alias: tagDepth},
+		},
+		From: []Source{
+			Table{Name: cte, Alias: alias},
+		},
+	}})
+	sel, ok := step.(Select)
+	if !ok || sel.onlyAsSubquery() {
+		return s, false
+	}
+	if direct, all := tableRefs(sel, cte); direct != 1 || all != 1 {
+		return s, false
+	}
+	sel = sel.Clone()
+	var head *Field
+	for i := 0; i < len(sel.Fields); i++ {
+		f := &sel.Fields[i]
+		switch f.Alias {
+		case tagNode:
+			head = f
+		case tagDepth:
+			sel.Fields = append(sel.Fields[:i], sel.Fields[i+1:]...)
+			i--
+		default:
+			// tags from the step cannot be passed through the recursion
+			return s, false
+		}
+	}
+	if head == nil {
+		return s, false
+	}
+	f := *head
+	f.Alias = ""
+	sel.Fields = []Field{
+		f,
+		{Name: alias + "." + cteDepth + " + 1", Raw: true},
+	}
+	sel.Where = append(sel.Where, Where{
+		Table: alias, Field: cteDepth, Op: OpLT,
+		Value: sel.AppendParam(IntVal(depth)),
+	})
+	sel.nextPath = false
+
+	base := opt.nextTable()
+	out := Select{
+		With: []RecursiveCTE{{
+			Name:    cte,
+			Columns: []string{cteNode, cteDepth},
+			Base: Select{
+				Fields: []Field{
+					{Table: base, Name: tagNode},
+					{Name: "0", Raw: true},
+				},
+				From: []Source{
+					Subquery{Query: from, Alias: base},
+				},
+			},
+			Step: sel,
+		}},
+		Fields: []Field{
+			{Table: cte, Name: cteNode, Alias: tagNode},
+		},
+		From: []Source{
+			Table{Name: cte},
+		},
+		GroupBy: []FieldName{
+			{Table: cte, Name: cteNode},
+		},
+	}
+	for _, tag := range s.DepthTags {
+		out.Fields = append(out.Fields, Field{
+			Name: "MIN(" + cte + "." + cteDepth + ")", Raw: true, Int: true, Alias: tag,
+		})
+	}
+	// nodes of the source are only returned if they are reached by the recursion
+	out.Where = append(out.Where, Where{
+		Table: cte, Field: cteDepth, Op: OpGT,
+		Value: out.AppendParam(IntVal(0)),
+	})
+	return out, true
+}
+
+// headField returns an index of the field used as a primary value of the query, or -1 if there is none.
+func headField(s Select) int {
+	for i, f := range s.Fields {
+		if f.Alias == tagNode {
+			return i
+		}
+	}
+	return -1
+}
+
+// asSubquery wraps the query into a subquery that returns the same columns.
+func (opt *Optimizer) asSubquery(s Select) Select {
+	alias := opt.nextTable()
+	out := Select{
+		From: []Source{
+			Subquery{Query: s, Alias: alias},
+		},
+		nextPath: s.nextPath,
+	}
+	for _, f := range s.Fields {
+		name := f.NameOrAlias()
+		out.Fields = append(out.Fields, Field{
+			Table: alias, Name: name, Alias: name, Int: f.Int,
+		})
+	}
+	return out
+}
+
+// mergeable returns a copy of the query that can be extended with new tables and conditions.
+func (opt *Optimizer) mergeable(s Select) Select {
+	if s.onlyAsSubquery() {
+		return opt.asSubquery(s)
+	}
+	s = s.Clone()
+	opt.ensureAliases(&s)
+	return s
+}
+
+func (opt *Optimizer) optimizeUnion(s shape.Union) (shape.Shape, bool) {
+	var (
+		sels  []Select
+		cols  []string
+		other shape.Union
+	)
+	// we will add our merged Select to this slot
+	other = append(other, nil)
+	for _, sub := range s {
+		sel, ok := sub.(Select)
+		if !ok {
+			other = append(other, sub)
+			continue
+		}
+		scols := append([]string{}, sel.Columns()...)
+		sort.Strings(scols)
+		if cols == nil {
+			cols = scols
+		} else if strings.Join(cols, ",") != strings.Join(scols, ",") {
+			// TODO: fill missing tags with NULLs
+			other = append(other, sub)
+			continue
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) <= 1 {
+		return s, false
+	}
+	alias := opt.nextTable()
+	u := Union{Alias: alias}
+	out := Select{
+		From: []Source{u},
+	}
+	for i, sel := range sels {
+		if sel.onlyAsSubquery() {
+			sel = opt.asSubquery(sel)
+		} else {
+			sel = sel.Clone()
+		}
+		// all queries must return columns in the same order
+		sort.SliceStable(sel.Fields, func(i, j int) bool {
+			return sel.Fields[i].NameOrAlias() < sel.Fields[j].NameOrAlias()
+		})
+		if i == 0 {
+			for _, f := range sel.Fields {
+				name := f.NameOrAlias()
+				out.Fields = append(out.Fields, Field{
+					Table: alias, Name: name, Alias: name, Int: f.Int,
+				})
+			}
+		} else {
+			for j, f := range sel.Fields {
+				if f.Int != out.Fields[j].Int {
+					return s, false
+				}
+			}
+		}
+		out.nextPath = out.nextPath || sel.nextPath
+		u.Queries = append(u.Queries, sel)
+	}
+	out.From[0] = u
+	if len(other) == 1 {
+		return out, true
+	}
+	other[0] = out
+	return other, true
+}
+
+func (opt *Optimizer) optimizeSort(s shape.Sort) (shape.Shape, bool) {
+	from, ok := s.From.(Select)
+	if !ok || opt.byteOrder == nil {
+		return s, false
+	}
+	sel := opt.mergeable(from)
+	i := headField(sel)
+	if i < 0 || sel.Fields[i].Int {
+		return s, false
+	}
+	head := sel.Fields[i]
+	n := opt.nextTable()
+	sel.From = append(sel.From, Table{Name: "nodes", Alias: n})
+	sel.Where = append(sel.Where, Where{
+		Table: n, Field: "hash", Op: OpEqual,
+		Value: FieldName{Table: head.Table, Name: head.Name},
+	})
+	// iterator.Sort compares string representations of values; IRIs and blank nodes are not escaped
+	// in them, thus the database can build the same representation and compare it byte by byte
+	key := fmt.Sprintf(`CASE WHEN %[1]s.iri IS NOT NULL THEN '<' || %[1]s.value_string || '>' ELSE '_:' || %[1]s.value_string END`, n)
+	sel.OrderBy = []Field{
+		{Name: opt.byteOrder(key), Raw: true},
+		// keep rows of the same node together for NextPath
+		{Table: head.Table, Name: head.Name},
+	}
+	// the order of other values is different, so the query must be sorted by iterator.Sort if it returns them
+	check := opt.mergeable(from)
+	head = check.Fields[headField(check)]
+	n = opt.nextTable()
+	check.Fields = []Field{{Name: "1", Raw: true}}
+	check.From = append(check.From, Table{Name: "nodes", Alias: n})
+	check.Where = append(check.Where,
+		Where{
+			Table: n, Field: "hash", Op: OpEqual,
+			Value: FieldName{Table: head.Table, Name: head.Name},
+		},
+		Where{Table: n, Field: "iri", Op: OpIsNull},
+		Where{Table: n, Field: "bnode", Op: OpIsNull},
+	)
+	check.Limit = 1
+	return Sort{Query: sel, From: from, Check: check}, true
+}
+
+func (opt *Optimizer) optimizeExcept(s shape.Except) (shape.Shape, bool) {
+	exc, ok := s.Exclude.(Select)
+	if !ok {
+		return s, false
+	}
+	from := AllNodes()
+	if s.From != nil {
+		if from, ok = s.From.(Select); !ok {
+			return s, false
+		}
+	}
+	if i := headField(exc); i < 0 || exc.Fields[i].Int {
+		return s, false
+	}
+	sel := opt.mergeable(from)
+	i := headField(sel)
+	if i < 0 || sel.Fields[i].Int {
+		return s, false
+	}
+	head := sel.Fields[i]
+	alias := opt.nextTable()
+	sub := Select{
+		Fields: []Field{
+			{Name: "1", Raw: true},
+		},
+		From: []Source{
+			Subquery{Query: exc, Alias: alias},
+		},
+		Where: []Where{{
+			Table: alias, Field: tagNode, Op: OpEqual,
+			Value: FieldName{Table: head.Table, Name: head.Name},
+		}},
+	}
+	sel.Where = append(sel.Where, Where{
+		Op:    OpNotExists,
+		Value: SubSelect{Query: sub},
+	})
+	sel.Params = append(sel.Params, sub.Args()...)
+	return sel, true
+}
+
+func (opt *Optimizer) optimizeCount(s shape.Count) (shape.Shape, bool) {
+	from, ok := s.Values.(Select)
+	if !ok {
+		return s, false
+	}
+	return Select{
+		Fields: []Field{
+			{Name: "COUNT(*)", Raw: true, Int: true, Alias: tagNode},
+		},
+		From: []Source{
+			Subquery{Query: from, Alias: opt.nextTable()},
+		},
+		aggregate: true,
+	}, true
+}
+
+func (opt *Optimizer) optimizeUnique(s shape.Unique) (shape.Shape, bool) {
+	from, ok := s.From.(Select)
+	if !ok || len(from.Fields) != 1 || from.Fields[0].Alias != tagNode {
+		// DISTINCT would consider tags as well
+		return s, false
+	}
+	var sel Select
+	if from.onlyAsSubquery() {
+		sel = opt.asSubquery(from)
+	} else {
+		sel = from.Clone()
+	}
+	sel.Distinct = true
+	sel.nextPath = false
+	return sel, true
+}
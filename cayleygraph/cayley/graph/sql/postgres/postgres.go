@@ -34,14 +34,87 @@ func init() {
 		QueryDialect:       QueryDialect,
 		ConditionalIndexes: true,
 		FillFactor:         true,
-		Error:              ConvError,
+		RecursiveCTE:       true,
+		SnapshotRows:       csql.SnapshotCursor,
+		ByteOrder: func(expr string) string {
+			return "(" + expr + `) COLLATE "C"`
+		},
+		Error: ConvError,
 		Estimated: func(table string) string {
 			return "SELECT reltuples::BIGINT AS estimate FROM pg_class WHERE relname='" + table + "';"
 		},
 		RunTx: RunTxPostgres,
+		Bulk: &csql.BulkRegistration{
+			Tables: []string{
+				`CREATE TEMPORARY TABLE bulk_nodes (
+	hash BYTEA PRIMARY KEY,
+	value BYTEA,
+	value_string TEXT,
+	datatype TEXT,
+	language TEXT,
+	iri BOOLEAN,
+	bnode BOOLEAN,
+	value_int BIGINT,
+	value_bool BOOLEAN,
+	value_float double precision,
+	value_time timestamp with time zone
+) ON COMMIT DROP;`,
+				`CREATE TEMPORARY TABLE bulk_quads (
+	subject_hash BYTEA NOT NULL,
+	predicate_hash BYTEA NOT NULL,
+	object_hash BYTEA NOT NULL,
+	label_hash BYTEA
+) ON COMMIT DROP;`,
+			},
+			Copy:  CopyFrom,
+			Merge: bulkMerge,
+		},
 	})
 }
 
+var bulkMerge = []string{
+	`DELETE FROM bulk_quads AS b USING quads AS q
+	WHERE q.subject_hash = b.subject_hash AND q.predicate_hash = b.predicate_hash
+	AND q.object_hash = b.object_hash AND q.label_hash IS NOT DISTINCT FROM b.label_hash;`,
+	`INSERT INTO nodes(refs, hash, value, value_string, datatype, language, iri, bnode,
+	value_int, value_bool, value_float, value_time)
+	SELECT 0, hash, value, value_string, datatype, language, iri, bnode,
+	value_int, value_bool, value_float, value_time FROM bulk_nodes
+	ON CONFLICT (hash) DO NOTHING;`,
+	`UPDATE nodes SET refs = nodes.refs + r.n FROM (
+		SELECT h, COUNT(*) AS n FROM (
+			SELECT subject_hash AS h FROM bulk_quads
+			UNION ALL SELECT predicate_hash FROM bulk_quads
+			UNION ALL SELECT object_hash FROM bulk_quads
+			UNION ALL SELECT label_hash FROM bulk_quads WHERE label_hash IS NOT NULL
+		) AS d GROUP BY h
+	) AS r WHERE nodes.hash = r.h;`,
+	`INSERT INTO quads(subject_hash, predicate_hash, object_hash, label_hash, ts)
+	SELECT subject_hash, predicate_hash, object_hash, label_hash, now() FROM bulk_quads;`,
+}
+
+// CopyFrom loads rows into a table with COPY.
+func CopyFrom(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err = stmt.Exec(row...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err = stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
+
 func ConvError(err error) error {
 	e, ok := err.(*pq.Error)
 	if !ok {
@@ -0,0 +1,97 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/quad"
+)
+
+var _ graph.ConditionalWriter = (*QuadStore)(nil)
+
+// ApplyDeltasIf implements graph.ConditionalWriter.
+//
+// Preconditions are checked in the same transaction that applies the deltas. The transaction runs
+// with the serializable isolation level, thus concurrent writes that invalidate a precondition
+// either wait for it or fail the transaction.
+//
+// The SQL backend does not track versions, thus VersionEquals is not supported.
+func (qs *QuadStore) ApplyDeltasIf(conds []graph.Precondition, in []graph.Delta, opts graph.IgnoreOpts) error {
+	return qs.applyDeltas(conds, in, opts)
+}
+
+// checkPreconditions returns a PreconditionError for the first precondition that does not hold.
+func (qs *QuadStore) checkPreconditions(ctx context.Context, tx *sql.Tx, conds []graph.Precondition) error {
+	for _, c := range conds {
+		ok, err := qs.checkPrecondition(ctx, tx, c)
+		if err != nil {
+			return &graph.PreconditionError{Precondition: c, Err: err}
+		} else if !ok {
+			return &graph.PreconditionError{Precondition: c, Err: graph.ErrPreconditionFailed}
+		}
+	}
+	return nil
+}
+
+func (qs *QuadStore) checkPrecondition(ctx context.Context, tx *sql.Tx, c graph.Precondition) (bool, error) {
+	switch c := c.(type) {
+	case graph.QuadExists:
+		ok, err := qs.hasQuadTx(ctx, tx, c.Quad)
+		return ok, err
+	case graph.QuadNotExists:
+		ok, err := qs.hasQuadTx(ctx, tx, c.Quad)
+		return !ok, err
+	case graph.NoLinks:
+		if c.Dir < quad.Subject || c.Dir > quad.Label {
+			return false, fmt.Errorf("invalid direction: %v", c.Dir)
+		}
+		ok, err := qs.hasLinksTx(ctx, tx, c.Node, c.Dir, c.Via)
+		return !ok, err
+	}
+	return false, graph.ErrOperationNotSupported
+}
+
+// hasQuadTx checks if a quad exists.
+func (qs *QuadStore) hasQuadTx(ctx context.Context, tx *sql.Tx, q quad.Quad) (bool, error) {
+	var (
+		where string
+		args  []interface{}
+	)
+	for _, d := range quad.Directions {
+		if where != "" {
+			where += ` AND `
+		}
+		v := q.Get(d)
+		if v == nil {
+			where += dirField(d) + ` IS NULL`
+			continue
+		}
+		args = append(args, HashOf(v).SQLValue())
+		where += dirField(d) + ` = ` + qs.flavor.Placeholder(len(args))
+	}
+	return existsTx(ctx, tx, `SELECT 1 FROM quads WHERE `+where+` LIMIT 1;`, args...)
+}
+
+// hasLinksTx checks if a node has any quads in a given direction, optionally with a given predicate.
+func (qs *QuadStore) hasLinksTx(ctx context.Context, tx *sql.Tx, node quad.Value, d quad.Direction, via quad.Value) (bool, error) {
+	where := dirField(d) + ` = ` + qs.flavor.Placeholder(1)
+	args := []interface{}{HashOf(node).SQLValue()}
+	if via != nil {
+		args = append(args, HashOf(via).SQLValue())
+		where += ` AND ` + dirField(quad.Predicate) + ` = ` + qs.flavor.Placeholder(2)
+	}
+	return existsTx(ctx, tx, `SELECT 1 FROM quads WHERE `+where+` LIMIT 1;`, args...)
+}
+
+func existsTx(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (bool, error) {
+	var one int64
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
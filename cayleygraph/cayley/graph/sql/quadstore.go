@@ -115,6 +115,8 @@ type QuadStore struct {
 	mu    sync.RWMutex
 	nodes int64
 	quads int64
+
+	sn *snapshotTx // set only for snapshots
 }
 
 func connect(addr string, flavor string, opts graph.Options) (*sql.DB, error) {
@@ -295,6 +297,10 @@ func New(typ string, addr string, options graph.Options) (graph.QuadStore, error
 	if qs.flavor.NoOffsetWithoutLimit {
 		qs.opt.NoOffsetWithoutLimit()  // OffsetWithoutLimit
 	}
+	if qs.flavor.RecursiveCTE {
+		qs.opt.RecursiveCTE()
+	}
+	qs.opt.SetByteOrder(qs.flavor.ByteOrder)
 
 	if local, err := options.BoolKey("local_optimize", false); err != nil {
 		return nil, err
@@ -363,6 +369,9 @@ func NodeValues(h NodeHash, v quad.Value) (ValueType, []interface{}, error) {
 }
 
 func (qs *QuadStore) NewQuadWriter() (quad.WriteCloser, error) {
+	if qs.flavor.Bulk != nil && qs.sn == nil {
+		return &bulkWriter{qs: qs}, nil
+	}
 	return &quadWriter{qs: qs}, nil
 }
 
@@ -407,11 +416,27 @@ func (w *quadWriter) Close() error {
 }
 
 func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
+	return qs.applyDeltas(nil, in, opts)
+}
+
+func (qs *QuadStore) applyDeltas(conds []graph.Precondition, in []graph.Delta, opts graph.IgnoreOpts) error {
+	if qs.sn != nil {
+		return graph.ErrReadOnly
+	}
+	if err := graph.CheckNoExpiry(in); err != nil {
+		return err
+	}
+	ctx := context.TODO()
 	// first calculate values ref deltas
 	// 分解事务
 	deltas := graphlog.SplitDeltas(in)
 	// 开启事务(orm)
-	tx, err := qs.db.Begin()
+	var txOpts *sql.TxOptions
+	if len(conds) != 0 {
+		// preconditions must not be invalidated by concurrent writes
+		txOpts = &sql.TxOptions{Isolation: sql.LevelSerializable}
+	}
+	tx, err := qs.db.BeginTx(ctx, txOpts)
 	if err != nil {
 		clog.Errorf("couldn't begin write transaction: %v", err)
 		return err
@@ -432,6 +457,9 @@ func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error
 	}
 	// todo: 这个大结构可以注意下
 	err = retry(tx, func() error {
+		if err := qs.checkPreconditions(ctx, tx, conds); err != nil {
+			return err
+		}
 		// 调用实际注册的db的RunTx方法
 		err = qs.flavor.RunTx(tx, deltas.IncNode, deltas.QuadAdd, opts)
 		if err != nil {
@@ -567,12 +595,21 @@ func (qs *QuadStore) QuadIterator(d quad.Direction, val graph.Ref) iterator.Shap
 }
 
 func (qs *QuadStore) querySize(ctx context.Context, sel Select) (refs.Size, error) {
-	// 重新修改值
-	sel.Fields = []Field{
+	count := []Field{
 		{Name: "COUNT(*)", Raw: true}, // TODO: proper support for expressions
 	}
+	if sel.onlyAsSubquery() {
+		// rows might be grouped, ordered or limited, thus we need to count them in a subquery
+		sel = Select{
+			Fields: count,
+			From:   []Source{Subquery{Query: sel, Alias: "t"}},
+		}
+	} else {
+		// 重新修改值
+		sel.Fields = count
+	}
 	var sz int64
-	err := qs.QueryRow(ctx, sel).Scan(&sz)
+	err := qs.queryShapeRow(ctx, sel).Scan(&sz)
 	if err != nil {
 		return refs.Size{}, err
 	}
@@ -683,7 +720,7 @@ func (qs *QuadStore) NameOf(v graph.Ref) (quad.Value, error) {
 		value_float,
 		value_time
 	FROM nodes WHERE hash = ` + qs.flavor.Placeholder(1) + ` LIMIT 1;`
-	c := qs.db.QueryRow(query, hash.SQLValue())
+	c := qs.queryRow(context.TODO(), query, hash.SQLValue())
 	var (
 		data   []byte
 		str    sql.NullString
@@ -774,11 +811,11 @@ func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error)
 		st.Quads.Exact = false
 		st.Nodes.Exact = false
 	}
-	err := qs.db.QueryRow(query("quads")).Scan(&st.Quads.Value)
+	err := qs.queryRow(ctx, query("quads")).Scan(&st.Quads.Value)
 	if err != nil {
 		return graph.Stats{}, err
 	}
-	err = qs.db.QueryRow(query("nodes")).Scan(&st.Nodes.Value)
+	err = qs.queryRow(ctx, query("nodes")).Scan(&st.Nodes.Value)
 	if err != nil {
 		return graph.Stats{}, err
 	}
@@ -792,6 +829,9 @@ func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error)
 }
 
 func (qs *QuadStore) Close() error {
+	if qs.sn != nil {
+		return qs.sn.tx.Rollback()
+	}
 	return qs.db.Close()
 }
 
@@ -815,7 +855,7 @@ func (qs *QuadStore) sizeForIterator(dir quad.Direction, hash NodeHash) int64 {
 	if clog.V(4) {
 		clog.Infof("sql: getting size for select %s, %v", dir.String(), hash)
 	}
-	err = qs.db.QueryRow(
+	err = qs.queryRow(context.TODO(),
 		fmt.Sprintf("SELECT count(*) FROM quads WHERE %s_hash = "+qs.flavor.Placeholder(1)+";", dir.String()), hash.SQLValue()).Scan(&size)
 	if err != nil {
 		clog.Errorf("Error getting size from SQL database: %v", err)
@@ -149,6 +149,7 @@ func (f FieldName) SQL(b *Builder) string {
 type Field struct {
 	Name  string
 	Raw   bool // do not quote Name
+	Int   bool // field is an integer, not a node hash
 	Alias string
 	Table string
 }
@@ -205,6 +206,33 @@ func (s Subquery) Args() []Value {
 	return s.Query.Args()
 }
 
+// Union is a source that concatenates results of multiple queries with UNION ALL.
+// All queries must return the same columns in the same order.
+type Union struct {
+	Queries []Select
+	Alias   string
+}
+
+func (Union) isSource() {}
+func (s Union) SQL(b *Builder) string {
+	qs := make([]string, 0, len(s.Queries))
+	for _, q := range s.Queries {
+		qs = append(qs, q.SQL(b))
+	}
+	q := "(" + strings.Join(qs, "\nUNION ALL\n") + ")"
+	if s.Alias != "" {
+		q += " AS " + b.EscapeField(s.Alias)
+	}
+	return q
+}
+func (s Union) Args() []Value {
+	var args []Value
+	for _, q := range s.Queries {
+		args = append(args, q.Args()...)
+	}
+	return args
+}
+
 func (f Table) SQL(b *Builder) string {
 	if f.Alias == "" {
 		return f.Name
@@ -232,6 +260,8 @@ const (
 	OpLTE    = CmpOp("<=")
 	OpIsNull = CmpOp("IS NULL")
 	OpIsTrue = CmpOp("IS true")
+
+	OpNotExists = CmpOp("NOT EXISTS") // used without a field
 )
 
 type Expr interface {
@@ -247,6 +277,18 @@ func (Placeholder) SQL(b *Builder) string {
 	return b.Placeholder()
 }
 
+// SubSelect is a subquery used as an expression. Arguments of the query must be added to parameters
+// of the parent query in the place where the expression is used.
+type SubSelect struct {
+	Query Select
+}
+
+func (SubSelect) isExpr() {}
+
+func (s SubSelect) SQL(b *Builder) string {
+	return "(" + s.Query.SQL(b) + ")"
+}
+
 type Where struct {
 	Field string
 	Table string
@@ -260,34 +302,70 @@ func (w Where) SQL(b *Builder) string {
 	if w.Table != "" {
 		name = w.Table + "." + b.EscapeField(name)
 	}
-	parts := []string{name, string(w.Op)}
+	var parts []string
+	if name != "" {
+		parts = append(parts, name)
+	}
+	parts = append(parts, string(w.Op))
 	if w.Value != nil {
 		parts = append(parts, w.Value.SQL(b))
 	}
 	return strings.Join(parts, " ")
 }
 
+// RecursiveCTE is a recursive common table expression: WITH RECURSIVE Name(Columns) AS (Base UNION Step).
+type RecursiveCTE struct {
+	Name    string
+	Columns []string
+	Base    Select
+	Step    Select // must refer to Name exactly once
+}
+
+func (c RecursiveCTE) SQL(b *Builder) string {
+	cols := make([]string, 0, len(c.Columns))
+	for _, name := range c.Columns {
+		cols = append(cols, b.EscapeField(name))
+	}
+	return b.EscapeField(c.Name) + "(" + strings.Join(cols, ", ") + ") AS (\n" +
+		c.Base.SQL(b) + "\nUNION\n" + c.Step.SQL(b) + "\n)"
+}
+func (c RecursiveCTE) Args() []Value {
+	var args []Value
+	args = append(args, c.Base.Args()...)
+	args = append(args, c.Step.Args()...)
+	return args
+}
+
 var _ Shape = Select{}
 
 // Select is a simplified representation of SQL SELECT query.
 type Select struct {
-	Fields []Field
-	From   []Source
-	Where  []Where
-	Params []Value
-	Limit  int64
-	Offset int64
+	With     []RecursiveCTE
+	Distinct bool
+	Fields   []Field
+	From     []Source
+	Where    []Where
+	GroupBy  []FieldName
+	OrderBy  []Field
+	Params   []Value
+	Limit    int64
+	Offset   int64
 
 	// TODO(dennwc): this field in unexported because we don't want it to a be a part of the API
 	//               however, it's necessary to make NodesFrom optimizations to work with SQL
 	nextPath bool
+	// aggregate is set for queries that use aggregate functions without GROUP BY
+	aggregate bool
 }
 
 // Clone 深拷贝
 func (s Select) Clone() Select {
+	s.With = append([]RecursiveCTE{}, s.With...)
 	s.Fields = append([]Field{}, s.Fields...)
 	s.From = append([]Source{}, s.From...)
 	s.Where = append([]Where{}, s.Where...)
+	s.GroupBy = append([]FieldName{}, s.GroupBy...)
+	s.OrderBy = append([]Field{}, s.OrderBy...)
 	s.Params = append([]Value{}, s.Params...)
 	return s
 }
@@ -301,7 +379,8 @@ func (s Select) isAll() bool {
 // An example of such properties might be LIMIT, DISTINCT, etc.
 // 不能作为一个子查询（特征就是limit和offset都大于0)
 func (s Select) onlyAsSubquery() bool {
-	return s.Limit > 0 || s.Offset > 0
+	return s.Limit > 0 || s.Offset > 0 || s.Distinct || s.aggregate ||
+		len(s.With) != 0 || len(s.GroupBy) != 0 || len(s.OrderBy) != 0
 }
 
 // Columns 返回Column列表
@@ -319,7 +398,7 @@ func (s Select) Columns() []string {
 
 // BuildIterator 创建迭代器
 func (s Select) BuildIterator(qs graph.QuadStore) iterator.Shape {
-	sq, ok := qs.(*QuadStore)
+	sq, ok := graph.Base(qs).(*QuadStore)
 	if !ok {
 		return iterator.NewError(fmt.Errorf("not a SQL quadstore: %T", qs))
 	}
@@ -331,6 +410,26 @@ func (s Select) Optimize(ctx context.Context, r shape.Optimizer) (shape.Shape, b
 	return s, false
 }
 
+// Sort is a query sorted by the database. It is used only if Check returns no rows,
+// otherwise results of the unsorted query are sorted by iterator.Sort.
+type Sort struct {
+	Query Select // sorted query
+	From  Select // unsorted query
+	Check Select // returns a row if the database cannot sort results the same way as iterator.Sort
+}
+
+func (s Sort) BuildIterator(qs graph.QuadStore) iterator.Shape {
+	sq, ok := graph.Base(qs).(*QuadStore)
+	if !ok {
+		return iterator.NewError(fmt.Errorf("not a SQL quadstore: %T", qs))
+	}
+	return sq.newSortIterator(qs, s)
+}
+
+func (s Sort) Optimize(ctx context.Context, r shape.Optimizer) (shape.Shape, bool) {
+	return s, false
+}
+
 // AppendParam 追加参数
 func (s *Select) AppendParam(o Value) Expr {
 	s.Params = append(s.Params, o)
@@ -350,13 +449,25 @@ func (s *Select) WhereEq(tbl, field string, v Value) {
 func (s Select) SQL(b *Builder) string {
 	var parts []string
 
+	if len(s.With) != 0 {
+		var ctes []string
+		for _, c := range s.With {
+			ctes = append(ctes, c.SQL(b))
+		}
+		parts = append(parts, "WITH RECURSIVE "+strings.Join(ctes, ", "))
+	}
+
 	var fields []string
 	for _, f := range s.Fields {
 		// 注意用SQL进行外包装
 		fields = append(fields, f.SQL(b))
 	}
 	// 把fields连接起来
-	parts = append(parts, "SELECT "+strings.Join(fields, ", "))
+	if s.Distinct {
+		parts = append(parts, "SELECT DISTINCT "+strings.Join(fields, ", "))
+	} else {
+		parts = append(parts, "SELECT "+strings.Join(fields, ", "))
+	}
 
 	var tables []string
 	for _, t := range s.From {
@@ -373,6 +484,20 @@ func (s Select) SQL(b *Builder) string {
 		// 把Where用AND连接起来
 		parts = append(parts, "WHERE "+strings.Join(wheres, " AND "))
 	}
+	if len(s.GroupBy) != 0 {
+		var group []string
+		for _, f := range s.GroupBy {
+			group = append(group, f.SQL(b))
+		}
+		parts = append(parts, "GROUP BY "+strings.Join(group, ", "))
+	}
+	if len(s.OrderBy) != 0 {
+		var order []string
+		for _, f := range s.OrderBy {
+			order = append(order, f.SQL(b))
+		}
+		parts = append(parts, "ORDER BY "+strings.Join(order, ", "))
+	}
 	if s.Limit > 0 {
 		// limit参数
 		parts = append(parts, "LIMIT "+strconv.FormatInt(s.Limit, 10))
@@ -389,7 +514,11 @@ func (s Select) SQL(b *Builder) string {
 }
 func (s Select) Args() []Value {
 	var args []Value
-	// first add args for FROM subqueries
+	// common table expressions go first
+	for _, c := range s.With {
+		args = append(args, c.Args()...)
+	}
+	// then add args for FROM subqueries
 	for _, q := range s.From {
 		args = append(args, q.Args()...)
 	}
@@ -355,6 +355,41 @@ var shapeCases = []struct {
 		qu:   `SELECT t_5.object_hash AS __node FROM quads AS t_5, (SELECT t_3.subject_hash AS __node FROM quads AS t_3, (SELECT t_1.subject_hash AS __node FROM quads AS t_1, (SELECT subject_hash AS __node FROM quads WHERE predicate_hash = $1 AND object_hash = $2) AS t_2 WHERE t_1.predicate_hash = $3 AND t_1.object_hash = t_2.__node) AS t_4 WHERE t_3.predicate_hash = $4 AND t_3.object_hash = t_4.__node) AS t_6 WHERE t_5.predicate_hash = $5 AND t_5.subject_hash = t_6.__node`,
 		args: sVals("n", "k", "a", "s", "s"),
 	},
+	{
+		name: "union",
+		s: shape.Union{
+			shape.Lookup{quad.IRI("a")},
+			shape.Lookup{quad.IRI("b")},
+		},
+		qu:   "SELECT t_1.__node AS __node FROM (SELECT hash AS __node FROM nodes WHERE hash = $1\nUNION ALL\nSELECT hash AS __node FROM nodes WHERE hash = $2) AS t_1",
+		args: []Value{HashOf(quad.IRI("a")), HashOf(quad.IRI("b"))},
+	},
+	{
+		name: "union with limit",
+		s: shape.Union{
+			shape.Save{From: shape.AllNodes{}, Tags: []string{"x"}},
+			shape.Page{From: shape.Save{From: shape.AllNodes{}, Tags: []string{"x"}}, Limit: 2},
+		},
+		qu: "SELECT t_1.__node AS __node, t_1.x AS x\n\tFROM (SELECT hash AS __node, hash AS x\n\tFROM nodes\nUNION ALL\n" +
+			"SELECT t_2.__node AS __node, t_2.x AS x\n\tFROM (SELECT hash AS x, hash AS __node\n\tFROM nodes\n\tLIMIT 2) AS t_2) AS t_1",
+	},
+	{
+		name: "except",
+		s:    shape.Except{Exclude: shape.Lookup{quad.IRI("a")}},
+		qu:   `SELECT t_1.hash AS __node FROM nodes AS t_1 WHERE NOT EXISTS (SELECT 1 FROM (SELECT hash AS __node FROM nodes WHERE hash = $1) AS t_2 WHERE t_2.__node = t_1.hash)`,
+		args: []Value{HashOf(quad.IRI("a"))},
+	},
+	{
+		name: "count",
+		s:    shape.Count{Values: shape.Lookup{quad.IRI("a")}},
+		qu:   `SELECT COUNT(*) AS __node FROM (SELECT hash AS __node FROM nodes WHERE hash = $1) AS t_1`,
+		args: []Value{HashOf(quad.IRI("a"))},
+	},
+	{
+		name: "unique page",
+		s:    shape.Unique{From: shape.Page{From: shape.AllNodes{}, Skip: 2, Limit: 3}},
+		qu:   `SELECT DISTINCT t_1.__node AS __node FROM (SELECT hash AS __node FROM nodes LIMIT 3 OFFSET 2) AS t_1`,
+	},
 }
 
 func TestSQLShapes(t *testing.T) {
@@ -378,3 +413,82 @@ func TestSQLShapes(t *testing.T) {
 		})
 	}
 }
+
+func TestSQLRecursive(t *testing.T) {
+	dialect := DefaultDialect
+	dialect.Placeholder = func(i int) string {
+		return fmt.Sprintf("$%d", i)
+	}
+	s := shape.Recursive{
+		From: shape.Lookup{quad.IRI("a")},
+		Step: shape.NodesFrom{
+			Dir: quad.Object,
+			Quads: shape.Quads{
+				{Dir: quad.Subject, Values: shape.RecursiveBase{}},
+				{Dir: quad.Predicate, Values: shape.Fixed{sVal("p")}},
+			},
+		},
+		MaxDepth:  3,
+		DepthTags: []string{"depth"},
+	}
+
+	// recursion is not pushed down if the database does not support it
+	out, _ := s.Optimize(context.TODO(), NewOptimizer())
+	_, ok := out.(shape.Recursive)
+	require.True(t, ok, "%#v", out)
+
+	opt := NewOptimizer()
+	opt.RecursiveCTE()
+	out, ok = s.Optimize(context.TODO(), opt)
+	require.True(t, ok, "%#v", out)
+	sq, ok := out.(Select)
+	require.True(t, ok, "%#v", out)
+	b := NewBuilder(dialect)
+	require.Equal(t, `WITH RECURSIVE t_2(node, depth) AS (
+SELECT t_5.__node, 0
+	FROM (SELECT hash AS __node FROM nodes WHERE hash = $1) AS t_5
+UNION
+SELECT t_4.object_hash, t_3.depth + 1
+	FROM quads AS t_4, t_2 AS t_3
+	WHERE t_4.predicate_hash = $2 AND t_4.subject_hash = t_3.node AND t_3.depth < $3
+)
+	SELECT t_2.node AS __node, MIN(t_2.depth) AS depth
+	FROM t_2
+	WHERE t_2.depth > $4
+	GROUP BY t_2.node`, sq.SQL(b), "%#v", sq)
+	require.Equal(t, []Value{HashOf(quad.IRI("a")), sVal("p"), IntVal(3), IntVal(0)}, sq.Args())
+
+	// tags cannot be passed through the recursion
+	s.From = shape.Save{From: s.From, Tags: []string{"base"}}
+	out, _ = s.Optimize(context.TODO(), opt)
+	_, ok = out.(shape.Recursive)
+	require.True(t, ok, "%#v", out)
+}
+
+func TestSQLSort(t *testing.T) {
+	dialect := DefaultDialect
+	dialect.Placeholder = func(i int) string {
+		return fmt.Sprintf("$%d", i)
+	}
+	s := shape.Sort{From: shape.Lookup{quad.IRI("a")}}
+
+	// database cannot compare strings byte by byte
+	out, _ := s.Optimize(context.TODO(), NewOptimizer())
+	_, ok := out.(shape.Sort)
+	require.True(t, ok, "%#v", out)
+
+	opt := NewOptimizer()
+	opt.SetByteOrder(func(expr string) string {
+		return "(" + expr + ") COLLATE binary"
+	})
+	out, ok = s.Optimize(context.TODO(), opt)
+	require.True(t, ok, "%#v", out)
+	sq, ok := out.(Sort)
+	require.True(t, ok, "%#v", out)
+	require.Equal(t, `SELECT t_1.hash AS __node FROM nodes AS t_1, nodes AS t_2 WHERE t_1.hash = $1 AND t_2.hash = t_1.hash `+
+		`ORDER BY (CASE WHEN t_2.iri IS NOT NULL THEN '<' || t_2.value_string || '>' ELSE '_:' || t_2.value_string END) COLLATE binary, t_1.hash`,
+		sq.Query.SQL(NewBuilder(dialect)))
+	require.Equal(t, `SELECT 1 FROM nodes AS t_3, nodes AS t_4 WHERE t_3.hash = $1 AND t_4.hash = t_3.hash AND t_4.iri IS NULL AND t_4.bnode IS NULL LIMIT 1`,
+		sq.Check.SQL(NewBuilder(dialect)))
+	require.Equal(t, []Value{HashOf(quad.IRI("a"))}, sq.Check.Args())
+}
@@ -0,0 +1,316 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/internal/lru"
+)
+
+var _ graph.Snapshotter = (*QuadStore)(nil)
+
+// Snapshot implements graph.Snapshotter.
+//
+// The snapshot runs all queries in a single read-only transaction with repeatable read isolation,
+// that observes the state of the database at the time Snapshot returns. Since all queries share
+// a single connection, their results are read as set by Registration.SnapshotRows: SQLite streams them,
+// PostgreSQL and CockroachDB read them in batches through cursors. Other databases would have to read
+// results in full, thus they return graph.ErrOperationNotSupported.
+//
+// SQLite holds a read lock while the snapshot is open, which blocks writers with the default rollback journal.
+// Add _journal_mode=WAL to the address of the database to let writes proceed.
+func (qs *QuadStore) Snapshot(ctx context.Context) (graph.QuadStore, error) {
+	if qs.sn != nil {
+		return nil, fmt.Errorf("sql: cannot take a snapshot of a snapshot")
+	} else if qs.flavor.SnapshotRows == SnapshotNone {
+		return nil, graph.ErrOperationNotSupported
+	}
+	tx, err := qs.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Databases take the snapshot on the first read in the transaction, not at the beginning of it.
+	// SQLite also defers acquiring a read lock until then. Thus, read something right away.
+	var one int64
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM quads LIMIT 1;`).Scan(&one)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return nil, err
+	}
+	return &QuadStore{
+		db:      qs.db,
+		opt:     qs.opt,
+		flavor:  qs.flavor,
+		ids:     qs.ids, // hash to value mapping never changes
+		sizes:   lru.New(1024),
+		noSizes: qs.noSizes,
+		quads:   -1,
+		nodes:   -1,
+		sn:      &snapshotTx{tx: tx},
+	}, nil
+}
+
+// SnapshotRows is a way to read results of queries that share the connection of a snapshot.
+type SnapshotRows int
+
+const (
+	// SnapshotNone disables snapshots, since results would have to be read in full before the next query can run.
+	SnapshotNone = SnapshotRows(iota)
+	// SnapshotStream reads results directly, since the connection can read several of them at once.
+	SnapshotStream
+	// SnapshotCursor reads results in batches of snapshotFetch rows through cursors (DECLARE ... CURSOR).
+	SnapshotCursor
+)
+
+// snapshotFetch is the number of rows fetched from a cursor of a snapshot at once.
+var snapshotFetch = 1024
+
+// snapshotTx is a read-only transaction shared by all queries of a snapshot.
+type snapshotTx struct {
+	mu      sync.Mutex
+	tx      *sql.Tx
+	cursors int // number of cursors declared in the transaction, used for their names
+}
+
+// queryer is implemented by both sql.DB and sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn returns a connection that should be used for read queries.
+func (qs *QuadStore) conn() queryer {
+	if qs.sn != nil {
+		return qs.sn.tx
+	}
+	return qs.db
+}
+
+// rowsCursor is a subset of sql.Rows methods used by iterators.
+type rowsCursor interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close() error
+}
+
+// rowScanner is a subset of sql.Row methods.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// query runs a read query. For snapshots, results are read as set by Registration.SnapshotRows.
+func (qs *QuadStore) query(ctx context.Context, qu string, args ...interface{}) (rowsCursor, error) {
+	if qs.sn == nil {
+		return qs.db.QueryContext(ctx, qu, args...)
+	}
+	if qs.flavor.SnapshotRows == SnapshotStream {
+		return qs.sn.tx.QueryContext(ctx, qu, args...)
+	}
+	return qs.sn.declare(ctx, qu, args...)
+}
+
+// buffer runs a query in the snapshot transaction and reads its results in full,
+// so the transaction can be used by other queries right away. It is only used for
+// batches fetched from cursors and for queries that return a single row.
+func (sn *snapshotTx) buffer(ctx context.Context, qu string, args ...interface{}) (*bufferedRows, error) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	rows, err := sn.tx.QueryContext(ctx, qu, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return bufferRows(rows)
+}
+
+// declare opens a cursor for a query in the snapshot transaction.
+func (sn *snapshotTx) declare(ctx context.Context, qu string, args ...interface{}) (*cursorRows, error) {
+	sn.mu.Lock()
+	sn.cursors++
+	name := "cayley_snapshot_" + strconv.Itoa(sn.cursors)
+	sn.mu.Unlock()
+	qu = strings.TrimSuffix(strings.TrimSpace(qu), ";")
+	if _, err := sn.exec(ctx, "DECLARE "+name+" NO SCROLL CURSOR FOR "+qu+";", args...); err != nil {
+		return nil, err
+	}
+	return &cursorRows{sn: sn, ctx: ctx, name: name, cur: &bufferedRows{}}, nil
+}
+
+func (sn *snapshotTx) exec(ctx context.Context, qu string, args ...interface{}) (sql.Result, error) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	return sn.tx.ExecContext(ctx, qu, args...)
+}
+
+// cursorRows reads results of a query through a cursor, fetching a batch of rows at once.
+type cursorRows struct {
+	sn   *snapshotTx
+	ctx  context.Context
+	name string
+	cur  *bufferedRows
+	done bool // the last batch was fetched
+	err  error
+}
+
+func (r *cursorRows) Next() bool {
+	if r.cur.Next() {
+		return true
+	} else if r.done || r.err != nil {
+		return false
+	}
+	r.cur, r.err = r.sn.buffer(r.ctx, "FETCH "+strconv.Itoa(snapshotFetch)+" FROM "+r.name+";")
+	if r.err != nil {
+		r.cur = &bufferedRows{}
+		return false
+	}
+	r.done = len(r.cur.rows) < snapshotFetch
+	return r.cur.Next()
+}
+
+func (r *cursorRows) Scan(dest ...interface{}) error { return r.cur.Scan(dest...) }
+
+func (r *cursorRows) Err() error { return r.err }
+
+func (r *cursorRows) Close() error {
+	if r.name == "" {
+		return nil
+	}
+	r.cur.Close()
+	_, err := r.sn.exec(context.Background(), "CLOSE "+r.name+";")
+	r.name = ""
+	if err == sql.ErrTxDone {
+		// cursors are closed with the snapshot
+		return nil
+	}
+	return err
+}
+
+// queryRow is the same as query, but returns only the first row.
+func (qs *QuadStore) queryRow(ctx context.Context, qu string, args ...interface{}) rowScanner {
+	if qs.sn == nil {
+		return qs.db.QueryRowContext(ctx, qu, args...)
+	} else if qs.flavor.SnapshotRows == SnapshotStream {
+		return qs.sn.tx.QueryRowContext(ctx, qu, args...)
+	}
+	rows, err := qs.sn.buffer(ctx, qu, args...)
+	return &bufferedRow{rows: rows, err: err}
+}
+
+func bufferRows(rows *sql.Rows) (*bufferedRows, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	b := &bufferedRows{}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		// scanning to an interface copies byte slices, so it's safe to keep them
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		b.rows = append(b.rows, vals)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// bufferedRows is an in-memory implementation of rowsCursor.
+type bufferedRows struct {
+	rows [][]interface{}
+	cur  []interface{}
+}
+
+func (r *bufferedRows) Next() bool {
+	if len(r.rows) == 0 {
+		r.cur = nil
+		return false
+	}
+	r.cur, r.rows = r.rows[0], r.rows[1:]
+	return true
+}
+
+func (r *bufferedRows) Scan(dest ...interface{}) error {
+	if r.cur == nil {
+		return fmt.Errorf("sql: Scan called without calling Next")
+	} else if len(dest) != len(r.cur) {
+		return fmt.Errorf("sql: expected %d destination arguments in Scan, not %d", len(r.cur), len(dest))
+	}
+	for i, src := range r.cur {
+		if err := convertAssign(dest[i], src); err != nil {
+			return fmt.Errorf("sql: Scan error on column index %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *bufferedRows) Err() error { return nil }
+
+func (r *bufferedRows) Close() error {
+	r.rows, r.cur = nil, nil
+	return nil
+}
+
+// convertAssign copies a value scanned from the database to one of destination types used by the QuadStore.
+func convertAssign(dest, src interface{}) error {
+	switch d := dest.(type) {
+	case sql.Scanner:
+		return d.Scan(src)
+	case *interface{}:
+		*d = src
+		return nil
+	case *[]byte:
+		switch s := src.(type) {
+		case nil:
+			*d = nil
+		case []byte:
+			*d = append([]byte{}, s...)
+		case string:
+			*d = []byte(s)
+		default:
+			return fmt.Errorf("unsupported conversion from %T to %T", src, dest)
+		}
+		return nil
+	case *int64:
+		var v sql.NullInt64
+		if err := v.Scan(src); err != nil {
+			return err
+		}
+		*d = v.Int64
+		return nil
+	}
+	return fmt.Errorf("unsupported scan destination: %T", dest)
+}
+
+type bufferedRow struct {
+	rows rowsCursor
+	err  error
+}
+
+func (r *bufferedRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	return r.rows.Scan(dest...)
+}
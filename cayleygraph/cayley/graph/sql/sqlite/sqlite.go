@@ -44,15 +44,73 @@ func init() {
 		TimeType:             `DATETIME`,
 		QueryDialect:         QueryDialect,
 		NoOffsetWithoutLimit: true,
+		RecursiveCTE:         true,
+		SnapshotRows:         csql.SnapshotStream,
 		NoForeignKeys:        true,
+		ByteOrder: func(expr string) string {
+			return expr // BINARY collation is the default one
+		},
 		Error: func(err error) error {
 			return err
 		},
 		Estimated: nil,
 		RunTx:     runTxSqlite,
+		Bulk: &csql.BulkRegistration{
+			Tables: []string{
+				fmt.Sprintf(`CREATE TEMP TABLE bulk_nodes (
+	hash BINARY(%d) PRIMARY KEY,
+	value BLOB,
+	value_string TEXT,
+	datatype TEXT,
+	language TEXT,
+	iri BOOLEAN,
+	bnode BOOLEAN,
+	value_int BIGINT,
+	value_bool BOOLEAN,
+	value_float double precision,
+	value_time DATETIME
+);`, quad.HashSize),
+				fmt.Sprintf(`CREATE TEMP TABLE bulk_quads (
+	subject_hash BINARY(%d) NOT NULL,
+	predicate_hash BINARY(%d) NOT NULL,
+	object_hash BINARY(%d) NOT NULL,
+	label_hash BINARY(%d)
+);`, quad.HashSize, quad.HashSize, quad.HashSize, quad.HashSize),
+				fmt.Sprintf(`CREATE TEMP TABLE bulk_refs (
+	hash BINARY(%d) PRIMARY KEY,
+	n INTEGER NOT NULL
+);`, quad.HashSize),
+			},
+			Merge: bulkMerge,
+		},
 	})
 }
 
+var bulkMerge = []string{
+	`DELETE FROM bulk_quads WHERE EXISTS (
+		SELECT 1 FROM quads AS q WHERE q.subject_hash = bulk_quads.subject_hash
+		AND q.predicate_hash = bulk_quads.predicate_hash AND q.object_hash = bulk_quads.object_hash
+		AND q.label_hash IS bulk_quads.label_hash
+	);`,
+	`INSERT OR IGNORE INTO nodes(refs, hash, value, value_string, datatype, language, iri, bnode,
+	value_int, value_bool, value_float, value_time)
+	SELECT 0, hash, value, value_string, datatype, language, iri, bnode,
+	value_int, value_bool, value_float, value_time FROM bulk_nodes;`,
+	`INSERT INTO bulk_refs(hash, n) SELECT h, COUNT(*) FROM (
+		SELECT subject_hash AS h FROM bulk_quads
+		UNION ALL SELECT predicate_hash FROM bulk_quads
+		UNION ALL SELECT object_hash FROM bulk_quads
+		UNION ALL SELECT label_hash FROM bulk_quads WHERE label_hash IS NOT NULL
+	) GROUP BY h;`,
+	`UPDATE nodes SET refs = refs + (SELECT n FROM bulk_refs WHERE bulk_refs.hash = nodes.hash)
+	WHERE hash IN (SELECT hash FROM bulk_refs);`,
+	`INSERT INTO quads(subject_hash, predicate_hash, object_hash, label_hash, ts)
+	SELECT subject_hash, predicate_hash, object_hash, label_hash, datetime() FROM bulk_quads;`,
+	`DROP TABLE bulk_nodes;`,
+	`DROP TABLE bulk_quads;`,
+	`DROP TABLE bulk_refs;`,
+}
+
 func runTxSqlite(tx *sql.Tx, nodes []graphlog.NodeUpdate, quads []graphlog.QuadUpdate, opts graph.IgnoreOpts) error {
 	// update node ref counts and insert nodes
 	var (
@@ -17,14 +17,16 @@ func makeSqlite(t testing.TB) (string, graph.Options, func()) {
 	if err != nil {
 		t.Fatalf("Could not create working directory: %v", err)
 	}
-	return fmt.Sprintf("file:%s?_loc=UTC", tmpFile.Name()), nil, func() {
+	// snapshots block writers unless the database uses a write-ahead log
+	return fmt.Sprintf("file:%s?_loc=UTC&_journal_mode=WAL", tmpFile.Name()), nil, func() {
 		os.RemoveAll(tmpFile.Name())
 	}
 }
 
 var conf = &sqltest.Config{
-	TimeRound: true,
-	TimeInMcs: false,
+	TimeRound:     true,
+	TimeInMcs:     false,
+	UniqueTriples: true,
 }
 
 func TestSqlite(t *testing.T) {
@@ -1,6 +1,7 @@
 package sqltest
 
 import (
+	"context"
 	"testing"
 	"unicode/utf8"
 
@@ -13,8 +14,9 @@ import (
 )
 
 type Config struct {
-	TimeRound bool
-	TimeInMcs bool
+	TimeRound     bool
+	TimeInMcs     bool
+	UniqueTriples bool // database has no conditional indexes
 }
 
 func (c Config) quadStore() *graphtest.Config {
@@ -23,6 +25,7 @@ func (c Config) quadStore() *graphtest.Config {
 		TimeInMcs:           c.TimeInMcs,
 		TimeRound:           c.TimeRound,
 		OptimizesComparison: true,
+		UniqueTriples:       c.UniqueTriples,
 	}
 }
 
@@ -39,6 +42,10 @@ func TestAll(t *testing.T, typ string, fnc DatabaseFunc, c *Config) {
 		t.Parallel()
 		testZeroRune(t, create)
 	})
+	t.Run("bulk", func(t *testing.T) {
+		t.Parallel()
+		testBulk(t, create)
+	})
 }
 
 func BenchmarkAll(t *testing.B, typ string, fnc DatabaseFunc, c *Config) {
@@ -95,3 +102,41 @@ func testZeroRune(t testing.TB, create testutil.DatabaseFunc) {
 	require.NoError(t, err)
 	require.Equal(t, obj, qsn)
 }
+
+func testBulk(t testing.TB, create testutil.DatabaseFunc) {
+	qs, opts, closer := create(t)
+	defer closer()
+
+	quads := []quad.Quad{
+		quad.MakeIRI("a", "b", "c", ""),
+		quad.MakeIRI("a", "b", "d", "g"),
+		quad.MakeIRI("a", "b", "c", ""),
+		quad.Make(quad.IRI("d"), quad.IRI("b"), quad.Int(1), nil),
+	}
+	load := func(buf []quad.Quad) {
+		qw, err := qs.NewQuadWriter()
+		require.NoError(t, err)
+		_, err = qw.WriteQuads(buf)
+		require.NoError(t, err)
+		require.NoError(t, qw.Close())
+	}
+	load(quads[:2])
+	load(quads[2:])
+
+	ctx := context.TODO()
+	st, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), st.Quads.Value)
+	require.Equal(t, int64(6), st.Nodes.Value)
+
+	w := testutil.MakeWriter(t, qs, opts)
+	require.NoError(t, w.RemoveQuad(quads[0]))
+	require.NoError(t, w.RemoveQuad(quads[1]))
+	require.NoError(t, w.RemoveQuad(quads[3]))
+
+	// reference counters of nodes must match the number of loaded quads
+	st, err = qs.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), st.Quads.Value)
+	require.Equal(t, int64(0), st.Nodes.Value)
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"context"
+
+	"github.com/cayleygraph/cayley/graph/refs"
+)
+
+// PredicateStats contains cardinality statistics for quads with a single predicate.
+type PredicateStats struct {
+	Predicate Ref       // predicate node
+	Quads     refs.Size // number of quads with this predicate
+	Subjects  refs.Size // number of distinct subjects of these quads
+	Objects   refs.Size // number of distinct objects of these quads
+}
+
+// StatsProvider is an optional interface for QuadStores that track statistics for each predicate,
+// in addition to the totals reported by Stats. HasA iterators use them to estimate their costs.
+type StatsProvider interface {
+	// PredicateStats returns statistics for quads with a given predicate node.
+	PredicateStats(ctx context.Context, pred Ref) (PredicateStats, error)
+	// AllPredicateStats returns statistics for all predicates in the store.
+	AllPredicateStats(ctx context.Context) ([]PredicateStats, error)
+}
+
+func statsProvider(qs QuadIndexer) (StatsProvider, bool) {
+	if s, ok := qs.(QuadStore); ok {
+		qs = Unwrap(s)
+	}
+	p, ok := qs.(StatsProvider)
+	return p, ok
+}
+
+// GetPredicateStats returns statistics for quads with a given predicate node.
+//
+// It returns ErrOperationNotSupported if the backend does not track per-predicate statistics.
+func GetPredicateStats(ctx context.Context, qs QuadIndexer, pred Ref) (PredicateStats, error) {
+	if p, ok := statsProvider(qs); ok {
+		return p.PredicateStats(ctx, pred)
+	}
+	return PredicateStats{}, ErrOperationNotSupported
+}
+
+// GetAllPredicateStats returns statistics for all predicates in the QuadStore.
+//
+// It returns ErrOperationNotSupported if the backend does not track per-predicate statistics.
+func GetAllPredicateStats(ctx context.Context, qs QuadIndexer) ([]PredicateStats, error) {
+	if p, ok := statsProvider(qs); ok {
+		return p.AllPredicateStats(ctx)
+	}
+	return nil, ErrOperationNotSupported
+}
@@ -14,7 +14,11 @@
 
 package graph
 
-import "github.com/cayleygraph/quad"
+import (
+	"time"
+
+	"github.com/cayleygraph/quad"
+)
 
 // Transaction stores a bunch of Deltas to apply together in an atomic step on the database.
 type Transaction struct {
@@ -24,6 +28,8 @@ type Transaction struct {
 	// deltas stores the deltas in a map to avoid duplications
 	// 保证事务操作不重复
 	deltas map[Delta]struct{}
+	// Preconditions must hold for the transaction to be applied, see ConditionalWriter.
+	Preconditions []Precondition
 }
 
 // NewTransaction initialize a new transaction.
@@ -56,6 +62,24 @@ func (t *Transaction) AddQuad(q quad.Quad) {
 	}
 }
 
+// AddQuadExpiring adds a quad that expires at a given time to the transaction.
+// It replaces all other deltas for that quad in the transaction.
+func (t *Transaction) AddQuadExpiring(q quad.Quad, expires time.Time) {
+	for i := 0; i < len(t.Deltas); i++ {
+		if d := t.Deltas[i]; d.Quad == q {
+			delete(t.deltas, d)
+			t.Deltas = append(t.Deltas[:i], t.Deltas[i+1:]...)
+			i--
+		}
+	}
+	t.addDelta(Delta{Quad: q, Action: Add, Expires: expires})
+}
+
+// Require adds preconditions to the transaction. They are checked atomically with applying the transaction.
+func (t *Transaction) Require(conds ...Precondition) {
+	t.Preconditions = append(t.Preconditions, conds...)
+}
+
 // RemoveQuad adds a quad to remove to the transaction.
 // The quad will be removed from the database if it is not present in the
 // transaction, otherwise it simply remove it from the transaction.
@@ -2,6 +2,7 @@ package graph
 
 import (
 	"testing"
+	"time"
 
 	"github.com/cayleygraph/quad"
 )
@@ -59,4 +60,13 @@ func TestTransaction(t *testing.T) {
 	if len(tx.Deltas) != 1 {
 		t.Errorf("Expected [add, remove, remove]->[remove], have %d delta(s)", len(tx.Deltas))
 	}
+
+	// remove, add with expiry -> add with expiry
+	exp := time.Unix(100, 0)
+	tx = NewTransaction()
+	tx.RemoveQuad(quad.Make("E", "follows", "G", nil))
+	tx.AddQuadExpiring(quad.Make("E", "follows", "G", nil), exp)
+	if len(tx.Deltas) != 1 || tx.Deltas[0].Action != Add || !tx.Deltas[0].Expires.Equal(exp) {
+		t.Errorf("Expected [remove, add with expiry]->[add with expiry], have %v", tx.Deltas)
+	}
 }
@@ -0,0 +1,168 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package union implements a read-only QuadStore that merges quads of a number of QuadStores.
+package union
+
+import (
+	"context"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/cayley/internal/fanout"
+	"github.com/cayleygraph/quad"
+)
+
+func init() {
+	graph.RegisterUnion(func(members ...graph.QuadStore) graph.QuadStore {
+		return New(members...)
+	})
+}
+
+var _ graph.QuadStore = (*QuadStore)(nil)
+
+// QuadStore is a read-only union of QuadStores.
+//
+// Members must use the same value semantics: the same quad.Value must identify the same node in all of them.
+// Quads that are present in more than one member are returned only once, from the first member that has them:
+// each quad of a member is looked up in all members before it (see graph.QuadChecker).
+// Node refs are not tied to a particular member and carry the value instead.
+type QuadStore struct {
+	members fanout.Members
+}
+
+// New creates a read-only union of QuadStores, see graph.Union. Union takes ownership of the members and closes them on Close.
+func New(members ...graph.QuadStore) *QuadStore {
+	return &QuadStore{members: members}
+}
+
+// Members returns QuadStores combined by the union.
+func (qs *QuadStore) Members() []graph.QuadStore {
+	return qs.members
+}
+
+// hasQuadBefore checks if any of the members before a given one has the quad.
+//
+// It's called for each quad returned by all members except the first one. Members that implement graph.QuadChecker
+// find the quad by its values directly, while other members iterate over quads that share all nodes with it,
+// which is considerably slower (see graph.HasQuad).
+func (qs *QuadStore) hasQuadBefore(ctx context.Context, member int, subs []iterator.Shape, q quad.Quad) (bool, error) {
+	for j, m := range qs.members[:member] {
+		if subs != nil && subs[j] == nil {
+			continue // member cannot have such quads
+		}
+		if ok, err := graph.HasQuad(ctx, m, q); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+var _ graph.QuadChecker = (*QuadStore)(nil)
+
+// HasQuad implements graph.QuadChecker by checking all members.
+func (qs *QuadStore) HasQuad(ctx context.Context, q quad.Quad) (bool, error) {
+	return qs.hasQuadBefore(ctx, len(qs.members), nil, q)
+}
+
+func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
+	return graph.ErrReadOnly
+}
+
+func (qs *QuadStore) NewQuadWriter() (quad.WriteCloser, error) {
+	return nil, graph.ErrReadOnly
+}
+
+func (qs *QuadStore) NameOf(ref graph.Ref) (quad.Value, error) {
+	return fanout.ValueOf(ref), nil
+}
+
+func (qs *QuadStore) ValueOf(v quad.Value) (graph.Ref, error) {
+	return qs.members.ValueOf(v)
+}
+
+func (qs *QuadStore) Quad(ref graph.Ref) (quad.Quad, error) {
+	return qs.members.Quad(ref)
+}
+
+func (qs *QuadStore) QuadDirection(ref graph.Ref, d quad.Direction) (graph.Ref, error) {
+	return qs.members.QuadDirection(ref, d)
+}
+
+// QuadIterator returns a union of quad iterators of all members that have a given node.
+func (qs *QuadStore) QuadIterator(d quad.Direction, ref graph.Ref) iterator.Shape {
+	s, err := qs.quadsShape(context.TODO(), map[quad.Direction]graph.Ref{d: ref})
+	if err != nil {
+		return iterator.NewError(err)
+	}
+	return s.BuildIterator(qs)
+}
+
+// QuadIteratorSize returns an estimate made by each member that has a given node for its own quads.
+//
+// Estimates are added together, thus the size is an upper bound if more than one member has the node:
+// quads present in several members are returned only once.
+func (qs *QuadStore) QuadIteratorSize(ctx context.Context, d quad.Direction, ref graph.Ref) (refs.Size, error) {
+	st, err := qs.QuadIterator(d, ref).Stats(ctx)
+	return st.Size, err
+}
+
+func (qs *QuadStore) QuadsAllIterator() iterator.Shape {
+	subs := make([]iterator.Shape, 0, len(qs.members))
+	for _, m := range qs.members {
+		subs = append(subs, m.QuadsAllIterator())
+	}
+	return qs.members.Quads(subs, qs.hasQuadBefore)
+}
+
+func (qs *QuadStore) NodesAllIterator() iterator.Shape {
+	return qs.members.Nodes(false)
+}
+
+// Stats returns a sum of stats of all members. If exact stats are requested,
+// nodes and quads are counted, since the same node or quad may be present in multiple members.
+func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
+	if len(qs.members) == 1 {
+		return qs.members[0].Stats(ctx, exact)
+	}
+	var st graph.Stats
+	for _, m := range qs.members {
+		mst, err := m.Stats(ctx, false)
+		if err != nil {
+			return st, err
+		}
+		st.Nodes.Value += mst.Nodes.Value
+		st.Quads.Value += mst.Quads.Value
+	}
+	if !exact {
+		return st, nil
+	}
+	n, err := iterator.Iterate(ctx, qs.NodesAllIterator()).Paths(false).Count()
+	if err != nil {
+		return st, err
+	}
+	st.Nodes = refs.Size{Value: n, Exact: true}
+	n, err = iterator.Iterate(ctx, qs.QuadsAllIterator()).Paths(false).Count()
+	if err != nil {
+		return st, err
+	}
+	st.Quads = refs.Size{Value: n, Exact: true}
+	return st, nil
+}
+
+// Close closes all members.
+func (qs *QuadStore) Close() error {
+	return qs.members.Close()
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package union_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/graphtest"
+	"github.com/cayleygraph/cayley/graph/memstore"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/cayley/graph/union"
+	"github.com/cayleygraph/cayley/query/path"
+	"github.com/cayleygraph/cayley/query/shape"
+	"github.com/cayleygraph/quad"
+)
+
+func newUnion(t testing.TB) (graph.QuadStore, []quad.Quad) {
+	ref := []quad.Quad{
+		quad.MakeIRI("alice", "type", "person", ""),
+		quad.MakeIRI("bob", "type", "person", ""),
+		quad.MakeIRI("person", "label", "Person", ""),
+	}
+	tenant := []quad.Quad{
+		quad.MakeIRI("alice", "type", "person", ""), // duplicate
+		quad.MakeIRI("alice", "follows", "bob", ""),
+		quad.MakeIRI("carol", "follows", "alice", ""),
+	}
+	all := append(append([]quad.Quad{}, ref...), tenant[1:]...)
+	qs, err := graph.Union(memstore.New(ref...), memstore.New(tenant...))
+	require.NoError(t, err)
+	require.IsType(t, &union.QuadStore{}, qs)
+	return qs, all
+}
+
+func TestUnionQuads(t *testing.T) {
+	ctx := context.TODO()
+	qs, all := newUnion(t)
+	defer qs.Close()
+
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), all, true)
+
+	st, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, graph.Stats{
+		Nodes: refs.Size{Value: 8, Exact: true},
+		Quads: refs.Size{Value: int64(len(all)), Exact: true},
+	}, st)
+
+	alice, err := qs.ValueOf(quad.IRI("alice"))
+	require.NoError(t, err)
+	require.NotNil(t, alice)
+	carol, err := qs.ValueOf(quad.IRI("carol"))
+	require.NoError(t, err)
+	require.NotNil(t, carol, "value from the second member")
+	v, err := qs.ValueOf(quad.IRI("dave"))
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadIterator(quad.Subject, alice), []quad.Quad{
+		quad.MakeIRI("alice", "type", "person", ""),
+		quad.MakeIRI("alice", "follows", "bob", ""),
+	}, true)
+
+	sz, err := qs.QuadIteratorSize(ctx, quad.Subject, alice)
+	require.NoError(t, err)
+	require.Equal(t, refs.Size{Value: 3, Exact: false}, sz)
+	sz, err = qs.QuadIteratorSize(ctx, quad.Subject, carol)
+	require.NoError(t, err)
+	require.Equal(t, refs.Size{Value: 1, Exact: true}, sz)
+
+	require.Equal(t, graph.ErrReadOnly, qs.ApplyDeltas(nil, graph.IgnoreOpts{}))
+}
+
+func TestUnionPath(t *testing.T) {
+	ctx := context.TODO()
+	qs, _ := newUnion(t)
+	defer qs.Close()
+
+	// type comes from the first member, follows from the second one
+	p := path.StartPath(qs).Has(quad.IRI("type"), quad.IRI("person")).In(quad.IRI("follows"))
+	vals, err := p.Iterate(ctx).AllValues(qs)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []quad.Value{quad.IRI("alice"), quad.IRI("carol")}, vals)
+
+	p = path.StartPath(qs, quad.IRI("carol")).Out(quad.IRI("follows")).Out(quad.IRI("type")).Out(quad.IRI("label"))
+	vals, err = p.Iterate(ctx).AllValues(qs)
+	require.NoError(t, err)
+	require.Equal(t, []quad.Value{quad.IRI("Person")}, vals)
+}
+
+func TestUnionOptimize(t *testing.T) {
+	ctx := context.TODO()
+	qs, _ := newUnion(t)
+	defer qs.Close()
+
+	memberShapes := func(s shape.Shape) [][]bool {
+		var out [][]bool
+		shape.Walk(s, func(s shape.Shape) bool {
+			if q, ok := s.(union.Quads); ok {
+				var has []bool
+				for _, sub := range q.Members {
+					has = append(has, !shape.IsNull(sub))
+				}
+				out = append(out, has)
+			}
+			return true
+		})
+		return out
+	}
+
+	// both members have quads with this predicate
+	p := path.StartPath(qs).In(quad.IRI("type"))
+	s, ok := shape.Optimize(ctx, p.Shape(), qs)
+	require.True(t, ok)
+	require.Equal(t, [][]bool{{true, true}}, memberShapes(s))
+	vals, err := p.Iterate(ctx).AllValues(qs)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []quad.Value{quad.IRI("alice"), quad.IRI("bob")}, vals)
+
+	// only the second member has quads with this node
+	p = path.StartPath(qs, quad.IRI("carol")).Out(quad.IRI("follows"))
+	s, ok = shape.Optimize(ctx, p.Shape(), qs)
+	require.True(t, ok)
+	require.Equal(t, [][]bool{{false, true}}, memberShapes(s))
+	vals, err = p.Iterate(ctx).AllValues(qs)
+	require.NoError(t, err)
+	require.Equal(t, []quad.Value{quad.IRI("alice")}, vals)
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package union
+
+import (
+	"context"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/query/shape"
+	"github.com/cayleygraph/quad"
+)
+
+var _ shape.Optimizer = (*QuadStore)(nil)
+
+// OptimizeShape implements shape.Optimizer.
+//
+// Quad filters on fixed nodes are replaced with a Quads shape that holds a separate shape for each member.
+// Each of them is optimized by the member, thus selective lookups use the indexes and estimates of that member.
+func (qs *QuadStore) OptimizeShape(ctx context.Context, s shape.Shape) (shape.Shape, bool) {
+	switch s := s.(type) {
+	case shape.Quads:
+		return qs.optimizeQuads(ctx, s)
+	case shape.QuadsAction:
+		if len(s.Filter) == 0 {
+			return s, false
+		}
+		q, err := qs.quadsShape(ctx, s.Filter)
+		if err != nil {
+			return s, false
+		}
+		return s.SimplifyFrom(q), true
+	}
+	return s, false
+}
+
+func (qs *QuadStore) optimizeQuads(ctx context.Context, s shape.Quads) (shape.Shape, bool) {
+	var (
+		filter = make(map[quad.Direction]graph.Ref)
+		rest   shape.Quads
+	)
+	for _, f := range s {
+		v, ok := shape.One(f.Values)
+		if _, dup := filter[f.Dir]; !ok || dup || f.Dir == quad.Any {
+			rest = append(rest, f)
+			continue
+		}
+		filter[f.Dir] = v
+	}
+	if len(filter) == 0 {
+		return s, false
+	}
+	q, err := qs.quadsShape(ctx, filter)
+	if err != nil {
+		return s, false
+	} else if len(rest) == 0 {
+		return q, true
+	}
+	return shape.IntersectShapes(q, rest), true
+}
+
+// quadsShape creates a shape for each member that matches quads with given nodes.
+func (qs *QuadStore) quadsShape(ctx context.Context, filter map[quad.Direction]graph.Ref) (Quads, error) {
+	s := Quads{qs: qs, Members: make([]shape.Shape, len(qs.members))}
+	for i, m := range qs.members {
+		s.Members[i] = shape.Null{}
+		sub, err := qs.memberQuads(i, filter)
+		if err != nil {
+			return s, err
+		} else if sub == nil {
+			continue
+		}
+		if o, ok := graph.Unwrap(m).(shape.Optimizer); ok {
+			sub, _ = sub.Optimize(ctx, o)
+		}
+		if !shape.IsNull(sub) {
+			s.Members[i] = sub
+		}
+	}
+	return s, nil
+}
+
+// memberQuads converts a filter to a quad shape of the member. It returns nil if the member has none of the nodes.
+func (qs *QuadStore) memberQuads(member int, filter map[quad.Direction]graph.Ref) (shape.Shape, error) {
+	q := make(shape.Quads, 0, len(filter))
+	for _, d := range quad.Directions {
+		ref, ok := filter[d]
+		if !ok {
+			continue
+		}
+		mref, err := qs.members.MemberRef(member, ref)
+		if err != nil {
+			return nil, err
+		} else if mref == nil {
+			return nil, nil
+		}
+		q = append(q, shape.QuadFilter{Dir: d, Values: shape.Fixed{mref}})
+	}
+	return q, nil
+}
+
+var _ shape.Shape = Quads{}
+
+// Quads is a union of quad shapes, one for each member of the union. Each shape is built with its member.
+type Quads struct {
+	qs      *QuadStore
+	Members []shape.Shape // Null if the member cannot have any results
+}
+
+func (s Quads) BuildIterator(qs graph.QuadStore) iterator.Shape {
+	subs := make([]iterator.Shape, len(s.Members))
+	for i, sub := range s.Members {
+		if !shape.IsNull(sub) {
+			subs[i] = sub.BuildIterator(s.qs.members[i])
+		}
+	}
+	return s.qs.members.Quads(subs, s.qs.hasQuadBefore)
+}
+
+// Optimize returns the shape as is, since shapes of the members were already optimized by them.
+func (s Quads) Optimize(ctx context.Context, r shape.Optimizer) (shape.Shape, bool) {
+	return s, false
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrChangesCompacted is returned by a change feed that starts at a position that was already removed
+// from the feed. Consumers of the feed cannot continue from that position and must copy the database again.
+var ErrChangesCompacted = errors.New("changes were removed by compaction")
+
+// Change is a batch of deltas that were committed to the QuadStore at once.
+type Change struct {
+	// Position of the batch in the change feed. Positions of consecutive batches increase monotonically.
+	Position int64 `json:"position"`
+	// Time when the batch was committed.
+	Time   time.Time `json:"time"`
+	Deltas []Delta   `json:"deltas"`
+}
+
+// ChangeFeed is a stream of changes committed to the QuadStore.
+type ChangeFeed interface {
+	// Next waits for the next batch of changes to be committed.
+	// It returns false if the feed was closed, the context was cancelled or an error occurred.
+	Next(ctx context.Context) bool
+	// Result returns the current batch of changes.
+	Result() Change
+	// Err returns an error that stopped the feed.
+	Err() error
+	// Close stops the feed.
+	Close() error
+}
+
+// Watcher is an optional interface for QuadStores that can stream committed changes.
+type Watcher interface {
+	// Watch opens a feed of changes committed after a given position.
+	//
+	// A zero position streams all changes known to the QuadStore, while a negative one
+	// streams only changes committed after this call.
+	Watch(ctx context.Context, from int64) (ChangeFeed, error)
+}
+
+// Watch opens a feed of changes committed to the QuadStore after a given position.
+//
+// It returns ErrOperationNotSupported if the backend cannot stream changes.
+func Watch(ctx context.Context, qs QuadStore, from int64) (ChangeFeed, error) {
+	if w, ok := Unwrap(qs).(Watcher); ok {
+		return w.Watch(ctx, from)
+	}
+	return nil, ErrOperationNotSupported
+}
+
+// ChangeNotifier wakes up change feeds when new changes are committed.
+//
+// A zero value is ready to use.
+type ChangeNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// Changed returns a channel that is closed on the next call to Notify.
+func (n *ChangeNotifier) Changed() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+// Notify wakes up all feeds waiting for changes.
+func (n *ChangeNotifier) Notify() {
+	n.mu.Lock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+	n.mu.Unlock()
+}
+
+// ChangeReaderFunc returns changes committed after a given position, in order.
+// It may return only a part of them, or none if there are no new changes.
+type ChangeReaderFunc func(ctx context.Context, after int64) ([]Change, error)
+
+// NewChangeFeed creates a change feed that reads changes after a given position with a read function
+// and waits on a notifier when there are no new changes.
+//
+// It is intended to be used by backends that implement Watcher.
+func NewChangeFeed(n *ChangeNotifier, after int64, read ChangeReaderFunc) ChangeFeed {
+	return &changeFeed{
+		n: n, read: read, pos: after,
+		done: make(chan struct{}),
+	}
+}
+
+type changeFeed struct {
+	n    *ChangeNotifier
+	read ChangeReaderFunc
+	pos  int64
+	buf  []Change
+	cur  Change
+	err  error
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (f *changeFeed) Next(ctx context.Context) bool {
+	f.cur = Change{}
+	if f.err != nil {
+		return false
+	}
+	for len(f.buf) == 0 {
+		// subscribe before reading, so changes committed in between are not missed
+		changed := f.n.Changed()
+		buf, err := f.read(ctx, f.pos)
+		if err != nil {
+			f.err = err
+			return false
+		} else if len(buf) != 0 {
+			f.buf = buf
+			break
+		}
+		select {
+		case <-ctx.Done():
+			f.err = ctx.Err()
+			return false
+		case <-f.done:
+			return false
+		case <-changed:
+		}
+	}
+	f.cur, f.buf = f.buf[0], f.buf[1:]
+	f.pos = f.cur.Position
+	return true
+}
+
+func (f *changeFeed) Result() Change {
+	return f.cur
+}
+
+func (f *changeFeed) Err() error {
+	return f.err
+}
+
+func (f *changeFeed) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+	})
+	return nil
+}
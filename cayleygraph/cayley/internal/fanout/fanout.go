@@ -0,0 +1,119 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fanout implements refs and iterators shared by QuadStores that combine a number of member QuadStores.
+package fanout
+
+import (
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+)
+
+// Members is a list of QuadStores combined into a single one.
+//
+// The same node may be present in multiple members, thus node refs are not tied to a particular member
+// and carry the value instead, while quad refs are qualified with the member index (see QuadRef).
+type Members []graph.QuadStore
+
+// QuadRef is a quad ref of one of the members.
+type QuadRef struct {
+	Member int
+	Ref    graph.Ref
+}
+
+type quadKey struct {
+	member int
+	key    interface{}
+}
+
+func (r QuadRef) Key() interface{} { return quadKey{member: r.Member, key: r.Ref.Key()} }
+
+// ValueOf returns a value of a node ref.
+func ValueOf(ref graph.Ref) quad.Value {
+	if v, ok := ref.(refs.PreFetchedValue); ok {
+		return v.NameOf()
+	}
+	return nil
+}
+
+// NodeRef converts a node ref of the member to a combined ref.
+func (m Members) NodeRef(member int, ref graph.Ref) (graph.Ref, error) {
+	if ref == nil {
+		return nil, nil
+	}
+	v, err := m[member].NameOf(ref)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return refs.PreFetched(v), nil
+}
+
+// MemberRef converts a combined node ref to a ref of the member. It returns nil if the member has no such node.
+func (m Members) MemberRef(member int, ref graph.Ref) (graph.Ref, error) {
+	v := ValueOf(ref)
+	if v == nil {
+		return nil, nil
+	}
+	return m[member].ValueOf(v)
+}
+
+// ValueOf returns a combined ref of the value, if any of the members has it.
+func (m Members) ValueOf(v quad.Value) (graph.Ref, error) {
+	if v == nil {
+		return nil, nil
+	}
+	for _, qs := range m {
+		ref, err := qs.ValueOf(v)
+		if err != nil {
+			return nil, err
+		} else if ref != nil {
+			return refs.PreFetched(v), nil
+		}
+	}
+	return nil, nil
+}
+
+// Quad returns a quad for a quad ref of one of the members.
+func (m Members) Quad(ref graph.Ref) (quad.Quad, error) {
+	r, ok := ref.(QuadRef)
+	if !ok {
+		return quad.Quad{}, nil
+	}
+	return m[r.Member].Quad(r.Ref)
+}
+
+// QuadDirection returns a combined node ref for a quad ref of one of the members.
+func (m Members) QuadDirection(ref graph.Ref, d quad.Direction) (graph.Ref, error) {
+	r, ok := ref.(QuadRef)
+	if !ok {
+		return nil, nil
+	}
+	mref, err := m[r.Member].QuadDirection(r.Ref, d)
+	if err != nil {
+		return nil, err
+	}
+	return m.NodeRef(r.Member, mref)
+}
+
+// Close closes all members.
+func (m Members) Close() error {
+	var last error
+	for _, qs := range m {
+		if err := qs.Close(); err != nil {
+			last = err
+		}
+	}
+	return last
+}
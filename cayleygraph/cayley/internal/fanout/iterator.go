@@ -0,0 +1,435 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/graph/refs"
+	"github.com/cayleygraph/quad"
+)
+
+var (
+	_ iterator.Shape = (*quadsIterator)(nil)
+	_ iterator.Shape = (*nodesIterator)(nil)
+)
+
+// DupFunc checks if a quad of the member is present in any of the members before it.
+// Iterators of the members are passed as well; nil if the member cannot have any results.
+type DupFunc func(ctx context.Context, member int, subs []iterator.Shape, q quad.Quad) (bool, error)
+
+// Quads iterates over quad iterators of members, one after another. Subs must have one iterator for each member;
+// nil if the member cannot have any results. If dup is set, quads of all members except the first one are checked
+// with it, and duplicates are skipped.
+func (m Members) Quads(subs []iterator.Shape, dup DupFunc) iterator.Shape {
+	for _, sub := range subs {
+		if sub != nil {
+			return &quadsIterator{m: m, subs: subs, dup: dup}
+		}
+	}
+	return iterator.NewNull()
+}
+
+// quadsIterator iterates over quad iterators of members, one after another.
+type quadsIterator struct {
+	m    Members
+	subs []iterator.Shape // one per member; nil if the member cannot have any results
+	dup  DupFunc
+}
+
+func (it *quadsIterator) Iterate() iterator.Scanner {
+	return &quadsNext{m: it.m, subs: it.subs, dup: it.dup, member: -1}
+}
+
+func (it *quadsIterator) Lookup() iterator.Index {
+	return &quadsContains{subs: it.subs, idx: make([]iterator.Index, len(it.subs))}
+}
+
+// SubIterators returns nil, since refs of the members cannot be used outside of this iterator.
+func (it *quadsIterator) SubIterators() []iterator.Shape {
+	return nil
+}
+
+func (it *quadsIterator) Optimize(ctx context.Context) (iterator.Shape, bool) {
+	var subs []iterator.Shape
+	for i, sub := range it.subs {
+		if sub == nil {
+			continue
+		}
+		nsub, ok := sub.Optimize(ctx)
+		if !ok {
+			continue
+		}
+		if subs == nil {
+			subs = append([]iterator.Shape{}, it.subs...)
+		}
+		subs[i] = nsub
+	}
+	if subs == nil {
+		return it, false
+	}
+	return &quadsIterator{m: it.m, subs: subs, dup: it.dup}, true
+}
+
+func (it *quadsIterator) Stats(ctx context.Context) (iterator.Costs, error) {
+	st := iterator.Costs{Size: refs.Size{Exact: true}}
+	n := 0
+	for _, sub := range it.subs {
+		if sub == nil {
+			continue
+		}
+		sst, err := sub.Stats(ctx)
+		if err != nil {
+			return st, err
+		}
+		st.Size.Value += sst.Size.Value
+		st.Size.Exact = st.Size.Exact && sst.Size.Exact
+		if sst.NextCost > st.NextCost {
+			st.NextCost = sst.NextCost
+		}
+		if sst.ContainsCost > st.ContainsCost {
+			st.ContainsCost = sst.ContainsCost
+		}
+		n++
+	}
+	if it.dup != nil && n > 1 {
+		// quads of all members except the first one are checked for duplicates
+		st.Size.Exact = false
+		st.NextCost *= int64(n)
+	}
+	return st, nil
+}
+
+func (it *quadsIterator) String() string {
+	return fmt.Sprintf("FanoutQuads(%d)", len(it.subs))
+}
+
+type quadsNext struct {
+	m      Members
+	subs   []iterator.Shape
+	dup    DupFunc
+	member int
+	sub    iterator.Scanner
+	result graph.Ref
+	err    error
+}
+
+// TagResults does nothing, since tags of the member iterators hold refs of the members.
+func (it *quadsNext) TagResults(dst map[string]graph.Ref) {}
+
+func (it *quadsNext) Next(ctx context.Context) bool {
+	it.result = nil
+	for it.err == nil {
+		if it.sub == nil {
+			it.member++
+			if it.member >= len(it.subs) {
+				return false
+			} else if it.subs[it.member] == nil {
+				continue
+			}
+			it.sub = it.subs[it.member].Iterate()
+		}
+		if !it.sub.Next(ctx) {
+			it.err = it.sub.Err()
+			it.sub.Close()
+			it.sub = nil
+			continue
+		}
+		ref := it.sub.Result()
+		if it.dup != nil && it.member != 0 {
+			q, err := it.m[it.member].Quad(ref)
+			if err != nil {
+				it.err = err
+				break
+			}
+			if dup, err := it.dup(ctx, it.member, it.subs, q); err != nil {
+				it.err = err
+				break
+			} else if dup {
+				continue
+			}
+		}
+		it.result = QuadRef{Member: it.member, Ref: ref}
+		return true
+	}
+	return false
+}
+
+func (it *quadsNext) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *quadsNext) Result() graph.Ref {
+	return it.result
+}
+
+func (it *quadsNext) Err() error {
+	return it.err
+}
+
+func (it *quadsNext) Close() error {
+	if it.sub == nil {
+		return nil
+	}
+	err := it.sub.Close()
+	it.sub = nil
+	return err
+}
+
+func (it *quadsNext) String() string {
+	return "FanoutQuadsNext"
+}
+
+type quadsContains struct {
+	subs   []iterator.Shape
+	idx    []iterator.Index // created on demand
+	result graph.Ref
+	err    error
+}
+
+// TagResults does nothing, since tags of the member iterators hold refs of the members.
+func (it *quadsContains) TagResults(dst map[string]graph.Ref) {}
+
+// Contains checks only the member the quad ref belongs to.
+func (it *quadsContains) Contains(ctx context.Context, v graph.Ref) bool {
+	it.result = nil
+	r, ok := v.(QuadRef)
+	if !ok || r.Member < 0 || r.Member >= len(it.subs) || it.subs[r.Member] == nil {
+		return false
+	}
+	idx := it.idx[r.Member]
+	if idx == nil {
+		idx = it.subs[r.Member].Lookup()
+		it.idx[r.Member] = idx
+	}
+	if !idx.Contains(ctx, r.Ref) {
+		it.err = idx.Err()
+		return false
+	}
+	it.result = r
+	return true
+}
+
+func (it *quadsContains) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *quadsContains) Result() graph.Ref {
+	return it.result
+}
+
+func (it *quadsContains) Err() error {
+	return it.err
+}
+
+func (it *quadsContains) Close() error {
+	var last error
+	for i, idx := range it.idx {
+		if idx == nil {
+			continue
+		}
+		if err := idx.Close(); err != nil {
+			last = err
+		}
+		it.idx[i] = nil
+	}
+	return last
+}
+
+func (it *quadsContains) String() string {
+	return "FanoutQuadsContains"
+}
+
+// Nodes lists nodes of all members. A node is returned only from the first member that has it.
+//
+// If shared is set, most nodes are expected to be present in all members, thus the size of the largest member
+// is used as an estimate instead of the sum of all members.
+func (m Members) Nodes(shared bool) iterator.Shape {
+	return &nodesIterator{m: m, shared: shared}
+}
+
+// nodesIterator lists nodes of all members.
+type nodesIterator struct {
+	m      Members
+	shared bool
+}
+
+func (it *nodesIterator) Iterate() iterator.Scanner {
+	return &nodesNext{m: it.m, member: -1}
+}
+
+func (it *nodesIterator) Lookup() iterator.Index {
+	return &nodesContains{m: it.m}
+}
+
+func (it *nodesIterator) SubIterators() []iterator.Shape {
+	return nil
+}
+
+func (it *nodesIterator) Optimize(ctx context.Context) (iterator.Shape, bool) {
+	return it, false
+}
+
+func (it *nodesIterator) Stats(ctx context.Context) (iterator.Costs, error) {
+	var st iterator.Costs
+	st.Size.Exact = len(it.m) == 1
+	for _, qs := range it.m {
+		mst, err := qs.NodesAllIterator().Stats(ctx)
+		if err != nil {
+			return st, err
+		}
+		if !it.shared {
+			st.Size.Value += mst.Size.Value
+		} else if mst.Size.Value > st.Size.Value {
+			st.Size.Value = mst.Size.Value
+		}
+		st.Size.Exact = st.Size.Exact && mst.Size.Exact
+		// every node from the member is checked against previous members
+		st.NextCost += mst.NextCost + int64(len(it.m)-1)
+		st.ContainsCost += mst.ContainsCost
+	}
+	return st, nil
+}
+
+func (it *nodesIterator) String() string {
+	return "FanoutNodesAll"
+}
+
+type nodesNext struct {
+	m      Members
+	member int
+	sub    iterator.Scanner
+	result graph.Ref
+	err    error
+}
+
+func (it *nodesNext) TagResults(dst map[string]graph.Ref) {}
+
+// seen checks if any of the members before the current one has a given node.
+func (it *nodesNext) seen(v quad.Value) (bool, error) {
+	for _, qs := range it.m[:it.member] {
+		mref, err := qs.ValueOf(v)
+		if err != nil {
+			return false, err
+		} else if mref != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (it *nodesNext) Next(ctx context.Context) bool {
+	it.result = nil
+	for it.err == nil {
+		if it.sub == nil {
+			if it.member+1 >= len(it.m) {
+				return false
+			}
+			it.member++
+			it.sub = it.m[it.member].NodesAllIterator().Iterate()
+		}
+		if !it.sub.Next(ctx) {
+			it.err = it.sub.Err()
+			it.sub.Close()
+			it.sub = nil
+			continue
+		}
+		ref, err := it.m.NodeRef(it.member, it.sub.Result())
+		if err != nil {
+			it.err = err
+			break
+		} else if ref == nil {
+			continue
+		}
+		if ok, err := it.seen(ValueOf(ref)); err != nil {
+			it.err = err
+			break
+		} else if ok {
+			continue
+		}
+		it.result = ref
+		return true
+	}
+	return false
+}
+
+func (it *nodesNext) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *nodesNext) Result() graph.Ref {
+	return it.result
+}
+
+func (it *nodesNext) Err() error {
+	return it.err
+}
+
+func (it *nodesNext) Close() error {
+	if it.sub == nil {
+		return nil
+	}
+	err := it.sub.Close()
+	it.sub = nil
+	return err
+}
+
+func (it *nodesNext) String() string {
+	return "FanoutNodesAllNext"
+}
+
+type nodesContains struct {
+	m      Members
+	result graph.Ref
+	err    error
+}
+
+func (it *nodesContains) TagResults(dst map[string]graph.Ref) {}
+
+func (it *nodesContains) Contains(ctx context.Context, v graph.Ref) bool {
+	it.result = nil
+	ref, err := it.m.ValueOf(ValueOf(v))
+	if err != nil {
+		it.err = err
+		return false
+	} else if ref == nil {
+		return false
+	}
+	it.result = ref
+	return true
+}
+
+func (it *nodesContains) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *nodesContains) Result() graph.Ref {
+	return it.result
+}
+
+func (it *nodesContains) Err() error {
+	return it.err
+}
+
+func (it *nodesContains) Close() error {
+	return nil
+}
+
+func (it *nodesContains) String() string {
+	return "FanoutNodesAllContains"
+}
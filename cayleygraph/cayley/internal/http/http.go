@@ -24,6 +24,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 
 	"github.com/cayleygraph/cayley/graph"
+	httpgraph "github.com/cayleygraph/cayley/graph/http"
 	"github.com/cayleygraph/cayley/internal/gephi"
 	cayleyhttp "github.com/cayleygraph/cayley/server/http"
 )
@@ -42,6 +43,7 @@ func jsonResponse(w http.ResponseWriter, code int, err interface{}) {
 // Config holds the HTTP server configuration
 type Config struct {
 	ReadOnly bool
+	Backup   bool
 	Timeout  time.Duration
 	Batch    int
 }
@@ -66,13 +68,19 @@ func SetupRoutes(handle *graph.Handle, cfg *Config) error {
 	// Register API V2
 	api2 := cayleyhttp.NewBoundAPIv2(handle, r)
 	api2.SetReadOnly(cfg.ReadOnly)
+	api2.SetBackup(cfg.Backup)
 	api2.SetBatchSize(cfg.Batch)
 	api2.SetQueryTimeout(cfg.Timeout)
 
 	// For non API requests serve the UI
 	r.NotFound = http.FileServer(ui)
 
-	http.Handle("/", CORS(LogRequest(r)))
+	var root http.Handler = r
+	if dbs, ok := handle.QuadStore.(*httpgraph.Databases); ok {
+		// select a named database for each request
+		root = dbs.Handler(r)
+	}
+	http.Handle("/", CORS(LogRequest(root)))
 
 	return nil
 }
@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/fulltext"
 	"github.com/cayleygraph/cayley/graph/graphtest/testutil"
 	_ "github.com/cayleygraph/cayley/graph/memstore"
 	"github.com/cayleygraph/cayley/query"
@@ -825,6 +826,43 @@ func TestIssue160(t *testing.T) {
 	}
 }
 
+func TestSearch(t *testing.T) {
+	qs, _ := graph.NewQuadStore("memstore", "", nil)
+	w, _ := graph.NewQuadWriter("single", qs, nil)
+	for _, q := range issue160TestGraph {
+		w.AddQuad(q)
+	}
+	ctx := context.TODO()
+	fqs, err := fulltext.Wrap(ctx, qs, fulltext.NewMemIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ses := NewSession(fqs)
+	it, err := ses.Execute(ctx, `g.V().search("not cool").all()`, query.Options{
+		Collation: query.Raw,
+		Limit:     -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var got []string
+	for it.Next(ctx) {
+		nv, err := fqs.NameOf(it.Result().(*Result).Tags[TopResultTag])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, quadValueToString(nv))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"not cool", "cool"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected result, got: %q expected: %q", got, expect)
+	}
+}
+
 const issue718Limit = 5
 
 func issue718Graph() []quad.Quad {
@@ -702,6 +702,22 @@ func (p *pathObject) Skip(offset int) *pathObject {
 	return p.new(np)
 }
 
+// Search keeps only string literals that match a full-text query, ordered by relevance.
+// It requires a database with a full-text index.
+//
+// Arguments:
+//
+// * `text`: A free-form text to search for.
+//
+// Example:
+//	// javascript
+//	// Find all statuses that mention "coolness", starting from the best match
+//	g.V().out("<status>").search("coolness").all()
+func (p *pathObject) Search(text string) *pathObject {
+	np := p.clonePath().Search(text)
+	return p.new(np)
+}
+
 func (p *pathObject) Order() *pathObject {
 	np := p.clonePath().Order()
 	return p.new(np)
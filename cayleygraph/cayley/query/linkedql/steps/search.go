@@ -0,0 +1,34 @@
+package steps
+
+import (
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/query/linkedql"
+	"github.com/cayleygraph/cayley/query/path"
+	"github.com/cayleygraph/quad/voc"
+)
+
+func init() {
+	linkedql.Register(&Search{})
+}
+
+var _ linkedql.PathStep = (*Search)(nil)
+
+// Search corresponds to .search().
+type Search struct {
+	From  linkedql.PathStep `json:"from"`
+	Query string            `json:"query"`
+}
+
+// Description implements Step.
+func (s *Search) Description() string {
+	return "Search filters out values that do not match given full-text query and orders the rest by relevance. Requires a full-text index."
+}
+
+// BuildPath implements linkedql.PathStep.
+func (s *Search) BuildPath(qs graph.QuadStore, ns *voc.Namespaces) (*path.Path, error) {
+	fromPath, err := s.From.BuildPath(qs, ns)
+	if err != nil {
+		return nil, err
+	}
+	return fromPath.Search(s.Query), nil
+}
@@ -313,29 +313,19 @@ func followMorphism(p *Path) morphism {
 	}
 }
 
-type iteratorBuilder func(qs graph.QuadStore) iterator.Shape
-
-func (s iteratorBuilder) BuildIterator(qs graph.QuadStore) iterator.Shape {
-	return s(qs)
-}
-func (s iteratorBuilder) Optimize(ctx context.Context, r shape.Optimizer) (shape.Shape, bool) {
-	return s, false
-}
-
 func followRecursiveMorphism(p *Path, maxDepth int, depthTags []string) morphism {
 	return morphism{
 		Reversal: func(ctx *pathContext) (morphism, *pathContext) {
 			return followRecursiveMorphism(p.Reverse(), maxDepth, depthTags), ctx
 		},
 		Apply: func(in shape.Shape, ctx *pathContext) (shape.Shape, *pathContext) {
-			return iteratorBuilder(func(qs graph.QuadStore) iterator.Shape {
-				in := in.BuildIterator(qs)
-				it := iterator.NewRecursive(in, p.MorphismFor(qs), maxDepth)
-				for _, s := range depthTags {
-					it.AddDepthTag(s)
-				}
-				return it
-			}), ctx
+			return shape.Recursive{
+				From:      in,
+				Step:      p.ShapeFrom(shape.RecursiveBase{}),
+				Morphism:  p.MorphismFor,
+				MaxDepth:  maxDepth,
+				DepthTags: depthTags,
+			}, ctx
 		},
 	}
 }
@@ -451,6 +441,16 @@ func orderMorphism() morphism {
 	}
 }
 
+// searchMorphism will run a full-text search over the values and order them by score.
+func searchMorphism(text string) morphism {
+	return morphism{
+		Reversal: func(ctx *pathContext) (morphism, *pathContext) { return searchMorphism(text), ctx },
+		Apply: func(in shape.Shape, ctx *pathContext) (shape.Shape, *pathContext) {
+			return shape.FullText{From: in, Query: text}, ctx
+		},
+	}
+}
+
 // limitMorphism will limit a number of values-- if number is negative or zero, this function
 // acts as a passthrough for the previous iterator.
 func limitMorphism(v int64) morphism {
@@ -17,6 +17,7 @@ package path
 import (
 	"context"
 	"regexp"
+	"time"
 
 	"github.com/cayleygraph/cayley/graph"
 	"github.com/cayleygraph/cayley/graph/iterator"
@@ -72,6 +73,7 @@ type Path struct {
 	stack       []morphism
 	qs          graph.QuadStore // Optionally. A nil qs is equivalent to a morphism.
 	baseContext pathContext
+	asOf        time.Time // Optionally. A zero value means the current state of the graph.
 }
 
 // IsMorphism returns whether this Path is a morphism.
@@ -117,6 +119,7 @@ func (p *Path) Clone() *Path {
 		stack:       stack[:len(stack):len(stack)],
 		qs:          p.qs,
 		baseContext: p.baseContext,
+		asOf:        p.asOf,
 	}
 }
 
@@ -130,12 +133,14 @@ func (p *Path) clone() *Path {
 		stack:       stack,
 		qs:          p.qs,
 		baseContext: p.baseContext,
+		asOf:        p.asOf,
 	}
 }
 
 // Reverse returns a new Path that is the reverse of the current one.
 func (p *Path) Reverse() *Path {
 	newPath := NewPath(p.qs)
+	newPath.asOf = p.asOf
 	ctx := &newPath.baseContext
 	for i := len(p.stack) - 1; i >= 0; i-- {
 		var revMorphism morphism
@@ -526,9 +531,28 @@ func (p *Path) BuildIterator(ctx context.Context) iterator.Shape {
 
 // BuildIteratorOn will return an iterator for this path on the given QuadStore.
 func (p *Path) BuildIteratorOn(ctx context.Context, qs graph.QuadStore) iterator.Shape {
+	qs, err := p.quadStoreOn(ctx, qs)
+	if err != nil {
+		return iterator.NewError(err)
+	}
 	return shape.BuildIterator(ctx, qs, p.Shape())
 }
 
+// AsOf runs the whole path against the state of the graph at a given time.
+// The QuadStore must implement graph.TimeTraveler.
+func (p *Path) AsOf(t time.Time) *Path {
+	p.asOf = t
+	return p
+}
+
+// quadStoreOn returns a view of the QuadStore for the time set by AsOf.
+func (p *Path) quadStoreOn(ctx context.Context, qs graph.QuadStore) (graph.QuadStore, error) {
+	if p.asOf.IsZero() {
+		return qs, nil
+	}
+	return graph.AsOf(ctx, qs, p.asOf)
+}
+
 // MorphismFor returns the morphism of this path. The returned value is a
 // function that, when given an existing Iterator, will return a new Iterator
 // that yields the subset of values from the existing iterator matched by the
@@ -550,6 +574,13 @@ func (p *Path) Order() *Path {
 	return p
 }
 
+// Search will keep only string literals that match a full-text query, ordered by relevance.
+// It requires a QuadStore with a full-text index.
+func (p *Path) Search(text string) *Path {
+	p.stack = append(p.stack, searchMorphism(text))
+	return p
+}
+
 // Limit will limit a number of values in result set.
 func (p *Path) Limit(v int64) *Path {
 	p.stack = append(p.stack, limitMorphism(v))
@@ -564,7 +595,11 @@ func (p *Path) Count() *Path {
 
 // Iterate is an shortcut for graph.Iterate.
 func (p *Path) Iterate(ctx context.Context) *iterator.Chain {
-	return shape.Iterate(ctx, p.qs, p.Shape())
+	qs, err := p.quadStoreOn(ctx, p.qs)
+	if err != nil {
+		return iterator.Iterate(ctx, iterator.NewError(err)).On(p.qs)
+	}
+	return shape.Iterate(ctx, qs, p.Shape())
 }
 func (p *Path) Shape() shape.Shape {
 	// 初始值是个AllNodes迭代器
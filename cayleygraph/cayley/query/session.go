@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/cayleygraph/cayley/graph"
 )
@@ -67,6 +68,10 @@ const (
 type Options struct {
 	Limit     int
 	Collation Collation
+	// AsOf runs the query against the state of the graph at a given time.
+	// A zero value means the current state. It is applied by Execute; sessions
+	// created directly should be bound to a view returned by graph.AsOf instead.
+	AsOf time.Time
 }
 
 type Session interface {
@@ -136,6 +141,13 @@ func Execute(ctx context.Context, qs graph.QuadStore, lang, query string, opt Op
 	if l == nil {
 		return nil, fmt.Errorf("unsupported language: %q", lang)
 	}
+	if !opt.AsOf.IsZero() {
+		var err error
+		qs, err = graph.AsOf(ctx, qs, opt.AsOf)
+		if err != nil {
+			return nil, err
+		}
+	}
 	sess := l.Session(qs)
 	return sess.Execute(ctx, query, opt)
 }
@@ -2,6 +2,7 @@ package shape
 
 import (
 	"context"
+	"errors"
 	"os"
 	"reflect"
 	"regexp"
@@ -536,8 +537,28 @@ func (s NodesFrom) BuildIterator(qs graph.QuadStore) iterator.Shape {
 	if s.Dir == quad.Any {
 		panic("direction is not set")
 	}
+	if pred := s.predicate(); pred != nil {
+		return graph.NewHasAWithPredicate(qs, sub, s.Dir, pred)
+	}
 	return graph.NewHasA(qs, sub, s.Dir)
 }
+
+// predicate returns a predicate of all quads, if they are filtered by a single fixed one.
+func (s NodesFrom) predicate() refs.Ref {
+	q, ok := s.Quads.(Quads)
+	if !ok {
+		return nil
+	}
+	for _, f := range q {
+		if f.Dir != quad.Predicate {
+			continue
+		}
+		if v, ok := One(f.Values); ok {
+			return v
+		}
+	}
+	return nil
+}
 // todo: 这个函数很复杂啊，后面再看
 func (s NodesFrom) Optimize(ctx context.Context, r Optimizer) (Shape, bool) {
 	if IsNull(s.Quads) {
@@ -1481,3 +1502,121 @@ func (s Sort) Optimize(ctx context.Context, r Optimizer) (Shape, bool) {
 	}
 	return s, opt
 }
+
+// FullText runs a full-text search over string literals and returns matching nodes, ordered by score.
+// It requires a QuadStore that implements graph.TextSearcher.
+type FullText struct {
+	From  Shape // nodes to search in; nil means AllNodes
+	Query string
+	Lang  string // optional language tag; if set, only literals in this language are returned
+}
+
+func (s FullText) BuildIterator(qs graph.QuadStore) iterator.Shape {
+	if _, ok := graph.Unwrap(qs).(graph.TextSearcher); !ok {
+		return iterator.NewError(graph.ErrOperationNotSupported)
+	}
+	var from iterator.Shape
+	if s.From != nil {
+		if IsNull(s.From) {
+			return iterator.NewNull()
+		}
+		from = s.From.BuildIterator(qs)
+	}
+	return iterator.NewSearch(func(ctx context.Context) ([]refs.Ref, error) {
+		hits, err := graph.SearchText(ctx, qs, s.Query, s.Lang)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]refs.Ref, 0, len(hits))
+		for _, h := range hits {
+			ref, err := qs.ValueOf(h.Value)
+			if err != nil {
+				return nil, err
+			} else if ref != nil {
+				out = append(out, ref)
+			}
+		}
+		return out, nil
+	}, from)
+}
+func (s FullText) Optimize(ctx context.Context, r Optimizer) (Shape, bool) {
+	var opt bool
+	if s.From != nil {
+		if IsNull(s.From) {
+			return nil, true
+		}
+		s.From, opt = s.From.Optimize(ctx, r)
+		if IsNull(s.From) {
+			return nil, true
+		} else if _, ok := s.From.(AllNodes); ok {
+			s.From, opt = nil, true
+		}
+	}
+	if r != nil {
+		ns, nopt := r.OptimizeShape(ctx, s)
+		return ns, opt || nopt
+	}
+	return s, opt
+}
+
+// Recursive applies a morphism to the results of a query recursively, up to a given depth.
+// Each node is returned only once, at the minimal depth it was reached, and the nodes from
+// the source are not included unless they are reached by the morphism.
+type Recursive struct {
+	From Shape
+	// Step is a shape of a single step of the recursion, applied to RecursiveBase.
+	// It is only used by QuadStore optimizers; iterators are built from Morphism.
+	Step     Shape
+	Morphism func(qs graph.QuadStore) iterator.Morphism
+	// MaxDepth limits the depth of the recursion. If zero, iterator.DefaultMaxRecursiveSteps is used.
+	MaxDepth  int
+	DepthTags []string
+}
+
+func (s Recursive) BuildIterator(qs graph.QuadStore) iterator.Shape {
+	if IsNull(s.From) {
+		return iterator.NewNull()
+	}
+	it := iterator.NewRecursive(s.From.BuildIterator(qs), s.Morphism(qs), s.MaxDepth)
+	for _, tag := range s.DepthTags {
+		it.AddDepthTag(tag)
+	}
+	return it
+}
+func (s Recursive) Optimize(ctx context.Context, r Optimizer) (Shape, bool) {
+	if IsNull(s.From) {
+		return nil, true
+	}
+	var opt, sopt bool
+	s.From, opt = s.From.Optimize(ctx, r)
+	if IsNull(s.From) {
+		return nil, true
+	}
+	if s.Step != nil {
+		s.Step, sopt = s.Step.Optimize(ctx, r)
+		opt = opt || sopt
+		if IsNull(s.Step) {
+			// nothing can be reached from the source
+			return nil, true
+		}
+	}
+	if r != nil {
+		ns, nopt := r.OptimizeShape(ctx, s)
+		return ns, opt || nopt
+	}
+	return s, opt
+}
+
+// RecursiveBase is a placeholder for the nodes reached on the previous step of Recursive.
+// It can only be used in Recursive.Step and cannot be iterated on its own.
+type RecursiveBase struct{}
+
+func (RecursiveBase) BuildIterator(qs graph.QuadStore) iterator.Shape {
+	return iterator.NewError(errors.New("recursive base used outside of recursion"))
+}
+func (s RecursiveBase) Optimize(ctx context.Context, r Optimizer) (Shape, bool) {
+	if r != nil {
+		return r.OptimizeShape(ctx, s)
+	}
+	return s, false
+}
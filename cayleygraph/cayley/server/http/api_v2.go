@@ -15,6 +15,7 @@
 package cayleyhttp
 
 import (
+	"bufio"
 	"compress/gzip"
 	"context"
 	"encoding/json"
@@ -23,6 +24,8 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,12 +33,13 @@ import (
 
 	"github.com/cayleygraph/cayley/clog"
 	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/graph/remote"
 	"github.com/cayleygraph/cayley/query"
 	"github.com/cayleygraph/cayley/query/shape"
-
-	// Writer is imported for writers to be registered
-	_ "github.com/cayleygraph/cayley/writer"
+	"github.com/cayleygraph/cayley/writer"
 	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
 	"github.com/cayleygraph/quad/voc"
 )
 
@@ -74,6 +78,7 @@ func NewAPIv2Writer(h *graph.Handle, wtype string, wopts graph.Options, wrappers
 type APIv2 struct {
 	h       *graph.Handle
 	ro      bool
+	backup  bool
 	batch   int
 	handler http.Handler
 
@@ -91,6 +96,11 @@ func (api *APIv2) SetReadOnly(ro bool) {
 	api.ro = ro
 }
 
+// SetBackup enables serving backups of the database
+func (api *APIv2) SetBackup(enabled bool) {
+	api.backup = enabled
+}
+
 // SetBatchSize sets batch-size mode for the request
 func (api *APIv2) SetBatchSize(n int) {
 	api.batch = n
@@ -127,10 +137,22 @@ func (api *APIv2) registerDataOn(r *httprouter.Router) {
 		r.POST(prefix+"/write", toHandle(api.ServeWrite))
 		r.POST(prefix+"/delete", toHandle(api.ServeDelete))
 		r.POST(prefix+"/node/delete", toHandle(api.ServeNodeDelete))
+		r.POST(prefix+"/graph/drop", toHandle(api.ServeGraphDrop))
+		r.POST(prefix+"/graph/copy", toHandle(api.ServeGraphCopy))
+		r.POST(prefix+"/graph/move", toHandle(api.ServeGraphMove))
+		r.POST(prefix+"/index/deltas", toHandle(api.ServeIndexDeltas))
 	}
+	r.GET(prefix+"/graph/list", toHandle(api.ServeGraphList))
+	r.GET(prefix+"/stats", toHandle(api.ServeStats))
+	r.GET(prefix+"/backup", toHandle(api.ServeBackup))
+	r.GET(prefix+"/changes", toHandle(api.ServeChanges))
+	r.GET(prefix+"/replication", toHandle(api.ServeReplication))
 	r.POST(prefix+"/read", toHandle(api.ServeRead))
 	r.GET(prefix+"/read", toHandle(api.ServeRead))
 	r.GET(prefix+"/formats", toHandle(api.ServeFormats))
+	r.GET(prefix+"/index/quads", toHandle(api.ServeIndexQuads))
+	r.GET(prefix+"/index/nodes", toHandle(api.ServeIndexNodes))
+	r.GET(prefix+"/index/size", toHandle(api.ServeIndexSize))
 }
 
 func (api *APIv2) registerQueryOn(r *httprouter.Router) {
@@ -151,6 +173,8 @@ const (
 	hdrAcceptEncoding  = "Accept-Encoding"
 	contentTypeJSON    = "application/json"
 	contentTypeJSONLD  = "application/ld+json"
+	contentTypeEvents  = "text/event-stream"
+	contentTypeBinary  = "application/octet-stream"
 )
 
 func getFormat(r *http.Request, formKey string, acceptName string) *quad.Format {
@@ -347,6 +371,317 @@ func (api *APIv2) ServeNodeDelete(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, `{"result": "Successfully deleted %d nodes.", "count": %d}`+"\n", n, n)
 }
 
+// labelsResponse is a list of named graphs in the database.
+type labelsResponse struct {
+	Labels []string `json:"labels"`
+}
+
+// ServeGraphList responds with labels of all named graphs in the database.
+// Labels are encoded in the same way as values in the "label" parameter of ServeRead.
+func (api *APIv2) ServeGraphList(w http.ResponseWriter, r *http.Request) {
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	labels, err := graph.ListLabels(r.Context(), h)
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := labelsResponse{Labels: make([]string, 0, len(labels))}
+	for _, l := range labels {
+		resp.Labels = append(resp.Labels, quad.StringOf(l))
+	}
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ServeGraphDrop removes all quads of a named graph set in the "label" parameter.
+func (api *APIv2) ServeGraphDrop(w http.ResponseWriter, r *http.Request) {
+	if api.ro {
+		jsonResponse(w, http.StatusForbidden, errors.New("database is read-only"))
+		return
+	}
+	s := r.FormValue("label")
+	if s == "" {
+		jsonResponse(w, http.StatusBadRequest, errors.New("label is not set"))
+		return
+	}
+	label := quad.StringToValue(s)
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = graph.DropLabel(r.Context(), h, label); err != nil {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	fmt.Fprintf(w, `{"result": %q}`+"\n", "Successfully dropped graph "+s+".")
+}
+
+// ServeGraphCopy copies all quads of a named graph set in the "from" parameter to a graph
+// set in the "to" parameter. Empty value refers to the default graph.
+func (api *APIv2) ServeGraphCopy(w http.ResponseWriter, r *http.Request) {
+	api.serveGraphRelabel(w, r, false)
+}
+
+// ServeGraphMove moves all quads of a named graph set in the "from" parameter to a graph
+// set in the "to" parameter. Empty value refers to the default graph.
+func (api *APIv2) ServeGraphMove(w http.ResponseWriter, r *http.Request) {
+	api.serveGraphRelabel(w, r, true)
+}
+
+func (api *APIv2) serveGraphRelabel(w http.ResponseWriter, r *http.Request, move bool) {
+	if api.ro {
+		jsonResponse(w, http.StatusForbidden, errors.New("database is read-only"))
+		return
+	}
+	fs, ts := r.FormValue("from"), r.FormValue("to")
+	if fs == "" && ts == "" {
+		jsonResponse(w, http.StatusBadRequest, errors.New("source and destination graphs are not set"))
+		return
+	}
+	var from, to quad.Value
+	if fs != "" {
+		from = quad.StringToValue(fs)
+	}
+	if ts != "" {
+		to = quad.StringToValue(ts)
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	op := "copied"
+	if move {
+		op = "moved"
+		err = graph.MoveLabel(r.Context(), h, from, to)
+	} else {
+		err = graph.CopyLabel(r.Context(), h, from, to)
+	}
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	if fs == "" {
+		fs = "default graph"
+	}
+	if ts == "" {
+		ts = "default graph"
+	}
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	fmt.Fprintf(w, `{"result": %q}`+"\n", "Successfully "+op+" "+fs+" to "+ts+".")
+}
+
+// predicateStatsResponse contains statistics for quads with a single predicate.
+type predicateStatsResponse struct {
+	Predicate string `json:"predicate"`
+	Quads     int64  `json:"quads"`
+	Subjects  int64  `json:"subjects"`
+	Objects   int64  `json:"objects"`
+}
+
+// statsResponse contains statistics of the database.
+type statsResponse struct {
+	Nodes      int64                    `json:"nodes"`
+	Quads      int64                    `json:"quads"`
+	Predicates []predicateStatsResponse `json:"predicates,omitempty"`
+}
+
+// ServeStats responds with the number of nodes and quads in the database. If the backend tracks
+// per-predicate statistics, the number of quads, distinct subjects and objects for each predicate is included.
+// Setting the "exact" parameter forces the backend to calculate precise totals, which may be slow.
+func (api *APIv2) ServeStats(w http.ResponseWriter, r *http.Request) {
+	exact, _ := strconv.ParseBool(r.FormValue("exact"))
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx := r.Context()
+	st, err := h.Stats(ctx, exact)
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := statsResponse{Nodes: st.Nodes.Value, Quads: st.Quads.Value}
+	preds, err := graph.GetAllPredicateStats(ctx, h)
+	if err != nil && err != graph.ErrOperationNotSupported {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, p := range preds {
+		v, err := h.NameOf(p.Predicate)
+		if err != nil {
+			jsonResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Predicates = append(resp.Predicates, predicateStatsResponse{
+			Predicate: quad.StringOf(v),
+			Quads:     p.Quads.Value,
+			Subjects:  p.Subjects.Value,
+			Objects:   p.Objects.Value,
+		})
+	}
+	sort.Slice(resp.Predicates, func(i, j int) bool {
+		return resp.Predicates[i].Predicate < resp.Predicates[j].Predicate
+	})
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// backupWriter sends response headers on the first write, thus errors returned by the backend
+// before any data is written can still be reported to the client.
+type backupWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (w *backupWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.w.Header().Set(hdrContentType, contentTypeBinary)
+		w.w.Header().Set("Content-Disposition", `attachment; filename="cayley.backup"`)
+		w.w.WriteHeader(http.StatusOK)
+	}
+	return w.w.Write(p)
+}
+
+// ServeBackup streams a consistent copy of the raw database content, in a backend-specific format.
+// The database can be read and written while the backup is in progress. Backups are served only if enabled with SetBackup.
+func (api *APIv2) ServeBackup(w http.ResponseWriter, r *http.Request) {
+	if !api.backup {
+		jsonResponse(w, http.StatusForbidden, errors.New("backup is disabled"))
+		return
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	bw := &backupWriter{w: w}
+	err = graph.Backup(r.Context(), h.QuadStore, bw)
+	if err == nil {
+		return
+	} else if bw.started {
+		// too late to report an error to the client; the backup will fail verification on restore
+		clog.Errorf("backup failed: %v", err)
+	} else if err == graph.ErrOperationNotSupported {
+		jsonResponse(w, http.StatusNotImplemented, err)
+	} else {
+		jsonResponse(w, http.StatusInternalServerError, err)
+	}
+}
+
+// ServeChanges streams batches of changes committed to the database as server-sent events.
+// ID of each event is a position of the batch in the change feed. Clients can resume the stream
+// by passing the last seen position in the "from" parameter or in the Last-Event-ID header.
+func (api *APIv2) ServeChanges(w http.ResponseWriter, r *http.Request) {
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		jsonResponse(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	from := int64(-1)
+	s := r.FormValue("from")
+	if s == "" {
+		s = r.Header.Get("Last-Event-ID")
+	}
+	if s != "" {
+		var err error
+		from, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || from < 0 {
+			jsonResponse(w, http.StatusBadRequest, fmt.Errorf("invalid change feed position: %q", s))
+			return
+		}
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx := r.Context()
+	feed, err := h.Watch(ctx, from)
+	if err == graph.ErrOperationNotSupported {
+		jsonResponse(w, http.StatusNotImplemented, err)
+		return
+	} else if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer feed.Close()
+
+	w.Header().Set(hdrContentType, contentTypeEvents)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fl.Flush()
+	for feed.Next(ctx) {
+		c, err := remote.EncodeChange(feed.Result())
+		if err != nil {
+			clog.Errorf("cannot encode change: %v", err)
+			return
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			clog.Errorf("cannot encode change: %v", err)
+			return
+		}
+		if _, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", c.Position, data); err != nil {
+			return
+		}
+		fl.Flush()
+	}
+	if err = feed.Err(); err != nil && err != ctx.Err() {
+		clog.Errorf("change feed error: %v", err)
+		data, _ := json.Marshal(remote.ErrorResponse{Error: err.Error(), Code: remote.ErrorCode(err)})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+		fl.Flush()
+	}
+}
+
+// replicationResponse is a JSON representation of writer.ReplicaStatus.
+type replicationResponse struct {
+	Primary   string     `json:"primary"`
+	Position  int64      `json:"position"`
+	Lag       float64    `json:"lag"`
+	Synced    *time.Time `json:"synced,omitempty"`
+	Connected bool       `json:"connected"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// ServeReplication responds with the state of the replication, if the database is a replica.
+func (api *APIv2) ServeReplication(w http.ResponseWriter, r *http.Request) {
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	rep, ok := h.QuadWriter.(*writer.Replica)
+	if !ok {
+		jsonResponse(w, http.StatusNotFound, errors.New("database is not a replica"))
+		return
+	}
+	st := rep.Status()
+	resp := replicationResponse{
+		Primary:   rep.Primary(),
+		Position:  st.Position,
+		Lag:       st.Lag.Seconds(),
+		Connected: st.Connected,
+	}
+	if !st.Synced.IsZero() {
+		resp.Synced = &st.Synced
+	}
+	if st.Err != nil {
+		resp.Error = st.Err.Error()
+	}
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	json.NewEncoder(w).Encode(resp)
+}
+
 type checkWriter struct {
 	w       io.Writer
 	written bool
@@ -429,6 +764,219 @@ func (api *APIv2) ServeRead(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// indexParams parses the direction and the value of an index from the request.
+// If neither is set, the request refers to all quads.
+func indexParams(r *http.Request) (quad.Direction, quad.Value, error) {
+	ds, vs := r.FormValue("dir"), r.FormValue("value")
+	if ds == "" && vs == "" {
+		return quad.Any, nil, nil
+	}
+	d, err := remote.ParseDirection(ds)
+	if err != nil {
+		return d, nil, err
+	}
+	v, err := remote.DecodeValue(vs)
+	if err != nil {
+		return d, nil, fmt.Errorf("cannot decode value: %v", err)
+	} else if v == nil {
+		return d, nil, errors.New("value must be set")
+	}
+	return d, v, nil
+}
+
+// ServeIndexQuads streams quads in pquads format that have a given value in a given direction,
+// or all quads if no value is set. The value must be encoded with remote.EncodeValue.
+func (api *APIv2) ServeIndexQuads(w http.ResponseWriter, r *http.Request) {
+	d, v, err := indexParams(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	var it iterator.Shape
+	if v == nil {
+		it = h.QuadsAllIterator()
+	} else if ref, err := h.ValueOf(v); err != nil {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	} else if ref == nil {
+		it = iterator.NewNull()
+	} else {
+		it = h.QuadIterator(d, ref)
+	}
+	sc := it.Iterate()
+	defer sc.Close()
+	qr := graph.NewResultReader(h.QuadStore, sc)
+	defer qr.Close()
+
+	// read the first quad before writing the header, thus errors can still be reported to the client
+	first, err := qr.ReadQuad()
+	if err != nil && err != io.EOF {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set(hdrContentType, pquads.ContentType)
+	qw := pquads.NewWriter(w, nil)
+	defer qw.Close()
+	if err == io.EOF {
+		return
+	}
+	if err = qw.WriteQuad(first); err == nil {
+		_, err = quad.Copy(qw, qr)
+	}
+	if err != nil {
+		// the client will get a truncated stream
+		clog.Errorf("read index error: %v", err)
+	}
+}
+
+// ServeIndexNodes streams all nodes, each encoded with remote.WriteValue. If a value is set,
+// the response contains only this value, or nothing if the node does not exist.
+func (api *APIv2) ServeIndexNodes(w http.ResponseWriter, r *http.Request) {
+	v, err := remote.DecodeValue(r.FormValue("value"))
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode value: %v", err))
+		return
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	if v != nil {
+		ref, err := h.ValueOf(v)
+		if err != nil {
+			jsonResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set(hdrContentType, contentTypeBinary)
+		if ref != nil {
+			remote.WriteValue(w, v)
+		}
+		return
+	}
+	ctx := r.Context()
+	it := h.NodesAllIterator().Iterate()
+	defer it.Close()
+	w.Header().Set(hdrContentType, contentTypeBinary)
+	bw := bufio.NewWriter(w)
+	for it.Next(ctx) {
+		nv, err := h.NameOf(it.Result())
+		if err == nil && nv != nil {
+			err = remote.WriteValue(bw, nv)
+		}
+		if err != nil {
+			clog.Errorf("read nodes error: %v", err)
+			return
+		}
+	}
+	if err = it.Err(); err != nil {
+		clog.Errorf("read nodes error: %v", err)
+		return
+	}
+	bw.Flush()
+}
+
+// ServeIndexSize responds with the size of the index with a given value in a given direction,
+// or with the number of all quads if no value is set.
+func (api *APIv2) ServeIndexSize(w http.ResponseWriter, r *http.Request) {
+	d, v, err := indexParams(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx := r.Context()
+	var resp remote.SizeResponse
+	if v == nil {
+		st, err := h.Stats(ctx, false)
+		if err != nil {
+			jsonResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp = remote.SizeResponse{Size: st.Quads.Value, Exact: st.Quads.Exact}
+	} else if ref, err := h.ValueOf(v); err != nil {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	} else if ref == nil {
+		resp = remote.SizeResponse{Size: 0, Exact: true}
+	} else {
+		sz, err := h.QuadIteratorSize(ctx, d, ref)
+		if err != nil {
+			jsonResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp = remote.SizeResponse{Size: sz.Value, Exact: sz.Exact}
+	}
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ServeIndexDeltas applies deltas from a remote.DeltasRequest in a single transaction.
+// If the transaction fails because of one of the deltas, it responds with a remote.DeltaErrorResponse.
+func (api *APIv2) ServeIndexDeltas(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if api.ro {
+		jsonResponse(w, http.StatusForbidden, errors.New("database is read-only"))
+		return
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	} else if _, ok := h.QuadWriter.(*writer.Replica); ok {
+		jsonResponse(w, http.StatusForbidden, graph.ErrReadOnly)
+		return
+	}
+	var req remote.DeltasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	deltas := make([]graph.Delta, 0, len(req.Deltas))
+	for _, wd := range req.Deltas {
+		d, err := wd.Decode()
+		if err != nil {
+			jsonResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode delta: %v", err))
+			return
+		}
+		deltas = append(deltas, d)
+	}
+	err = h.ApplyDeltas(deltas, graph.IgnoreOpts{
+		IgnoreDup:     req.IgnoreDup,
+		IgnoreMissing: req.IgnoreMissing,
+	})
+	if derr, ok := err.(*graph.DeltaError); ok && remote.ErrorCode(derr.Err) != "" {
+		resp := remote.DeltaErrorResponse{Error: derr.Error(), Delta: -1, Code: remote.ErrorCode(derr.Err)}
+		for i, d := range deltas {
+			if d == derr.Delta {
+				resp.Delta = i
+				break
+			}
+		}
+		w.Header().Set(hdrContentType, contentTypeJSON)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(resp)
+		return
+	} else if err == graph.ErrReadOnly {
+		jsonResponse(w, http.StatusForbidden, err)
+		return
+	} else if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	fmt.Fprintf(w, `{"result": "Successfully applied %d deltas.", "count": %d}`+"\n", len(deltas), len(deltas))
+}
+
 // ServeFormats responds with formats known for the database
 func (api *APIv2) ServeFormats(w http.ResponseWriter, r *http.Request) {
 	type Format struct {
@@ -521,16 +1069,29 @@ func (api *APIv2) ServeQuery(w http.ResponseWriter, r *http.Request) {
 		errFunc(w, err)
 		return
 	}
+	qs := h.QuadStore
+	if s := vals.Get("as_of"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			jsonResponse(w, http.StatusBadRequest, fmt.Errorf("cannot parse as_of time: %v", err))
+			return
+		}
+		qs, err = graph.AsOf(ctx, qs, t)
+		if err != nil {
+			errFunc(w, err)
+			return
+		}
+	}
 	if l.HTTPQuery != nil {
 		defer r.Body.Close()
-		l.HTTPQuery(ctx, h.QuadStore, w, r.Body)
+		l.HTTPQuery(ctx, qs, w, r.Body)
 		return
 	}
 	if l.Session == nil {
 		errFunc(w, errors.New("HTTP interface is not supported for this query language"))
 		return
 	}
-	ses := l.Session(h.QuadStore)
+	ses := l.Session(qs)
 	var qu string
 	if r.Method == "GET" {
 		qu = vals.Get("qu")
@@ -1,16 +1,20 @@
 package cayleyhttp
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/cayleygraph/cayley/graph"
 	"github.com/cayleygraph/cayley/graph/memstore"
+	"github.com/cayleygraph/cayley/graph/remote"
 	"github.com/cayleygraph/cayley/writer"
 	"github.com/cayleygraph/quad"
 	"github.com/cayleygraph/quad/jsonld"
@@ -161,3 +165,101 @@ func TestV2PostNamespaceRules(t *testing.T) {
 	require.Equal(t, contentTypeJSON, rr.Header().Get(hdrContentType))
 	require.Contains(t, rules, rule)
 }
+
+func TestV2Changes(t *testing.T) {
+	h := makeHandle(t)
+	err := h.AddQuadSet(quads)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(NewAPIv2(h))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, srv.URL+prefix+"/changes?from=0", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, contentTypeEvents, resp.Header.Get(hdrContentType))
+
+	sc := bufio.NewScanner(resp.Body)
+	readEvent := func() (string, graph.Change) {
+		var (
+			id string
+			ev graph.Change
+		)
+		for sc.Scan() {
+			line := sc.Text()
+			if line == "" {
+				break
+			} else if strings.HasPrefix(line, "id: ") {
+				id = strings.TrimPrefix(line, "id: ")
+			} else if strings.HasPrefix(line, "data: ") {
+				var c remote.Change
+				err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &c)
+				require.NoError(t, err)
+				ev, err = c.Decode()
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, sc.Err())
+		return id, ev
+	}
+
+	id, ev := readEvent()
+	require.Equal(t, "1", id)
+	require.Equal(t, int64(1), ev.Position)
+	require.Len(t, ev.Deltas, len(quads))
+	for i, d := range ev.Deltas {
+		require.Equal(t, graph.Add, d.Action)
+		require.Equal(t, quads[i], d.Quad)
+	}
+
+	err = h.RemoveQuad(quads[0])
+	require.NoError(t, err)
+
+	id, ev = readEvent()
+	require.Equal(t, "2", id)
+	require.Equal(t, []graph.Delta{{Quad: quads[0], Action: graph.Delete}}, ev.Deltas)
+}
+
+func TestV2Graphs(t *testing.T) {
+	api := makeServerV2(t,
+		quad.MakeIRI("http://example.com/bob", "http://example.com/likes", "http://example.com/alice", "http://example.com/g1"),
+		quad.MakeIRI("http://example.com/alice", "http://example.com/likes", "http://example.com/bob", "http://example.com/g2"),
+	)
+
+	serve := func(h http.HandlerFunc, method, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, prefix+path, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	list := func() []string {
+		rr := serve(api.ServeGraphList, http.MethodGet, "/graph/list")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp labelsResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &resp)
+		require.NoError(t, err)
+		return resp.Labels
+	}
+	require.Equal(t, []string{"<http://example.com/g1>", "<http://example.com/g2>"}, list())
+
+	rr := serve(api.ServeGraphCopy, http.MethodPost, "/graph/copy?from=<http://example.com/g1>&to=<http://example.com/g3>")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, []string{"<http://example.com/g1>", "<http://example.com/g2>", "<http://example.com/g3>"}, list())
+
+	rr = serve(api.ServeGraphMove, http.MethodPost, "/graph/move?from=<http://example.com/g2>")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, []string{"<http://example.com/g1>", "<http://example.com/g3>"}, list())
+
+	rr = serve(api.ServeGraphDrop, http.MethodPost, "/graph/drop")
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+	rr = serve(api.ServeGraphDrop, http.MethodPost, "/graph/drop?label=<http://example.com/g1>")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, []string{"<http://example.com/g3>"}, list())
+}
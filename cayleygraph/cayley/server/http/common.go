@@ -27,8 +27,17 @@ func jsonResponse(w http.ResponseWriter, code int, err interface{}) {
 	w.Write([]byte(`}`))
 }
 
-// HandleForRequest returns new graph.Handle for given writer name, options and request
+// HandleForRequest returns graph.Handle for a given request. If the QuadStore selects a database for each
+// request, a new writer with a given name and options is created for it, unless the QuadStore hosts
+// databases with their own writers (see httpgraph.Handles). The default database always uses the writer of h.
 func HandleForRequest(h *graph.Handle, wtyp string, wopt graph.Options, r *http.Request) (*graph.Handle, error) {
+	if d, ok := h.QuadStore.(httpgraph.Handles); ok {
+		if dh, ok := d.HandleForRequest(r); ok {
+			return dh, nil
+		}
+		// unwrap the default database to expose its optional interfaces
+		return &graph.Handle{QuadStore: d.Default(), QuadWriter: h.QuadWriter}, nil
+	}
 	g, ok := h.QuadStore.(httpgraph.QuadStore)
 	if !ok {
 		return h, nil
@@ -36,6 +45,8 @@ func HandleForRequest(h *graph.Handle, wtyp string, wopt graph.Options, r *http.
 	qs, err := g.ForRequest(r)
 	if err != nil {
 		return nil, err
+	} else if qs == h.QuadStore {
+		return h, nil
 	}
 	qw, err := graph.NewQuadWriter(wtyp, qs, wopt)
 	if err != nil {
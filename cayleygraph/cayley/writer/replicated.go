@@ -0,0 +1,419 @@
+// Copyright 2017 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cayleygraph/cayley/clog"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/remote"
+	"github.com/cayleygraph/quad"
+)
+
+func init() {
+	graph.RegisterWriter("replicated", NewReplicatedReplication)
+}
+
+var (
+	mReplicaPosition = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cayley_replica_position",
+		Help: "Position of the last batch applied from the primary.",
+	}, []string{"database"})
+	mReplicaLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cayley_replica_lag_seconds",
+		Help: "Delay between a commit of the last applied batch on the primary and applying it on the replica.",
+	}, []string{"database"})
+)
+
+// ErrResyncRequired is reported by a replica that stopped because the primary removed changes
+// after the position of the replica from its change feed. The replica cannot catch up by tailing
+// the feed; its database must be restored from a new backup of the primary, and the position in
+// the state file set to the position of the primary at the time of the backup.
+var ErrResyncRequired = errors.New("replication: changes after the replica position were compacted on the primary; resync required")
+
+const (
+	changesPath = "/api/v2/changes"
+
+	replicaMinRetry = time.Second
+	replicaMaxRetry = time.Minute
+)
+
+// NewReplicatedReplication creates a writer for a primary or a replica, depending on options.
+//
+// If the "primary" option is set to a base URL of the primary's HTTP API, the writer tails
+// the change feed of the primary and rejects all other writes (see NewReplica).
+// Otherwise, the writer works the same way as the single writer, but requires a backend that
+// supports change feeds, so replicas can read them from /api/v2/changes.
+func NewReplicatedReplication(qs graph.QuadStore, opts graph.Options) (graph.QuadWriter, error) {
+	primary, err := opts.StringKey("primary", "")
+	if err != nil {
+		return nil, err
+	}
+	if primary == "" {
+		if _, ok := graph.Unwrap(qs).(graph.Watcher); !ok {
+			return nil, errors.New("replication: backend does not support change feeds")
+		}
+		return NewSingleReplication(qs, opts)
+	}
+	state, err := opts.StringKey("state_file", "")
+	if err != nil {
+		return nil, err
+	}
+	database, err := opts.StringKey("database", "")
+	if err != nil {
+		return nil, err
+	}
+	return NewReplica(qs, ReplicaConfig{
+		Primary:   primary,
+		StateFile: state,
+		Database:  database,
+	})
+}
+
+// ReplicaConfig is a configuration of a replica.
+type ReplicaConfig struct {
+	// Primary is a base URL of the primary's HTTP API.
+	Primary string
+	// StateFile is a path to the file that stores the position of the replica.
+	// If not set, the replica applies the whole change feed of the primary after each restart.
+	StateFile string
+	// Client is used to connect to the primary. Default client is used, if not set.
+	Client *http.Client
+	// Database is a name of the database, used as a label of the replica metrics.
+	// It is empty for the default database.
+	Database string
+}
+
+// ReplicaStatus describes the state of a replica.
+type ReplicaStatus struct {
+	// Position of the last batch applied from the primary.
+	Position int64
+	// Lag is a delay between a commit of the last applied batch on the primary and applying it on the replica.
+	Lag time.Duration
+	// Synced is the time when the last batch was applied.
+	Synced time.Time
+	// Connected is set while the replica is tailing the change feed of the primary.
+	Connected bool
+	// Err is the last error that interrupted the replication. It is set to ErrResyncRequired if
+	// the replica stopped and won't reconnect to the primary.
+	Err error
+}
+
+// replicaState is persisted in the state file of a replica.
+type replicaState struct {
+	Primary  string `json:"primary"`
+	Position int64  `json:"position"`
+}
+
+// Replica is a QuadWriter that applies changes committed to the primary.
+// All writes to the replica fail with graph.ErrReadOnly.
+type Replica struct {
+	qs   graph.QuadStore
+	conf ReplicaConfig
+
+	cancel func()
+	done   chan struct{}
+
+	mu     sync.Mutex
+	status ReplicaStatus
+}
+
+// NewReplica creates a replica for a given QuadStore and starts tailing the change feed of the primary.
+func NewReplica(qs graph.QuadStore, conf ReplicaConfig) (*Replica, error) {
+	conf.Primary = strings.TrimSuffix(conf.Primary, "/")
+	if conf.Primary == "" {
+		return nil, errors.New("replication: primary address is not set")
+	}
+	if conf.Client == nil {
+		conf.Client = http.DefaultClient
+	}
+	r := &Replica{qs: qs, conf: conf, done: make(chan struct{})}
+	if conf.StateFile != "" {
+		st, err := readReplicaState(conf.StateFile)
+		if err != nil {
+			return nil, err
+		} else if st.Primary != "" && st.Primary != conf.Primary {
+			return nil, fmt.Errorf("replication: state file was created for a different primary: %q", st.Primary)
+		}
+		r.status.Position = st.Position
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.run(ctx)
+	return r, nil
+}
+
+func readReplicaState(path string) (replicaState, error) {
+	var st replicaState
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return st, err
+	}
+	if err = json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("replication: cannot read state file: %v", err)
+	}
+	return st, nil
+}
+
+func (r *Replica) writeState(pos int64) error {
+	if r.conf.StateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(replicaState{Primary: r.conf.Primary, Position: pos})
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so a crash won't leave a broken state
+	tmp := r.conf.StateFile + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.conf.StateFile)
+}
+
+// Primary returns the address of the primary.
+func (r *Replica) Primary() string {
+	return r.conf.Primary
+}
+
+// Status returns the current state of the replica.
+func (r *Replica) Status() ReplicaStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func (r *Replica) position() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Position
+}
+
+func (r *Replica) setConnected(v bool) {
+	r.mu.Lock()
+	r.status.Connected = v
+	if v {
+		r.status.Err = nil
+	}
+	r.mu.Unlock()
+}
+
+func (r *Replica) run(ctx context.Context) {
+	defer close(r.done)
+	delay := replicaMinRetry
+	for {
+		last := r.position()
+		err := r.tail(ctx)
+		if ctx.Err() != nil {
+			return
+		} else if err == graph.ErrChangesCompacted {
+			// retrying won't help, the missing changes are gone
+			r.mu.Lock()
+			r.status.Err = ErrResyncRequired
+			r.mu.Unlock()
+			clog.Errorf("%v (position %d)", ErrResyncRequired, r.position())
+			return
+		}
+		if r.position() != last {
+			// made some progress, thus the primary is alive
+			delay = replicaMinRetry
+		}
+		r.mu.Lock()
+		r.status.Err = err
+		r.mu.Unlock()
+		clog.Warningf("replication: %v; reconnecting in %v", err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > replicaMaxRetry {
+			delay = replicaMaxRetry
+		}
+	}
+}
+
+// tail reads server-sent events from the change feed of the primary and applies them.
+func (r *Replica) tail(ctx context.Context) error {
+	addr := r.conf.Primary + changesPath + "?from=" + strconv.FormatInt(r.position(), 10)
+	req, err := http.NewRequest(http.MethodGet, addr, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.conf.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("primary responded with %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	r.setConnected(true)
+	defer r.setConnected(false)
+
+	br := bufio.NewReader(resp.Body)
+	var (
+		event string
+		data  []byte
+	)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return errors.New("primary closed the connection")
+		} else if err != nil {
+			return err
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		switch {
+		case len(line) == 0:
+			// end of event
+			if err := r.handleEvent(event, data); err != nil {
+				return err
+			}
+			event, data = "", nil
+		case bytes.HasPrefix(line, []byte("event: ")):
+			event = string(line[len("event: "):])
+		case bytes.HasPrefix(line, []byte("data: ")):
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, line[len("data: "):]...)
+		}
+	}
+}
+
+func (r *Replica) handleEvent(event string, data []byte) error {
+	switch event {
+	case "":
+	case "error":
+		var e remote.ErrorResponse
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("primary: %s", data)
+		} else if err = e.Err(); err == graph.ErrChangesCompacted {
+			return err
+		}
+		return fmt.Errorf("primary: %s", e.Error)
+	default:
+		return nil
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	var ec remote.Change
+	if err := json.Unmarshal(data, &ec); err != nil {
+		return fmt.Errorf("cannot decode change: %v", err)
+	}
+	c, err := ec.Decode()
+	if err != nil {
+		return fmt.Errorf("cannot decode change: %v", err)
+	}
+	return r.apply(c)
+}
+
+// apply writes a batch of changes to the replica. Batches might be applied more than once
+// after a crash, thus duplicate and missing quads are ignored.
+func (r *Replica) apply(c graph.Change) error {
+	if c.Position <= r.position() {
+		return nil
+	}
+	err := r.qs.ApplyDeltas(c.Deltas, graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true})
+	if err != nil {
+		return fmt.Errorf("cannot apply change %d: %v", c.Position, err)
+	}
+	if err = r.writeState(c.Position); err != nil {
+		return fmt.Errorf("cannot save replica state: %v", err)
+	}
+	now := time.Now()
+	r.mu.Lock()
+	r.status.Position = c.Position
+	r.status.Synced = now
+	if !c.Time.IsZero() {
+		r.status.Lag = now.Sub(c.Time)
+	}
+	lag := r.status.Lag
+	r.mu.Unlock()
+	mReplicaPosition.WithLabelValues(r.conf.Database).Set(float64(c.Position))
+	mReplicaLag.WithLabelValues(r.conf.Database).Set(lag.Seconds())
+	return nil
+}
+
+func (r *Replica) AddQuad(quad.Quad) error {
+	return graph.ErrReadOnly
+}
+
+func (r *Replica) AddQuadSet([]quad.Quad) error {
+	return graph.ErrReadOnly
+}
+
+func (r *Replica) RemoveQuad(quad.Quad) error {
+	return graph.ErrReadOnly
+}
+
+func (r *Replica) ApplyTransaction(*graph.Transaction) error {
+	return graph.ErrReadOnly
+}
+
+func (r *Replica) RemoveNode(quad.Value) error {
+	return graph.ErrReadOnly
+}
+
+var _ graph.LabelManager = (*Replica)(nil)
+
+// ListLabels implements graph.LabelManager. Labels are listed from the local copy.
+func (r *Replica) ListLabels(ctx context.Context) ([]quad.Value, error) {
+	return graph.ListLabels(ctx, r.qs)
+}
+
+func (r *Replica) DropLabel(context.Context, quad.Value) error {
+	return graph.ErrReadOnly
+}
+
+func (r *Replica) CopyLabel(context.Context, quad.Value, quad.Value) error {
+	return graph.ErrReadOnly
+}
+
+func (r *Replica) MoveLabel(context.Context, quad.Value, quad.Value) error {
+	return graph.ErrReadOnly
+}
+
+// Close stops the replication.
+func (r *Replica) Close() error {
+	r.cancel()
+	<-r.done
+	// the database may be closed while idle, thus it must not report a stale state
+	mReplicaPosition.DeleteLabelValues(r.conf.Database)
+	mReplicaLag.DeleteLabelValues(r.conf.Database)
+	return nil
+}
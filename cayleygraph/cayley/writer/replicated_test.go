@@ -0,0 +1,206 @@
+package writer_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/graphtest"
+	"github.com/cayleygraph/cayley/graph/kv"
+	"github.com/cayleygraph/cayley/graph/kv/btree"
+	"github.com/cayleygraph/cayley/graph/memstore"
+	cayleyhttp "github.com/cayleygraph/cayley/server/http"
+	"github.com/cayleygraph/cayley/writer"
+	"github.com/cayleygraph/quad"
+)
+
+func waitPosition(t testing.TB, r *writer.Replica, pos int64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		st := r.Status()
+		if st.Position >= pos {
+			require.Equal(t, pos, st.Position)
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("replica is stuck at %d: %v", st.Position, st.Err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cayley-replica")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "state.json")
+
+	pqs := memstore.New()
+	pw, err := graph.NewQuadWriter("replicated", pqs, nil)
+	require.NoError(t, err)
+	srv := httptest.NewServer(cayleyhttp.NewAPIv2(&graph.Handle{QuadStore: pqs, QuadWriter: pw}))
+	defer srv.Close()
+
+	err = pw.AddQuadSet([]quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+	})
+	require.NoError(t, err)
+
+	qs := memstore.New()
+	r, err := writer.NewReplica(qs, writer.ReplicaConfig{Primary: srv.URL, StateFile: state})
+	require.NoError(t, err)
+
+	waitPosition(t, r, 1)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+		quad.Make("A", "follows", "C", nil),
+	}, true)
+
+	err = pw.RemoveQuad(quad.Make("A", "follows", "C", nil))
+	require.NoError(t, err)
+
+	waitPosition(t, r, 2)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{
+		quad.Make("A", "follows", "B", nil),
+	}, true)
+	st := r.Status()
+	require.True(t, st.Connected)
+	require.NoError(t, st.Err)
+	require.True(t, st.Lag >= 0)
+
+	err = r.AddQuad(quad.Make("A", "follows", "D", nil))
+	require.Equal(t, graph.ErrReadOnly, err)
+	require.NoError(t, r.Close())
+
+	err = pw.AddQuad(quad.Make("A", "follows", "D", nil))
+	require.NoError(t, err)
+
+	// replica must continue from the saved position
+	qs = memstore.New()
+	r, err = writer.NewReplica(qs, writer.ReplicaConfig{Primary: srv.URL, StateFile: state})
+	require.NoError(t, err)
+	defer r.Close()
+
+	waitPosition(t, r, 3)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{
+		quad.Make("A", "follows", "D", nil),
+	}, true)
+
+	_, err = writer.NewReplica(memstore.New(), writer.ReplicaConfig{Primary: "http://localhost:1", StateFile: state})
+	require.Error(t, err)
+}
+
+func TestReplicaValueTypes(t *testing.T) {
+	pqs := memstore.New()
+	pw, err := graph.NewQuadWriter("replicated", pqs, nil)
+	require.NoError(t, err)
+	srv := httptest.NewServer(cayleyhttp.NewAPIv2(&graph.Handle{QuadStore: pqs, QuadWriter: pw}))
+	defer srv.Close()
+
+	quads := []quad.Quad{
+		quad.MakeIRI("a", "name", "b", ""),
+		{Subject: quad.IRI("a"), Predicate: quad.IRI("age"), Object: quad.Int(42)},
+		{Subject: quad.IRI("a"), Predicate: quad.IRI("score"), Object: quad.Float(1.5)},
+		{Subject: quad.IRI("a"), Predicate: quad.IRI("alive"), Object: quad.Bool(true)},
+		{Subject: quad.BNode("n1"), Predicate: quad.IRI("label"), Object: quad.LangString{Value: "chat", Lang: "fr"}},
+		{Subject: quad.BNode("n1"), Predicate: quad.IRI("born"), Object: quad.Time(time.Unix(1500000000, 0).UTC())},
+	}
+	err = pw.AddQuadSet(quads)
+	require.NoError(t, err)
+
+	qs := memstore.New()
+	r, err := writer.NewReplica(qs, writer.ReplicaConfig{Primary: srv.URL})
+	require.NoError(t, err)
+	defer r.Close()
+
+	waitPosition(t, r, 1)
+	graphtest.ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), quads, true)
+}
+
+func TestReplicaCompacted(t *testing.T) {
+	ctx := context.TODO()
+	db := btree.New()
+	require.NoError(t, kv.Init(db, nil))
+	// the primary keeps no batches for replicas that are not connected
+	pqs, err := kv.New(db, graph.Options{kv.OptChangesKeep: 0})
+	require.NoError(t, err)
+	defer pqs.Close()
+	pw, err := graph.NewQuadWriter("replicated", pqs, nil)
+	require.NoError(t, err)
+	srv := httptest.NewServer(cayleyhttp.NewAPIv2(&graph.Handle{QuadStore: pqs, QuadWriter: pw}))
+	defer srv.Close()
+
+	require.NoError(t, pw.AddQuad(quad.Make("A", "follows", "B", nil)))
+	require.NoError(t, pw.RemoveQuad(quad.Make("A", "follows", "B", nil)))
+	_, err = pqs.(*kv.QuadStore).Compact(ctx)
+	require.NoError(t, err)
+
+	r, err := writer.NewReplica(memstore.New(), writer.ReplicaConfig{Primary: srv.URL})
+	require.NoError(t, err)
+	defer r.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for r.Status().Err != writer.ErrResyncRequired {
+		if time.Now().After(deadline) {
+			t.Fatalf("replica didn't stop: %v", r.Status().Err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// replicaPositions returns the position metric of replicas by the name of the database.
+func replicaPositions(t testing.TB) map[string]float64 {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	out := make(map[string]float64)
+	for _, mf := range mfs {
+		if mf.GetName() != "cayley_replica_position" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "database" {
+					out[l.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return out
+}
+
+func TestReplicaMetrics(t *testing.T) {
+	pqs := memstore.New()
+	pw, err := graph.NewQuadWriter("replicated", pqs, nil)
+	require.NoError(t, err)
+	srv := httptest.NewServer(cayleyhttp.NewAPIv2(&graph.Handle{QuadStore: pqs, QuadWriter: pw}))
+	defer srv.Close()
+	require.NoError(t, pw.AddQuad(quad.Make("A", "follows", "B", nil)))
+
+	// each database reports its own state
+	r1, err := writer.NewReplica(memstore.New(), writer.ReplicaConfig{Primary: srv.URL, Database: "metrics-a"})
+	require.NoError(t, err)
+	waitPosition(t, r1, 1)
+	require.NoError(t, pw.AddQuad(quad.Make("A", "follows", "C", nil)))
+	r2, err := writer.NewReplica(memstore.New(), writer.ReplicaConfig{Primary: srv.URL, Database: "metrics-b"})
+	require.NoError(t, err)
+	defer r2.Close()
+	waitPosition(t, r2, 2)
+	waitPosition(t, r1, 2)
+	pos := replicaPositions(t)
+	require.Equal(t, float64(2), pos["metrics-a"])
+	require.Equal(t, float64(2), pos["metrics-b"])
+
+	// and removes it when closed
+	require.NoError(t, r1.Close())
+	pos = replicaPositions(t)
+	require.NotContains(t, pos, "metrics-a")
+	require.Contains(t, pos, "metrics-b")
+}
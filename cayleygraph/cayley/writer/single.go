@@ -118,5 +118,5 @@ func (s *Single) Close() error {
 }
 
 func (s *Single) ApplyTransaction(t *graph.Transaction) error {
-	return s.qs.ApplyDeltas(t.Deltas, s.ignoreOpts)
+	return graph.ApplyDeltasIf(s.qs, t.Preconditions, t.Deltas, s.ignoreOpts)
 }